@@ -76,6 +76,30 @@ type Payments struct {
 	StudentReferralReward int `mapstructure:"student_referral_reward"`
 	StudentSignupReward   int `mapstructure:"student_signup_reward"`
 	TutorReferralReward   int `mapstructure:"tutor_referral_reward"`
+	// Gateway is what payments go through: stripe, or memory to charge nothing.
+	Gateway string `mapstructure:"gateway"`
+	// PayoutSchedule is how often tutors are paid out: weekly or biweekly.
+	PayoutSchedule string `mapstructure:"payout_schedule"`
+	// PayoutMinimum is the least a tutor is paid out, in dollars. Less waits for the next payout.
+	PayoutMinimum int `mapstructure:"payout_minimum"`
+	// PayoutHold is how long earnings wait before they're paid out, e.g. 72h.
+	PayoutHold string `mapstructure:"payout_hold"`
+}
+
+// PayoutInterval returns how long there is between payouts, a week unless they're biweekly
+func (p Payments) PayoutInterval() time.Duration {
+	if p.PayoutSchedule == "biweekly" {
+		return 14 * 24 * time.Hour
+	}
+	return 7 * 24 * time.Hour
+}
+
+// ParsePayoutHold returns how long earnings are held before they're paid out, 7 days when it isn't set
+func (p Payments) ParsePayoutHold() (time.Duration, error) {
+	if p.PayoutHold == "" {
+		return 7 * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(p.PayoutHold)
 }
 
 type Mail struct {
@@ -96,6 +120,16 @@ type From struct {
 
 type Lessons struct {
 	AdvanceDuration string `mapstructure:"advance_duration"`
+	// NoShowGracePeriod is how long after a lesson starts its participants are
+	// marked as no-shows if they didn't enter the classroom.
+	NoShowGracePeriod string `mapstructure:"no_show_grace_period"`
+	// RescheduleExpiry is how long a reschedule proposal waits for an answer.
+	RescheduleExpiry string `mapstructure:"reschedule_expiry"`
+	// RescheduleSlots is how many time slots a reschedule proposal can offer.
+	RescheduleSlots int `mapstructure:"reschedule_slots"`
+	// InstantSessionTimeout is how long an instant session request waits for a
+	// tutor to accept it before it's escalated.
+	InstantSessionTimeout string `mapstructure:"instant_session_timeout"`
 }
 
 type Service struct {
@@ -181,6 +215,38 @@ func (l Lessons) ParseAdvanceDuration() (time.Duration, error) {
 	return time.ParseDuration(l.AdvanceDuration)
 }
 
+// ParseNoShowGracePeriod returns the no-show grace period, 15 minutes when it isn't set
+func (l Lessons) ParseNoShowGracePeriod() (time.Duration, error) {
+	if l.NoShowGracePeriod == "" {
+		return 15 * time.Minute, nil
+	}
+	return time.ParseDuration(l.NoShowGracePeriod)
+}
+
+// ParseRescheduleExpiry returns how long reschedule proposals last, 24 hours when it isn't set
+func (l Lessons) ParseRescheduleExpiry() (time.Duration, error) {
+	if l.RescheduleExpiry == "" {
+		return 24 * time.Hour, nil
+	}
+	return time.ParseDuration(l.RescheduleExpiry)
+}
+
+// MaxRescheduleSlots returns how many slots a reschedule proposal can offer, 3 when it isn't set
+func (l Lessons) MaxRescheduleSlots() int {
+	if l.RescheduleSlots < 1 {
+		return 3
+	}
+	return l.RescheduleSlots
+}
+
+// ParseInstantSessionTimeout returns how long instant session requests wait, 2 minutes when it isn't set
+func (l Lessons) ParseInstantSessionTimeout() (time.Duration, error) {
+	if l.InstantSessionTimeout == "" {
+		return 2 * time.Minute, nil
+	}
+	return time.ParseDuration(l.InstantSessionTimeout)
+}
+
 type Messenger struct {
 	RequireApproval bool `mapstructure:"require_approval"`
 }
@@ -184,6 +184,34 @@ func TestConfigValues(t *testing.T) {
 		t.Errorf("error reading lessons.AdvanceDuration: got %s", d)
 	}
 
+	grace, err := c.Lessons.ParseNoShowGracePeriod()
+	if err != nil {
+		t.Fatal("Could not parse no show grace period:", err)
+	}
+	if grace != 10*time.Minute {
+		t.Errorf("error reading lessons.NoShowGracePeriod: got %s", grace)
+	}
+
+	expiry, err := c.Lessons.ParseRescheduleExpiry()
+	if err != nil {
+		t.Fatal("Could not parse reschedule expiry:", err)
+	}
+	if expiry != 12*time.Hour {
+		t.Errorf("error reading lessons.RescheduleExpiry: got %s", expiry)
+	}
+
+	if c.Lessons.MaxRescheduleSlots() != 3 {
+		t.Errorf("error reading lessons.RescheduleSlots: got %d", c.Lessons.MaxRescheduleSlots())
+	}
+
+	instantTimeout, err := c.Lessons.ParseInstantSessionTimeout()
+	if err != nil {
+		t.Fatal("Could not parse instant session timeout:", err)
+	}
+	if instantTimeout != 90*time.Second {
+		t.Errorf("error reading lessons.InstantSessionTimeout: got %s", instantTimeout)
+	}
+
 	// test service portion
 	if c.Service.Google.Key != "sdsd8j.apps.googleusercontent.com" {
 		t.Errorf("error reading service.Google.Key: got %s", c.Service.Google.Key)
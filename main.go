@@ -26,6 +26,7 @@ import (
 	"gitlab.com/learnt/api/pkg/routes/checkr"
 	"gitlab.com/learnt/api/pkg/routes/common"
 	"gitlab.com/learnt/api/pkg/routes/countries"
+	"gitlab.com/learnt/api/pkg/routes/courses"
 	"gitlab.com/learnt/api/pkg/routes/hooks"
 	"gitlab.com/learnt/api/pkg/routes/importcontacts"
 	"gitlab.com/learnt/api/pkg/routes/intercom"
@@ -90,11 +91,13 @@ func routes(router *gin.Engine, close func()) {
 	common.Setup(router)
 	common.SetupCore(router.Group("/url"))
 	countries.Setup(router.Group("/countries", core.CORS))
+	courses.Setup(router.Group("/courses", auth.Middleware, core.CORS))
 	hooks.Setup(router.Group("/hooks"))
 	importcontacts.Setup(router.Group("/import", auth.Middleware, core.CORS))
 	intercom.Setup(router.Group("/intercom"))
 	lessons.SetupLessons(ctx, router.Group("/lessons", auth.MiddlewareSilent, core.CORS))
 	me.Setup(router.Group("/me", auth.Middleware, core.CORS))
+	me.SetupFeed(router.Group("/calendar"))
 	messenger.Setup(router.Group("/messenger", auth.Middleware, core.CORS))
 	metrics.Setup(router.Group("/metrics", auth.Middleware, core.CORS))
 	notifications.Setup(router.Group("/notifications"))
@@ -245,6 +248,109 @@ func startCronJobs() {
 		logger.Get().Fatal(err)
 	}
 
+	gracePeriod, err := config.GetConfig().Lessons.ParseNoShowGracePeriod()
+	if err != nil {
+		logger.Get().Fatal(err)
+	}
+
+	// mark lessons as no-shows once their grace period passed (checks every minute)
+	_, err = c.AddFunc("* * * * *", func() {
+		logger.Get().Infof("running no-show detection")
+		detector := jobs.NoShowDetector{GracePeriod: gracePeriod}
+		detector.DetectNoShows()
+	})
+
+	if err != nil {
+		logger.Get().Fatal(err)
+	}
+
+	// expire the reschedule proposals nobody answered (checks every minute)
+	_, err = c.AddFunc("* * * * *", func() {
+		logger.Get().Infof("running reschedule expiry")
+		expirer := jobs.RescheduleExpirer{}
+		expirer.ExpireReschedules()
+	})
+
+	if err != nil {
+		logger.Get().Fatal(err)
+	}
+
+	// import the busy time of the tutors' external calendars (syncs every hour)
+	_, err = c.AddFunc("45 * * * *", func() {
+		logger.Get().Infof("running external calendars sync")
+		syncer := jobs.ExternalCalendarSyncer{}
+		syncer.SyncCalendars()
+	})
+
+	if err != nil {
+		logger.Get().Fatal(err)
+	}
+
+	// escalate the instant requests no tutor accepted in time (checks every minute)
+	_, err = c.AddFunc("* * * * *", func() {
+		instant := jobs.InstantRequestExpirer{}
+		instant.TimeOutRequests()
+	})
+
+	if err != nil {
+		logger.Get().Fatal(err)
+	}
+
+	// expire the lesson packages that weren't used up in time (checks every hour)
+	_, err = c.AddFunc("30 * * * *", func() {
+		logger.Get().Infof("running package expiry")
+		expirer := jobs.PackageExpirer{}
+		expirer.ExpirePackages()
+	})
+
+	if err != nil {
+		logger.Get().Fatal(err)
+	}
+
+	// expire the gift cards that weren't redeemed in time (checks every hour)
+	_, err = c.AddFunc("35 * * * *", func() {
+		logger.Get().Infof("running gift card expiry")
+		expirer := jobs.GiftCardExpirer{}
+		expirer.ExpireGiftCards()
+	})
+
+	if err != nil {
+		logger.Get().Fatal(err)
+	}
+
+	// renew the holds on the students' cards expiring before their lesson (checks every hour)
+	_, err = c.AddFunc("5 * * * *", func() {
+		logger.Get().Infof("running hold renewal")
+		renewer := jobs.HoldRenewer{}
+		renewer.RenewHolds()
+	})
+
+	if err != nil {
+		logger.Get().Fatal(err)
+	}
+
+	// create the tutors' payout batch when it's due, weekly or biweekly (checks every Monday)
+	_, err = c.AddFunc("0 6 * * MON", func() {
+		logger.Get().Infof("running payout schedule")
+		scheduler := jobs.PayoutScheduler{}
+		scheduler.RunSchedule()
+	})
+
+	if err != nil {
+		logger.Get().Fatal(err)
+	}
+
+	// remind the students of the assignments due within a day (checks every hour)
+	_, err = c.AddFunc("15 * * * *", func() {
+		logger.Get().Infof("running assignment reminder")
+		reminder := jobs.AssignmentReminder{Before: 24 * time.Hour}
+		reminder.RemindAssignments()
+	})
+
+	if err != nil {
+		logger.Get().Fatal(err)
+	}
+
 	_, err = c.AddFunc("0 0 * * MON", func() {
 		logger.Get().Infof("running weekly reminder")
 		reminder := jobs.WeeklyProfileReminder{}
@@ -0,0 +1,403 @@
+package ics
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ical "github.com/arran4/golang-ical"
+	"github.com/pkg/errors"
+)
+
+// maxOccurrences bounds how many times a recurring event is repeated
+const maxOccurrences = 1000
+
+// properties the library has no component constants for
+const (
+	propertyDuration     = ical.ComponentProperty(ical.PropertyDuration)
+	propertyRecurrenceID = ical.ComponentProperty(ical.PropertyRecurrenceId)
+	propertyRrule        = ical.ComponentProperty(ical.PropertyRrule)
+)
+
+// Busy is a time an external calendar is busy
+type Busy struct {
+	UID     string
+	Summary string
+	From    time.Time
+	To      time.Time
+}
+
+// ParseBusy reads the busy times of the calendar between from and to. Free and
+// cancelled events are skipped. Recurring events are repeated with their RRULE
+// (DAILY, WEEKLY, MONTHLY and YEARLY), without their EXDATEs and the instances
+// moved by other events. Times without a timezone are read in loc.
+func ParseBusy(r io.Reader, from, to time.Time, loc *time.Location) ([]Busy, error) {
+	cal, err := ical.ParseCalendar(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't parse calendar")
+	}
+
+	// the instances of recurring events moved to another time are events of their own
+	moved := make(map[string]map[int64]bool)
+	for _, event := range cal.Events() {
+		if p := event.GetProperty(propertyRecurrenceID); p != nil {
+			if t, _, err := parseTime(p, loc); err == nil {
+				if moved[event.Id()] == nil {
+					moved[event.Id()] = make(map[int64]bool)
+				}
+				moved[event.Id()][t.Unix()] = true
+			}
+		}
+	}
+
+	busy := make([]Busy, 0)
+
+	for _, event := range cal.Events() {
+		if p := event.GetProperty(ical.ComponentPropertyTransp); p != nil && strings.EqualFold(p.Value, string(ical.TransparencyTransparent)) {
+			continue
+		}
+
+		if p := event.GetProperty(ical.ComponentPropertyStatus); p != nil && strings.EqualFold(p.Value, string(ical.ObjectStatusCancelled)) {
+			continue
+		}
+
+		start, end, err := eventTimes(event, loc)
+		if err != nil {
+			continue
+		}
+
+		summary := ""
+		if p := event.GetProperty(ical.ComponentPropertySummary); p != nil {
+			summary = ical.FromText(p.Value)
+		}
+
+		length := end.Sub(start)
+
+		starts := []time.Time{start}
+		if p := event.GetProperty(propertyRrule); p != nil && event.GetProperty(propertyRecurrenceID) == nil {
+			rule, err := parseRule(p.Value, loc)
+			if err != nil {
+				continue
+			}
+
+			excluded := exdates(event, loc)
+			for t := range moved[event.Id()] {
+				excluded[t] = true
+			}
+
+			starts = rule.occurrences(start, from.Add(-length), to, excluded)
+		}
+
+		for _, s := range starts {
+			e := s.Add(length)
+			if !e.After(from) || !s.Before(to) {
+				continue
+			}
+
+			busy = append(busy, Busy{UID: event.Id(), Summary: summary, From: s, To: e})
+		}
+	}
+
+	sort.Slice(busy, func(i, j int) bool {
+		return busy[i].From.Before(busy[j].From)
+	})
+
+	return busy, nil
+}
+
+// eventTimes gets when the event starts and ends. Events without an end last
+// their DURATION, or a day when they are all day.
+func eventTimes(event *ical.VEvent, loc *time.Location) (start, end time.Time, err error) {
+	p := event.GetProperty(ical.ComponentPropertyDtStart)
+	if p == nil {
+		return start, end, errors.New("event has no start")
+	}
+
+	start, allDay, err := parseTime(p, loc)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case event.GetProperty(ical.ComponentPropertyDtEnd) != nil:
+		end, _, err = parseTime(event.GetProperty(ical.ComponentPropertyDtEnd), loc)
+		if err != nil {
+			return
+		}
+	case event.GetProperty(propertyDuration) != nil:
+		var d time.Duration
+		d, err = parseDuration(event.GetProperty(propertyDuration).Value)
+		if err != nil {
+			return
+		}
+		end = start.Add(d)
+	case allDay:
+		end = start.AddDate(0, 0, 1)
+	}
+
+	if !end.After(start) {
+		return start, end, errors.New("event has no duration")
+	}
+
+	return
+}
+
+// parseTime reads a DATE or DATE-TIME property, in UTC, its TZID or loc
+func parseTime(p *ical.IANAProperty, loc *time.Location) (t time.Time, allDay bool, err error) {
+	value := strings.TrimSpace(p.Value)
+
+	if tzid, ok := p.ICalParameters[string(ical.PropertyTzid)]; ok && len(tzid) > 0 {
+		if l, err := time.LoadLocation(strings.Trim(tzid[0], `"`)); err == nil {
+			loc = l
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse("20060102T150405Z", value)
+	case len(value) == len("20060102"):
+		t, err = time.ParseInLocation("20060102", value, loc)
+		allDay = true
+	default:
+		t, err = time.ParseInLocation("20060102T150405", value, loc)
+	}
+
+	return t, allDay, errors.Wrapf(err, "invalid time %q", value)
+}
+
+// parseDuration reads a positive duration like P1W, P1D or PT1H30M
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "+")
+	if !strings.HasPrefix(value, "P") {
+		return 0, errors.Errorf("invalid duration %q", value)
+	}
+
+	units := map[byte]time.Duration{
+		'W': 7 * 24 * time.Hour,
+		'D': 24 * time.Hour,
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+	}
+
+	var d time.Duration
+	num := ""
+
+	for i := 1; i < len(value); i++ {
+		c := value[i]
+
+		switch {
+		case c == 'T':
+		case c >= '0' && c <= '9':
+			num += string(c)
+		default:
+			unit, ok := units[c]
+			if !ok || num == "" {
+				return 0, errors.Errorf("invalid duration %q", value)
+			}
+
+			n, _ := strconv.Atoi(num)
+			d += time.Duration(n) * unit
+			num = ""
+		}
+	}
+
+	return d, nil
+}
+
+// exdates gets the starts of the occurrences excluded from a recurring event
+func exdates(event *ical.VEvent, loc *time.Location) map[int64]bool {
+	excluded := make(map[int64]bool)
+
+	for i := range event.Properties {
+		p := event.Properties[i]
+		if p.IANAToken != string(ical.PropertyExdate) {
+			continue
+		}
+
+		// EXDATE can list several times
+		for _, value := range strings.Split(p.Value, ",") {
+			single := p
+			single.Value = value
+			if t, _, err := parseTime(&single, loc); err == nil {
+				excluded[t.Unix()] = true
+			}
+		}
+	}
+
+	return excluded
+}
+
+type rule struct {
+	freq     string
+	interval int
+	count    int
+	until    time.Time
+	byDay    []time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+func parseRule(value string, loc *time.Location) (*rule, error) {
+	r := &rule{interval: 1}
+
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch strings.ToUpper(kv[0]) {
+		case "FREQ":
+			r.freq = strings.ToUpper(kv[1])
+		case "INTERVAL":
+			if n, err := strconv.Atoi(kv[1]); err == nil && n > 0 {
+				r.interval = n
+			}
+		case "COUNT":
+			if n, err := strconv.Atoi(kv[1]); err == nil {
+				r.count = n
+			}
+		case "UNTIL":
+			p := &ical.IANAProperty{BaseProperty: ical.BaseProperty{Value: kv[1]}}
+			until, allDay, err := parseTime(p, loc)
+			if err != nil {
+				return nil, err
+			}
+			if allDay {
+				until = until.AddDate(0, 0, 1).Add(-time.Second)
+			}
+			r.until = until
+		case "BYDAY":
+			for _, day := range strings.Split(kv[1], ",") {
+				// ordinals like 1MO are only kept for their weekday
+				day = strings.ToUpper(day)
+				if len(day) >= 2 {
+					if wd, ok := weekdays[day[len(day)-2:]]; ok {
+						r.byDay = append(r.byDay, wd)
+					}
+				}
+			}
+		}
+	}
+
+	switch r.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+		return r, nil
+	}
+
+	return nil, errors.Errorf("unsupported recurrence %q", value)
+}
+
+// occurrences repeats start until the rule ends or to, and returns the starts
+// after from that aren't excluded
+func (r *rule) occurrences(start, from, to time.Time, excluded map[int64]bool) []time.Time {
+	out := make([]time.Time, 0)
+	count := 0
+
+	add := func(t time.Time) bool {
+		if t.Before(start) {
+			return true
+		}
+
+		if t.After(to) || (!r.until.IsZero() && t.After(r.until)) {
+			return false
+		}
+
+		count++
+		if r.count > 0 && count > r.count {
+			return false
+		}
+
+		if !t.Before(from) && !excluded[t.Unix()] {
+			out = append(out, t)
+		}
+
+		return true
+	}
+
+	y, m, d := start.Date()
+	hour, min, sec := start.Clock()
+	loc := start.Location()
+
+	// without a count, the periods before from don't need to be repeated
+	first := 0
+	if r.count == 0 && from.After(start) {
+		switch r.freq {
+		case "DAILY":
+			first = int(from.Sub(start).Hours()/24) / r.interval
+		case "WEEKLY":
+			first = int(from.Sub(start).Hours()/(24*7)) / r.interval
+		case "MONTHLY":
+			first = ((from.Year()-y)*12 + int(from.Month()-m)) / r.interval
+		case "YEARLY":
+			first = (from.Year() - y) / r.interval
+		}
+
+		// a period back, for the days of the week before the start's weekday
+		if first > 0 {
+			first--
+		}
+	}
+
+	for i := first; i < first+maxOccurrences; i++ {
+		n := i * r.interval
+
+		switch r.freq {
+		case "DAILY":
+			if !add(time.Date(y, m, d+n, hour, min, sec, 0, loc)) {
+				return out
+			}
+
+		case "WEEKLY":
+			if len(r.byDay) == 0 {
+				if !add(time.Date(y, m, d+7*n, hour, min, sec, 0, loc)) {
+					return out
+				}
+				continue
+			}
+
+			// the week of the start, from its sunday
+			week := time.Date(y, m, d-int(start.Weekday())+7*n, hour, min, sec, 0, loc)
+			days := append([]time.Weekday(nil), r.byDay...)
+			sort.Slice(days, func(i, j int) bool { return days[i] < days[j] })
+
+			for _, wd := range days {
+				if !add(week.AddDate(0, 0, int(wd))) {
+					return out
+				}
+			}
+
+		case "MONTHLY":
+			t := time.Date(y, m+time.Month(n), d, hour, min, sec, 0, loc)
+			// months without the day are skipped
+			if t.Day() != d {
+				continue
+			}
+			if !add(t) {
+				return out
+			}
+
+		case "YEARLY":
+			t := time.Date(y+n, m, d, hour, min, sec, 0, loc)
+			if t.Day() != d {
+				continue
+			}
+			if !add(t) {
+				return out
+			}
+		}
+	}
+
+	return out
+}
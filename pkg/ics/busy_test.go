@@ -0,0 +1,99 @@
+package ics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Test//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:single\r\n" +
+	"DTSTART:20210104T100000Z\r\n" +
+	"DTEND:20210104T110000Z\r\n" +
+	"SUMMARY:Dentist\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:free\r\n" +
+	"DTSTART:20210104T120000Z\r\n" +
+	"DTEND:20210104T130000Z\r\n" +
+	"TRANSP:TRANSPARENT\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:cancelled\r\n" +
+	"DTSTART:20210105T120000Z\r\n" +
+	"DTEND:20210105T130000Z\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:weekly\r\n" +
+	"DTSTART;TZID=America/New_York:20201228T090000\r\n" +
+	"DURATION:PT30M\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=MO,WE\r\n" +
+	"EXDATE;TZID=America/New_York:20210106T090000\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:weekly\r\n" +
+	"RECURRENCE-ID;TZID=America/New_York:20210111T090000\r\n" +
+	"DTSTART;TZID=America/New_York:20210111T150000\r\n" +
+	"DTEND;TZID=America/New_York:20210111T153000\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:allday\r\n" +
+	"DTSTART;VALUE=DATE:20210108\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseBusy(t *testing.T) {
+	from := time.Date(2021, time.January, 4, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, time.January, 12, 0, 0, 0, 0, time.UTC)
+
+	busy, err := ParseBusy(strings.NewReader(testCalendar), from, to, time.UTC)
+	if err != nil {
+		t.Fatalf("expected calendar to parse, got %v", err)
+	}
+
+	ny, _ := time.LoadLocation("America/New_York")
+	expected := []time.Time{
+		time.Date(2021, time.January, 4, 10, 0, 0, 0, time.UTC), // single
+		time.Date(2021, time.January, 4, 9, 0, 0, 0, ny),        // weekly, monday
+		time.Date(2021, time.January, 8, 0, 0, 0, 0, time.UTC),  // all day
+		time.Date(2021, time.January, 11, 15, 0, 0, 0, ny),      // weekly, moved monday
+	}
+
+	if len(busy) != len(expected) {
+		t.Fatalf("expected %d busy times, got %+v", len(expected), busy)
+	}
+
+	for i, want := range expected {
+		if !busy[i].From.Equal(want) {
+			t.Errorf("expected busy time %d to start at %v, got %v", i, want, busy[i].From)
+		}
+	}
+
+	if d := busy[2].To.Sub(busy[2].From); d != 24*time.Hour {
+		t.Errorf("expected all day event to last a day, got %v", d)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"PT30M":   30 * time.Minute,
+		"PT1H30M": 90 * time.Minute,
+		"P1D":     24 * time.Hour,
+		"P1W":     7 * 24 * time.Hour,
+		"P1DT2H":  26 * time.Hour,
+	}
+
+	for value, want := range tests {
+		if got, err := parseDuration(value); err != nil || got != want {
+			t.Errorf("parseDuration(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+
+	if _, err := parseDuration("1H"); err == nil {
+		t.Error("expected invalid duration to fail")
+	}
+}
@@ -1,17 +1,26 @@
 package ics
 
 import (
-	"crypto/md5"
 	"fmt"
 	ical "github.com/arran4/golang-ical"
 	"github.com/gin-gonic/gin"
 	"gitlab.com/learnt/api/pkg/core"
 	"gitlab.com/learnt/api/pkg/store"
+	"gopkg.in/mgo.v2/bson"
 	"io"
 	"strings"
 	"time"
 )
 
+// reminder is how long before a lesson calendar apps remind of it
+const reminder = "-PT30M"
+
+// alarm properties the library has no component constants for
+const (
+	propertyAction  = ical.ComponentProperty(ical.PropertyAction)
+	propertyTrigger = ical.ComponentProperty(ical.PropertyTrigger)
+)
+
 type lessons struct{}
 
 func Lessons() *lessons {
@@ -19,50 +28,115 @@ func Lessons() *lessons {
 }
 
 func (t *lessons) Serve(c *gin.Context, user *store.UserMgo, items []*store.LessonDto) (err error) {
+	c.Header("Content-Type", "text/calendar; charset=utf-8")
 	return t.Write(c.Writer, user, items)
 }
 
+// UID is the lesson's event ID. It doesn't change with the lesson, so calendar apps
+// update the event instead of adding another one.
+func UID(lesson bson.ObjectId) string {
+	return fmt.Sprintf("lesson-%s@learnt.io", lesson.Hex())
+}
+
+// status is the event status of the lesson. Lessons that expired without being
+// confirmed never happened, like the cancelled ones.
+func status(state store.LessonState) ical.ObjectStatus {
+	switch state {
+	case store.LessonBooked:
+		return ical.ObjectStatusTentative
+	case store.LessonCancelled, store.LessonExpired:
+		return ical.ObjectStatusCancelled
+	}
+	return ical.ObjectStatusConfirmed
+}
+
+// Write writes the lessons as a calendar in the user's timezone. Each lesson is an
+// event with a stable UID, and its SEQUENCE counts its changes.
 func (t *lessons) Write(w io.Writer, user *store.UserMgo, items []*store.LessonDto) (err error) {
+	loc := user.TimezoneLocation()
+
 	cal := ical.NewCalendar()
-	cal.SetMethod(ical.MethodRequest)
+	cal.SetProductId("-//Learnt//Lessons//EN")
+	cal.SetMethod(ical.MethodPublish)
+	cal.SetXWRCalName("Learnt Lessons")
+	cal.CalendarProperties = append(cal.CalendarProperties, ical.CalendarProperty{BaseProperty: ical.BaseProperty{
+		IANAToken:      "X-WR-TIMEZONE",
+		Value:          loc.String(),
+		ICalParameters: map[string][]string{},
+	}})
+	cal.SetRefreshInterval("PT1H")
+	cal.SetXPublishedTTL("PT1H")
+
+	if loc != time.UTC && len(items) > 0 {
+		from, to := items[0].StartsAt, items[0].EndsAt
+		for _, item := range items {
+			if item.StartsAt.Before(from) {
+				from = item.StartsAt
+			}
+			if item.EndsAt.After(to) {
+				to = item.EndsAt
+			}
+		}
+		cal.Components = append(cal.Components, Timezone(loc, from, to))
+	}
 
 	for _, item := range items {
 		lessonNotesURL, err := core.AppURL("/main/account/calendar/details/%s", item.ID.Hex())
 		if err != nil {
 			return err
 		}
-		start := item.StartsAt
-		end := item.EndsAt
 		summary := fmt.Sprintf("Learnt Session: %s", item.Subject.Name)
 		desc := fmt.Sprintf("Lesson Details: %s", lessonNotesURL)
 
-		toBeHashed := fmt.Sprintf("%s%s%s%s", start, end, summary, desc)
-		id := fmt.Sprintf("%x", md5.Sum([]byte(toBeHashed)))
-		event := cal.AddEvent(id)
-		event.SetCreatedTime(time.Now())
-		event.SetModifiedAt(item.CreatedAt)
+		modified := item.CreatedAt
+		if item.UpdatedAt != nil {
+			modified = *item.UpdatedAt
+		}
+
+		event := cal.AddEvent(UID(item.ID))
+		event.SetCreatedTime(item.CreatedAt)
+		event.SetModifiedAt(modified)
 		event.SetDtStampTime(time.Now())
-		event.SetStartAt(start)
-		event.SetEndAt(end)
+		event.SetSequence(item.Sequence)
+		if loc == time.UTC {
+			event.SetStartAt(item.StartsAt)
+			event.SetEndAt(item.EndsAt)
+		} else {
+			event.SetProperty(ical.ComponentPropertyDtStart, item.StartsAt.In(loc).Format(localTimeFormat), withTZID(loc))
+			event.SetProperty(ical.ComponentPropertyDtEnd, item.EndsAt.In(loc).Format(localTimeFormat), withTZID(loc))
+		}
 		event.SetSummary(summary)
-		event.SetLocation("")
-		event.SetStatus(ical.ObjectStatusConfirmed)
 		event.SetDescription(desc)
+		event.SetURL(lessonNotesURL)
+		event.SetStatus(status(item.State))
 		event.SetTimeTransparency(ical.TransparencyOpaque)
 
-		// TO-DO: may need to fork the lib as it doesn't support the whole RFC, e.g. X-WR-TIMEZONE, CALSCALE, etc
+		if item.Meet == store.MeetInPerson && item.Location != "" {
+			event.SetLocation(item.Location)
+		} else {
+			event.SetLocation("Online")
+		}
+
 		// the organizer appears to be the first in accepted.
-		if item.Accepted[0].Emails != nil {
-			event.SetOrganizer(fmt.Sprintf("mailto:%s", item.Accepted[0].Emails[0].Email), ical.WithCN(fmt.Sprintf("%s", item.Accepted[0].Emails[0].Email)))
+		if len(item.Accepted) > 0 && len(item.Accepted[0].Emails) > 0 {
+			event.SetOrganizer(fmt.Sprintf("mailto:%s", item.Accepted[0].Emails[0].Email), ical.WithCN(item.Accepted[0].Emails[0].Email))
 		}
 
 		for _, att := range item.Accepted {
-			if att.Emails != nil {
-				// do we assume accepted?
-				event.AddAttendee(fmt.Sprintf("%s", att.Emails[0].Email), ical.CalendarUserTypeIndividual, ical.ParticipationRoleReqParticipant, ical.ParticipationStatusAccepted, ical.WithCN(fmt.Sprintf("%s", att.Emails[0].Email)))
+			if len(att.Emails) > 0 {
+				event.AddAttendee(att.Emails[0].Email, ical.CalendarUserTypeIndividual, ical.ParticipationRoleReqParticipant, ical.ParticipationStatusAccepted, ical.WithCN(att.Emails[0].Email))
 			}
 		}
 
+		if status(item.State) != ical.ObjectStatusCancelled {
+			alarm := &ical.VAlarm{}
+			alarm.Properties = []ical.IANAProperty{
+				property(propertyAction, "DISPLAY"),
+				property(ical.ComponentPropertyDescription, ical.ToText(summary)),
+				property(propertyTrigger, reminder),
+			}
+			event.Components = append(event.Components, alarm)
+		}
 	}
 	r := strings.NewReader(cal.Serialize())
 	_, err = r.WriteTo(w)
@@ -0,0 +1,90 @@
+package ics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/learnt/api/pkg/store"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestTimezone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tz := Timezone(loc, time.Date(2021, 2, 1, 0, 0, 0, 0, loc), time.Date(2021, 12, 1, 0, 0, 0, 0, loc)).Serialize()
+
+	for _, want := range []string{
+		"TZID:America/New_York\r\n",
+		"BEGIN:DAYLIGHT\r\nDTSTART:20210314T020000\r\nTZOFFSETFROM:-0500\r\nTZOFFSETTO:-0400\r\nTZNAME:EDT\r\n",
+		"BEGIN:STANDARD\r\nDTSTART:20211107T020000\r\nTZOFFSETFROM:-0400\r\nTZOFFSETTO:-0500\r\nTZNAME:EST\r\n",
+	} {
+		if !strings.Contains(tz, want) {
+			t.Errorf("timezone doesn't have %q:\n%s", want, tz)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	if _, err := time.LoadLocation("Europe/Bucharest"); err != nil {
+		t.Skip(err)
+	}
+
+	user := &store.UserMgo{Timezone: "Europe/Bucharest"}
+	starts := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	confirmed := &store.LessonDto{
+		ID:       bson.NewObjectId(),
+		StartsAt: starts,
+		EndsAt:   starts.Add(time.Hour),
+		State:    store.LessonConfirmed,
+		Sequence: 3,
+	}
+	cancelled := &store.LessonDto{
+		ID:       bson.NewObjectId(),
+		StartsAt: starts.AddDate(0, 0, 1),
+		EndsAt:   starts.AddDate(0, 0, 1).Add(time.Hour),
+		State:    store.LessonCancelled,
+		Sequence: 1,
+	}
+
+	var b bytes.Buffer
+	if err := Lessons().Write(&b, user, []*store.LessonDto{confirmed, cancelled}); err != nil {
+		t.Fatal(err)
+	}
+
+	events := strings.Split(b.String(), "BEGIN:VEVENT")
+	if len(events) != 3 {
+		t.Fatalf("expected 2 events, got %d", len(events)-1)
+	}
+
+	for _, want := range []string{
+		"UID:" + UID(confirmed.ID),
+		"SEQUENCE:3",
+		"DTSTART;TZID=Europe/Bucharest:20210601T150000",
+		"STATUS:CONFIRMED",
+		"TRIGGER:-PT30M",
+	} {
+		if !strings.Contains(events[1], want) {
+			t.Errorf("confirmed lesson doesn't have %q:\n%s", want, events[1])
+		}
+	}
+
+	for _, want := range []string{"UID:" + UID(cancelled.ID), "SEQUENCE:1", "STATUS:CANCELLED"} {
+		if !strings.Contains(events[2], want) {
+			t.Errorf("cancelled lesson doesn't have %q:\n%s", want, events[2])
+		}
+	}
+
+	if strings.Contains(events[2], "BEGIN:VALARM") {
+		t.Error("cancelled lesson has an alarm")
+	}
+
+	if !strings.Contains(events[0], "BEGIN:VTIMEZONE\r\nTZID:Europe/Bucharest") {
+		t.Errorf("calendar has no timezone:\n%s", events[0])
+	}
+}
@@ -0,0 +1,111 @@
+package ics
+
+import (
+	"fmt"
+	"time"
+
+	ical "github.com/arran4/golang-ical"
+)
+
+// timezone properties the library has no component constants for
+const (
+	propertyTzid         = ical.ComponentProperty(ical.PropertyTzid)
+	propertyTzoffsetfrom = ical.ComponentProperty(ical.PropertyTzoffsetfrom)
+	propertyTzoffsetto   = ical.ComponentProperty(ical.PropertyTzoffsetto)
+	propertyTzname       = ical.ComponentProperty(ical.PropertyTzname)
+)
+
+// localTimeFormat is a DATE-TIME in the timezone of its TZID
+const localTimeFormat = "20060102T150405"
+
+// withTZID is the TZID parameter of a time in loc
+func withTZID(loc *time.Location) ical.PropertyParameter {
+	return &ical.KeyValues{Key: string(ical.ParameterTzid), Value: []string{loc.String()}}
+}
+
+// property is a property of a component built by hand
+func property(token ical.ComponentProperty, value string) ical.IANAProperty {
+	return ical.IANAProperty{BaseProperty: ical.BaseProperty{
+		IANAToken:      string(token),
+		Value:          value,
+		ICalParameters: map[string][]string{},
+	}}
+}
+
+// offset formats a UTC offset in seconds like +0130
+func offset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+}
+
+// isDaylight tells if the offset is daylight saving time, more than the smallest
+// offset of the year
+func isDaylight(t time.Time) bool {
+	_, jan := time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location()).Zone()
+	_, jul := time.Date(t.Year(), time.July, 1, 0, 0, 0, 0, t.Location()).Zone()
+	_, off := t.Zone()
+
+	standard := jan
+	if jul < standard {
+		standard = jul
+	}
+
+	return off > standard
+}
+
+// transition finds the second the offset changes between from and to
+func transition(from, to time.Time) time.Time {
+	_, before := from.Zone()
+	for to.Sub(from) > time.Second {
+		mid := from.Add(to.Sub(from) / 2)
+		if _, off := mid.Zone(); off == before {
+			from = mid
+		} else {
+			to = mid
+		}
+	}
+	return to
+}
+
+// observance is the STANDARD or DAYLIGHT time starting at t, after the offset before
+func observance(t time.Time, before int) ical.Component {
+	name, after := t.Zone()
+
+	// the onset is in the local time before the change
+	base := ical.ComponentBase{Properties: []ical.IANAProperty{
+		property(ical.ComponentPropertyDtStart, t.In(time.FixedZone("", before)).Format(localTimeFormat)),
+		property(propertyTzoffsetfrom, offset(before)),
+		property(propertyTzoffsetto, offset(after)),
+		property(propertyTzname, name),
+	}}
+
+	if isDaylight(t) {
+		return &ical.Daylight{ComponentBase: base}
+	}
+	return &ical.Standard{ComponentBase: base}
+}
+
+// Timezone describes loc between from and to, with every change of its offset
+func Timezone(loc *time.Location, from, to time.Time) *ical.VTimezone {
+	tz := &ical.VTimezone{}
+	tz.Properties = append(tz.Properties, property(propertyTzid, loc.String()))
+
+	// the offset at the start, then a day at a time to find the changes
+	t := time.Date(from.Year(), time.January, 1, 0, 0, 0, 0, loc)
+	_, current := t.Zone()
+	tz.Components = append(tz.Components, observance(t, current))
+
+	for ; t.Before(to); t = t.Add(24 * time.Hour) {
+		next := t.Add(24 * time.Hour)
+		if _, off := next.Zone(); off != current {
+			tz.Components = append(tz.Components, observance(transition(t, next), current))
+			current = off
+		}
+	}
+
+	return tz
+}
@@ -57,6 +57,8 @@ func emailLessonReminder(lesson store.LessonMgo, tpl m.Tpl) {
 		}
 	}
 
+	agenda := services.GetLessons().Agenda(&lesson).Text()
+
 	for _, user := range participants {
 		lessonDayTime := lesson.WhenFormattedWithTimezone(user.Timezone)
 
@@ -64,6 +66,7 @@ func emailLessonReminder(lesson store.LessonMgo, tpl m.Tpl) {
 		go d.Send(user, tpl, &m.P{
 			"FIRST_NAME":    user.GetFirstName(),
 			"CLASSROOM_URL": roomURL,
+			"AGENDA":        agenda,
 		})
 	}
 }
@@ -114,6 +117,7 @@ func emailDailyReminder(lesson store.LessonMgo, now time.Time) {
 			go d.Send(user, m.TPL_LESSON_REMINDER_7AM, &m.P{
 				"FIRST_NAME":    user.GetFirstName(),
 				"CLASSROOM_URL": roomURL,
+				"AGENDA":        services.GetLessons().Agenda(&lesson).Text(),
 			})
 		}
 	}
@@ -199,3 +203,77 @@ func (lr WeeklyProfileReminder) WeeklyProfileReminder() {
 		}
 	}
 }
+
+// NoShowDetector marks the lessons nobody attended once their grace period passed
+type NoShowDetector struct {
+	GracePeriod time.Duration
+}
+
+func (nd NoShowDetector) DetectNoShows() {
+	until := time.Now().UTC().Add(-nd.GracePeriod)
+	lessons, err := store.GetLessonsStore().GetLessonsToCheckAttendance(until.Add(-24*time.Hour), until)
+	if err != nil {
+		logger.Get().Errorf("couldn't get lessons to check for no-shows: %v", err)
+		return
+	}
+
+	for i := range lessons {
+		if err := services.GetLessons().DetectNoShow(&lessons[i]); err != nil {
+			logger.Get().Errorf("couldn't check lesson %s for no-shows: %v", lessons[i].ID.Hex(), err)
+		}
+	}
+}
+
+type RescheduleExpirer struct{}
+
+func (re RescheduleExpirer) ExpireReschedules() {
+	services.GetLessons().ExpireReschedules()
+}
+
+type PackageExpirer struct{}
+
+func (pe PackageExpirer) ExpirePackages() {
+	services.GetPackages().ExpirePackages()
+}
+
+// GiftCardExpirer expires the gift cards that weren't redeemed in time
+type GiftCardExpirer struct{}
+
+func (ge GiftCardExpirer) ExpireGiftCards() {
+	services.GetGiftCards().ExpireGiftCards()
+}
+
+// HoldRenewer places again the holds on the students' cards that expire before their lesson
+type HoldRenewer struct{}
+
+func (hr HoldRenewer) RenewHolds() {
+	services.GetLessons().RenewHolds()
+}
+
+// PayoutScheduler creates the tutors' payout batches on the configured schedule
+type PayoutScheduler struct{}
+
+func (ps PayoutScheduler) RunSchedule() {
+	services.GetPayouts().RunSchedule()
+}
+
+type InstantRequestExpirer struct{}
+
+func (ie InstantRequestExpirer) TimeOutRequests() {
+	services.GetInstantSessions().TimeOutRequests()
+}
+
+type ExternalCalendarSyncer struct{}
+
+func (es ExternalCalendarSyncer) SyncCalendars() {
+	services.GetExternalCalendars().SyncAll()
+}
+
+// AssignmentReminder reminds the students of the assignments due soon they didn't submit
+type AssignmentReminder struct {
+	Before time.Duration
+}
+
+func (ar AssignmentReminder) RemindAssignments() {
+	services.GetAssignments().RemindDue(ar.Before)
+}
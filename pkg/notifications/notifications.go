@@ -102,6 +102,31 @@ const (
 
 	LessonNotifyBefore
 	FavoriteTutorOnline
+
+	LessonStudentJoined
+	LessonStudentLeft
+
+	LessonNoShow
+	LessonRescheduleExpired
+
+	LessonPackageExpired
+
+	CourseEnrolled
+	CourseCompleted
+
+	AssignmentCreated
+	AssignmentSubmitted
+	AssignmentGraded
+	AssignmentDue
+
+	LessonAgendaUpdated
+
+	LessonRefunded
+	PaymentFailed
+	PayoutsDisabled
+	PayoutPaid
+
+	PromoCodeNotApplied
 )
 
 func Notify(request *NotifyRequest) (response chan *NotifyResponse) {
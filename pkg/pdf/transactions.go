@@ -3,6 +3,7 @@ package pdf
 import (
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"gitlab.com/learnt/api/pkg/core"
@@ -42,6 +43,9 @@ func (t *transactions) Write(w io.Writer, user *store.UserMgo, items []*store.Tr
 
 	width, height := pdf.GetPageSize()
 
+	// the core fonts aren't UTF-8, currency symbols like £ are translated to their encoding
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
 	page := func() {
 		pdf.AddPage()
 
@@ -78,6 +82,9 @@ func (t *transactions) Write(w io.Writer, user *store.UserMgo, items []*store.Tr
 	page()
 
 	var row = 1
+	// the transactions can be in more than one currency, each gets its own total
+	totals := make(map[store.Currency]float64)
+	var currencies []store.Currency
 
 	for _, item := range items {
 		var description string
@@ -87,17 +94,16 @@ func (t *transactions) Write(w io.Writer, user *store.UserMgo, items []*store.Tr
 			description = item.Lesson.StudentsNames(false)
 		}
 
-		var amount string
-		if item.Amount > 0 {
-			amount = fmt.Sprintf("$%.2f", item.Amount)
-		} else {
-			amount = fmt.Sprintf("-$%.2f", -item.Amount)
+		currency := item.Currency.OrDefault()
+		if _, ok := totals[currency]; !ok {
+			currencies = append(currencies, currency)
 		}
+		totals[currency] += item.Amount
 
 		pdf.Cell(cellWidthRef, cellHeight, item.Reference)
 		pdf.Cell(width-cellWidthStatus-cellWidthAmount-cellWidthDate-50, cellHeight, description)
 		pdf.Cell(cellWidthStatus, cellHeight, item.Status)
-		pdf.Cell(cellWidthAmount, cellHeight, amount)
+		pdf.Cell(cellWidthAmount, cellHeight, tr(formatAmount(item.Amount, currency)))
 		pdf.CellFormat(cellWidthDate, cellHeight, item.Time.Format("02 Jan 2006"), "", 0, "R", false, 0, "")
 
 		Y := row*cellHeight + 30
@@ -112,5 +118,26 @@ func (t *transactions) Write(w io.Writer, user *store.UserMgo, items []*store.Tr
 		}
 	}
 
+	if len(currencies) == 0 {
+		currencies = append(currencies, store.DefaultCurrency)
+	}
+
+	for _, currency := range currencies {
+		label := "Total"
+		if len(currencies) > 1 {
+			label = fmt.Sprintf("Total %s", strings.ToUpper(string(currency)))
+		}
+
+		pdf.Cell(cellWidthRef, cellHeight, label)
+		pdf.Cell(width-cellWidthAmount-cellWidthDate-50, cellHeight, "")
+		pdf.Cell(cellWidthAmount, cellHeight, tr(formatAmount(totals[currency], currency)))
+		pdf.Ln(cellHeight)
+	}
+
 	return pdf.Output(w)
 }
+
+// formatAmount writes the amount, in major units, with the symbol of its currency
+func formatAmount(amount float64, currency store.Currency) string {
+	return currency.Format(store.MinorUnits(amount))
+}
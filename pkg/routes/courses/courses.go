@@ -0,0 +1,263 @@
+package courses
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/learnt/api/pkg/core"
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/routes/auth"
+	"gitlab.com/learnt/api/pkg/services"
+	"gitlab.com/learnt/api/pkg/store"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// getCourse gets the course of the route, or responds that it's missing
+func getCourse(c *gin.Context) (*store.Course, bool) {
+	if !bson.IsObjectIdHex(c.Param("course")) {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("invalid course id"))
+		return nil, false
+	}
+
+	course, ok := store.GetCourse(bson.ObjectIdHex(c.Param("course")))
+	if !ok {
+		c.JSON(http.StatusNotFound, core.NewErrorResponse("course not found"))
+		return nil, false
+	}
+
+	return course, true
+}
+
+// enrollmentDTOs fills out the enrollments, the ones that fail are left out
+func enrollmentDTOs(c *gin.Context, enrollments []store.CourseEnrollment) []*store.EnrollmentDto {
+	dtos := make([]*store.EnrollmentDto, 0, len(enrollments))
+	for i := range enrollments {
+		dto, err := enrollments[i].DTO()
+		if err != nil {
+			logger.GetCtx(c).Errorf("couldn't get enrollment %s: %v", enrollments[i].ID.Hex(), err)
+			continue
+		}
+		dtos = append(dtos, dto)
+	}
+	return dtos
+}
+
+// coursesHandler lists the tutor's courses. Tutors see their closed courses too.
+func coursesHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	tutor := user.ID
+	if id := c.Query("tutor"); id != "" {
+		if !bson.IsObjectIdHex(id) {
+			c.JSON(http.StatusBadRequest, core.NewErrorResponse("invalid tutor id"))
+			return
+		}
+		tutor = bson.ObjectIdHex(id)
+	}
+
+	courses, err := store.GetTutorCourses(tutor, tutor == user.ID || auth.IsAdmin(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	if courses == nil {
+		courses = make([]store.Course, 0)
+	}
+
+	c.JSON(http.StatusOK, courses)
+}
+
+func courseHandler(c *gin.Context) {
+	course, ok := getCourse(c)
+	if !ok {
+		return
+	}
+
+	dto, err := course.DTO()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, dto)
+}
+
+func createCourseHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	var course store.Course
+	if err := c.BindJSON(&course); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("invalid fields"))
+		return
+	}
+
+	if err := services.GetCourses().Create(user, &course); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, course)
+}
+
+func updateCourseHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	course, ok := getCourse(c)
+	if !ok {
+		return
+	}
+
+	var changes store.Course
+	if err := c.BindJSON(&changes); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("invalid fields"))
+		return
+	}
+
+	if err := services.GetCourses().Update(user, course, &changes); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, course)
+}
+
+func closeCourseHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	course, ok := getCourse(c)
+	if !ok {
+		return
+	}
+
+	if err := services.GetCourses().Close(user, course); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, course)
+}
+
+func enrollHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	course, ok := getCourse(c)
+	if !ok {
+		return
+	}
+
+	var req services.EnrollRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("invalid fields"))
+		return
+	}
+
+	enrollment, err := services.GetCourses().Enroll(user, course, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	dto, err := enrollment.DTO()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, dto)
+}
+
+// courseEnrollmentsHandler lists the students enrolled in the tutor's course
+func courseEnrollmentsHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	course, ok := getCourse(c)
+	if !ok {
+		return
+	}
+
+	if course.Tutor != user.ID && !auth.IsAdmin(c) {
+		c.JSON(http.StatusForbidden, core.NewErrorResponse("course isn't yours"))
+		return
+	}
+
+	enrollments, err := store.GetCourseEnrollments(course.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollmentDTOs(c, enrollments))
+}
+
+// enrollmentsHandler lists the courses the user teaches or takes, with their progress
+func enrollmentsHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	enrollments, err := store.GetUserEnrollments(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollmentDTOs(c, enrollments))
+}
+
+func enrollmentHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	if !bson.IsObjectIdHex(c.Param("enrollment")) {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("invalid enrollment id"))
+		return
+	}
+
+	enrollment, ok := store.GetEnrollment(bson.ObjectIdHex(c.Param("enrollment")))
+	if !ok || (enrollment.Tutor != user.ID && enrollment.Student != user.ID && !auth.IsAdmin(c)) {
+		c.JSON(http.StatusNotFound, core.NewErrorResponse("enrollment not found"))
+		return
+	}
+
+	dto, err := enrollment.DTO()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, dto)
+}
+
+func Setup(g *gin.RouterGroup) {
+	g.GET("", coursesHandler)
+	g.POST("", createCourseHandler)
+	g.GET("/id/:course", courseHandler)
+	g.PUT("/id/:course", updateCourseHandler)
+	g.DELETE("/id/:course", closeCourseHandler)
+	g.POST("/id/:course/enroll", enrollHandler)
+	g.GET("/id/:course/enrollments", courseEnrollmentsHandler)
+	g.GET("/enrollments", enrollmentsHandler)
+	g.GET("/enrollments/:enrollment", enrollmentHandler)
+}
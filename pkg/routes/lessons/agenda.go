@@ -0,0 +1,55 @@
+package lessons
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/services"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+// agendaDTO is the lesson's agenda with its files, empty when it has none
+func agendaDTO(lesson *store.LessonMgo) *store.LessonAgendaDto {
+	if agenda := services.GetLessons().Agenda(lesson); agenda != nil {
+		return agenda
+	}
+
+	return &store.LessonAgendaDto{
+		LessonAgenda: &store.LessonAgenda{
+			Topics: make([]store.AgendaTopic, 0),
+			Files:  make([]bson.ObjectId, 0),
+		},
+		Files: make([]*store.FilesDto, 0),
+	}
+}
+
+func agendaHandler(c *gin.Context) {
+	_, lesson, ok := setup(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, agendaDTO(lesson))
+}
+
+func agendaUpdateHandler(c *gin.Context) {
+	user, lesson, ok := setup(c)
+	if !ok {
+		return
+	}
+
+	var agenda store.LessonAgenda
+	if err := c.BindJSON(&agenda); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't bind form", Raw: err.Error()})
+		return
+	}
+
+	if err := services.GetLessons().SetAgenda(user, lesson, &agenda); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, agendaDTO(lesson))
+}
@@ -0,0 +1,200 @@
+package lessons
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/services"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+type submitForm struct {
+	Text    string          `json:"text"`
+	Uploads []bson.ObjectId `json:"uploads"`
+}
+
+type gradeForm struct {
+	Student bson.ObjectId `json:"student" binding:"required"`
+	Grade   *int          `json:"grade" binding:"required"`
+	Comment string        `json:"comment"`
+}
+
+// setupAssignment gets the lesson and the assignment from the route
+func setupAssignment(c *gin.Context) (*store.UserMgo, *store.LessonMgo, *store.Assignment, bool) {
+	user, lesson, ok := setup(c)
+	if !ok {
+		return nil, nil, nil, false
+	}
+
+	if !bson.IsObjectIdHex(c.Param("assignment")) {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "invalid assignment id"})
+		return nil, nil, nil, false
+	}
+
+	assignment, exist := store.GetAssignment(bson.ObjectIdHex(c.Param("assignment")))
+	if !exist || assignment.Lesson != lesson.ID {
+		c.JSON(http.StatusNotFound, response{Error: true, Message: "assignment not found"})
+		return nil, nil, nil, false
+	}
+
+	return user, lesson, assignment, true
+}
+
+func assignmentListHandler(c *gin.Context) {
+	_, lesson, ok := setup(c)
+	if !ok {
+		return
+	}
+
+	assignments, err := store.GetLessonAssignments(lesson.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response{Error: true, Message: "couldn't get assignments", Raw: err.Error()})
+		return
+	}
+
+	dtos := make([]*store.AssignmentDto, 0, len(assignments))
+	for i := range assignments {
+		dto, err := assignments[i].DTO()
+		if err != nil {
+			logger.GetCtx(c).Errorf("couldn't get assignment %s: %v", assignments[i].ID.Hex(), err)
+			continue
+		}
+		dtos = append(dtos, dto)
+	}
+
+	c.JSON(http.StatusOK, dtos)
+}
+
+func assignmentCreateHandler(c *gin.Context) {
+	user, lesson, ok := setup(c)
+	if !ok {
+		return
+	}
+
+	var assignment store.Assignment
+	if err := c.BindJSON(&assignment); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't bind form", Raw: err.Error()})
+		return
+	}
+
+	if err := services.GetAssignments().Create(user, lesson, &assignment); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, assignment)
+}
+
+func assignmentUpdateHandler(c *gin.Context) {
+	user, _, assignment, ok := setupAssignment(c)
+	if !ok {
+		return
+	}
+
+	var changes store.Assignment
+	if err := c.BindJSON(&changes); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't bind form", Raw: err.Error()})
+		return
+	}
+
+	if err := services.GetAssignments().Update(user, assignment, &changes); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, assignment)
+}
+
+func assignmentDeleteHandler(c *gin.Context) {
+	user, _, assignment, ok := setupAssignment(c)
+	if !ok {
+		return
+	}
+
+	if err := services.GetAssignments().Delete(user, assignment); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func assignmentSubmitHandler(c *gin.Context) {
+	user, lesson, assignment, ok := setupAssignment(c)
+	if !ok {
+		return
+	}
+
+	var f submitForm
+	if err := c.BindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't bind form", Raw: err.Error()})
+		return
+	}
+
+	submission, err := services.GetAssignments().Submit(user, lesson, assignment, f.Text, f.Uploads)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, submission)
+}
+
+// submissionListHandler lists the work submitted for the assignment. Students only
+// see their own.
+func submissionListHandler(c *gin.Context) {
+	user, _, assignment, ok := setupAssignment(c)
+	if !ok {
+		return
+	}
+
+	if assignment.Tutor != user.ID {
+		submissions := make([]*store.AssignmentSubmission, 0, 1)
+		if submission, exist := store.GetSubmission(assignment.ID, user.ID); exist {
+			submissions = append(submissions, submission)
+		}
+		c.JSON(http.StatusOK, submissions)
+		return
+	}
+
+	submissions, err := store.GetSubmissions(assignment.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response{Error: true, Message: "couldn't get submissions", Raw: err.Error()})
+		return
+	}
+
+	if submissions == nil {
+		submissions = make([]store.AssignmentSubmission, 0)
+	}
+
+	c.JSON(http.StatusOK, submissions)
+}
+
+func assignmentGradeHandler(c *gin.Context) {
+	user, _, assignment, ok := setupAssignment(c)
+	if !ok {
+		return
+	}
+
+	var f gradeForm
+	if err := c.BindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't bind form", Raw: err.Error()})
+		return
+	}
+
+	submission, exist := store.GetSubmission(assignment.ID, f.Student)
+	if !exist {
+		c.JSON(http.StatusNotFound, response{Error: true, Message: "submission not found"})
+		return
+	}
+
+	if err := services.GetAssignments().Grade(user, assignment, submission, *f.Grade, f.Comment); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, submission)
+}
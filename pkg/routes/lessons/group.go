@@ -0,0 +1,131 @@
+package lessons
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/core"
+	"gitlab.com/learnt/api/pkg/services"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+// getOpenGroupLessons lists the upcoming group lessons that still have open seats,
+// optionally filtered by subject
+func getOpenGroupLessons(c *gin.Context) {
+	var subject bson.ObjectId
+	if s := c.Query("subject"); s != "" {
+		if !bson.IsObjectIdHex(s) {
+			c.JSON(http.StatusBadRequest, response{Error: true, Message: "subject is not a valid id"})
+			return
+		}
+		subject = bson.ObjectIdHex(s)
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil {
+		limit = 20
+	}
+
+	offset, err := strconv.Atoi(c.Query("offset"))
+	if err != nil {
+		offset = 0
+	}
+
+	paginated, err := services.GetLessons().GetOpenGroupLessons(subject, offset, limit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't get lessons", Raw: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, paginatedLessons{store.LessonsToDTO(paginated.Lessons), paginated.Length})
+}
+
+// groupLesson returns the logged user and the lesson from the path, without
+// requiring the user to be a participant of the lesson
+func groupLesson(c *gin.Context) (*store.UserMgo, *store.LessonMgo, bool) {
+	user, exist := store.GetUser(c)
+	if !exist {
+		return nil, nil, false
+	}
+
+	if !bson.IsObjectIdHex(c.Param("lesson")) {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "invalid lesson id"})
+		return nil, nil, false
+	}
+
+	lesson, exist := store.GetLessonsStore().Get(bson.ObjectIdHex(c.Param("lesson")))
+	if !exist {
+		c.JSON(http.StatusNotFound, response{Error: true, Message: "lesson not found"})
+		return nil, nil, false
+	}
+
+	return user, lesson, true
+}
+
+func joinHandler(c *gin.Context) {
+	user, lesson, ok := groupLesson(c)
+	if !ok {
+		return
+	}
+
+	if err := services.GetLessons().Join(lesson, user); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	dto, err := lesson.DTO()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, dto)
+}
+
+func leaveHandler(c *gin.Context) {
+	user, lesson, ok := setup(c)
+	if !ok {
+		return
+	}
+
+	cancellation, err := services.GetLessons().Leave(lesson, user)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, cancellation)
+}
+
+type capacityRequest struct {
+	Capacity int `json:"capacity" binding:"required"`
+}
+
+func capacityHandler(c *gin.Context) {
+	user, lesson, ok := setup(c)
+	if !ok {
+		return
+	}
+
+	var req capacityRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("invalid capacity"))
+		return
+	}
+
+	if err := services.GetLessons().SetCapacity(lesson, user, req.Capacity); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	dto, err := lesson.DTO()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, dto)
+}
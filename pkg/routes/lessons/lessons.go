@@ -69,6 +69,13 @@ func get(c *gin.Context) {
 		c.JSON(http.StatusOK, paginatedLessons{lessonsDTO, paginated.Length})
 		return
 	}
+
+	// gets group lessons with open seats students can join
+	if c.Query("open") == "true" {
+		getOpenGroupLessons(c)
+		return
+	}
+
 	user, exists := store.GetUser(c)
 
 	tutorID := c.Query("tutorId")
@@ -343,16 +350,14 @@ func propose(c *gin.Context) {
 		return
 	}
 
-	if lesson.Tutor.Hex() == tutor.ID.Hex() {
-		if !user.IsFreeIgnoreIDs(req.When, lesson.Duration(), []bson.ObjectId{lesson.ID}) {
-			c.JSON(http.StatusBadRequest, response{Error: true, Message: "student isn't free"})
-			return
-		}
-	} else {
-		if !tutor.IsFreeIgnoreIDs(req.When, lesson.Duration(), []bson.ObjectId{lesson.ID}) {
-			c.JSON(http.StatusBadRequest, response{Error: true, Message: "tutor isn't free"})
-			return
-		}
+	meet := store.Meet(req.Meet)
+	if meet != store.MeetOnline && meet != store.MeetInPerson {
+		meet = lesson.Meet
+	}
+
+	if !tutor.IsFreeFor(req.When, lesson.Duration(), meet, []bson.ObjectId{lesson.ID}) {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "tutor isn't free"})
+		return
 	}
 
 	for _, studentID := range lesson.Students {
@@ -461,8 +466,12 @@ func propose(c *gin.Context) {
 		logger.GetCtx(c).Errorf("couldn't send message notification: %v", err)
 	}
 
-	if err := lesson.SetState(store.LessonBooked, nil); err != nil {
-		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't change lesson status to booked"})
+	if err := lesson.SetState(store.LessonBooked, store.StateChange{
+		Actor:  user,
+		Reason: "lesson change proposed",
+		Scope:  store.ScopeOccurrence,
+	}); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't change lesson status to booked", Raw: err.Error()})
 		return
 	}
 
@@ -561,22 +570,23 @@ func proposeAccept(c *gin.Context) {
 		return
 	}
 
-	all := false
-	if c.Query("all") != "" {
-		all, err = strconv.ParseBool(c.Query("all"))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, response{Error: true, Message: "query param(s) invalid", Raw: err})
-			return
-		}
+	scope, err := seriesScope(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "query param(s) invalid", Raw: err.Error()})
+		return
 	}
 
-	if err := lesson.SetState(store.LessonConfirmed, &all); err != nil {
+	if err := lesson.SetState(store.LessonConfirmed, store.StateChange{
+		Actor:  user,
+		Reason: "lesson change accepted",
+		Scope:  scope,
+	}); err != nil {
 		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't set lesson as confirmed", Raw: err.Error()})
 		return
 	}
 
 	change := lesson.ChangeProposals[len(lesson.ChangeProposals)-1]
-	if _, err := change.Apply(lesson.ID); err != nil {
+	if _, err := change.Apply(lesson.ID, scope); err != nil {
 		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't apply changes to lesson", Raw: err.Error()})
 		return
 	}
@@ -611,32 +621,23 @@ func proposeDecline(c *gin.Context) {
 
 	// cancelling the lesson
 
-	if lesson.State == store.LessonCancelled {
-		c.JSON(http.StatusBadRequest, response{Error: true, Message: "can't modify cancelled lesson"})
-		return
-	}
-
 	role := "student"
 	if lesson.Tutor.Hex() == user.ID.Hex() {
 		role = "tutor"
 	}
 
-	var err error
-
-	all := false
-	if c.Query("all") != "" {
-		all, err = strconv.ParseBool(c.Query("all"))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, response{Error: true, Message: "query param(s) invalid", Raw: err})
-			return
-		}
+	scope, err := seriesScope(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "query param(s) invalid", Raw: err.Error()})
+		return
 	}
 
-	if err := lesson.SetState(store.LessonCancelled, &all, map[string]interface{}{
-		"user":   user.Name(),
-		"reason": fmt.Sprintf("Lesson was cancelled by %s %s for declining the change proposal.", role, user.Name()),
+	if err := lesson.SetState(store.LessonCancelled, store.StateChange{
+		Actor:  user,
+		Reason: fmt.Sprintf("Lesson was cancelled by %s %s for declining the change proposal.", role, user.Name()),
+		Scope:  scope,
 	}); err != nil {
-		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't set cancelled state to lesson", Raw: err})
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't set cancelled state to lesson", Raw: err.Error()})
 		return
 	}
 
@@ -666,6 +667,27 @@ func getOne(c *gin.Context) {
 	c.JSON(http.StatusOK, dto)
 }
 
+// seriesScope reads which lessons of a recurring series a change applies to, from
+// the scope query param. all=true is kept for clients that don't send the scope.
+func seriesScope(c *gin.Context) (store.SeriesScope, error) {
+	if c.Query("scope") != "" {
+		return store.ParseSeriesScope(c.Query("scope"))
+	}
+
+	if c.Query("all") != "" {
+		all, err := strconv.ParseBool(c.Query("all"))
+		if err != nil {
+			return store.ScopeOccurrence, err
+		}
+
+		if all {
+			return store.ScopeFollowing, nil
+		}
+	}
+
+	return store.ScopeOccurrence, nil
+}
+
 func getCurrentRunningLessons(c *gin.Context) (*store.PaginatedLessons, error) {
 	// only allow admin to access
 	if !auth.IsAdmin(c) {
@@ -739,11 +761,6 @@ func cancelHandler(c *gin.Context) {
 		return
 	}
 
-	if lesson.State == store.LessonCancelled {
-		c.JSON(http.StatusBadRequest, response{Error: true, Message: "can't modify cancelled lesson"})
-		return
-	}
-
 	role := "student"
 	if lesson.Tutor.Hex() == user.ID.Hex() {
 		role = "tutor"
@@ -755,22 +772,52 @@ func cancelHandler(c *gin.Context) {
 		return
 	}
 
-	all := false
+	scope, err := seriesScope(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "query param(s) invalid", Raw: err.Error()})
+		return
+	}
 
-	var err error
-	if c.Query("all") != "" {
-		all, err = strconv.ParseBool(c.Query("all"))
+	// a student cancelling a group lesson gives up their seat, the lesson goes on for the others
+	if lesson.IsGroup() && lesson.HasStudent(user.ID) {
+		cancellation, err := services.GetLessons().Leave(lesson, user)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, response{Error: true, Message: "query param(s) invalid", Raw: err})
+			c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't leave the lesson", Raw: err.Error()})
 			return
 		}
+
+		c.JSON(http.StatusOK, cancellation)
+		return
 	}
 
-	if err := lesson.SetState(store.LessonCancelled, &all, map[string]interface{}{
-		"user":   user.Name(),
-		"reason": fmt.Sprintf("Lesson was cancelled by %s %s using reason %q.", role, user.Name(), f.Reason),
-	}); err != nil {
-		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't set cancelled state to lesson", Raw: err})
+	cancelled, err := lesson.SetScopedState(store.LessonCancelled, store.StateChange{
+		Actor:  user,
+		Reason: fmt.Sprintf("Lesson was cancelled by %s %s using reason %q.", role, user.Name(), f.Reason),
+		Scope:  scope,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't set cancelled state to lesson", Raw: err.Error()})
+		return
+	}
+
+	// every lesson this cancelled is billed under the policy, the lessons of the series
+	// someone else cancelled meanwhile were billed by them. A lesson that couldn't be
+	// billed doesn't keep the others from being billed.
+	var cancellation *store.LessonCancellation
+	var policyErr error
+	for _, cancelledLesson := range cancelled {
+		applied, err := services.GetLessons().ApplyCancellationPolicy(cancelledLesson, user)
+		if err != nil && policyErr == nil {
+			policyErr = err
+		}
+
+		if cancelledLesson == lesson {
+			cancellation = applied
+		}
+	}
+
+	if policyErr != nil {
+		c.JSON(http.StatusInternalServerError, response{Error: true, Message: "couldn't apply the cancellation policy", Raw: policyErr.Error()})
 		return
 	}
 
@@ -789,8 +836,6 @@ func cancelHandler(c *gin.Context) {
 		data["TUTOR_NAME"] = lesson.GetTutor().Name()
 		data["STUDENT_NAME"] = user.Name()
 		if lesson.StartsWithin24Hours() {
-			services.GetLessons().AuthorizeCharge(user, lesson)
-
 			if err = d.Send(lesson.GetTutor(), m.TPL_CANCELLED_WITHIN_24_HOURS, &data); err == nil {
 				err = mail.GetSender(conf).SendTo(m.HIRING_EMAIL, m.TPL_CANCELLED_WITHIN_24_HOURS, &data)
 			}
@@ -821,5 +866,52 @@ func cancelHandler(c *gin.Context) {
 		return
 	}
 
-	c.Status(http.StatusOK)
+	c.JSON(http.StatusOK, cancellation)
+}
+
+type cancellationOverrideForm struct {
+	Student *bson.ObjectId `json:"student"`
+	store.CancellationOverride
+}
+
+// overrideCancellationHandler lets admins change the refund and penalty applied to a
+// cancelled lesson, or to a student that left a group lesson.
+func overrideCancellationHandler(c *gin.Context) {
+	admin, exist := store.GetUser(c)
+	if !exist {
+		return
+	}
+
+	if !bson.IsObjectIdHex(c.Param("lesson")) {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "invalid lesson id"})
+		return
+	}
+
+	lesson, exist := store.GetLessonsStore().Get(bson.ObjectIdHex(c.Param("lesson")))
+	if !exist {
+		c.JSON(http.StatusNotFound, response{Error: true, Message: "lesson not found"})
+		return
+	}
+
+	var f cancellationOverrideForm
+	if err := c.BindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't bind form", Raw: err})
+		return
+	}
+
+	var student *store.UserMgo
+	if f.Student != nil {
+		if student, exist = services.NewUsers().ByID(*f.Student); !exist {
+			c.JSON(http.StatusNotFound, response{Error: true, Message: "student not found"})
+			return
+		}
+	}
+
+	cancellation, err := services.GetLessons().OverrideCancellation(lesson, admin, student, &f.CancellationOverride)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't override the cancellation", Raw: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, cancellation)
 }
@@ -0,0 +1,35 @@
+package lessons
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"gitlab.com/learnt/api/pkg/services"
+)
+
+type promoCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// promoCodeHandler sets the promo code the logged student's seat is charged with.
+// An empty code removes it.
+func promoCodeHandler(c *gin.Context) {
+	user, lesson, ok := setup(c)
+	if !ok {
+		return
+	}
+
+	var req promoCodeRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "invalid form"})
+		return
+	}
+
+	if err := services.GetLessons().ApplyPromoCode(lesson, user, req.Code); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
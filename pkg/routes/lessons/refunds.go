@@ -0,0 +1,44 @@
+package lessons
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/services"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+// refundHandler refunds what a student paid for the lesson. Admins only.
+func refundHandler(c *gin.Context) {
+	admin, exist := store.GetUser(c)
+	if !exist {
+		return
+	}
+
+	if !bson.IsObjectIdHex(c.Param("lesson")) {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "invalid lesson id"})
+		return
+	}
+
+	lesson, exist := store.GetLessonsStore().Get(bson.ObjectIdHex(c.Param("lesson")))
+	if !exist {
+		c.JSON(http.StatusNotFound, response{Error: true, Message: "lesson not found"})
+		return
+	}
+
+	var params services.RefundParams
+	if err := c.BindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't bind form", Raw: err.Error()})
+		return
+	}
+
+	refund, err := services.GetPayments().RefundLesson(lesson, admin, params)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't refund the lesson", Raw: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, refund)
+}
@@ -0,0 +1,146 @@
+package lessons
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/services"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+type rescheduleForm struct {
+	Slots []store.RescheduleSlot `json:"slots" binding:"required"`
+}
+
+type rescheduleAcceptForm struct {
+	Slot int `json:"slot"`
+}
+
+func rescheduleError(c *gin.Context, message string, err error) {
+	if lessonErr, ok := err.(*services.LessonErr); ok {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: lessonErr.Message, Raw: lessonErr})
+		return
+	}
+	c.JSON(http.StatusBadRequest, response{Error: true, Message: message, Raw: err.Error()})
+}
+
+// setupProposal gets the lesson and the reschedule proposal from the route
+func setupProposal(c *gin.Context) (*store.UserMgo, *store.LessonMgo, *store.RescheduleProposal, bool) {
+	user, lesson, ok := setup(c)
+	if !ok {
+		return nil, nil, nil, false
+	}
+
+	if !bson.IsObjectIdHex(c.Param("proposal")) {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "invalid proposal id"})
+		return nil, nil, nil, false
+	}
+
+	proposal, exist := store.GetRescheduleProposal(bson.ObjectIdHex(c.Param("proposal")))
+	if !exist || proposal.Lesson != lesson.ID {
+		c.JSON(http.StatusNotFound, response{Error: true, Message: "proposal not found"})
+		return nil, nil, nil, false
+	}
+
+	return user, lesson, proposal, true
+}
+
+func rescheduleListHandler(c *gin.Context) {
+	_, lesson, ok := setup(c)
+	if !ok {
+		return
+	}
+
+	proposals, err := store.GetRescheduleProposals(lesson.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response{Error: true, Message: "couldn't get reschedule proposals", Raw: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, proposals)
+}
+
+func rescheduleHandler(c *gin.Context) {
+	user, lesson, ok := setup(c)
+	if !ok {
+		return
+	}
+
+	var f rescheduleForm
+	if err := c.BindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't bind form", Raw: err})
+		return
+	}
+
+	proposal, err := services.GetLessons().ProposeReschedule(lesson, user, f.Slots)
+	if err != nil {
+		rescheduleError(c, "couldn't propose reschedule", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, proposal)
+}
+
+func rescheduleCounterHandler(c *gin.Context) {
+	user, lesson, proposal, ok := setupProposal(c)
+	if !ok {
+		return
+	}
+
+	var f rescheduleForm
+	if err := c.BindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't bind form", Raw: err})
+		return
+	}
+
+	counter, err := services.GetLessons().CounterReschedule(lesson, proposal, user, f.Slots)
+	if err != nil {
+		rescheduleError(c, "couldn't counter reschedule", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, counter)
+}
+
+func rescheduleAcceptHandler(c *gin.Context) {
+	user, lesson, proposal, ok := setupProposal(c)
+	if !ok {
+		return
+	}
+
+	var f rescheduleAcceptForm
+	if err := c.BindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't bind form", Raw: err})
+		return
+	}
+
+	updated, err := services.GetLessons().AcceptReschedule(lesson, proposal, user, f.Slot)
+	if err != nil {
+		rescheduleError(c, "couldn't accept reschedule", err)
+		return
+	}
+
+	dto, err := updated.DTO()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: true, Message: "couldn't get lesson", Raw: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto)
+}
+
+func rescheduleDeclineHandler(c *gin.Context) {
+	user, lesson, proposal, ok := setupProposal(c)
+	if !ok {
+		return
+	}
+
+	if err := services.GetLessons().DeclineReschedule(lesson, proposal, user); err != nil {
+		rescheduleError(c, "couldn't decline reschedule", err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -2,15 +2,11 @@ package lessons
 
 import (
 	"context"
-	"fmt"
 	"net/http"
-	"time"
-	"log"
 
 	"github.com/gin-gonic/gin"
 	"gitlab.com/learnt/api/config"
 	"gitlab.com/learnt/api/pkg/core"
-	"gitlab.com/learnt/api/pkg/logger"
 	"gitlab.com/learnt/api/pkg/routes/auth"
 	"gitlab.com/learnt/api/pkg/services"
 	"gitlab.com/learnt/api/pkg/services/delivery"
@@ -176,16 +172,9 @@ func noteListHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, notes)
 }
 
-type InstantSessionRequest struct {
-	Student *store.UserMgo `json:"student"`
-	Tutor   *store.UserMgo `json:"tutor"`
-	Subject *store.Subject `json:"subject"`
-	Expire  time.Time
-}
-
-// InstantLessonRequests map[TutorID] = Request
-var InstantLessonRequests = make(map[string]*InstantSessionRequest)
-
+// onInstantSessionRequest handles the instant requests. Students POST the subject to
+// broadcast a request to the online tutors, tutors POST the request to accept it.
+// DELETE declines the request for tutors and cancels it for students.
 func onInstantSessionRequest(c *gin.Context) {
 	if c.Param("lesson") != "instant" {
 		return
@@ -197,41 +186,33 @@ func onInstantSessionRequest(c *gin.Context) {
 		return
 	}
 
-	// When tutor request this endpoint
-	if existentRequest, exist := InstantLessonRequests[user.ID.Hex()]; exist {
-		if c.Request.Method == "DELETE" {
-			if tc := ws.GetEngine().Hub.User(existentRequest.Student.ID); tc != nil {
-				_ = tc.Send(ws.Event{
-					Type: "instant.deny",
-					Data: ws.EventData{
-						"tutor": existentRequest.Tutor.Dto(true),
-					},
-				})
-			}
-
-			delete(InstantLessonRequests, user.ID.Hex())
+	is := services.GetInstantSessions()
 
+	if c.Request.Method == "DELETE" {
+		if !bson.IsObjectIdHex(c.Query("request")) {
+			c.JSON(http.StatusBadRequest, bson.M{"error": "Param 'request' required"})
 			return
 		}
 
-		delete(InstantLessonRequests, user.ID.Hex())
-
-		if ws.GetEngine().Hub.User(existentRequest.Student.ID) == nil {
-			c.JSON(http.StatusBadRequest, bson.M{"error": "Student no longer online"})
+		request, exist := store.GetInstantRequest(bson.ObjectIdHex(c.Query("request")))
+		if !exist {
+			c.JSON(http.StatusNotFound, bson.M{"error": "Instant request not found"})
 			return
 		}
 
-		err := services.GetLessons().CreateInstantSession(
-			existentRequest.Student,
-			existentRequest.Tutor,
-			existentRequest.Subject,
-		)
+		var err error
+		if user.IsTutor() {
+			err = is.Decline(user, request)
+		} else {
+			err = is.Cancel(user, request)
+		}
 
 		if err != nil {
 			c.JSON(http.StatusBadRequest, bson.M{"error": err.Error()})
 			return
 		}
 
+		c.JSON(http.StatusOK, request)
 		return
 	}
 
@@ -241,9 +222,27 @@ func onInstantSessionRequest(c *gin.Context) {
 		return
 	}
 
-	tutorID, tutorIDRequest := req["tutor"]
-	if !tutorIDRequest {
-		c.JSON(http.StatusBadRequest, bson.M{"error": "Param 'tutor' required"})
+	// When tutor accepts a request
+	if user.IsTutor() {
+		requestID, exist := req["request"]
+		if !exist {
+			c.JSON(http.StatusBadRequest, bson.M{"error": "Param 'request' required"})
+			return
+		}
+
+		request, exist := store.GetInstantRequest(requestID)
+		if !exist || !request.IsPending() {
+			c.JSON(http.StatusNotAcceptable, bson.M{"error": "Instant request is no longer available"})
+			return
+		}
+
+		lesson, err := is.Accept(user, request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, bson.M{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, lesson)
 		return
 	}
 
@@ -259,54 +258,13 @@ func onInstantSessionRequest(c *gin.Context) {
 		return
 	}
 
-	tutor, tutorExist := services.NewUsers().ByID(tutorID)
-	if !tutorExist || !tutor.IsTutor() {
-		c.JSON(http.StatusBadRequest, bson.M{"error": "Tutor not found"})
-		return
-	}
-
-	if request, exist := InstantLessonRequests[tutor.ID.Hex()]; exist {
-		c.JSON(http.StatusNotAcceptable, bson.M{
-			"error": fmt.Sprintf(
-				"Already requested instant session for this tutor. Please wait %v seconds for response, then you can request instant session again.",
-				int(time.Until(request.Expire).Round(time.Second).Seconds()),
-			),
-		})
-
+	request, err := is.Request(user, subject)
+	if err != nil {
+		c.JSON(http.StatusNotAcceptable, bson.M{"error": err.Error()})
 		return
 	}
 
-	tmp := &InstantSessionRequest{
-		Student: user,
-		Tutor:   tutor,
-		Subject: subject,
-		Expire:  time.Now().Add(time.Minute * 2),
-	}
-
-	InstantLessonRequests[tutor.ID.Hex()] = tmp
-
-	if tc := ws.GetEngine().Hub.User(tutor.ID); tc != nil {
-		d := delivery.New(config.GetConfig())
-		tutorProfileLink, err := core.AppURL("/main/account/calendar/details/%s", tutor.ID.Hex())
-		if err != nil {
-			log.Println(err.Error())
-			c.JSON(http.StatusInternalServerError, response{Error: true, Message: err.Error(), Raw: err.Error()})
-			return
-		}
-		go d.Send(tutor, m.TPL_INSTANT_LESSON_REQUEST, &m.P{
-			"TUTOR_NAME": tutor.GetFirstName(),
-			"STUDENT_NAME": user.GetFirstName(),
-			"JOIN_SESSION": tutorProfileLink,
-		})
-		_ = tc.Send(ws.Event{
-			Type: "instant.request",
-			Data: ws.EventData{
-				"student": user.Dto(true),
-				"subject": subject,
-				"timeout": time.Until(tmp.Expire).Seconds(),
-			},
-		})
-	}
+	c.JSON(http.StatusOK, request)
 }
 
 func onSocketUserEnter(c *ws.Connection) {
@@ -316,50 +274,11 @@ func onSocketUserEnter(c *ws.Connection) {
 		return
 	}
 
-	if req, exist := InstantLessonRequests[user.ID.Hex()]; exist {
-		_ = c.Send(ws.Event{
-			Type: "instant.request",
-			Data: ws.EventData{
-				"student": req.Student.Dto(true),
-				"subject": req.Subject,
-				"timeout": time.Until(time.Now()).Seconds(),
-			},
-		})
-	}
-}
-
-func instantSessionClean() {
-	for {
-		var nextExpire time.Time
-
-		for id, req := range InstantLessonRequests {
-			if time.Now().After(req.Expire) {
-				if tc := ws.GetEngine().Hub.User(req.Student.ID); tc != nil {
-					_ = tc.Send(ws.Event{Type: "instant.timeout"})
-				}
-
-				delete(InstantLessonRequests, id)
-			}
-
-			if nextExpire.IsZero() || req.Expire.Before(nextExpire) {
-				nextExpire = req.Expire.Add(0)
-			}
-		}
-
-		if nextExpire.IsZero() || nextExpire.Before(time.Now()) {
-			//TODO: Is this performant?
-			time.Sleep(time.Second)
-		} else {
-			wait := time.Until(nextExpire)
-			logger.Get().Infof("Wait %s for checking instant lesson timeout\n", wait.String())
-			time.Sleep(wait)
-		}
-	}
+	services.GetInstantSessions().SendPending(user)
 }
 
 // SetupLessons  adds lessons routes to the router and starts the service
 func SetupLessons(ctx context.Context, g *gin.RouterGroup) {
-	go instantSessionClean()
 	go services.GetLessons().Start(ctx)
 	ws.GetEngine().OnEnter(onSocketUserEnter)
 
@@ -382,9 +301,33 @@ func SetupLessons(ctx context.Context, g *gin.RouterGroup) {
 	authRequired.POST("/:lesson/propose/accept", proposeAccept)
 	authRequired.POST("/:lesson/propose/decline", proposeDecline)
 
+	authRequired.GET("/:lesson/reschedule", rescheduleListHandler)
+	authRequired.POST("/:lesson/reschedule", rescheduleHandler)
+	authRequired.POST("/:lesson/reschedule/:proposal/accept", rescheduleAcceptHandler)
+	authRequired.POST("/:lesson/reschedule/:proposal/counter", rescheduleCounterHandler)
+	authRequired.POST("/:lesson/reschedule/:proposal/decline", rescheduleDeclineHandler)
+
 	authRequired.POST("/:lesson/recurrent", recurrentHandler)
 	authRequired.POST("/:lesson/cancel", cancelHandler)
+	authRequired.POST("/:lesson/cancellation", auth.IsAdminMiddleware, overrideCancellationHandler)
+	authRequired.POST("/:lesson/refund", auth.IsAdminMiddleware, refundHandler)
+
+	authRequired.POST("/:lesson/join", joinHandler)
+	authRequired.POST("/:lesson/leave", leaveHandler)
+	authRequired.PUT("/:lesson/promo-code", promoCodeHandler)
+	authRequired.POST("/:lesson/capacity", capacityHandler)
 
 	authRequired.GET("/:lesson/notes", noteListHandler)
 	authRequired.POST("/:lesson/notes", notePostHandler)
+
+	authRequired.GET("/:lesson/agenda", agendaHandler)
+	authRequired.PUT("/:lesson/agenda", agendaUpdateHandler)
+
+	authRequired.GET("/:lesson/assignments", assignmentListHandler)
+	authRequired.POST("/:lesson/assignments", assignmentCreateHandler)
+	authRequired.PUT("/:lesson/assignments/:assignment", assignmentUpdateHandler)
+	authRequired.DELETE("/:lesson/assignments/:assignment", assignmentDeleteHandler)
+	authRequired.POST("/:lesson/assignments/:assignment/submit", assignmentSubmitHandler)
+	authRequired.GET("/:lesson/assignments/:assignment/submissions", submissionListHandler)
+	authRequired.POST("/:lesson/assignments/:assignment/grade", assignmentGradeHandler)
 }
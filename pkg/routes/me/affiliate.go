@@ -301,7 +301,7 @@ func getLessons(ids []bson.ObjectId, from, to time.Time) ([]lessonResponse, erro
 			continue
 		}
 
-		amount := float64(tutorDto.Tutoring.Rate/60) * lesson.Duration().Minutes()
+		amount := float64(tutorDto.Tutoring.Rate) / 100 / 60 * lesson.Duration().Minutes()
 
 		var state int
 		switch lesson.State {
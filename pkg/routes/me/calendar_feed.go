@@ -0,0 +1,86 @@
+package me
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/learnt/api/pkg/core"
+	"gitlab.com/learnt/api/pkg/ics"
+	"gitlab.com/learnt/api/pkg/services"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+type calendarFeedResponse struct {
+	URL       string     `json:"url"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+func newCalendarFeedResponse(feed *store.CalendarFeed) calendarFeedResponse {
+	if feed == nil {
+		return calendarFeedResponse{}
+	}
+
+	return calendarFeedResponse{
+		URL:       core.APIURL("/calendar/%s/lessons.ics", feed.Secret),
+		CreatedAt: &feed.CreatedAt,
+	}
+}
+
+// SetupFeed adds the lessons feed calendar apps subscribe to. It's authenticated by
+// the secret in its URL, which the users get from /me/calendar-lessons/feed.
+func SetupFeed(g *gin.RouterGroup) {
+	g.GET("/:secret/lessons.ics", calendarFeed)
+}
+
+func calendarFeed(c *gin.Context) {
+	user, ok := store.GetUserByCalendarFeed(c.Param("secret"))
+	if !ok {
+		c.JSON(http.StatusNotFound, core.NewErrorResponse("calendar not found"))
+		return
+	}
+
+	lessons := services.NewUsers().GetLessons(user)
+	if err := ics.Lessons().Serve(c, user, lessons); err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse("failed to generate ICS file"))
+	}
+}
+
+func calendarFeedHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, newCalendarFeedResponse(user.CalendarFeed))
+}
+
+// rotateCalendarFeedHandler gives the user a feed URL, and stops the one they had
+func rotateCalendarFeedHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	feed, err := user.RotateCalendarFeed()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, newCalendarFeedResponse(feed))
+}
+
+func revokeCalendarFeedHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	if err := user.RevokeCalendarFeed(); err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, newCalendarFeedResponse(nil))
+}
@@ -0,0 +1,97 @@
+package me
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/learnt/api/pkg/core"
+	"gitlab.com/learnt/api/pkg/services"
+	"gitlab.com/learnt/api/pkg/store"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type externalCalendarRequest struct {
+	Name string `json:"name"`
+	URL  string `json:"url" binding:"required"`
+}
+
+func externalCalendarsHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	calendars := user.ExternalCalendars
+	if calendars == nil {
+		calendars = make([]*store.ExternalCalendar, 0)
+	}
+
+	c.JSON(http.StatusOK, calendars)
+}
+
+func addExternalCalendarHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	var req externalCalendarRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("invalid fields"))
+		return
+	}
+
+	cal, err := services.GetExternalCalendars().Add(user, req.Name, req.URL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	go notifyProfileChangeFor(user, ProfileUpdateAvailability)
+
+	c.JSON(http.StatusOK, cal)
+}
+
+func removeExternalCalendarHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("invalid calendar id"))
+		return
+	}
+
+	if err := user.RemoveExternalCalendar(bson.ObjectIdHex(c.Param("id"))); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	go notifyProfileChangeFor(user, ProfileUpdateAvailability)
+
+	c.JSON(http.StatusOK, user.ExternalCalendars)
+}
+
+// syncExternalCalendarsHandler refreshes the tutor's calendars on demand. The
+// calendars that couldn't sync have their error set.
+func syncExternalCalendarsHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	if len(user.ExternalCalendars) == 0 {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("no calendar to sync"))
+		return
+	}
+
+	if err := services.GetExternalCalendars().Sync(user); err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	go notifyProfileChangeFor(user, ProfileUpdateAvailability)
+
+	c.JSON(http.StatusOK, user.ExternalCalendars)
+}
@@ -0,0 +1,73 @@
+package me
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/learnt/api/pkg/core"
+	"gitlab.com/learnt/api/pkg/services"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+type redeemGiftCardRequest struct {
+	Code string `json:"code" binding:"required"`
+	// Amount is in cents, zero redeems all that's left
+	Amount int64 `json:"amount"`
+}
+
+func giftCardsHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	cards, err := store.GetUserGiftCards(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, cards)
+}
+
+func buyGiftCardHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	var req services.GiftCardParams
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("invalid fields"))
+		return
+	}
+
+	card, err := services.GetGiftCards().Buy(user, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, card)
+}
+
+func redeemGiftCardHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	var req redeemGiftCardRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("invalid fields"))
+		return
+	}
+
+	card, err := services.GetGiftCards().Redeem(user, req.Code, req.Amount)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, card)
+}
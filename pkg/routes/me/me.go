@@ -590,9 +590,30 @@ func earnings(c *gin.Context) {
 		return
 	}
 
-	amount := services.GetTransactions().GetAmount(user)
+	// the period is optional, earnings are all the user earned without it
+	from, _ := time.Parse(time.RFC3339Nano, c.Query("from"))
+	to, _ := time.Parse(time.RFC3339Nano, c.Query("to"))
 
-	c.Data(200, "text/plain", []byte(strconv.FormatFloat(amount, 'f', 2, 64)))
+	// earnings are per currency, tutors who changed currency earned in more than one
+	earnings, err := services.GetTransactions().GetEarnings(user, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	// the balance of the prepaid packages comes with the earnings when asked for
+	if c.Query("prepaid") != "" {
+		prepaid, err := store.GetPrepaidPackages(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"earnings": earnings, "prepaid": prepaid})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"earnings": earnings})
 }
 
 type transactionResponse struct {
@@ -632,9 +653,11 @@ func transactions(c *gin.Context) {
 		res := struct {
 			Transactions []*store.TransactionDto `json:"transactions"`
 			Count        int                     `json:"count"`
+			Prepaid      []store.LessonPackage   `json:"prepaid,omitempty"`
 		}{}
 		res.Transactions = transactions
 		res.Count = count
+		res.Prepaid, _ = store.GetPrepaidPackages(user.ID)
 		c.JSON(http.StatusOK, res)
 		return
 	} else {
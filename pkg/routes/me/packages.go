@@ -0,0 +1,83 @@
+package me
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/learnt/api/pkg/core"
+	"gitlab.com/learnt/api/pkg/services"
+	"gitlab.com/learnt/api/pkg/store"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type buyPackageRequest struct {
+	Tutor bson.ObjectId `json:"tutor" binding:"required"`
+	Offer bson.ObjectId `json:"offer" binding:"required"`
+}
+
+func packagesHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	packages, err := store.GetUserPackages(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, packages)
+}
+
+func buyPackageHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	var req buyPackageRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("invalid fields"))
+		return
+	}
+
+	tutor, exist := services.NewUsers().ByID(req.Tutor)
+	if !exist {
+		c.JSON(http.StatusNotFound, core.NewErrorResponse("tutor not found"))
+		return
+	}
+
+	pkg, err := services.GetPackages().Buy(user, tutor, req.Offer)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, pkg)
+}
+
+func refundPackageHandler(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("invalid package id"))
+		return
+	}
+
+	pkg, exist := store.GetLessonPackage(bson.ObjectIdHex(c.Param("id")))
+	if !exist {
+		c.JSON(http.StatusNotFound, core.NewErrorResponse("package not found"))
+		return
+	}
+
+	if err := services.GetPackages().Refund(pkg, user); err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, pkg)
+}
@@ -70,6 +70,9 @@ func Setup(g *gin.RouterGroup) {
 	g.GET("/calendar-lessons/ics", getCalendarLessonsICS)
 	g.GET("/calendar-lessons/dates", getCalendarLessonsDates)
 	g.GET("/calendar-lessons/icsfeed", getCalendarLessonsICSFeed)
+	g.GET("/calendar-lessons/feed", calendarFeedHandler)
+	g.POST("/calendar-lessons/feed", rotateCalendarFeedHandler)
+	g.DELETE("/calendar-lessons/feed", revokeCalendarFeedHandler)
 	g.PUT("", updateHandler)
 	g.DELETE("", deleteAccount)
 	g.PUT("/avatar", updateAvatar)
@@ -87,8 +90,21 @@ func Setup(g *gin.RouterGroup) {
 	g.PUT("/blackout/:id", updateBlackout)
 	g.DELETE("/blackout/:id", removeBlackout)
 
+	g.GET("/calendars", externalCalendarsHandler)
+	g.POST("/calendars", addExternalCalendarHandler)
+	g.POST("/calendars/sync", syncExternalCalendarsHandler)
+	g.DELETE("/calendars/:id", removeExternalCalendarHandler)
+
 	g.POST("/cards", updatePaymentsCard)
 
+	g.GET("/packages", packagesHandler)
+	g.POST("/packages", buyPackageHandler)
+	g.POST("/packages/:id/refund", refundPackageHandler)
+
+	g.GET("/gift-cards", giftCardsHandler)
+	g.POST("/gift-cards", buyGiftCardHandler)
+	g.POST("/gift-cards/redeem", redeemGiftCardHandler)
+
 	g.POST("/degrees", addDegree)
 	g.DELETE("/degrees/:id", deleteDegree)
 
@@ -5,6 +5,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"gitlab.com/learnt/api/pkg/routes/auth"
 	"gitlab.com/learnt/api/pkg/services"
+	"gitlab.com/learnt/api/pkg/store"
 	"gitlab.com/learnt/api/pkg/utils"
 	"net/http"
 	"time"
@@ -132,9 +133,63 @@ func instantSessionsHourlyMetricHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
+type instantFillRate struct {
+	store.InstantRequestDay
+	FillRate float64 `json:"fill_rate"`
+}
+
+type instantFillRateResponse struct {
+	Data     []instantFillRate `json:"data"`
+	FillRate float64           `json:"fill_rate"`
+}
+
+// instantFillRateHandler reports how many instant requests a tutor took, by day.
+// It covers the last 30 days, unless from and to (2006-01-02) are given.
+func instantFillRateHandler(c *gin.Context) {
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+
+	if c.Query("from") != "" {
+		t, err := time.Parse("2006-01-02", c.Query("from"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from date"})
+			return
+		}
+		from = t
+	}
+
+	if c.Query("to") != "" {
+		t, err := time.Parse("2006-01-02", c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to date"})
+			return
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+
+	days, err := store.GetInstantRequestDays(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var total store.InstantRequestDay
+	res := instantFillRateResponse{Data: make([]instantFillRate, len(days))}
+	for i, day := range days {
+		res.Data[i] = instantFillRate{InstantRequestDay: day, FillRate: day.FillRate()}
+		total.Requests += day.Requests
+		total.Accepted += day.Accepted
+		total.Cancelled += day.Cancelled
+	}
+	res.FillRate = total.FillRate()
+
+	c.JSON(http.StatusOK, res)
+}
+
 func Setup(g *gin.RouterGroup) {
 	g.GET("/sessions", auth.IsAdminMiddleware, sessionsMetricHandler)
 	g.GET("/sessions-hourly", auth.IsAdminMiddleware, sessionsHourlyMetricHandler)
 	g.GET("/instant", auth.IsAdminMiddleware, instantSessionsMetricHandler)
 	g.GET("/instant-hourly", auth.IsAdminMiddleware, instantSessionsHourlyMetricHandler)
+	g.GET("/instant-fill-rate", auth.IsAdminMiddleware, instantFillRateHandler)
 }
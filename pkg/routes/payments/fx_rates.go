@@ -0,0 +1,52 @@
+package payments
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+type fxRateRequest struct {
+	From string  `json:"from" binding:"required"`
+	To   string  `json:"to" binding:"required"`
+	Rate float64 `json:"rate" binding:"required"`
+}
+
+// fxRates lists the FX rates prices are shown in the students' currencies with
+func fxRates(c *gin.Context) {
+	rates, err := store.GetFXRates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: true, Message: "couldn't get fx rates", Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, rates)
+}
+
+// setFXRate sets the rate of a currency pair, replacing the one set before
+func setFXRate(c *gin.Context) {
+	admin, ok := store.GetUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, errorResponse{Error: true, Message: "unauthorized"})
+		return
+	}
+
+	var req fxRateRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: "invalid parameter"})
+		return
+	}
+
+	from := store.Currency(strings.ToLower(req.From))
+	to := store.Currency(strings.ToLower(req.To))
+
+	rate, err := store.SetFXRate(from, to, req.Rate, admin.ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, rate)
+}
@@ -0,0 +1,105 @@
+package payments
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/services"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+type voidGiftCardRequest struct {
+	Reason string `json:"reason"`
+	// Refund refunds what's left of the card to the purchaser's card
+	Refund bool `json:"refund"`
+}
+
+// giftCards looks up the gift cards by code, recipient email or purchaser, the newest
+// ones when none is given
+func giftCards(c *gin.Context) {
+	match := bson.M{}
+
+	if code := c.Query("code"); code != "" {
+		match["code"] = store.NormalizeGiftCardCode(code)
+	}
+
+	if email := c.Query("email"); email != "" {
+		match["recipient_email"] = strings.ToLower(strings.TrimSpace(email))
+	}
+
+	if purchaser := c.Query("purchaser"); purchaser != "" {
+		if !bson.IsObjectIdHex(purchaser) {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: "invalid purchaser id"})
+			return
+		}
+		match["purchaser"] = bson.ObjectIdHex(purchaser)
+	}
+
+	if status := c.Query("status"); status != "" {
+		match["status"] = store.GiftCardStatus(status)
+	}
+
+	cards, err := store.GetGiftCards(match, 100)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: true, Message: "couldn't get gift cards", Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, cards)
+}
+
+// giftCardParam gets the gift card of the path
+func giftCardParam(c *gin.Context) (*store.GiftCard, bool) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: "invalid gift card id"})
+		return nil, false
+	}
+
+	card, ok := store.GetGiftCardByID(bson.ObjectIdHex(id))
+	if !ok {
+		c.JSON(http.StatusNotFound, errorResponse{Error: true, Message: "gift card not found"})
+		return nil, false
+	}
+
+	return card, true
+}
+
+func giftCard(c *gin.Context) {
+	card, ok := giftCardParam(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, card)
+}
+
+// voidGiftCard stops the gift card from being redeemed
+func voidGiftCard(c *gin.Context) {
+	admin, ok := store.GetUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, errorResponse{Error: true, Message: "unauthorized"})
+		return
+	}
+
+	card, ok := giftCardParam(c)
+	if !ok {
+		return
+	}
+
+	var req voidGiftCardRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: "invalid parameter"})
+		return
+	}
+
+	if err := services.GetGiftCards().Void(card, admin, req.Reason, req.Refund); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, card)
+}
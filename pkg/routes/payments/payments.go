@@ -235,6 +235,30 @@ func addCredit(c *gin.Context) {
 	}
 }
 
+// stripeEvents lists the Stripe webhook events in the state, the failed ones by default
+func stripeEvents(c *gin.Context) {
+	state := store.StripeEventState(c.DefaultQuery("state", string(store.StripeEventFailed)))
+
+	events, err := store.GetStripeEvents(state, 100)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: true, Message: "couldn't get events", Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+// replayStripeEvent processes a failed Stripe webhook event again
+func replayStripeEvent(c *gin.Context) {
+	event, err := services.GetPayments().ReplayEvent(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, event)
+}
+
 // test handlers
 
 func cards(c *gin.Context) {
@@ -264,4 +288,22 @@ func Setup(ctx context.Context, g *gin.RouterGroup) {
 	g.PUT("default/:id", setDefaultCard)
 	g.POST("ensureconnect", ensureConnectAccount)
 	g.PUT("add-credit/:id", addCredit)
+	g.GET("events", auth.IsAdminMiddleware, stripeEvents)
+	g.POST("events/:id/replay", auth.IsAdminMiddleware, replayStripeEvent)
+	g.GET("payouts/preview", auth.IsAdminMiddleware, payoutPreview)
+	g.GET("payouts/batches", auth.IsAdminMiddleware, payoutBatches)
+	g.POST("payouts/batches", auth.IsAdminMiddleware, createPayoutBatch)
+	g.GET("payouts/batches/:id", auth.IsAdminMiddleware, payoutBatch)
+	g.POST("payouts/batches/:id/approve", auth.IsAdminMiddleware, approvePayoutBatch)
+	g.POST("payouts/batches/:id/retry", auth.IsAdminMiddleware, retryPayoutBatch)
+	g.GET("fx-rates", fxRates)
+	g.PUT("fx-rates", auth.IsAdminMiddleware, setFXRate)
+	g.GET("promo-code", checkPromoCode)
+	g.GET("promo-codes", auth.IsAdminMiddleware, promoCodes)
+	g.POST("promo-codes", auth.IsAdminMiddleware, createPromoCode)
+	g.GET("promo-codes/:id", auth.IsAdminMiddleware, promoCode)
+	g.DELETE("promo-codes/:id", auth.IsAdminMiddleware, disablePromoCode)
+	g.GET("gift-cards", auth.IsAdminMiddleware, giftCards)
+	g.GET("gift-cards/:id", auth.IsAdminMiddleware, giftCard)
+	g.POST("gift-cards/:id/void", auth.IsAdminMiddleware, voidGiftCard)
 }
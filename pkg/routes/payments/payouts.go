@@ -0,0 +1,99 @@
+package payments
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/learnt/api/pkg/services"
+	"gitlab.com/learnt/api/pkg/store"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// payoutPreview shows the payout batch that would be made now
+func payoutPreview(c *gin.Context) {
+	batch, err := services.GetPayouts().Preview()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: true, Message: "couldn't preview payouts", Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, batch)
+}
+
+// payoutBatches lists the payout batches in the status, all of them by default
+func payoutBatches(c *gin.Context) {
+	batches, err := store.GetPayoutBatches(store.PayoutBatchStatus(c.Query("status")), 100)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: true, Message: "couldn't get payout batches", Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, batches)
+}
+
+// createPayoutBatch creates the payout batch of what the tutors earned, to be approved
+func createPayoutBatch(c *gin.Context) {
+	batch, err := services.GetPayouts().CreateBatch()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, batch)
+}
+
+func payoutBatch(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: "invalid batch id"})
+		return
+	}
+
+	batch, ok := store.GetPayoutBatch(bson.ObjectIdHex(id))
+	if !ok {
+		c.JSON(http.StatusNotFound, errorResponse{Error: true, Message: "payout batch not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, batch)
+}
+
+// approvePayoutBatch pays the tutors of the pending batch
+func approvePayoutBatch(c *gin.Context) {
+	admin, ok := store.GetUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, errorResponse{Error: true, Message: "unauthorized"})
+		return
+	}
+
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: "invalid batch id"})
+		return
+	}
+
+	batch, err := services.GetPayouts().Approve(bson.ObjectIdHex(id), admin)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, batch)
+}
+
+// retryPayoutBatch pays the failed payouts of the batch again
+func retryPayoutBatch(c *gin.Context) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: "invalid batch id"})
+		return
+	}
+
+	batch, err := services.GetPayouts().Retry(bson.ObjectIdHex(id))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, batch)
+}
@@ -0,0 +1,177 @@
+package payments
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/services"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+type promoCodeRequest struct {
+	Code            string          `json:"code" binding:"required"`
+	Description     string          `json:"description"`
+	Kind            store.PromoKind `json:"kind" binding:"required"`
+	Percent         int             `json:"percent"`
+	Amount          int64           `json:"amount"`
+	Currency        store.Currency  `json:"currency"`
+	FirstLessonOnly bool            `json:"first_lesson_only"`
+	Subjects        []bson.ObjectId `json:"subjects"`
+	Tutors          []bson.ObjectId `json:"tutors"`
+	MaxRedemptions  int             `json:"max_redemptions"`
+	MaxPerUser      int             `json:"max_per_user"`
+	ExpiresAt       *time.Time      `json:"expires_at"`
+}
+
+type promoCodeResponse struct {
+	*store.PromoCode
+	Redemptions []store.PromoRedemption `json:"redemptions_list"`
+}
+
+// promoCodes lists the promo codes, newest first
+func promoCodes(c *gin.Context) {
+	codes, err := store.GetPromoCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: true, Message: "couldn't get promo codes", Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+// createPromoCode creates a promo code students can book lessons with
+func createPromoCode(c *gin.Context) {
+	admin, ok := store.GetUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, errorResponse{Error: true, Message: "unauthorized"})
+		return
+	}
+
+	var req promoCodeRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: "invalid parameter"})
+		return
+	}
+
+	promo := &store.PromoCode{
+		Code:            req.Code,
+		Description:     req.Description,
+		Kind:            req.Kind,
+		Percent:         req.Percent,
+		Amount:          req.Amount,
+		Currency:        req.Currency,
+		FirstLessonOnly: req.FirstLessonOnly,
+		Subjects:        req.Subjects,
+		Tutors:          req.Tutors,
+		MaxRedemptions:  req.MaxRedemptions,
+		MaxPerUser:      req.MaxPerUser,
+		ExpiresAt:       req.ExpiresAt,
+		CreatedBy:       admin.ID,
+	}
+
+	if err := promo.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: err.Error()})
+		return
+	}
+
+	if err := promo.Insert(); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, promo)
+}
+
+// promoCodeParam gets the promo code of the path
+func promoCodeParam(c *gin.Context) (*store.PromoCode, bool) {
+	id := c.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: "invalid promo code id"})
+		return nil, false
+	}
+
+	promo, ok := store.GetPromoCodeByID(bson.ObjectIdHex(id))
+	if !ok {
+		c.JSON(http.StatusNotFound, errorResponse{Error: true, Message: "promo code not found"})
+		return nil, false
+	}
+
+	return promo, true
+}
+
+// promoCode shows the promo code with its redemptions
+func promoCode(c *gin.Context) {
+	promo, ok := promoCodeParam(c)
+	if !ok {
+		return
+	}
+
+	redemptions, err := store.GetPromoRedemptions(bson.M{"promo": promo.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: true, Message: "couldn't get promo code redemptions", Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, promoCodeResponse{promo, redemptions})
+}
+
+// disablePromoCode stops the promo code from being redeemed, keeping its redemptions
+func disablePromoCode(c *gin.Context) {
+	promo, ok := promoCodeParam(c)
+	if !ok {
+		return
+	}
+
+	if err := promo.Disable(); err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, promo)
+}
+
+// checkPromoCode tells if the logged student can book a lesson of the subject with the
+// tutor with the promo code, for the checkout to show the discount
+func checkPromoCode(c *gin.Context) {
+	user, ok := store.GetUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, errorResponse{Error: true, Message: "unauthorized"})
+		return
+	}
+
+	if !bson.IsObjectIdHex(c.Query("tutor")) {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: "invalid tutor id"})
+		return
+	}
+
+	tutor, ok := services.NewUsers().ByID(bson.ObjectIdHex(c.Query("tutor")))
+	if !ok || !tutor.IsTutor() {
+		c.JSON(http.StatusNotFound, errorResponse{Error: true, Message: "tutor not found"})
+		return
+	}
+
+	var subject bson.ObjectId
+	if s := c.Query("subject"); bson.IsObjectIdHex(s) {
+		subject = bson.ObjectIdHex(s)
+	}
+
+	promo, err := services.GetPayments().CheckPromoCode(c.Query("code"), user, tutor, subject)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{Error: true, Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":              promo.Code,
+		"description":       promo.Description,
+		"kind":              promo.Kind,
+		"percent":           promo.Percent,
+		"amount":            promo.Amount,
+		"currency":          promo.Currency,
+		"first_lesson_only": promo.FirstLessonOnly,
+		"expires_at":        promo.ExpiresAt,
+	})
+}
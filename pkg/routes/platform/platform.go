@@ -286,6 +286,41 @@ func getCreditsSummary(c *gin.Context) {
 	c.JSON(http.StatusOK, transactions)
 }
 
+func getCancellationPolicy(c *gin.Context) {
+	policy, ok := store.GetPlatformCancellationPolicy()
+	if !ok {
+		policy = &store.DefaultCancellationPolicy
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+func updateCancellationPolicy(c *gin.Context) {
+	var policy store.CancellationPolicy
+
+	if err := c.BindJSON(&policy); err != nil {
+		c.JSON(
+			http.StatusBadRequest,
+			core.NewErrorResponse(
+				err.Error(),
+			),
+		)
+		return
+	}
+
+	if err := store.SetPlatformCancellationPolicy(&policy); err != nil {
+		c.JSON(
+			http.StatusBadRequest,
+			core.NewErrorResponse(
+				err.Error(),
+			),
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
 // Setup adds the platform routes to the router
 func Setup(g *gin.RouterGroup, version string, build string) {
 
@@ -300,6 +335,8 @@ func Setup(g *gin.RouterGroup, version string, build string) {
 	g.GET("/uploads", isLoggedAdmin, getUploads)
 	g.GET("/stats", isLoggedAdmin, getStats)
 	g.GET("/credits-summary", isLoggedAdmin, getCreditsSummary)
+	g.GET("/cancellation-policy", isLoggedAdmin, getCancellationPolicy)
+	g.PUT("/cancellation-policy", isLoggedAdmin, updateCancellationPolicy)
 
 	// Put required settings
 	store.GetCollection(collectionName).Insert(
@@ -324,7 +324,7 @@ func CreateStripeConnectAccount(c *gin.Context, user *store.UserMgo, ssn, compan
 		MetaData: map[string]string{stripe.MetadataLearntAccountID: user.ID.Hex()},
 	}
 
-	account, err := stripe.NewAccount(&ap)
+	account, err := services.GetPayments().Gateway().NewAccount(&ap)
 	if err != nil {
 		return err
 	}
@@ -22,6 +22,31 @@ type searchResponse struct {
 	QueryError  string          `json:"query_error,omitempty"`
 }
 
+// searchCurrency is the currency prices are searched and shown in: the one asked for, or the
+// user's. Without the FX rates, prices only match in their own currency.
+func searchCurrency(c *gin.Context) (store.Currency, store.FXTable, error) {
+	currency := store.DefaultCurrency
+	if user, ok := store.GetUser(c); ok {
+		currency = user.DisplayCurrency()
+	}
+
+	if query := c.Query("currency"); query != "" {
+		currency = store.Currency(strings.ToLower(query))
+	}
+
+	if !currency.Valid() {
+		return "", nil, errors.Errorf("invalid currency %s", currency)
+	}
+
+	fx, err := store.GetFXTable()
+	if err != nil {
+		logger.GetCtx(c).Errorf("couldn't get fx rates: %v", err)
+		fx = store.FXTable{}
+	}
+
+	return currency, fx, nil
+}
+
 func search(c *gin.Context) {
 	s := services.GetSearch()
 
@@ -42,6 +67,13 @@ func search(c *gin.Context) {
 		s.Timezone(user.Timezone)
 	}
 
+	currency, fx, err := searchCurrency(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	// the price range is in whole units of the currency
 	if price := c.Query("price"); price != "" {
 		priceRange := strings.Split(price, "-")
 
@@ -62,7 +94,7 @@ func search(c *gin.Context) {
 			return
 		}
 
-		s.Price(minPrice, maxPrice)
+		s.Price(int64(minPrice)*100, int64(maxPrice)*100, currency, fx)
 	}
 
 	if general := c.Query("when"); general != "" {
@@ -123,6 +155,10 @@ func search(c *gin.Context) {
 		}
 		verifyDuplicates[dto.ID.Hex()] = true
 
+		if dto.Tutoring != nil {
+			dto.Tutoring.ShowIn(currency, fx)
+		}
+
 		if result.IsTestAccount && userExists && shouldIncludeTestAccountTutor(c, user, dto) {
 			res.Tutors = append(res.Tutors, *dto)
 		} else if !result.IsTestAccount {
@@ -135,6 +171,68 @@ func search(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
+type courseSearchResponse struct {
+	Courses []*store.CourseDto `json:"courses"`
+}
+
+func searchCourses(c *gin.Context) {
+	var filter services.CourseFilter
+
+	filter.Query = c.Query("query")
+
+	for param, id := range map[string]*bson.ObjectId{"subject": &filter.Subject, "tutor": &filter.Tutor} {
+		if value := c.Query(param); value != "" {
+			if !bson.IsObjectIdHex(value) {
+				c.JSON(http.StatusBadRequest, core.NewErrorResponse(fmt.Sprintf("Invalid %s id", param)))
+				return
+			}
+			*id = bson.ObjectIdHex(value)
+		}
+	}
+
+	currency, fx, err := searchCurrency(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(err.Error()))
+		return
+	}
+	filter.Currency, filter.FX = currency, fx
+
+	if price := c.Query("price"); price != "" {
+		maxPrice, err := strconv.ParseFloat(price, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, core.NewErrorResponse("Invalid max price"))
+			return
+		}
+		filter.MaxPrice = store.MinorUnits(maxPrice)
+	}
+
+	switch c.Query("meet") {
+	case "online":
+		filter.Meet = store.MeetOnline
+	case "inperson":
+		filter.Meet = store.MeetInPerson
+	}
+
+	courses, err := services.GetSearch().Courses(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, core.NewErrorResponse(err.Error()))
+		return
+	}
+
+	res := courseSearchResponse{Courses: make([]*store.CourseDto, 0, len(courses))}
+	for i := range courses {
+		dto, err := courses[i].DTO()
+		if err != nil {
+			logger.GetCtx(c).Errorf("couldn't get course %s: %v", courses[i].ID.Hex(), err)
+			continue
+		}
+		dto.DisplayPrice = fx.Display(dto.Price, dto.Currency, currency)
+		res.Courses = append(res.Courses, dto)
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
 func shouldIncludeTestAccountTutor(c *gin.Context, user *store.UserMgo, dto *store.UserDto) bool {
 	// test account, return everything
 	if user.IsTestStudent() || user.IsTestTutor() || auth.IsAdmin(c) {
@@ -185,4 +283,5 @@ func setMeetingPlace(meetPlace string) error {
 
 func Setup(g *gin.RouterGroup) {
 	g.GET("", auth.MiddlewareSilent, search)
+	g.GET("/courses", auth.MiddlewareSilent, searchCourses)
 }
@@ -570,6 +570,16 @@ func getAvailability(c *gin.Context) {
 				return
 			}
 
+			// the blackout is already expanded in the interval, don't repeat it weekly
+			for i, slot := range blackoutSlots {
+				blackoutSlots[i] = &timeline.Slot{
+					ID:        slot.GetID(),
+					From:      slot.GetFrom(),
+					To:        slot.GetTo(),
+					Occurence: timeline.None,
+				}
+			}
+
 			availability = user.GetAvailabilityWithBlackout(recurrent, blackoutSlots...)
 		}
 
@@ -655,6 +665,69 @@ func getBlackout(c *gin.Context) {
 	c.JSON(http.StatusBadRequest, core.NewErrorResponse("No interval provided"))
 }
 
+// getMutualFreeTime finds when the tutor and the student are both free for a lesson
+// of the duration (in minutes), ranked, in the requester's timezone
+func getMutualFreeTime(c *gin.Context) {
+	requester, ok := store.GetUser(c)
+	if !ok {
+		return
+	}
+
+	if !bson.IsObjectIdHex(c.Param("user")) || !bson.IsObjectIdHex(c.Query("student")) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	tutor, exist := services.NewUsers().ByID(bson.ObjectIdHex(c.Param("user")))
+	if !exist || !tutor.IsTutor() {
+		c.JSON(http.StatusNotFound, core.NewErrorResponse("Tutor not found"))
+		return
+	}
+
+	student, exist := services.NewUsers().ByID(bson.ObjectIdHex(c.Query("student")))
+	if !exist {
+		c.JSON(http.StatusNotFound, core.NewErrorResponse("Student not found"))
+		return
+	}
+
+	if requester.ID != tutor.ID && requester.ID != student.ID && !requester.IsAdmin() {
+		c.JSON(http.StatusForbidden, core.NewErrorResponse("Only the tutor or the student can look up their free time"))
+		return
+	}
+
+	from, er := time.Parse(time.RFC3339, c.Query("from"))
+	if er != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("Failed to parse from"))
+		return
+	}
+
+	to, er := time.Parse(time.RFC3339, c.Query("to"))
+	if er != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("Failed to parse to"))
+		return
+	}
+
+	duration, er := strconv.Atoi(c.DefaultQuery("duration", "60"))
+	if er != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("Failed to parse duration"))
+		return
+	}
+
+	limit, er := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if er != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse("Failed to parse limit"))
+		return
+	}
+
+	slots, er := services.GetFreeTime().Mutual(tutor, student, from, to, time.Duration(duration)*time.Minute, requester.TimezoneLocation(), limit)
+	if er != nil {
+		c.JSON(http.StatusBadRequest, core.NewErrorResponse(er.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, slots)
+}
+
 func getStudents(c *gin.Context) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -945,6 +1018,7 @@ func Setup(g *gin.RouterGroup) {
 	g.GET("/id/:user/availability", core.CORS, auth.Middleware, getAvailability)
 	g.GET("/id/:user/blackout", core.CORS, auth.Middleware, getBlackout)
 	g.GET("/id/:user/availability/available", core.CORS, auth.Middleware, isAvailable)
+	g.GET("/id/:user/availability/mutual", core.CORS, auth.Middleware, getMutualFreeTime)
 
 	g.PUT("/:user/approve", core.CORS, auth.Middleware, auth.IsAdminMiddleware, approveUser)
 	g.PUT("/:user/reject", core.CORS, auth.Middleware, auth.IsAdminMiddleware, rejectUser)
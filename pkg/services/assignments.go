@@ -0,0 +1,252 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/config"
+	"gitlab.com/learnt/api/pkg/core"
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/notifications"
+	"gitlab.com/learnt/api/pkg/services/delivery"
+	"gitlab.com/learnt/api/pkg/store"
+	"gitlab.com/learnt/api/pkg/utils"
+	m "gitlab.com/learnt/api/pkg/utils/messaging"
+)
+
+// maxSubmissionFiles is how many files a student can hand in for an assignment
+const maxSubmissionFiles = 10
+
+type assignments struct{}
+
+func GetAssignments() *assignments {
+	return &assignments{}
+}
+
+// check validates the assignment of the tutor and its attachments
+func (a *assignments) check(tutor *store.UserMgo, assignment *store.Assignment) error {
+	if err := assignment.Validate(); err != nil {
+		return err
+	}
+
+	for _, id := range assignment.Attachments {
+		file, ok := store.GetFilesStore().Get(id)
+		if !ok || file.Deleted || file.UploadedBy != tutor.ID {
+			return fmt.Errorf("attachment %s isn't in your library", id.Hex())
+		}
+	}
+
+	return nil
+}
+
+// Create gives the assignment to the students of the tutor's lesson
+func (a *assignments) Create(tutor *store.UserMgo, lesson *store.LessonMgo, assignment *store.Assignment) error {
+	if lesson.Tutor != tutor.ID {
+		return errors.New("only the tutor of the lesson can give assignments")
+	}
+
+	if lesson.State == store.LessonCancelled {
+		return errors.New("lesson was cancelled")
+	}
+
+	if len(lesson.Students) == 0 {
+		return errors.New("lesson has no students")
+	}
+
+	if !assignment.DueAt.After(time.Now()) {
+		return errors.New("assignment must be due in the future")
+	}
+
+	if err := a.check(tutor, assignment); err != nil {
+		return err
+	}
+
+	assignment.ID = bson.NewObjectId()
+	assignment.Lesson = lesson.ID
+	assignment.Tutor = tutor.ID
+	assignment.Students = append([]bson.ObjectId{}, lesson.Students...)
+
+	if err := assignment.Insert(); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("%s gave you %s, due %s", tutor.GetFirstName(), assignment.Title, utils.FormatTime(assignment.DueAt))
+	for _, student := range assignment.Students {
+		notifications.Notify(&notifications.NotifyRequest{
+			User:    student,
+			Type:    notifications.AssignmentCreated,
+			Title:   "New assignment",
+			Message: message,
+			Data:    map[string]interface{}{"assignment": assignment},
+		})
+	}
+
+	return nil
+}
+
+// Update replaces the assignment's details. The work submitted already is kept.
+func (a *assignments) Update(tutor *store.UserMgo, assignment *store.Assignment, changes *store.Assignment) error {
+	if assignment.Tutor != tutor.ID {
+		return errors.New("assignment isn't yours")
+	}
+
+	updated := *assignment
+	updated.Title = changes.Title
+	updated.Instructions = changes.Instructions
+	updated.DueAt = changes.DueAt
+	updated.Attachments = changes.Attachments
+
+	if err := a.check(tutor, &updated); err != nil {
+		return err
+	}
+
+	*assignment = updated
+	return assignment.Save()
+}
+
+// Delete removes the assignment and the work submitted for it
+func (a *assignments) Delete(tutor *store.UserMgo, assignment *store.Assignment) error {
+	if assignment.Tutor != tutor.ID {
+		return errors.New("assignment isn't yours")
+	}
+
+	return assignment.Remove()
+}
+
+// Submit hands in the student's work. The files are the student's temporary uploads,
+// which are kept once the work is submitted.
+func (a *assignments) Submit(student *store.UserMgo, lesson *store.LessonMgo, assignment *store.Assignment, text string, uploads []bson.ObjectId) (*store.AssignmentSubmission, error) {
+	if lesson.Tutor == student.ID || !lesson.HasUserID(student.ID) {
+		return nil, errors.New("only the students of the lesson can submit work")
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" && len(uploads) == 0 {
+		return nil, errors.New("submission needs some text or files")
+	}
+
+	if len(uploads) > maxSubmissionFiles {
+		return nil, errors.Errorf("submission can have up to %d files", maxSubmissionFiles)
+	}
+
+	files := make([]*store.Upload, len(uploads))
+	for i, id := range uploads {
+		upload, err := Uploads.Get(id)
+		if err != nil || upload.UploadedBy != student.ID {
+			return nil, errors.Errorf("upload %s is missing or expired", id.Hex())
+		}
+
+		if upload.State == store.UploadFailed {
+			return nil, errors.Errorf("upload of %s failed", upload.Name)
+		}
+
+		files[i] = upload
+	}
+
+	for _, upload := range files {
+		if err := Uploads.Approve(upload); err != nil {
+			return nil, errors.Wrapf(err, "couldn't keep %s", upload.Name)
+		}
+		upload.Expire = nil
+	}
+
+	submission, err := assignment.Submit(student.ID, text, files)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications.Notify(&notifications.NotifyRequest{
+		User:    assignment.Tutor,
+		Type:    notifications.AssignmentSubmitted,
+		Title:   "Assignment submitted",
+		Message: fmt.Sprintf("%s submitted %s", student.GetFirstName(), assignment.Title),
+		Data:    map[string]interface{}{"assignment": assignment.ID, "submission": submission},
+	})
+
+	return submission, nil
+}
+
+// Grade grades the student's work, with a comment from the tutor
+func (a *assignments) Grade(tutor *store.UserMgo, assignment *store.Assignment, submission *store.AssignmentSubmission, grade int, comment string) error {
+	if assignment.Tutor != tutor.ID {
+		return errors.New("assignment isn't yours")
+	}
+
+	if err := assignment.Grade(submission, grade, comment); err != nil {
+		return err
+	}
+
+	notifications.Notify(&notifications.NotifyRequest{
+		User:    submission.Student,
+		Type:    notifications.AssignmentGraded,
+		Title:   "Assignment graded",
+		Message: fmt.Sprintf("%s graded %s: %d", tutor.GetFirstName(), assignment.Title, grade),
+		Data:    map[string]interface{}{"assignment": assignment.ID, "submission": submission},
+	})
+
+	return nil
+}
+
+// RemindDue reminds the students of the assignments due within the duration
+// that they didn't submit their work yet. Each assignment is reminded of once.
+func (a *assignments) RemindDue(within time.Duration) {
+	due, err := store.GetAssignmentsDue(time.Now().Add(within))
+	if err != nil {
+		logger.Get().Errorf("couldn't get assignments due: %v", err)
+		return
+	}
+
+	d := delivery.New(config.GetConfig())
+	for i := range due {
+		assignment := &due[i]
+		if err := assignment.SetReminded(); err != nil {
+			logger.Get().Errorf("couldn't remind of assignment %s: %v", assignment.ID.Hex(), err)
+			continue
+		}
+
+		lesson, ok := store.GetLessonsStore().Get(assignment.Lesson)
+		if !ok {
+			continue
+		}
+
+		tutor, ok := NewUsers().ByID(assignment.Tutor)
+		if !ok {
+			continue
+		}
+
+		link, err := core.AppURL("/main/account/calendar/details/%s/assignments", lesson.ID.Hex())
+		if err != nil {
+			logger.Get().Errorf("couldn't build assignment link: %v", err)
+			continue
+		}
+
+		subject := lesson.FetchSubjectName()
+		for _, student := range NewUsers().ByIDs(assignment.Students) {
+			if assignment.StatusFor(student.ID, time.Now()) != store.AssignmentAssigned {
+				continue
+			}
+
+			dueAt := utils.FormatTime(assignment.DueAt.In(student.TimezoneLocation()))
+			go d.Send(student, m.TPL_ASSIGNMENT_DUE, &m.P{
+				"FIRST_NAME":       student.GetFirstName(),
+				"TUTOR_NAME":       tutor.GetFirstName(),
+				"ASSIGNMENT_TITLE": assignment.Title,
+				"LESSON_SUBJECT":   subject,
+				"DUE_AT":           dueAt,
+				"ASSIGNMENT_URL":   link,
+			})
+
+			notifications.Notify(&notifications.NotifyRequest{
+				User:    student.ID,
+				Type:    notifications.AssignmentDue,
+				Title:   "Assignment due soon",
+				Message: fmt.Sprintf("%s is due %s", assignment.Title, dueAt),
+				Data:    map[string]interface{}{"assignment": assignment.ID},
+			})
+		}
+	}
+}
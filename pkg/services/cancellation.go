@@ -0,0 +1,206 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+// seatAmounts returns what a seat of the lesson costs its student and pays the tutor, in minor units
+// of the lesson's currency
+func seatAmounts(lesson *store.LessonMgo) (studentCost, tutorPay int64) {
+	tutorPay, _, studentCost = lessonAmounts(lesson.Rate, math.Ceil(lesson.Duration().Minutes()))
+	return studentCost, tutorPay
+}
+
+// ApplyCancellationPolicy applies the tutor's or the platform's cancellation policy to
+// a lesson cancelled by the user. The student cancelling pays the share of the lesson
+// that isn't refunded, and a tutor cancelling pays the penalty. The applied policy is
+// stored on the lesson, or on the student's seat when they leave a group lesson.
+func (l *Lessons) ApplyCancellationPolicy(lesson *store.LessonMgo, user *store.UserMgo) (*store.LessonCancellation, error) {
+	return l.applyCancellation(lesson, newCancellation(lesson, user))
+}
+
+// newCancellation is the cancellation the policy gives the user, while they're still in the lesson
+func newCancellation(lesson *store.LessonMgo, user *store.UserMgo) *store.LessonCancellation {
+	policy, source := store.CancellationPolicyFor(lesson.GetTutor())
+	studentCost, tutorPay := seatAmounts(lesson)
+
+	return store.NewLessonCancellation(policy, source, lesson, user, studentCost, tutorPay)
+}
+
+// applyCancellation saves the cancellation and bills it
+func (l *Lessons) applyCancellation(lesson *store.LessonMgo, c *store.LessonCancellation) (*store.LessonCancellation, error) {
+	if err := l.saveCancellation(lesson, c); err != nil {
+		return nil, err
+	}
+
+	if err := l.settleCancellation(lesson, c, 100, 0); err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+// OverrideCancellation replaces the percents applied to a cancelled lesson, or to
+// the seat of a student that left a group lesson, and settles the difference.
+func (l *Lessons) OverrideCancellation(lesson *store.LessonMgo, admin *store.UserMgo, student *store.UserMgo, o *store.CancellationOverride) (*store.LessonCancellation, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := lesson.Cancellation
+	if student != nil {
+		c = lesson.SeatCancellations[student.ID.Hex()]
+	}
+
+	if c == nil {
+		return nil, errors.New("no cancellation to override")
+	}
+
+	refundBefore, penaltyBefore := c.RefundPercent, c.PenaltyPercent
+
+	studentCost, tutorPay := seatAmounts(lesson)
+	c.Override(admin, o, studentCost, tutorPay)
+
+	if err := l.saveCancellation(lesson, c); err != nil {
+		return nil, err
+	}
+
+	if err := l.settleCancellation(lesson, c, refundBefore, penaltyBefore); err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+func (l *Lessons) saveCancellation(lesson *store.LessonMgo, c *store.LessonCancellation) error {
+	if c.Role == "student" && lesson.IsGroup() {
+		return lesson.SetSeatCancellation(c.By, c)
+	}
+	return lesson.SetCancellation(c)
+}
+
+// cancelledStudents returns the students billed by the cancellation: the student
+// leaving, or everyone when the whole lesson is cancelled.
+func (l *Lessons) cancelledStudents(lesson *store.LessonMgo, c *store.LessonCancellation) []*store.UserMgo {
+	if c.Role == "student" {
+		if student, ok := NewUsers().ByID(c.By); ok {
+			return []*store.UserMgo{student}
+		}
+		return nil
+	}
+	return NewUsers().ByIDs(lesson.Students)
+}
+
+// settleCancellation bills the change from the previously applied percents, which
+// are a full refund and no penalty when the policy is first applied. Everything is
+// settled in the lesson's currency. What couldn't be settled doesn't stop the rest, it's
+// returned.
+func (l *Lessons) settleCancellation(lesson *store.LessonMgo, c *store.LessonCancellation, refundBefore, penaltyBefore int) error {
+	studentCost, tutorPay := seatAmounts(lesson)
+
+	var failed []string
+
+	if diff := refundBefore - c.RefundPercent; diff != 0 {
+		for _, student := range l.cancelledStudents(lesson, c) {
+			if student.IsTestStudent() {
+				continue
+			}
+
+			if diff > 0 {
+				l.chargeSeatShare(student, lesson, diff)
+				continue
+			}
+
+			amount := int64(math.Round(float64(studentCost) * float64(-diff) / 100))
+
+			// credits are in the default currency, lessons in others are refunded to the card.
+			// The student may have left the lesson, their seat is refunded all the same.
+			if lesson.Currency.OrDefault() != store.DefaultCurrency {
+				if _, err := GetPayments().refundStudent(lesson, RefundParams{
+					Student: student.ID,
+					Amount:  amount,
+					Reason:  "cancellation policy overridden",
+				}); err != nil {
+					failed = append(failed, fmt.Sprintf("refund of %s: %v", student.Name(), err))
+				}
+				continue
+			}
+
+			if err := GetPayments().AddCredits(student, CreditParams{
+				Amount: amount,
+				Reason: "refund",
+				Notes:  fmt.Sprintf("Refund for cancelled lesson on %s", lesson.WhenFormatted()),
+				Lesson: &lesson.ID,
+			}); err != nil {
+				failed = append(failed, fmt.Sprintf("refund of %s: %v", student.Name(), err))
+			}
+		}
+	}
+
+	// what's still held on the students' cards isn't owed under the policy
+	for _, student := range l.cancelledStudents(lesson, c) {
+		l.ReleaseHolds(lesson, []bson.ObjectId{student.ID})
+	}
+
+	if diff := c.PenaltyPercent - penaltyBefore; diff != 0 {
+		tutor, ok := NewUsers().ByID(lesson.Tutor)
+		if !ok {
+			failed = append(failed, "tutor penalty: tutor not found")
+			return settleError(failed)
+		}
+
+		params := CreditParams{
+			Amount:   -int64(math.Round(float64(tutorPay) * float64(diff) / 100)),
+			Reason:   "debit",
+			Notes:    fmt.Sprintf("Penalty for cancelling the lesson on %s", lesson.WhenFormatted()),
+			Lesson:   &lesson.ID,
+			Currency: lesson.Currency,
+		}
+
+		if diff < 0 {
+			params.Reason = "refund"
+			params.Notes = fmt.Sprintf("Penalty refund for the lesson on %s", lesson.WhenFormatted())
+		}
+
+		if err := GetPayments().AddCredits(tutor, params); err != nil {
+			failed = append(failed, fmt.Sprintf("tutor penalty: %v", err))
+		}
+	}
+
+	return settleError(failed)
+}
+
+// settleError is the error of the parts of a cancellation that couldn't be settled
+func settleError(failed []string) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return errors.Errorf("couldn't settle the cancellation: %s", strings.Join(failed, "; "))
+}
+
+// chargeSeatShare charges the student the percent of their seat in the lesson
+func (l *Lessons) chargeSeatShare(student *store.UserMgo, lesson *store.LessonMgo, percent int) {
+	tutor, ok := NewUsers().ByID(lesson.Tutor)
+	if !ok {
+		logger.Get().Error("couldn't get tutor from database")
+		return
+	}
+
+	duration := math.Ceil(lesson.Duration().Minutes()) * float64(percent) / 100
+
+	charge, err := GetPayments().ChargeForLesson(student, tutor, duration, lesson.StartsAtDateTimeFormatted(), lesson.ID.Hex(), false, lesson.Rate, lesson.Currency, lessonHold(lesson, student.ID), "")
+	if err != nil {
+		logger.Get().Errorf("couldn't charge student %s on lesson %v: %v\n", student.Name(), lesson.ID.Hex(), err)
+		return
+	}
+
+	l.SaveCharges(lesson, student.ID, charge)
+}
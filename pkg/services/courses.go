@@ -0,0 +1,275 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/notifications"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+type courses struct{}
+
+func GetCourses() *courses {
+	return &courses{}
+}
+
+// EnrollRequest is when the student takes the first session of a course, and where
+// they meet for courses taught in person without a location
+type EnrollRequest struct {
+	When     time.Time `json:"when" binding:"required"`
+	Location string    `json:"location"`
+}
+
+// check validates the course of the tutor and the materials of its sessions
+func (c *courses) check(tutor *store.UserMgo, course *store.Course) error {
+	if !tutor.IsTutor() {
+		return errors.New("only tutors can offer courses")
+	}
+
+	if _, ok := store.GetSubject(course.Subject); !ok || !tutor.HasSubject(course.Subject) {
+		return errors.New("tutor doesn't teach the course's subject")
+	}
+
+	if err := course.Validate(); err != nil {
+		return err
+	}
+
+	if tutor.Tutoring.Meet != store.MeetBoth && tutor.Tutoring.Meet != course.Meet {
+		return fmt.Errorf("tutor is not available to %s", course.Meet.String())
+	}
+
+	for _, session := range course.Sessions {
+		for _, id := range session.Materials {
+			file, ok := store.GetFilesStore().Get(id)
+			if !ok || file.Deleted || file.UploadedBy != tutor.ID {
+				return fmt.Errorf("material %s of session %q isn't in your library", id.Hex(), session.Title)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Create offers the course for students to enroll in
+func (c *courses) Create(tutor *store.UserMgo, course *store.Course) error {
+	course.ID = bson.NewObjectId()
+	course.Tutor = tutor.ID
+	course.Status = store.CourseOpen
+	course.Enrolled = 0
+
+	if err := c.check(tutor, course); err != nil {
+		return err
+	}
+
+	// the course is priced in the tutor's currency, and keeps it
+	course.Currency = tutor.Tutoring.Currency.OrDefault()
+
+	return course.Insert()
+}
+
+// Update replaces the course's details. The students enrolled already keep their lessons.
+func (c *courses) Update(tutor *store.UserMgo, course *store.Course, changes *store.Course) error {
+	if course.Tutor != tutor.ID {
+		return errors.New("course isn't yours")
+	}
+
+	changes.ID = course.ID
+	changes.Tutor = course.Tutor
+	changes.Enrolled = course.Enrolled
+	changes.CreatedAt = course.CreatedAt
+	changes.Currency = course.Currency
+	if changes.Status == "" {
+		changes.Status = course.Status
+	}
+
+	if err := c.check(tutor, changes); err != nil {
+		return err
+	}
+
+	*course = *changes
+	return course.Save()
+}
+
+// Close stops the enrollments in the course
+func (c *courses) Close(tutor *store.UserMgo, course *store.Course) error {
+	if course.Tutor != tutor.ID {
+		return errors.New("course isn't yours")
+	}
+
+	course.Status = store.CourseClosed
+	return course.Save()
+}
+
+// Enroll books a lesson for every session of the course, on its schedule from when.
+// The lessons are priced so they add up to the course's price.
+func (c *courses) Enroll(student *store.UserMgo, course *store.Course, request *EnrollRequest) (*store.CourseEnrollment, error) {
+	if !student.IsStudent() {
+		return nil, errors.New("only students can enroll in courses")
+	}
+
+	if course.Status != store.CourseOpen {
+		return nil, errors.New("course is closed")
+	}
+
+	if _, enrolled := store.GetActiveEnrollment(course.ID, student.ID); enrolled {
+		return nil, errors.New("you are already enrolled in this course")
+	}
+
+	tutor, ok := NewUsers().ByID(course.Tutor)
+	if !ok {
+		return nil, errors.New("couldn't get tutor of course")
+	}
+
+	rule, err := course.Rule()
+	if err != nil {
+		return nil, err
+	}
+
+	// the enrollment is claimed before the lessons are booked, an enrollment made at the same
+	// time fails without booking any
+	enrollment := &store.CourseEnrollment{
+		Course:  course.ID,
+		Tutor:   tutor.ID,
+		Student: student.ID,
+	}
+
+	if err := enrollment.Insert(); err != nil {
+		return nil, err
+	}
+
+	booked := false
+	defer func() {
+		if booked {
+			return
+		}
+		if err := enrollment.Remove(); err != nil {
+			logger.Get().Errorf("couldn't remove course enrollment %s that wasn't booked: %v", enrollment.ID.Hex(), err)
+		}
+	}()
+
+	location := course.Location
+	if location == "" {
+		location = request.Location
+	}
+
+	lessonRequest := &CreateLessonRequest{
+		Tutor:    tutor.ID,
+		Student:  student.ID,
+		Subject:  course.Subject,
+		When:     request.When,
+		Duration: course.Duration,
+		Meet:     course.Meet,
+		Location: location,
+	}
+
+	if rule != nil {
+		lessonRequest.RRule = rule.String()
+	}
+
+	first, err := GetLessons().Create(student, lessonRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	lessons := []bson.ObjectId{first.ID}
+	if first.RecurrentID != nil {
+		var series []store.LessonMgo
+		if err := store.GetCollection("lessons").Find(bson.M{"recurrent_id": *first.RecurrentID}).
+			Select(bson.M{"_id": 1}).Sort("starts_at").All(&series); err != nil {
+			removeEnrollLessons(&first)
+			return nil, errors.Wrap(err, "couldn't get course lessons")
+		}
+
+		lessons = make([]bson.ObjectId, len(series))
+		for i, lesson := range series {
+			lessons[i] = lesson.ID
+		}
+	}
+
+	if err := store.SetCourseLessons(lessons, course); err != nil {
+		removeEnrollLessons(&first)
+		return nil, err
+	}
+
+	if err := enrollment.SetLessons(lessons); err != nil {
+		removeEnrollLessons(&first)
+		return nil, err
+	}
+	booked = true
+
+	if err := course.AddEnrolled(); err != nil {
+		logger.Get().Errorf("couldn't count enrollment %s: %v", enrollment.ID.Hex(), err)
+	}
+
+	notifications.Notify(&notifications.NotifyRequest{
+		User:    tutor.ID,
+		Type:    notifications.CourseEnrolled,
+		Title:   "New course enrollment",
+		Message: fmt.Sprintf("%s enrolled in %s", student.GetFirstName(), course.Title),
+		Data:    map[string]interface{}{"enrollment": enrollment},
+	})
+
+	return enrollment, nil
+}
+
+// removeEnrollLessons removes the lessons booked for an enrollment that couldn't be made,
+// the first one and the rest of its series
+func removeEnrollLessons(first *store.LessonMgo) {
+	query := bson.M{"_id": first.ID}
+	if first.RecurrentID != nil {
+		query = bson.M{"$or": []bson.M{query, {"recurrent_id": *first.RecurrentID}}}
+
+		if err := store.GetCollection("lesson_series").RemoveId(*first.RecurrentID); err != nil {
+			logger.Get().Errorf("couldn't remove series %s of failed enrollment: %v", first.RecurrentID.Hex(), err)
+		}
+	}
+
+	if _, err := store.GetCollection("lessons").RemoveAll(query); err != nil {
+		logger.Get().Errorf("couldn't remove lessons of failed enrollment from %s: %v", first.ID.Hex(), err)
+	}
+}
+
+// onLessonEnded records the progress of the enrollment the lesson was booked for
+func (c *courses) onLessonEnded(t *store.LessonTransition) error {
+	if t.Lesson.Course == nil {
+		return nil
+	}
+
+	enrollment, ok := store.GetLessonEnrollment(t.Lesson.ID)
+	if !ok {
+		return nil
+	}
+
+	wasActive := enrollment.Status == store.EnrollmentActive
+	if err := enrollment.RecordLesson(t.Lesson.ID, t.To == store.LessonCompleted); err != nil {
+		logger.Get().Errorf("couldn't record lesson %s of enrollment %s: %v", t.Lesson.ID.Hex(), enrollment.ID.Hex(), err)
+		return nil
+	}
+
+	if !wasActive || enrollment.Status != store.EnrollmentCompleted {
+		return nil
+	}
+
+	title := "Course completed"
+	if course, ok := store.GetCourse(enrollment.Course); ok {
+		title = fmt.Sprintf("%s completed", course.Title)
+	}
+
+	message := fmt.Sprintf("%d of %d sessions were taught.", len(enrollment.Completed), len(enrollment.Lessons))
+	for _, user := range []bson.ObjectId{enrollment.Tutor, enrollment.Student} {
+		notifications.Notify(&notifications.NotifyRequest{
+			User:    user,
+			Type:    notifications.CourseCompleted,
+			Title:   title,
+			Message: message,
+			Data:    map[string]interface{}{"enrollment": enrollment},
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/ics"
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+const (
+	// externalCalendarDays is how far ahead the busy time is imported
+	externalCalendarDays = 90
+	// maxExternalCalendarSize bounds the size of a feed, in bytes
+	maxExternalCalendarSize = 10 << 20
+)
+
+// externalCalendarClient fetches the feeds. It doesn't connect to private addresses,
+// the URLs are given by the users. It doesn't use a proxy either, the addresses are
+// checked when dialing and a proxy would be dialed instead of the feed's host.
+var externalCalendarClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+
+				if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+					return errors.Errorf("calendar address %s isn't allowed", host)
+				}
+
+				return nil
+			},
+		}).DialContext,
+	},
+}
+
+var privateNetworks = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"}
+
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return true
+	}
+
+	for _, cidr := range privateNetworks {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type externalCalendars struct{}
+
+func GetExternalCalendars() *externalCalendars {
+	return &externalCalendars{}
+}
+
+// Add imports the feed as busy time of the tutor, and syncs it right away
+func (e *externalCalendars) Add(user *store.UserMgo, name, feed string) (*store.ExternalCalendar, error) {
+	cal, err := store.NewExternalCalendar(name, feed)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := user.AddExternalCalendar(cal); err != nil {
+		return nil, err
+	}
+
+	if err := e.Sync(user); err != nil {
+		return nil, err
+	}
+
+	cal, _ = user.GetExternalCalendar(cal.ID)
+	return cal, nil
+}
+
+// Sync imports the busy time of the tutor's calendars. A calendar that fails keeps
+// the busy time imported before, and its error is saved for the tutor to see. Each
+// calendar is saved on its own, a calendar removed meanwhile isn't brought back.
+func (e *externalCalendars) Sync(user *store.UserMgo) error {
+	from := time.Now()
+	to := from.AddDate(0, 0, externalCalendarDays)
+
+	calendars := make([]store.ExternalCalendar, len(user.ExternalCalendars))
+	for i, c := range user.ExternalCalendars {
+		calendars[i] = *c
+	}
+
+	var failed error
+	for i := range calendars {
+		cal := &calendars[i]
+		now := time.Now()
+		cal.SyncedAt = &now
+
+		slots, err := e.fetch(cal, user.TimezoneLocation(), from, to)
+		if err != nil {
+			logger.Get().Infof("couldn't sync calendar %s of %s: %v", cal.ID.Hex(), user.Name(), err)
+			cal.Error = err.Error()
+		} else {
+			cal.Error = ""
+			cal.Events = len(slots)
+		}
+
+		if err := user.SetExternalCalendarSynced(cal, slots); err != nil {
+			logger.Get().Errorf("couldn't save calendar %s of %s: %v", cal.ID.Hex(), user.Name(), err)
+			failed = err
+		}
+	}
+
+	return failed
+}
+
+func (e *externalCalendars) fetch(cal *store.ExternalCalendar, loc *time.Location, from, to time.Time) ([]*store.AvailabilitySlot, error) {
+	res, err := externalCalendarClient.Get(cal.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't download calendar")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("calendar responded with %s", res.Status)
+	}
+
+	busy, err := ics.ParseBusy(io.LimitReader(res.Body, maxExternalCalendarSize), from, to, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := make([]*store.AvailabilitySlot, len(busy))
+	for i, b := range busy {
+		slots[i] = &store.AvailabilitySlot{
+			ID:       bson.NewObjectId(),
+			From:     b.From.UTC(),
+			To:       b.To.UTC(),
+			Timezone: loc.String(),
+			Source:   &cal.ID,
+		}
+	}
+
+	return slots, nil
+}
+
+// SyncAll imports the busy time of every tutor's external calendars
+func (e *externalCalendars) SyncAll() {
+	users, err := store.GetUsersWithExternalCalendars()
+	if err != nil {
+		logger.Get().Errorf("couldn't get users with external calendars: %v", err)
+		return
+	}
+
+	for _, user := range users {
+		if err := e.Sync(user); err != nil {
+			logger.Get().Errorf("couldn't sync external calendars of %s: %v", user.Name(), err)
+		}
+	}
+}
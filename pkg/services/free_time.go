@@ -0,0 +1,151 @@
+package services
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/learnt/api/pkg/store"
+	"gitlab.com/learnt/api/pkg/utils/timeline"
+)
+
+const (
+	// freeTimeStep is how far apart the candidate start times are
+	freeTimeStep = 30 * time.Minute
+	// freeTimeMaxRange bounds the interval searched for free time
+	freeTimeMaxRange = 31 * 24 * time.Hour
+	// freeTimeDayStart and freeTimeDayEnd bound the hours preferred in both users' timezones
+	freeTimeDayStart = 8
+	freeTimeDayEnd   = 21
+)
+
+// FreeSlot is a time both the tutor and the student are free for a lesson
+type FreeSlot struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Rank orders the slots, the lower the better.
+	Rank int `json:"rank"`
+}
+
+type freeTime struct{}
+
+func GetFreeTime() *freeTime {
+	return &freeTime{}
+}
+
+// Free returns the tutor's availability between from and to, without their
+// blackout and the lessons booked by either user
+func (f *freeTime) Free(tutor, student *store.UserMgo, from, to time.Time) (*timeline.Timeline, error) {
+	availability := tutor.GetAvailabilityWithBlackout(false)
+	if availability == nil {
+		return nil, errors.New("tutor has no availability set")
+	}
+
+	slots, err := availability.Get(from, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't get tutor availability")
+	}
+
+	free := timeline.NewTimeline(slots...)
+
+	if blackout := tutor.GetBlackout(false); blackout != nil {
+		slots, err := blackout.Get(from, to)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't get tutor blackout")
+		}
+		free = free.Difference(timeline.NewTimeline(slots...))
+	}
+
+	// weekly lessons repeat on the tutor's wall clock, like their availability
+	booked := append(tutor.GetLessonsTimelineSlots(), student.GetLessonsTimelineSlots()...)
+	for _, slot := range booked {
+		if slot.GetOccurence() == timeline.Weekly {
+			slot.SetTimezone(tutor.TimezoneLocation())
+		}
+	}
+	free = free.Difference(timeline.NewTimeline(timeline.Expand(booked, from, to)...))
+
+	return free.Intersect(timeline.NewTimeline(&timeline.Slot{From: from, To: to})), nil
+}
+
+// Mutual finds the times between from and to that both the tutor and the student
+// are free for a lesson of the duration. The slots are in loc, ranked first by
+// how many of the users they fall outside of daytime for, then soonest first.
+func (f *freeTime) Mutual(tutor, student *store.UserMgo, from, to time.Time, duration time.Duration, loc *time.Location, limit int) ([]FreeSlot, error) {
+	if !to.After(from) {
+		return nil, errors.New("invalid interval")
+	}
+
+	if to.Sub(from) > freeTimeMaxRange {
+		return nil, errors.New("interval can't be longer than 31 days")
+	}
+
+	if duration <= 0 {
+		return nil, errors.New("invalid lesson duration")
+	}
+
+	if now := time.Now(); from.Before(now) {
+		from = now
+	}
+
+	free, err := f.Free(tutor, student, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	locations := []*time.Location{tutor.TimezoneLocation(), student.TimezoneLocation()}
+
+	candidates := make([]FreeSlot, 0)
+	for _, slot := range free.Slots() {
+		// start on the step, in the requester's timezone
+		start := truncateIn(slot.GetFrom(), loc, freeTimeStep)
+		if start.Before(slot.GetFrom()) {
+			start = start.Add(freeTimeStep)
+		}
+
+		for ; !start.Add(duration).After(slot.GetTo()); start = start.Add(freeTimeStep) {
+			candidates = append(candidates, FreeSlot{
+				From: start,
+				To:   start.Add(duration),
+				Rank: offHours(start, start.Add(duration), locations),
+			})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Rank != candidates[j].Rank {
+			return candidates[i].Rank < candidates[j].Rank
+		}
+		return candidates[i].From.Before(candidates[j].From)
+	})
+
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	return candidates, nil
+}
+
+// truncateIn rounds the time down to a multiple of the step on the location's wall clock.
+// Time.Truncate rounds from UTC, which is off the step in zones like +5:45.
+func truncateIn(t time.Time, loc *time.Location, step time.Duration) time.Time {
+	t = t.In(loc)
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second
+
+	return t.Add(shift).Truncate(step).Add(-shift)
+}
+
+// offHours counts the locations where the interval isn't within daytime hours
+func offHours(from, to time.Time, locations []*time.Location) (count int) {
+	for _, loc := range locations {
+		start, end := from.In(loc), to.In(loc)
+		dayEnd := time.Date(start.Year(), start.Month(), start.Day(), freeTimeDayEnd, 0, 0, 0, loc)
+
+		if start.Hour() < freeTimeDayStart || end.After(dayEnd) {
+			count++
+		}
+	}
+	return
+}
@@ -0,0 +1,21 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTruncateIn(t *testing.T) {
+	kathmandu := time.FixedZone("NPT", 5*3600+45*60)
+	at := time.Date(2020, 6, 1, 10, 40, 0, 0, kathmandu)
+
+	// 10:40 rounds down to 10:30 in Kathmandu, not to 10:15 as it does from UTC
+	got := truncateIn(at, kathmandu, 30*time.Minute)
+	if want := time.Date(2020, 6, 1, 10, 30, 0, 0, kathmandu); !got.Equal(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if got := truncateIn(at, time.UTC, 30*time.Minute); !got.Equal(at.Truncate(30 * time.Minute)) {
+		t.Errorf("expected rounding in UTC unchanged, got %v", got)
+	}
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"gitlab.com/learnt/api/pkg/services/stripe"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+// Payment gateways that can be set in the config's payments.gateway
+const (
+	gatewayStripe = "stripe"
+	gatewayMemory = "memory"
+)
+
+// PaymentGateway moves the money of the platform: it keeps the customers paying for
+// lessons and their cards, the connect accounts of the tutors and their bank accounts,
+// and charges, holds, refunds and transfers between them. Amounts are in minor units, of
+// the currency passed or of the default one.
+type PaymentGateway interface {
+	// NewCustomer creates a customer that pays with cards and returns its id
+	NewCustomer(name, email string, metadata map[string]string) (string, error)
+	// CustomerBalance is what the customer has on their balance
+	CustomerBalance(customerID string) (int64, error)
+	// AddToCustomerBalance adds to the customer's balance and returns the new balance
+	AddToCustomerBalance(customerID string, amount int64) (int64, error)
+	// SetDefaultSource makes a card or bank account the one charged by default
+	SetDefaultSource(customerID, sourceID string) error
+
+	// NewCardToken creates a token to add the card with
+	NewCardToken(stripeID, name, number, expMonth, expYear, cvc string) (string, error)
+	NewCard(stripeID, token string) (*stripe.Card, error)
+	DeleteCard(stripeID, cardID string) (*stripe.Card, error)
+	ListCards(stripeID string) ([]*stripe.Card, error)
+	GetCard(stripeID, cardID string) (*stripe.Card, error)
+
+	// NewBankAccountToken creates a token to add the bank account with, in the currency's country
+	NewBankAccountToken(name, number, routing, holderType string, currency store.Currency) (string, error)
+	ListBankAccounts(stripeID string) ([]*stripe.BankAccount, error)
+	DeleteBankAccount(stripeID, bankAccountID string) error
+	// ReplaceBankAccount adds the bank account and deletes the others
+	ReplaceBankAccount(stripeID, token string) (*stripe.BankAccount, error)
+
+	// NewAccount creates a connect account tutors are paid to
+	NewAccount(params *stripe.AccountParams) (*stripe.Account, error)
+	GetAccount(accountID string) (*stripe.Account, error)
+
+	// Charge charges the customer and pays the payee all but the fee
+	Charge(customerID, payeeAccount string, amount, fee int64, description, prefix, suffix string, metadata map[string]string) (string, error)
+	// ChargePlatform charges the customer to the platform, for money paid to tutors later
+	ChargePlatform(customerID string, amount int64, currency store.Currency, description, prefix string, metadata map[string]string) (string, error)
+	// ChargeCorporateCard pays the payee from the platform's own card
+	ChargeCorporateCard(payeeAccount string, amount int64, description, prefix, suffix string) (string, error)
+
+	// AuthorizeCharge holds the charge on the customer's card, to be captured or cancelled later
+	AuthorizeCharge(customerID, payeeAccount string, amount, fee int64, description, prefix, suffix string, metadata map[string]string) (string, error)
+	// AuthorizePlatform holds the charge on the customer's card for the platform, for money paid to tutors later
+	AuthorizePlatform(customerID string, amount int64, currency store.Currency, description, prefix string, metadata map[string]string) (string, error)
+	// CaptureCharge captures the amount of the hold and releases the rest, returning the refunds releasing it
+	CaptureCharge(chargeID string, amount, fee int64, metadata map[string]string) (string, []string, error)
+	// CancelCharge releases a hold, or refunds a captured charge in full
+	CancelCharge(chargeID string) (string, error)
+
+	// RefundCharge refunds part of a captured charge
+	RefundCharge(chargeID string, amount int64, metadata map[string]string) (string, error)
+	// ReverseChargeTransfer takes back up to amount of what the charge paid the payee, and
+	// returns how much it took back
+	ReverseChargeTransfer(chargeID string, amount int64, description string) (string, int64, error)
+
+	// Transfer pays the payee from the platform's balance, the transfers of a payout batch share the group.
+	// A transfer made again with the same key returns the first one instead of paying twice.
+	Transfer(payeeAccount string, amount int64, currency store.Currency, description, group, key string, metadata map[string]string) (string, error)
+}
+
+// gateway is the payment gateway set by the config when payments are initialized
+var gateway PaymentGateway = stripeGateway{}
+
+// Gateway returns the payment gateway the payments go through
+func (p *payments) Gateway() PaymentGateway {
+	return gateway
+}
+
+// stripeGateway moves the money through Stripe
+type stripeGateway struct{}
+
+func (stripeGateway) NewCustomer(name, email string, metadata map[string]string) (string, error) {
+	return stripe.NewCustomer(name, email, metadata)
+}
+
+func (stripeGateway) CustomerBalance(customerID string) (int64, error) {
+	return stripe.CustomerGetBalance(customerID)
+}
+
+func (stripeGateway) AddToCustomerBalance(customerID string, amount int64) (int64, error) {
+	return stripe.CustomerAddToBalance(customerID, amount)
+}
+
+func (stripeGateway) SetDefaultSource(customerID, sourceID string) error {
+	return stripe.CustomerSetDefaultSource(customerID, sourceID)
+}
+
+func (stripeGateway) NewCardToken(stripeID, name, number, expMonth, expYear, cvc string) (string, error) {
+	return stripe.NewCardToken(stripeID, name, number, expMonth, expYear, cvc)
+}
+
+func (stripeGateway) NewCard(stripeID, token string) (*stripe.Card, error) {
+	return stripe.NewCard(stripeID, token)
+}
+
+func (stripeGateway) DeleteCard(stripeID, cardID string) (*stripe.Card, error) {
+	return stripe.DeleteCard(stripeID, cardID)
+}
+
+func (stripeGateway) ListCards(stripeID string) ([]*stripe.Card, error) {
+	return stripe.ListCards(stripeID)
+}
+
+func (stripeGateway) GetCard(stripeID, cardID string) (*stripe.Card, error) {
+	return stripe.GetCard(stripeID, cardID)
+}
+
+func (stripeGateway) NewBankAccountToken(name, number, routing, holderType string, currency store.Currency) (string, error) {
+	currency = currency.OrDefault()
+	return stripe.NewExternalBankAccountToken(name, number, routing, holderType, currency.Country(), string(currency))
+}
+
+func (stripeGateway) ListBankAccounts(stripeID string) ([]*stripe.BankAccount, error) {
+	return stripe.ListBankAccounts(stripeID)
+}
+
+func (stripeGateway) DeleteBankAccount(stripeID, bankAccountID string) error {
+	return stripe.DeleteBankAccount(stripeID, bankAccountID)
+}
+
+func (stripeGateway) ReplaceBankAccount(stripeID, token string) (*stripe.BankAccount, error) {
+	return stripe.ReplaceBankAccount(stripeID, token)
+}
+
+func (stripeGateway) NewAccount(params *stripe.AccountParams) (*stripe.Account, error) {
+	return stripe.NewAccount(params)
+}
+
+func (stripeGateway) GetAccount(accountID string) (*stripe.Account, error) {
+	return stripe.GetAccount(accountID)
+}
+
+func (stripeGateway) Charge(customerID, payeeAccount string, amount, fee int64, description, prefix, suffix string, metadata map[string]string) (string, error) {
+	return stripe.Charge(customerID, payeeAccount, amount, fee, description, prefix, suffix, metadata)
+}
+
+func (stripeGateway) ChargePlatform(customerID string, amount int64, currency store.Currency, description, prefix string, metadata map[string]string) (string, error) {
+	return stripe.ChargePlatform(customerID, amount, string(currency.OrDefault()), description, prefix, metadata)
+}
+
+func (stripeGateway) ChargeCorporateCard(payeeAccount string, amount int64, description, prefix, suffix string) (string, error) {
+	return stripe.ChargeCorporateCardCompany(payeeAccount, amount, description, prefix, suffix)
+}
+
+func (stripeGateway) AuthorizeCharge(customerID, payeeAccount string, amount, fee int64, description, prefix, suffix string, metadata map[string]string) (string, error) {
+	return stripe.AuthorizeCharge(customerID, payeeAccount, amount, fee, description, prefix, suffix, metadata)
+}
+
+func (stripeGateway) AuthorizePlatform(customerID string, amount int64, currency store.Currency, description, prefix string, metadata map[string]string) (string, error) {
+	return stripe.AuthorizePlatform(customerID, amount, string(currency.OrDefault()), description, prefix, metadata)
+}
+
+func (stripeGateway) CaptureCharge(chargeID string, amount, fee int64, metadata map[string]string) (string, []string, error) {
+	return stripe.UpdateAndCaptureCharge(chargeID, amount, fee, metadata)
+}
+
+func (stripeGateway) CancelCharge(chargeID string) (string, error) {
+	return stripe.CancelCharge(chargeID)
+}
+
+func (stripeGateway) RefundCharge(chargeID string, amount int64, metadata map[string]string) (string, error) {
+	return stripe.RefundCharge(chargeID, amount, metadata)
+}
+
+func (stripeGateway) ReverseChargeTransfer(chargeID string, amount int64, description string) (string, int64, error) {
+	return stripe.ReverseChargeTransfer(chargeID, amount, description)
+}
+
+func (stripeGateway) Transfer(payeeAccount string, amount int64, currency store.Currency, description, group, key string, metadata map[string]string) (string, error) {
+	return stripe.Transfer(payeeAccount, amount, string(currency.OrDefault()), description, group, key, metadata)
+}
@@ -0,0 +1,677 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	stripeGo "github.com/stripe/stripe-go"
+
+	"gitlab.com/learnt/api/pkg/services/stripe"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+// MemoryCharge is a charge made through the memory gateway. Charges paid from the
+// platform's corporate card have no customer, and holds aren't captured yet.
+type MemoryCharge struct {
+	ID             string
+	Customer       string
+	Payee          string
+	Amount         int64
+	Currency       store.Currency
+	AmountCaptured int64
+	Fee            int64
+	Refunded       int64
+	Reversed       int64
+	Description    string
+	Metadata       map[string]string
+}
+
+// Transferred is what the charge paid its payee when it was captured
+func (c *MemoryCharge) Transferred() int64 {
+	if c.Payee == "" || c.AmountCaptured == 0 {
+		return 0
+	}
+	return c.AmountCaptured - c.Fee
+}
+
+// MemoryTransfer is a transfer from the platform made through the memory gateway
+type MemoryTransfer struct {
+	ID          string
+	Payee       string
+	Amount      int64
+	Currency    store.Currency
+	Group       string
+	Description string
+	Metadata    map[string]string
+}
+
+type memoryCustomer struct {
+	balance  int64
+	declined bool
+	cards    []*stripe.Card
+}
+
+// MemoryGateway is a payment gateway that keeps everything in memory, for tests and
+// development without Stripe. Its ids are numbered in the order they're made, so the
+// same calls always get the same ids.
+type MemoryGateway struct {
+	mu sync.Mutex
+
+	next      int
+	customers map[string]*memoryCustomer
+	accounts  map[string]*stripe.Account
+	cards     map[string]*stripe.Card
+	banks     map[string]*stripe.BankAccount
+	owned     map[string][]*stripe.BankAccount
+	charges   map[string]*MemoryCharge
+	order     []string
+	transfers []MemoryTransfer
+	// keys are the transfers by their idempotency key, failedKeys the failures
+	keys       map[string]string
+	failedKeys map[string]error
+}
+
+// NewMemoryGateway returns an empty memory gateway
+func NewMemoryGateway() *MemoryGateway {
+	return &MemoryGateway{
+		customers:  make(map[string]*memoryCustomer),
+		accounts:   make(map[string]*stripe.Account),
+		cards:      make(map[string]*stripe.Card),
+		banks:      make(map[string]*stripe.BankAccount),
+		owned:      make(map[string][]*stripe.BankAccount),
+		charges:    make(map[string]*MemoryCharge),
+		keys:       make(map[string]string),
+		failedKeys: make(map[string]error),
+	}
+}
+
+func (g *MemoryGateway) newID(prefix string) string {
+	g.next++
+	return prefix + "_mem_" + strconv.Itoa(g.next)
+}
+
+// Decline makes the charges and holds on the customer's cards fail
+func (g *MemoryGateway) Decline(customerID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if c, ok := g.customers[customerID]; ok {
+		c.declined = true
+	}
+}
+
+// GetCharge returns a copy of the charge
+func (g *MemoryGateway) GetCharge(chargeID string) (MemoryCharge, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ch, ok := g.charges[chargeID]
+	if !ok {
+		return MemoryCharge{}, false
+	}
+	return *ch, true
+}
+
+// Charges returns copies of the charges made, in the order they were made
+func (g *MemoryGateway) Charges() []MemoryCharge {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	charges := make([]MemoryCharge, 0, len(g.order))
+	for _, id := range g.order {
+		charges = append(charges, *g.charges[id])
+	}
+	return charges
+}
+
+// Transfers returns the transfers made, in the order they were made
+func (g *MemoryGateway) Transfers() []MemoryTransfer {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return append([]MemoryTransfer(nil), g.transfers...)
+}
+
+// SetPayoutsEnabled sets whether the account can be paid, transfers to it fail when it can't
+func (g *MemoryGateway) SetPayoutsEnabled(accountID string, enabled bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if a, ok := g.accounts[accountID]; ok {
+		a.PayoutsEnabled = enabled
+	}
+}
+
+func (g *MemoryGateway) customer(customerID string) (*memoryCustomer, error) {
+	c, ok := g.customers[customerID]
+	if !ok {
+		return nil, errors.Errorf("no such customer: %s", customerID)
+	}
+	return c, nil
+}
+
+func (g *MemoryGateway) account(accountID string) (*stripe.Account, error) {
+	a, ok := g.accounts[accountID]
+	if !ok {
+		return nil, errors.Errorf("no such account: %s", accountID)
+	}
+	return a, nil
+}
+
+// bankOwner checks the bank accounts' owner is a customer or an account
+func (g *MemoryGateway) bankOwner(stripeID string) error {
+	if _, ok := g.customers[stripeID]; ok {
+		return nil
+	}
+
+	_, err := g.account(stripeID)
+	return err
+}
+
+func (g *MemoryGateway) charge(chargeID string) (*MemoryCharge, error) {
+	ch, ok := g.charges[chargeID]
+	if !ok {
+		return nil, errors.Errorf("no such charge: %s", chargeID)
+	}
+	return ch, nil
+}
+
+func (g *MemoryGateway) NewCustomer(name, email string, metadata map[string]string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	id := g.newID("cus")
+	g.customers[id] = &memoryCustomer{}
+	return id, nil
+}
+
+func (g *MemoryGateway) CustomerBalance(customerID string) (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, err := g.customer(customerID)
+	if err != nil {
+		return 0, err
+	}
+	return c.balance, nil
+}
+
+func (g *MemoryGateway) AddToCustomerBalance(customerID string, amount int64) (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, err := g.customer(customerID)
+	if err != nil {
+		return 0, err
+	}
+
+	c.balance += amount
+	return c.balance, nil
+}
+
+func (g *MemoryGateway) SetDefaultSource(customerID, sourceID string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, err := g.customer(customerID)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, card := range c.cards {
+		card.Default = card.ID == sourceID
+		found = found || card.Default
+	}
+
+	if !found {
+		return errors.Errorf("no such source: %s", sourceID)
+	}
+	return nil
+}
+
+func (g *MemoryGateway) NewCardToken(stripeID, name, number, expMonth, expYear, cvc string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(number) < 4 {
+		return "", errors.New("card number is invalid")
+	}
+
+	month, err := strconv.ParseUint(expMonth, 10, 8)
+	if err != nil {
+		return "", errors.Wrap(err, "expiry month is invalid")
+	}
+
+	year, err := strconv.ParseUint(expYear, 10, 16)
+	if err != nil {
+		return "", errors.Wrap(err, "expiry year is invalid")
+	}
+
+	id := g.newID("tok")
+	g.cards[id] = &stripe.Card{
+		Number: number[len(number)-4:],
+		Month:  uint8(month),
+		Year:   uint16(year),
+		Type:   stripe.CardBrand(stripeGo.CardBrandVisa),
+	}
+	return id, nil
+}
+
+func (g *MemoryGateway) NewCard(stripeID, token string) (*stripe.Card, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, err := g.customer(stripeID)
+	if err != nil {
+		return nil, err
+	}
+
+	card, ok := g.cards[token]
+	if !ok {
+		return nil, errors.Errorf("no such token: %s", token)
+	}
+	delete(g.cards, token)
+
+	card.ID = g.newID("card")
+	card.Default = len(c.cards) == 0
+	c.cards = append(c.cards, card)
+
+	copied := *card
+	return &copied, nil
+}
+
+func (g *MemoryGateway) DeleteCard(stripeID, cardID string) (*stripe.Card, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, err := g.customer(stripeID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, card := range c.cards {
+		if card.ID == cardID {
+			c.cards = append(c.cards[:i], c.cards[i+1:]...)
+			copied := *card
+			return &copied, nil
+		}
+	}
+	return nil, errors.Errorf("no such card: %s", cardID)
+}
+
+func (g *MemoryGateway) ListCards(stripeID string) ([]*stripe.Card, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, err := g.customer(stripeID)
+	if err != nil {
+		return nil, err
+	}
+
+	cards := make([]*stripe.Card, 0, len(c.cards))
+	for _, card := range c.cards {
+		copied := *card
+		cards = append(cards, &copied)
+	}
+	return cards, nil
+}
+
+func (g *MemoryGateway) GetCard(stripeID, cardID string) (*stripe.Card, error) {
+	cards, err := g.ListCards(stripeID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, card := range cards {
+		if card.ID == cardID {
+			return card, nil
+		}
+	}
+	return nil, errors.Errorf("no such card: %s", cardID)
+}
+
+func (g *MemoryGateway) NewBankAccountToken(name, number, routing, holderType string, currency store.Currency) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(number) < 4 || routing == "" || !currency.OrDefault().Valid() {
+		return "", errors.New("bank account is invalid")
+	}
+
+	id := g.newID("btok")
+	g.banks[id] = &stripe.BankAccount{
+		Name:    name,
+		Type:    holderType,
+		Number:  number[len(number)-4:],
+		Routing: routing,
+		Status:  stripe.BankAccountStatus(stripeGo.BankAccountStatusNew),
+	}
+	return id, nil
+}
+
+func (g *MemoryGateway) ListBankAccounts(stripeID string) ([]*stripe.BankAccount, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.bankOwner(stripeID); err != nil {
+		return nil, err
+	}
+
+	banks := make([]*stripe.BankAccount, 0, len(g.owned[stripeID]))
+	for _, bank := range g.owned[stripeID] {
+		copied := *bank
+		banks = append(banks, &copied)
+	}
+	return banks, nil
+}
+
+func (g *MemoryGateway) DeleteBankAccount(stripeID, bankAccountID string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.bankOwner(stripeID); err != nil {
+		return err
+	}
+
+	banks := g.owned[stripeID]
+	for i, bank := range banks {
+		if bank.ID == bankAccountID {
+			g.owned[stripeID] = append(banks[:i], banks[i+1:]...)
+			return nil
+		}
+	}
+	return errors.Errorf("no such bank account: %s", bankAccountID)
+}
+
+func (g *MemoryGateway) ReplaceBankAccount(stripeID, token string) (*stripe.BankAccount, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.bankOwner(stripeID); err != nil {
+		return nil, err
+	}
+
+	bank, ok := g.banks[token]
+	if !ok {
+		return nil, errors.Errorf("no such token: %s", token)
+	}
+	delete(g.banks, token)
+
+	bank.ID = g.newID("ba")
+	bank.Default = true
+	g.owned[stripeID] = []*stripe.BankAccount{bank}
+
+	copied := *bank
+	return &copied, nil
+}
+
+func (g *MemoryGateway) NewAccount(params *stripe.AccountParams) (*stripe.Account, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !params.TermsAccepted {
+		return nil, errors.New("user has not accepted the payout terms")
+	}
+
+	account := &stripe.Account{
+		ID:             g.newID("acct"),
+		ChargesEnabled: true,
+		PayoutsEnabled: true,
+		LearntID:       params.MetaData[stripe.MetadataLearntAccountID],
+	}
+	g.accounts[account.ID] = account
+
+	copied := *account
+	return &copied, nil
+}
+
+func (g *MemoryGateway) GetAccount(accountID string) (*stripe.Account, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	a, err := g.account(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := *a
+	return &copied, nil
+}
+
+// newCharge charges the customer's default card, capturing it or holding it
+func (g *MemoryGateway) newCharge(customerID, payeeAccount string, amount, fee int64, currency store.Currency, description string, metadata map[string]string, capture bool) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if amount <= 0 || fee < 0 || fee > amount {
+		return "", errors.Errorf("invalid amount %d with fee %d", amount, fee)
+	}
+
+	if customerID != "" {
+		c, err := g.customer(customerID)
+		if err != nil {
+			return "", err
+		}
+
+		if len(c.cards) == 0 {
+			return "", errors.New("customer has no card")
+		}
+
+		if c.declined {
+			return "", errors.New("your card was declined")
+		}
+	}
+
+	if payeeAccount != "" {
+		if _, err := g.account(payeeAccount); err != nil {
+			return "", err
+		}
+	}
+
+	prefix := "ch"
+	if customerID == "" {
+		prefix = "py"
+	}
+
+	ch := &MemoryCharge{
+		ID:          g.newID(prefix),
+		Customer:    customerID,
+		Payee:       payeeAccount,
+		Amount:      amount,
+		Currency:    currency.OrDefault(),
+		Fee:         fee,
+		Description: description,
+		Metadata:    metadata,
+	}
+
+	if capture {
+		ch.AmountCaptured = amount
+	}
+
+	g.charges[ch.ID] = ch
+	g.order = append(g.order, ch.ID)
+	return ch.ID, nil
+}
+
+func (g *MemoryGateway) Charge(customerID, payeeAccount string, amount, fee int64, description, prefix, suffix string, metadata map[string]string) (string, error) {
+	if customerID == "" || payeeAccount == "" {
+		return "", errors.New("charge needs a customer and a payee")
+	}
+	return g.newCharge(customerID, payeeAccount, amount, fee, store.DefaultCurrency, description, metadata, true)
+}
+
+func (g *MemoryGateway) ChargePlatform(customerID string, amount int64, currency store.Currency, description, prefix string, metadata map[string]string) (string, error) {
+	if customerID == "" {
+		return "", errors.New("charge needs a customer")
+	}
+	return g.newCharge(customerID, "", amount, 0, currency, description, metadata, true)
+}
+
+func (g *MemoryGateway) ChargeCorporateCard(payeeAccount string, amount int64, description, prefix, suffix string) (string, error) {
+	if payeeAccount == "" {
+		return "", errors.New("charge needs a payee")
+	}
+	return g.newCharge("", payeeAccount, amount, 0, store.DefaultCurrency, description, nil, true)
+}
+
+func (g *MemoryGateway) AuthorizeCharge(customerID, payeeAccount string, amount, fee int64, description, prefix, suffix string, metadata map[string]string) (string, error) {
+	if customerID == "" || payeeAccount == "" {
+		return "", errors.New("charge needs a customer and a payee")
+	}
+	return g.newCharge(customerID, payeeAccount, amount, fee, store.DefaultCurrency, description, metadata, false)
+}
+
+func (g *MemoryGateway) AuthorizePlatform(customerID string, amount int64, currency store.Currency, description, prefix string, metadata map[string]string) (string, error) {
+	if customerID == "" {
+		return "", errors.New("charge needs a customer")
+	}
+	return g.newCharge(customerID, "", amount, 0, currency, description, metadata, false)
+}
+
+func (g *MemoryGateway) CaptureCharge(chargeID string, amount, fee int64, metadata map[string]string) (string, []string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ch, err := g.charge(chargeID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if ch.AmountCaptured > 0 || ch.Refunded > 0 {
+		return "", nil, errors.Errorf("charge %s is already captured or released", chargeID)
+	}
+
+	if amount <= 0 || amount > ch.Amount || fee < 0 || fee > amount {
+		return "", nil, errors.Errorf("can't capture %d with fee %d of %d", amount, fee, ch.Amount)
+	}
+
+	ch.AmountCaptured = amount
+	ch.Fee = fee
+	for k, v := range metadata {
+		if ch.Metadata == nil {
+			ch.Metadata = make(map[string]string)
+		}
+		ch.Metadata[k] = v
+	}
+
+	if amount == ch.Amount {
+		return ch.ID, nil, nil
+	}
+
+	ch.Refunded = ch.Amount - amount
+	return ch.ID, []string{g.newID("re")}, nil
+}
+
+func (g *MemoryGateway) CancelCharge(chargeID string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ch, err := g.charge(chargeID)
+	if err != nil {
+		return "", err
+	}
+
+	if ch.Refunded == ch.Amount {
+		return "", errors.Errorf("charge %s is already refunded", chargeID)
+	}
+
+	ch.Refunded = ch.Amount
+	return g.newID("re"), nil
+}
+
+func (g *MemoryGateway) RefundCharge(chargeID string, amount int64, metadata map[string]string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ch, err := g.charge(chargeID)
+	if err != nil {
+		return "", err
+	}
+
+	if ch.AmountCaptured == 0 {
+		return "", errors.Errorf("charge %s isn't captured", chargeID)
+	}
+
+	if amount <= 0 || amount > ch.Amount-ch.Refunded {
+		return "", errors.Errorf("can't refund %d of charge %s, %d left", amount, chargeID, ch.Amount-ch.Refunded)
+	}
+
+	ch.Refunded += amount
+	return g.newID("re"), nil
+}
+
+func (g *MemoryGateway) ReverseChargeTransfer(chargeID string, amount int64, description string) (string, int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if amount <= 0 {
+		return "", 0, errors.New("attempting to reverse a zero or negative amount")
+	}
+
+	ch, err := g.charge(chargeID)
+	if err != nil {
+		return "", 0, err
+	}
+
+	left := ch.Transferred() - ch.Reversed
+	if left <= 0 {
+		return "", 0, nil
+	}
+
+	if amount > left {
+		amount = left
+	}
+
+	ch.Reversed += amount
+	return g.newID("trr"), amount, nil
+}
+
+func (g *MemoryGateway) Transfer(payeeAccount string, amount int64, currency store.Currency, description, group, key string, metadata map[string]string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if id, ok := g.keys[key]; ok && key != "" {
+		return id, nil
+	}
+
+	if err, ok := g.failedKeys[key]; ok && key != "" {
+		return "", err
+	}
+
+	if amount <= 0 {
+		return "", errors.New("attempting to transfer a zero or negative amount")
+	}
+
+	a, err := g.account(payeeAccount)
+	if err != nil {
+		return "", err
+	}
+
+	if !a.PayoutsEnabled {
+		// Stripe answers the key with the same failure, until it's forgotten a day later
+		err := errors.Wrap(&stripe.FailedError{Msg: fmt.Sprintf("account %s can't be paid", payeeAccount)}, "could not transfer to account")
+		if key != "" {
+			g.failedKeys[key] = err
+		}
+		return "", err
+	}
+
+	tr := MemoryTransfer{
+		ID:          g.newID("tr"),
+		Payee:       payeeAccount,
+		Amount:      amount,
+		Currency:    currency.OrDefault(),
+		Group:       group,
+		Description: description,
+		Metadata:    metadata,
+	}
+	g.transfers = append(g.transfers, tr)
+	if key != "" {
+		g.keys[key] = tr.ID
+	}
+
+	return tr.ID, nil
+}
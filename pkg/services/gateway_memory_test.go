@@ -0,0 +1,149 @@
+package services
+
+import (
+	"testing"
+
+	"gitlab.com/learnt/api/pkg/services/stripe"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+// useMemoryGateway makes the payments go through a memory gateway until restored
+func useMemoryGateway() (g *MemoryGateway, restore func()) {
+	g = NewMemoryGateway()
+	before := gateway
+	gateway = g
+	return g, func() { gateway = before }
+}
+
+// memoryCardCustomer creates a customer with a card on the gateway
+func memoryCardCustomer(t *testing.T, g *MemoryGateway) string {
+	customer, err := g.NewCustomer("Student", "student@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := g.NewCardToken(customer, "Student", "4242424242424242", "12", "2030", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := g.NewCard(customer, token); err != nil {
+		t.Fatal(err)
+	}
+
+	return customer
+}
+
+func memoryAccount(t *testing.T, g *MemoryGateway) string {
+	account, err := g.NewAccount(&stripe.AccountParams{TermsAccepted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account.ID
+}
+
+func TestMemoryGatewayHold(t *testing.T) {
+	g := NewMemoryGateway()
+	customer, account := memoryCardCustomer(t, g), memoryAccount(t, g)
+
+	id, err := g.AuthorizeCharge(customer, account, 1365, 315, "Lesson", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ch, _ := g.GetCharge(id); ch.AmountCaptured != 0 || ch.Transferred() != 0 {
+		t.Errorf("expected hold not to be captured, got %+v", ch)
+	}
+
+	if _, _, err := g.CaptureCharge(id, 1500, 315, nil); err == nil {
+		t.Error("expected capturing more than was held to fail")
+	}
+
+	captured, released, err := g.CaptureCharge(id, 1024, 236, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if captured != id || len(released) != 1 {
+		t.Errorf("expected hold captured and the rest released, got %s %v", captured, released)
+	}
+
+	ch, _ := g.GetCharge(id)
+	if ch.AmountCaptured != 1024 || ch.Refunded != 341 || ch.Transferred() != 788 {
+		t.Errorf("expected 1024 captured paying 788, got %+v", ch)
+	}
+
+	if _, reversed, err := g.ReverseChargeTransfer(id, 1000, "Refund"); err != nil || reversed != 788 {
+		t.Errorf("expected reversal capped to the transfer, got %d (%v)", reversed, err)
+	}
+
+	if _, reversed, _ := g.ReverseChargeTransfer(id, 100, "Refund"); reversed != 0 {
+		t.Errorf("expected nothing left to reverse, got %d", reversed)
+	}
+
+	if _, err := g.RefundCharge(id, 1100, nil); err == nil {
+		t.Error("expected refunding more than was captured to fail")
+	}
+}
+
+func TestMemoryGatewayCharge(t *testing.T) {
+	g := NewMemoryGateway()
+	customer, account := memoryCardCustomer(t, g), memoryAccount(t, g)
+
+	if _, err := g.Charge(customer, "acct_unknown", 1000, 100, "Lesson", "", "", nil); err == nil {
+		t.Error("expected charge to an unknown account to fail")
+	}
+
+	first, err := g.Charge(customer, account, 1000, 100, "Lesson", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := g.ChargeCorporateCard(account, 500, "Cover", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	charges := g.Charges()
+	if len(charges) != 2 || charges[0].ID != first || charges[1].ID != second || charges[1].Customer != "" {
+		t.Errorf("expected card then corporate charge, got %+v", charges)
+	}
+
+	g.Decline(customer)
+	if _, err := g.AuthorizeCharge(customer, account, 1000, 100, "Lesson", "", "", nil); err == nil {
+		t.Error("expected declined card to fail")
+	}
+}
+
+func TestMemoryGatewayTransfer(t *testing.T) {
+	g := NewMemoryGateway()
+	account := memoryAccount(t, g)
+
+	if _, err := g.Transfer("acct_unknown", 1000, store.CurrencyUSD, "Payout", "payout_1", "", nil); err == nil {
+		t.Error("expected transfer to an unknown account to fail")
+	}
+
+	g.SetPayoutsEnabled(account, false)
+	if _, err := g.Transfer(account, 1000, store.CurrencyUSD, "Payout", "payout_1", "", nil); err == nil {
+		t.Error("expected transfer to an account that can't be paid to fail")
+	}
+
+	g.SetPayoutsEnabled(account, true)
+	id, err := g.Transfer(account, 1000, store.CurrencyGBP, "Payout", "payout_1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if transfers := g.Transfers(); len(transfers) != 1 || transfers[0].ID != id || transfers[0].Amount != 1000 || transfers[0].Currency != store.CurrencyGBP || transfers[0].Group != "payout_1" {
+		t.Errorf("expected one transfer of 1000 gbp, got %+v", transfers)
+	}
+
+	first, err := g.Transfer(account, 500, store.CurrencyUSD, "Payout", "payout_2", "payout_2_tutor", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again, err := g.Transfer(account, 500, store.CurrencyUSD, "Payout", "payout_2", "payout_2_tutor", nil); err != nil || again != first || len(g.Transfers()) != 2 {
+		t.Errorf("expected the transfer made again with the key not to be sent twice, got %s %v %+v", again, err, g.Transfers())
+	}
+}
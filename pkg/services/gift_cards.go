@@ -0,0 +1,300 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/learnt/api/config"
+	"gitlab.com/learnt/api/pkg/core"
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/services/stripe"
+	"gitlab.com/learnt/api/pkg/store"
+	m "gitlab.com/learnt/api/pkg/utils/messaging"
+	"gitlab.com/learnt/api/pkg/utils/messaging/mail"
+)
+
+const giftCardPrefix = "Learnt Gift Card"
+
+// GiftCardParams are the params of a gift card bought for someone. Amount is in cents.
+type GiftCardParams struct {
+	Amount         int64  `json:"amount" binding:"required"`
+	RecipientName  string `json:"recipient_name"`
+	RecipientEmail string `json:"recipient_email" binding:"required"`
+	Message        string `json:"message"`
+}
+
+type giftCards struct{}
+
+func GetGiftCards() *giftCards {
+	return &giftCards{}
+}
+
+// Buy charges the student's saved card for the gift card and emails its code to the
+// recipient. The money is held by the platform until the card is redeemed. The card is saved
+// before the charge and can't be redeemed until it's paid for and in the ledger, so no charge
+// is left without one. When either fails the purchaser is refunded.
+func (g *giftCards) Buy(purchaser *store.UserMgo, params GiftCardParams) (*store.GiftCard, error) {
+	if !purchaser.IsStudent() {
+		return nil, errors.New("only students can buy gift cards")
+	}
+
+	if purchaser.Payments == nil || purchaser.Payments.CustomerID == "" {
+		return nil, errors.New("student has no payment method")
+	}
+
+	card, err := store.NewGiftCard(purchaser, params.Amount, params.RecipientName, params.RecipientEmail, params.Message)
+	if err != nil {
+		return nil, err
+	}
+
+	description := fmt.Sprintf("%s gift card for %s (%s)", card.Currency.Format(card.Amount), card.RecipientEmail, card.ID.Hex())
+	metadata := map[string]string{"giftCardID": card.ID.Hex(), "purchaser": purchaser.Name()}
+
+	if err := card.Insert(); err != nil {
+		return nil, err
+	}
+
+	chargeID, err := gateway.ChargePlatform(purchaser.Payments.CustomerID, card.Amount, card.Currency, description, giftCardPrefix, metadata)
+	if err != nil {
+		if errRemove := card.Remove(); errRemove != nil {
+			logger.Get().Errorf("couldn't remove unpaid gift card %s: %v", card.ID.Hex(), errRemove)
+		}
+		return nil, errors.Wrap(err, "couldn't charge for gift card")
+	}
+
+	// the card can't be redeemed, the purchaser gets their money back
+	refund := func(err error) {
+		refundMetadata := map[string]string{"giftCardID": card.ID.Hex(), stripe.MetadataRecorded: "true"}
+		if _, errRefund := gateway.RefundCharge(chargeID, card.Amount, refundMetadata); errRefund != nil {
+			logger.Get().Errorf("gift card %s was charged with %s but couldn't be bought or refunded: %v, %v", card.ID.Hex(), chargeID, err, errRefund)
+		}
+	}
+
+	entry := store.NewLedgerEntry(store.EntryGiftCard, description,
+		store.Debit(store.AccountStudentCash, purchaser.ID, card.Amount),
+		store.Credit(store.AccountGiftCards, "", card.Amount),
+	).In(card.Currency).WithStripe(chargeID)
+	entry.GiftCard = &card.ID
+
+	if err := entry.Post(); err != nil {
+		refund(err)
+		if errRemove := card.Remove(); errRemove != nil {
+			logger.Get().Errorf("couldn't remove unpaid gift card %s: %v", card.ID.Hex(), errRemove)
+		}
+		return nil, errors.Wrap(err, "couldn't record gift card purchase")
+	}
+
+	if err := card.Activate(chargeID); err != nil {
+		refund(err)
+
+		reversal := store.NewLedgerEntry(store.EntryRefund, "Refund of "+description,
+			store.Debit(store.AccountGiftCards, "", card.Amount),
+			store.Credit(store.AccountStudentCash, purchaser.ID, card.Amount),
+		).In(card.Currency).WithStripe(chargeID)
+		reversal.GiftCard = &card.ID
+
+		if errPost := reversal.Post(); errPost != nil {
+			logger.Get().Errorf("couldn't post refund of gift card %s to the ledger: %v", card.ID.Hex(), errPost)
+		}
+		return nil, err
+	}
+
+	go sendGiftCard(card, purchaser)
+
+	return card, nil
+}
+
+// sendGiftCard emails the card's code to its recipient
+func sendGiftCard(card *store.GiftCard, purchaser *store.UserMgo) {
+	redeemURL, err := core.AppURL("/main/account/payments?gift_card=%s", url.QueryEscape(card.Code))
+	if err != nil {
+		logger.Get().Errorf("couldn't build gift card link: %v", err)
+		return
+	}
+
+	recipientName := card.RecipientName
+	if recipientName == "" {
+		recipientName = "there"
+	}
+
+	err = mail.GetSender(config.GetConfig()).SendTo(card.RecipientEmail, m.TPL_GIFT_CARD, &m.P{
+		"RECIPIENT_NAME": recipientName,
+		"PURCHASER_NAME": purchaser.Name(),
+		"AMOUNT":         card.Currency.Format(card.Amount),
+		"MESSAGE":        card.Message,
+		"CODE":           card.Code,
+		"EXPIRES_AT":     card.ExpiresAt.Format("January 2, 2006"),
+		"REDEEM_URL":     redeemURL,
+	})
+
+	if err != nil {
+		logger.Get().Errorf("couldn't email gift card %s: %v", card.ID.Hex(), err)
+	}
+}
+
+// Redeem takes the amount off the gift card's balance into the student's credits. Zero
+// redeems all that's left.
+func (g *giftCards) Redeem(student *store.UserMgo, code string, amount int64) (*store.GiftCard, error) {
+	if !student.IsStudent() {
+		return nil, errors.New("only students can redeem gift cards")
+	}
+
+	card, ok := store.GetGiftCard(code)
+	if !ok {
+		return nil, errors.New("gift card not found")
+	}
+
+	if amount == 0 {
+		amount = card.Balance
+	}
+
+	redemption, err := card.Redeem(student.ID, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = GetPayments().AddCredits(student, CreditParams{
+		Amount:   amount,
+		Reason:   string(store.EntryGiftCard),
+		Notes:    fmt.Sprintf("Redeemed %s of gift card %s", card.Currency.Format(amount), card.Code),
+		GiftCard: &card.ID,
+	})
+
+	// the balance goes back to the card when the credits couldn't be added
+	if err != nil {
+		if errUnredeem := card.Unredeem(*redemption); errUnredeem != nil {
+			logger.Get().Errorf("gift card %s was redeemed for %d by %s but the credits couldn't be added: %v, %v", card.ID.Hex(), amount, student.ID.Hex(), err, errUnredeem)
+		}
+		return nil, err
+	}
+
+	return card, nil
+}
+
+// Void stops the gift card from being redeemed. What's left of it is refunded to the
+// purchaser's card when refund is set, and kept by the platform otherwise. The card is voided
+// before the refund, so what's refunded can't be redeemed meanwhile.
+func (g *giftCards) Void(card *store.GiftCard, admin *store.UserMgo, reason string, refund bool) error {
+	if card.Status != store.GiftCardActive && card.Status != store.GiftCardExpired {
+		return errors.Errorf("%s gift cards can't be voided", card.Status)
+	}
+
+	reason = strings.TrimSpace(reason)
+	description := fmt.Sprintf("Voided gift card (%s)", card.ID.Hex())
+	if reason != "" {
+		description = fmt.Sprintf("%s: %s", description, reason)
+	}
+
+	// the balance of expired cards was already taken as revenue
+	status := card.Status
+	wasExpired := status == store.GiftCardExpired
+
+	if err := card.Void(admin.ID, reason); err != nil {
+		return err
+	}
+
+	var refundID string
+	if refund && card.Balance > 0 {
+		metadata := map[string]string{"giftCardID": card.ID.Hex(), stripe.MetadataRecorded: "true"}
+
+		var err error
+		if refundID, err = gateway.RefundCharge(card.ChargeID, card.Balance, metadata); err != nil {
+			if errUnvoid := card.Unvoid(status); errUnvoid != nil {
+				logger.Get().Errorf("gift card %s was voided but couldn't be refunded or put back: %v, %v", card.ID.Hex(), err, errUnvoid)
+			}
+			return errors.Wrap(err, "couldn't refund gift card")
+		}
+
+		if err := card.SetRefund(refundID); err != nil {
+			logger.Get().Errorf("gift card %s was refunded with %s but it couldn't be saved: %v", card.ID.Hex(), refundID, err)
+		}
+	}
+
+	// nothing is left to move when the balance was redeemed or taken as revenue when it expired
+	if card.Balance == 0 || (wasExpired && refundID == "") {
+		return nil
+	}
+
+	debit := store.Debit(store.AccountGiftCards, "", card.Balance)
+	if wasExpired {
+		debit = store.Debit(store.AccountPlatformRevenue, "", card.Balance)
+	}
+
+	kind, credit := store.EntryGiftCard, store.Credit(store.AccountPlatformRevenue, "", card.Balance)
+	if refundID != "" {
+		kind, credit = store.EntryRefund, store.Credit(store.AccountStudentCash, card.Purchaser, card.Balance)
+	}
+
+	entry := store.NewLedgerEntry(kind, description, debit, credit).In(card.Currency).WithStripe(refundID)
+	entry.GiftCard = &card.ID
+
+	if err := entry.Post(); err != nil {
+		logger.Get().Errorf("couldn't post void of gift card %s to the ledger: %v", card.ID.Hex(), err)
+	}
+
+	return nil
+}
+
+// takeBackGiftCard takes what the purchaser got back for the gift card of the entry off its
+// balance, when its charge was refunded or disputed outside the API, and returns what it took.
+// What was redeemed already is the platform's loss.
+func takeBackGiftCard(paid *store.LedgerEntry, amount int64, reason string) int64 {
+	if paid.GiftCard == nil {
+		return 0
+	}
+
+	card, ok := store.GetGiftCardByID(*paid.GiftCard)
+	if !ok {
+		return 0
+	}
+
+	// the balance of expired cards was already taken as revenue
+	wasExpired := card.Status == store.GiftCardExpired
+
+	taken, err := card.TakeBack(amount, reason)
+	if err != nil {
+		logger.Get().Errorf("couldn't take back gift card %s: %v", card.ID.Hex(), err)
+	}
+
+	if wasExpired {
+		return 0
+	}
+	return taken
+}
+
+// ExpireGiftCards expires the gift cards that weren't redeemed in time
+func (g *giftCards) ExpireGiftCards() {
+	expired, err := store.GetExpiredGiftCards()
+	if err != nil {
+		logger.Get().Errorf("couldn't get expired gift cards: %v", err)
+		return
+	}
+
+	for i := range expired {
+		card := &expired[i]
+
+		if err := card.Expire(); err != nil {
+			logger.Get().Errorf("couldn't expire gift card %s: %v", card.ID.Hex(), err)
+			continue
+		}
+
+		if card.Balance <= 0 {
+			continue
+		}
+
+		// the balance left isn't owed to anyone anymore
+		entry := store.NewLedgerEntry(store.EntryGiftCard,
+			fmt.Sprintf("%s left on expired gift card (%s)", card.Currency.Format(card.Balance), card.ID.Hex()),
+			store.Debit(store.AccountGiftCards, "", card.Balance),
+			store.Credit(store.AccountPlatformRevenue, "", card.Balance),
+		).In(card.Currency)
+		entry.GiftCard = &card.ID
+
+		if err := entry.Post(); err != nil {
+			logger.Get().Errorf("couldn't post expiry of gift card %s to the ledger: %v", card.ID.Hex(), err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+func cleanupGiftCard(t *testing.T, card *store.GiftCard) {
+	if _, err := store.GetCollection("ledger_entries").RemoveAll(bson.M{"gift_card": card.ID}); err != nil {
+		t.Error("could not remove ledger entries:", err)
+	}
+
+	if err := store.GetCollection("gift_cards").RemoveId(card.ID); err != nil {
+		t.Error("could not remove gift card:", err)
+	}
+}
+
+func TestGiftCards(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	purchaser := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, purchaser)
+
+	recipient := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, recipient)
+
+	card, err := GetGiftCards().Buy(purchaser, GiftCardParams{Amount: 5000, RecipientName: "Sam", RecipientEmail: "sam@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupGiftCard(t, card)
+
+	if ch, ok := g.GetCharge(card.ChargeID); !ok || ch.Amount != 5000 || ch.Payee != "" {
+		t.Errorf("expected purchaser's card charged to the platform, got %+v", ch)
+	}
+
+	// the card is redeemed a part at a time, with the code typed in any case and without dashes
+	redeemed, err := GetGiftCards().Redeem(recipient, strings.ToLower(strings.Replace(card.Code, "-", "", -1)), 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if redeemed.Balance != 3000 || recipient.Payments.Credits != 2000 {
+		t.Errorf("expected 2000 redeemed into credits and 3000 left, got %d left and %d credits", redeemed.Balance, recipient.Payments.Credits)
+	}
+
+	if _, err := GetGiftCards().Redeem(recipient, card.Code, 4000); err == nil {
+		t.Error("expected redeeming more than the balance to fail")
+	}
+
+	// what's left is refunded to the purchaser
+	if err := GetGiftCards().Void(redeemed, happyTutor(), "sent to the wrong email", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if ch, _ := g.GetCharge(card.ChargeID); ch.Refunded != 3000 {
+		t.Errorf("expected 3000 refunded to the purchaser, got %+v", ch)
+	}
+
+	if _, err := GetGiftCards().Redeem(recipient, card.Code, 0); err == nil {
+		t.Error("expected voided card not to be redeemed")
+	}
+
+	balances, err := store.GetLedgerBalances(recipient.ID, store.DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balances[store.AccountStudentCredits] != 2000 {
+		t.Errorf("expected redeemed credits posted to the ledger, got %+v", balances)
+	}
+}
+
+func TestGiftCardNotBoughtWhenDeclined(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	purchaser := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, purchaser)
+
+	g.Decline(purchaser.Payments.CustomerID)
+	if _, err := GetGiftCards().Buy(purchaser, GiftCardParams{Amount: 5000, RecipientEmail: "sam@example.com"}); err == nil {
+		t.Fatal("expected declined card to fail")
+	}
+
+	if n, _ := store.GetCollection("gift_cards").Find(bson.M{"purchaser": purchaser.ID}).Count(); n != 0 {
+		t.Errorf("expected no gift card left for the declined charge, got %d", n)
+	}
+}
+
+func TestGiftCardVoidRacesRedeem(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	purchaser := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, purchaser)
+
+	recipient := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, recipient)
+
+	card, err := GetGiftCards().Buy(purchaser, GiftCardParams{Amount: 5000, RecipientEmail: "sam@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupGiftCard(t, card)
+
+	// the card is voided with a refund while the recipient redeems it, only one of them goes through
+	var voidErr, redeemErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		voided, _ := store.GetGiftCardByID(card.ID)
+		voidErr = GetGiftCards().Void(voided, happyTutor(), "", true)
+	}()
+	go func() {
+		defer wg.Done()
+		_, redeemErr = GetGiftCards().Redeem(recipient, card.Code, 0)
+	}()
+	wg.Wait()
+
+	if (voidErr == nil) == (redeemErr == nil) {
+		t.Fatalf("expected one of void and redeem to fail, got %v and %v", voidErr, redeemErr)
+	}
+
+	saved, _ := NewUsers().ByID(recipient.ID)
+	ch, _ := g.GetCharge(card.ChargeID)
+
+	if voidErr == nil && (ch.Refunded != 5000 || saved.Payments.Credits != 0) {
+		t.Errorf("expected the voided card refunded and nothing redeemed, got %d refunded and %d credits", ch.Refunded, saved.Payments.Credits)
+	}
+
+	if redeemErr == nil && (ch.Refunded != 0 || saved.Payments.Credits != 5000) {
+		t.Errorf("expected the redeemed card not refunded, got %d refunded and %d credits", ch.Refunded, saved.Payments.Credits)
+	}
+}
@@ -1,340 +1,321 @@
 package services
 
-/*
-type sessions struct {
-	*mgo.Collection
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/config"
+	"gitlab.com/learnt/api/pkg/core"
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/notifications"
+	"gitlab.com/learnt/api/pkg/services/delivery"
+	"gitlab.com/learnt/api/pkg/store"
+	m "gitlab.com/learnt/api/pkg/utils/messaging"
+	"gitlab.com/learnt/api/pkg/utils/messaging/mail"
+	"gitlab.com/learnt/api/pkg/ws"
+)
+
+// maxInstantTutors is how many tutors an instant request is broadcast to
+const maxInstantTutors = 50
+
+type instantSessions struct{}
+
+func GetInstantSessions() *instantSessions {
+	return &instantSessions{}
 }
 
-// InstantSessions returns the instant sessions collection.
-func InstantSessions() *sessions {
-	return &sessions{
-		store.GetCollection("instant_sessions"),
-	}
-}
+// tutors gets the online tutors of the subject that take instant sessions and are free now
+func (s *instantSessions) tutors(student *store.UserMgo, subject *store.Subject) []*store.UserMgo {
+	var found []*store.UserMgo
 
-// CheckTimeout adds a timeout after which sets the session as failed
-// if the state wasn't updated from created.
-func (s *sessions) CheckTimeout(id bson.ObjectId) {
-	t := time.NewTicker(2 * time.Minute)
-	go func() {
-		select {
-		case <-t.C:
-			t.Stop()
-
-			var i *store.InstantSession
-			InstantSessions().FindId(id).One(&i)
-
-			if i.Ended() || i.Status != store.InstantSessionCreated {
-				return
-			}
-
-			now := time.Now().UTC()
-
-			i.Status = store.InstantSessionFailed
-			var reason = store.InstantSessionSystemReject
-			i.EndReason = &reason
-			i.EndedAt = &now
-
-			InstantSessions().UpdateId(i.ID, bson.M{"$set": bson.M{
-				"status":     i.Status,
-				"end_reason": i.EndReason,
-				"ended_at":   i.EndedAt,
-			}})
-		}
-	}()
-}
+	err := store.GetCollection("users").Find(bson.M{
+		"_id":                       bson.M{"$ne": student.ID},
+		"role":                      store.RoleTutor,
+		"online":                    store.Online,
+		"approval":                  store.ApprovalStatusApproved,
+		"tutoring.instant_session":  true,
+		"tutoring.subjects.subject": subject.ID,
+		"is_test_account":           bson.M{"$ne": true},
+	}).Limit(maxInstantTutors).All(&found)
 
-// InstantSessionRequest is the request that is sent to the API for instantiating an instant session.
-type InstantSessionRequest struct {
-	Tutor   bson.ObjectId `json:"tutor" binding:"required"`
-	Student bson.ObjectId `json:"student" binding:"required"`
-	When    time.Time     `json:"when" binding:"required"`
-}
+	if err != nil {
+		logger.Get().Errorf("couldn't get tutors for instant session: %v", err)
+		return nil
+	}
 
-// Get returns an instant session with the specified id if exists, otherwise an error.
-func (s *sessions) Get(lessonID string) (*store.InstantSession, error) {
-	var is *store.InstantSession
-	if err := store.GetCollection("instant_sessions").FindId(bson.ObjectIdHex(lessonID)).One(&is); err != nil {
-		return nil, errors.Wrap(err, "couldn't get instant session")
+	tutors := make([]*store.UserMgo, 0, len(found))
+	for _, tutor := range found {
+		if tutor.IsFree(time.Now(), time.Hour) {
+			tutors = append(tutors, tutor)
+		}
 	}
 
-	return is, nil
+	return tutors
 }
 
-// New is the method called by the API route to create an instant session.
-func (s *sessions) New(u *store.UserMgo, r *InstantSessionRequest) (*store.InstantSession, error) {
-	users := NewUsers()
-
-	tutor, ok := users.ByID(r.Tutor)
-	if !ok {
-		return nil, errors.New("invalid tutor")
+// Request broadcasts the student's request to the online tutors of the subject
+func (s *instantSessions) Request(student *store.UserMgo, subject *store.Subject) (*store.InstantRequest, error) {
+	if !student.IsStudent() {
+		return nil, errors.New("only students can request instant sessions")
 	}
 
-	if !tutor.IsTutor() {
-		return nil, errors.New("invalid tutor")
+	if student.Payments == nil || len(student.Payments.Cards) == 0 {
+		return nil, errors.New("add a card to request instant sessions")
 	}
 
-	student, ok := users.ByID(r.Student)
-	if !ok {
-		return nil, errors.New("invalid student")
+	if request, pending := store.GetStudentInstantRequest(student.ID); pending {
+		return nil, fmt.Errorf("you already requested an instant session, wait %v seconds for a tutor to accept it", int(time.Until(request.ExpiresAt).Seconds()))
 	}
 
-	if student.Payments == nil || len(student.Payments.Cards) == 0 {
-		return nil, errors.New("student does not a card for payment")
+	timeout, err := config.GetConfig().Lessons.ParseInstantSessionTimeout()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid instant session timeout")
 	}
 
-	when := r.When.UTC()
-
-	if !tutor.IsFree(when, 15*time.Minute) {
-		return nil, errors.New("tutor is not free")
+	tutors := s.tutors(student, subject)
+	if len(tutors) == 0 {
+		return nil, errors.New("no tutor is available for an instant session right now")
 	}
 
-	if len(tutor.Tutoring.Subjects) == 0 {
-		return nil, errors.New("Tutor must have at least one subject defined")
+	request := &store.InstantRequest{
+		Student:   student.ID,
+		Subject:   subject.ID,
+		ExpiresAt: time.Now().Add(timeout),
 	}
 
-	lesson := store.InstantSession{
-		ID: bson.NewObjectId(),
-
-		CreatedAt: when,
-		StartsAt:  nil,
-		EndedAt:   nil,
-
-		Tutor:   r.Tutor,
-		Student: r.Student,
-
-		Status:    store.InstantSessionCreated,
-		EndReason: nil,
-
-		Room: nil,
-
-		Subject: tutor.Tutoring.Subjects[0].Subject,
+	for _, tutor := range tutors {
+		request.Tutors = append(request.Tutors, tutor.ID)
 	}
 
-	if err := store.GetCollection("instant_sessions").Insert(lesson); err != nil {
-		return nil, errors.Wrap(err, "couldn't insert instant session")
+	if err := request.Insert(); err != nil {
+		return nil, err
 	}
 
-	InstantSessions().CheckTimeout(lesson.ID)
+	d := delivery.New(config.GetConfig())
+	for _, tutor := range tutors {
+		s.sendRequest(tutor.ID, request, student, subject)
 
-	title := fmt.Sprintf("%s wants to start an instant session", student.Profile.LastName)
-	message := "Are you available to start the class right now?"
-	action := ""
+		link, err := core.AppURL("/main/account/calendar/details/%s", tutor.ID.Hex())
+		if err != nil {
+			logger.Get().Errorf("couldn't build instant session link: %v", err)
+			continue
+		}
 
-	notifications.Notify(&notifications.NotifyRequest{
-		User:    r.Tutor,
-		Type:    notifications.InstantLessonRequest,
-		Title:   title,
-		Message: message,
-		Action:  &action,
-		Data:    map[string]interface{}{"lesson": lesson},
+		go d.Send(tutor, m.TPL_INSTANT_LESSON_REQUEST, &m.P{
+			"TUTOR_NAME":   tutor.GetFirstName(),
+			"STUDENT_NAME": student.GetFirstName(),
+			"JOIN_SESSION": link,
+		})
+	}
+
+	id := request.ID
+	time.AfterFunc(timeout, func() {
+		if request, exist := store.GetInstantRequest(id); exist && request.Status == store.InstantRequestPending {
+			s.timeOut(request)
+		}
 	})
 
-	return &lesson, nil
+	return request, nil
 }
 
-func (s *sessions) Accept(u *store.UserMgo, i string) (*store.InstantSession, error) {
-	if _, ok := NewUsers().ByID(u.ID); !ok {
-		return nil, errors.New("invalid user id")
+// sendRequest sends the request to the tutor's open connections
+func (s *instantSessions) sendRequest(tutor bson.ObjectId, request *store.InstantRequest, student *store.UserMgo, subject *store.Subject) {
+	if tc := ws.GetEngine().Hub.User(tutor); tc != nil {
+		_ = tc.Send(ws.Event{
+			Type: "instant.request",
+			Data: ws.EventData{
+				"request": request.ID.Hex(),
+				"student": student.Dto(true),
+				"subject": subject,
+				"timeout": time.Until(request.ExpiresAt).Seconds(),
+			},
+		})
 	}
+}
 
-	lesson, err := store.InstantSessionsStore().ByID(bson.ObjectIdHex(i))
+// SendPending sends the tutor the requests still waiting for them, when they connect
+func (s *instantSessions) SendPending(tutor *store.UserMgo) {
+	requests, err := store.GetTutorInstantRequests(tutor.ID)
 	if err != nil {
-		return nil, errors.Wrap(err, "couldn't get instant session")
+		logger.Get().Errorf("couldn't get instant requests of tutor %s: %v", tutor.ID.Hex(), err)
+		return
 	}
 
-	if lesson.Ended() {
-		return nil, errors.New("can't accept an already ended session")
-	}
-
-	if lesson.Status != store.InstantSessionCreated {
-		return nil, errors.New("can't accept a modified session")
-	}
+	for i := range requests {
+		request := &requests[i]
 
-	if u.ID.Hex() != lesson.Tutor.Hex() {
-		return nil, errors.New("invalid tutor accepting request")
-	}
+		student, ok := NewUsers().ByID(request.Student)
+		if !ok {
+			continue
+		}
 
-	if _, err := lesson.SetStatus(store.InstantSessionAccepted); err != nil {
-		return nil, errors.Wrap(err, "couldn't set status to accepted")
-	}
+		subject, ok := store.GetSubject(request.Subject)
+		if !ok {
+			continue
+		}
 
-	room, err := VCRInstance().CreateInstantRoom(lesson)
-	if err != nil {
-		return nil, errors.Wrap(err, "couldn't create instant room")
+		s.sendRequest(tutor.ID, request, student, subject)
 	}
+}
 
-	lesson.Room = &room.ID
-
-	if student, ok := NewUsers().ByID(lesson.Student); ok {
-		action := fmt.Sprintf("/class/%s", room.ID)
+// retract tells the tutors the request isn't theirs to answer anymore
+func (s *instantSessions) retract(request *store.InstantRequest, except *bson.ObjectId) {
+	for _, tutor := range request.Tutors {
+		if except != nil && tutor == *except {
+			continue
+		}
 
-		notifications.Notify(&notifications.NotifyRequest{
-			User:    student.ID,
-			Type:    notifications.InstantLessonAccept,
-			Title:   "Tutor accepted instant session",
-			Message: "Tutor accepted instant session",
-			Action:  &action,
-			Data:    map[string]interface{}{"lesson": lesson},
-		})
+		if tc := ws.GetEngine().Hub.User(tutor); tc != nil {
+			_ = tc.Send(ws.Event{
+				Type: "instant.retract",
+				Data: ws.EventData{
+					"request": request.ID.Hex(),
+					"status":  request.Status,
+				},
+			})
+		}
 	}
-
-	return lesson, nil
 }
 
-func (s *sessions) Reject(u *store.UserMgo, i string) (*store.InstantSession, error) {
-	lesson, err := store.InstantSessionsStore().ByID(bson.ObjectIdHex(i))
-	if err != nil {
-		return nil, errors.Wrap(err, "couldn't get instant session")
-	}
-
-	if lesson.Ended() {
-		return lesson, errors.New("can't reject an already ended session")
+// Accept gives the request to the first tutor accepting it and starts the session.
+// The other tutors get the request retracted once the session started, it's open to
+// them again when it couldn't be.
+func (s *instantSessions) Accept(tutor *store.UserMgo, request *store.InstantRequest) (*store.LessonMgo, error) {
+	if !request.HasTutor(tutor.ID) {
+		return nil, errors.New("instant request wasn't sent to you")
 	}
 
-	if lesson.Status != store.InstantSessionCreated {
-		return lesson, errors.New("can't reject a modified session")
+	student, ok := NewUsers().ByID(request.Student)
+	if !ok {
+		return nil, errors.New("couldn't get student of instant request")
 	}
 
-	student, ok := NewUsers().ByID(lesson.Student)
+	subject, ok := store.GetSubject(request.Subject)
 	if !ok {
-		return nil, errors.New("couldn't get instant session student")
+		return nil, errors.New("couldn't get subject of instant request")
 	}
 
-	tutor, ok := NewUsers().ByID(lesson.Tutor)
-	if !ok {
-		return nil, errors.New("couldn't get instant session tutor")
+	if ws.GetEngine().Hub.User(student.ID) == nil {
+		return nil, errors.New("student is no longer online")
 	}
 
-	var reason store.InstantSessionEndReason
-	var action string
+	if err := request.Accept(tutor.ID); err != nil {
+		return nil, errors.New("another tutor already accepted this request")
+	}
 
-	switch u.ID.Hex() {
-	case lesson.Tutor.Hex():
-		// the tutor rejected the instant session request
-		// announce the student about this
-		reason = store.InstantSessionTutorReject
+	lesson, err := GetLessons().CreateInstantSession(student, tutor, subject)
+	if err != nil {
+		if err := request.Reopen(tutor.ID); err != nil {
+			logger.Get().Errorf("couldn't reopen instant request %s: %v", request.ID.Hex(), err)
+		}
+		return nil, err
+	}
 
-		message := fmt.Sprintf("%s is not available for your session. ", tutor.Profile.FirstName)
-		message += "It's possible they stepped away from the computer for a moment."
+	s.retract(request, &tutor.ID)
 
-		notifications.Notify(&notifications.NotifyRequest{
-			User:    student.ID,
-			Type:    notifications.InstantLessonReject,
-			Title:   "Instant session was declined",
-			Message: message,
-			Action:  &action,
-			Data:    map[string]interface{}{"lesson": lesson},
-		})
-	case lesson.Student.Hex():
-		// the student cancelled the instant session request
-		// announce the tutor about this
-		reason = store.InstantSessionStudentReject
-
-		message := fmt.Sprintf("%s cancelled the instant session request. ", student.Profile.FirstName)
-		message += "It's possible they stepped away from the computer for a moment."
-
-		notifications.Notify(&notifications.NotifyRequest{
-			User:    tutor.ID,
-			Type:    notifications.InstantLessonCancel,
-			Title:   "Instant session was cancelled",
-			Message: message,
-			Action:  &action,
-			Data:    map[string]interface{}{"lesson": lesson},
-		})
-	default:
-		return nil, errors.New("invalid user rejecting request")
+	if err := request.SetLesson(lesson.ID); err != nil {
+		logger.Get().Errorf("couldn't link instant request %s to lesson: %v", request.ID.Hex(), err)
 	}
 
-	if _, err := lesson.SetStatus(store.InstantSessionRejected, reason); err != nil {
-		return lesson, errors.Wrap(err, "couldn't set status to rejected")
-	}
+	notifications.Notify(&notifications.NotifyRequest{
+		User:    student.ID,
+		Type:    notifications.InstantLessonAccept,
+		Title:   "Tutor accepted instant session",
+		Message: fmt.Sprintf("%s accepted your instant session", tutor.GetFirstName()),
+		Data:    map[string]interface{}{"lesson": lesson},
+	})
 
 	return lesson, nil
 }
 
-func (s *sessions) Complete(i *store.InstantSession, u *store.UserMgo, room *Room) (err error) {
-	reason := store.InstantSessionStudentQuit
-	if i.Tutor.Hex() == u.ID.Hex() {
-		reason = store.InstantSessionTutorQuit
-	}
-
-	i, err = i.SetStatus(store.InstantSessionCompleted)
+// Decline records that the tutor won't take the request. The request is escalated
+// when every tutor declined it.
+func (s *instantSessions) Decline(tutor *store.UserMgo, request *store.InstantRequest) error {
+	all, err := request.Decline(tutor.ID)
 	if err != nil {
-		return errors.Wrap(err, "couldn't set instant session status to completed")
+		return err
 	}
 
-	if err = i.SetEndReason(reason); err != nil {
-		return errors.Wrap(err, "couldn't set instant session reason")
+	if all {
+		s.escalate(request)
 	}
 
-	if i.EndedAt == nil || i.StartsAt == nil {
-		return errors.New("end time or start time for instant session is nil")
-	}
+	return nil
+}
 
-	room.Dto.Live = false
-	if err := store.GetCollection("rooms").UpdateId(room.Dto.ID, bson.M{"$set": bson.M{"live": false}}); err != nil {
-		return errors.Wrap(err, "couldn't update room to set live false")
+// Cancel ends the student's request and retracts it from the tutors
+func (s *instantSessions) Cancel(student *store.UserMgo, request *store.InstantRequest) error {
+	if request.Student != student.ID {
+		return errors.New("instant request isn't yours")
 	}
 
-	student, ok := NewUsers().ByID(i.Student)
-	if !ok {
-		return errors.New("couldn't get student from instant session")
+	if err := request.Cancel(); err != nil {
+		return err
 	}
 
-	tutor, ok := NewUsers().ByID(i.Tutor)
-	if !ok {
-		return errors.New("couldn't get tutor from instant session")
-	}
+	s.retract(request, nil)
 
-	// TODO: Bug for charging less than 50 cents can cause session to continue
-	p := GetPayments()
-	if err := p.ChargeForLesson(student, tutor, i.Duration().Minutes(), i.ID.Hex(), true); err != nil {
-		return errors.Wrapf(err, "[!] couldn't auhtorize charge for student %s on instant lesson %v", student.Name(), i.ID.Hex())
-	}
+	return nil
+}
 
-	if i.Duration() < 60*time.Minute {
-		// only complete refer links if the instant session took more than 60 minutes
-		return nil
+// TimeOutRequests escalates the requests nobody accepted in time, in case their
+// timer was lost with a restart
+func (s *instantSessions) TimeOutRequests() {
+	requests, err := store.GetExpiredInstantRequests()
+	if err != nil {
+		logger.Get().Errorf("couldn't get expired instant requests: %v", err)
+		return
 	}
 
-	referLinks, err := GetRefers().NeedPayment()
-	if err != nil {
-		return fmt.Errorf("couldn't get refer links: %s", err)
+	for i := range requests {
+		s.timeOut(&requests[i])
 	}
+}
 
-	if len(referLinks) == 0 {
-		return nil
+func (s *instantSessions) timeOut(request *store.InstantRequest) {
+	if err := request.TimeOut(); err != nil {
+		return
 	}
 
-	transactionDetails := fmt.Sprintf("Transaction for instant session with ID %s", i.ID.Hex())
+	s.retract(request, nil)
+	s.escalate(request)
+}
 
-	lessonIntf := lessonInterface{
-		ID:       i.ID,
-		Tutor:    tutor,
-		Student:  student,
-		StartsAt: *i.StartsAt,
+// escalate tells the student nobody took their request and the support team to follow up
+func (s *instantSessions) escalate(request *store.InstantRequest) {
+	student, ok := NewUsers().ByID(request.Student)
+	if !ok {
+		logger.Get().Errorf("couldn't get student of instant request %s", request.ID.Hex())
+		return
 	}
 
-	_, _, studentCost := lessonAmounts(float64(tutor.Tutoring.Rate), i.Duration().Minutes())
-	amount := float64(studentCost) / 100
+	if sc := ws.GetEngine().Hub.User(student.ID); sc != nil {
+		_ = sc.Send(ws.Event{
+			Type: "instant.timeout",
+			Data: ws.EventData{"request": request.ID.Hex()},
+		})
+	}
 
-	for _, link := range referLinks {
-		// check for students' link completion
-		if i.Student.Hex() == (*link.Referral).Hex() {
-			if err := completeLinkAndPay(link, amount, transactionDetails, lessonIntf); err != nil {
-				// todo: error handling
-			}
-		}
+	notifications.Notify(&notifications.NotifyRequest{
+		User:    student.ID,
+		Type:    notifications.InstantLessonReject,
+		Title:   "No tutor is available right now",
+		Message: "Nobody could take your instant session, our team will reach out to help you find a tutor.",
+		Data:    map[string]interface{}{"request": request},
+	})
 
-		// check for tutor's link completion
-		if i.Tutor.Hex() == (*link.Referral).Hex() {
-			if err := completeLinkAndPay(link, amount, transactionDetails, lessonIntf); err != nil {
-				// todo: error handling
-			}
-		}
+	subjectName := ""
+	if subject, ok := store.GetSubject(request.Subject); ok {
+		subjectName = subject.Name
 	}
 
-	return nil
+	go mail.GetSender(config.GetConfig()).SendTo(m.HIRING_EMAIL, m.TPL_INSTANT_LESSON_UNFILLED, &m.P{
+		"STUDENT_NAME":  student.Name(),
+		"STUDENT_EMAIL": student.GetEmail(),
+		"SUBJECT":       subjectName,
+		"TUTORS":        fmt.Sprint(len(request.Tutors)),
+	})
 }
-*/
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/notifications"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+// Agenda gets the lesson's agenda with its files, nil when it has none
+func (l *Lessons) Agenda(lesson *store.LessonMgo) *store.LessonAgendaDto {
+	if lesson.Agenda == nil {
+		return nil
+	}
+
+	return &store.LessonAgendaDto{
+		LessonAgenda: lesson.Agenda,
+		Files:        GetLibrary().Files(lesson.Agenda.Files),
+	}
+}
+
+// SetAgenda replaces the lesson's agenda. The tutor and the students can change it
+// until the lesson starts, linking files of their libraries.
+func (l *Lessons) SetAgenda(user *store.UserMgo, lesson *store.LessonMgo, agenda *store.LessonAgenda) error {
+	if !lesson.HasUser(user) {
+		return errors.New("only the tutor and students of the lesson can change its agenda")
+	}
+
+	if !lesson.CanBeModified() || !time.Now().Before(lesson.StartsAt) {
+		return errors.New("agenda can't be changed once the lesson started")
+	}
+
+	if err := agenda.Validate(); err != nil {
+		return err
+	}
+
+	// files linked already may be in the other party's library
+	linked := make(map[bson.ObjectId]bool)
+	if lesson.Agenda != nil {
+		for _, id := range lesson.Agenda.Files {
+			linked[id] = true
+		}
+	}
+
+	for _, id := range agenda.Files {
+		if linked[id] {
+			continue
+		}
+
+		file, ok := store.GetFilesStore().Get(id)
+		if !ok || file.Deleted || file.UploadedBy != user.ID {
+			return fmt.Errorf("file %s isn't in your library", id.Hex())
+		}
+	}
+
+	agenda.UpdatedBy = user.ID
+	agenda.UpdatedAt = time.Now()
+
+	if err := lesson.SetAgenda(agenda); err != nil {
+		return err
+	}
+
+	others, err := lesson.OtherParticipants(user.ID)
+	if err != nil {
+		logger.Get().Errorf("couldn't notify of agenda of lesson %s: %v", lesson.ID.Hex(), err)
+		return nil
+	}
+
+	for _, other := range others {
+		notifications.Notify(&notifications.NotifyRequest{
+			User:    other.ID,
+			Type:    notifications.LessonAgendaUpdated,
+			Title:   "Lesson agenda updated",
+			Message: fmt.Sprintf("%s updated the agenda of your %s lesson", user.GetFirstName(), lesson.FetchSubjectName()),
+			Data:    map[string]interface{}{"lesson": lesson.ID, "agenda": agenda},
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/services/models"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+const (
+	// holdLifetime is how long Stripe keeps an uncaptured charge before releasing it
+	holdLifetime = 7 * 24 * time.Hour
+	// holdRenewal is how long before it expires a hold is placed again, when the lesson ends after it
+	holdRenewal = 24 * time.Hour
+)
+
+// lessonHold returns the student's charge on the lesson, which holds the authorization until
+// the lesson is charged
+func lessonHold(lesson *store.LessonMgo, student bson.ObjectId) *models.ChargeData {
+	if charge, ok := lesson.Charges[student.Hex()]; ok && charge != nil {
+		return charge
+	}
+
+	if !lesson.IsGroup() {
+		return lesson.Charge
+	}
+
+	return nil
+}
+
+// holdAmount returns what the student's card would be charged for the lesson, in cents: the
+// minutes their packages with the tutor don't cover, less their credits
+func holdAmount(student, tutor *store.UserMgo, lesson *store.LessonMgo) int64 {
+	minutes := math.Ceil(lesson.Duration().Minutes())
+
+	// course lessons are priced by the course, packages don't cover them
+	if lesson.Course == nil {
+		usable, err := store.GetUsablePackages(tutor.ID, student.ID)
+		if err != nil {
+			logger.Get().Errorf("couldn't get packages of student %s: %v", student.Name(), err)
+		}
+
+		for _, pkg := range usable {
+			if pkg.Currency.OrDefault() == lesson.Currency.OrDefault() {
+				minutes -= float64(pkg.MinutesLeft)
+			}
+		}
+	}
+
+	if minutes <= 0 {
+		return 0
+	}
+
+	_, _, amount := lessonAmounts(lesson.Rate, minutes)
+
+	// credits only pay lessons in their currency
+	var credits int64
+	if student.Payments != nil && lesson.Currency.OrDefault() == store.DefaultCurrency {
+		credits = student.Payments.Credits
+	}
+
+	if credits >= amount {
+		return 0
+	}
+
+	if credits > 0 {
+		amount -= credits
+	}
+
+	return amount
+}
+
+// releaseHold gives back what the hold keeps on the student's card. Holds that can't be
+// released are left to expire.
+func releaseHold(hold *models.ChargeData) {
+	if !hold.IsHeld() {
+		return
+	}
+
+	if _, err := gateway.CancelCharge(hold.AuthorizationID); err != nil {
+		logger.Get().Errorf("couldn't release hold %s: %v", hold.AuthorizationID, err)
+		return
+	}
+
+	hold.AuthorizationStatus = models.AuthorizationReleased
+}
+
+// AuthorizeLesson places holds on the students' cards for the price of the lesson, to be
+// captured when it completes. Students already holding the lesson are left alone.
+func (l *Lessons) AuthorizeLesson(lesson *store.LessonMgo) {
+	tutor, ok := NewUsers().ByID(lesson.Tutor)
+	if !ok {
+		logger.Get().Errorf("couldn't get tutor to authorize lesson %s", lesson.ID.Hex())
+		return
+	}
+
+	for _, student := range NewUsers().ByIDs(lesson.Students) {
+		l.authorizeSeat(student, tutor, lesson)
+	}
+}
+
+// authorizeSeat places the student's hold on the lesson and saves it, unless they hold it already
+func (l *Lessons) authorizeSeat(student, tutor *store.UserMgo, lesson *store.LessonMgo) {
+	if student.IsTestStudent() || lessonHold(lesson, student.ID).IsHeld() {
+		return
+	}
+
+	hold, err := l.authorizeStudent(student, tutor, lesson)
+	if err != nil {
+		logger.Get().Errorf("couldn't authorize student %s on lesson %s: %v", student.Name(), lesson.ID.Hex(), err)
+	}
+
+	l.SaveCharges(lesson, student.ID, hold)
+}
+
+// authorizeStudent places the student's hold on the lesson, for the platform's account: the
+// tutor is paid with their next payout. There's no hold when their packages and credits
+// cover the lesson.
+func (l *Lessons) authorizeStudent(student, tutor *store.UserMgo, lesson *store.LessonMgo) (*models.ChargeData, error) {
+	amount := holdAmount(student, tutor, lesson)
+	if amount <= 0 {
+		return nil, nil
+	}
+
+	if student.Payments == nil || student.Payments.CustomerID == "" {
+		return nil, errors.New("student has no payment method")
+	}
+
+	now := time.Now()
+	hold := &models.ChargeData{
+		TutorRate:            lesson.Rate,
+		Currency:             string(lesson.Currency.OrDefault()),
+		AuthorizationStatus:  models.AuthorizationFailed,
+		AuthorizedAmount:     amount,
+		AuthorizedAt:         &now,
+		AuthorizedByPlatform: true,
+	}
+
+	description := fmt.Sprintf("%s with %s at %s (%s)", prefix, tutor.Name(), lesson.StartsAtDateTimeFormatted(), lesson.ID.Hex())
+	metadata := map[string]string{"lessonID": lesson.ID.Hex(), "student": student.Name(), "tutor": tutor.Name()}
+
+	id, err := gateway.AuthorizePlatform(student.Payments.CustomerID, amount, lesson.Currency, description, prefix, metadata)
+	if err != nil {
+		return hold, errors.Wrap(err, "couldn't authorize charge")
+	}
+
+	expires := now.Add(holdLifetime)
+	hold.AuthorizationID = id
+	hold.AuthorizationStatus = models.AuthorizationHeld
+	hold.AuthorizationExpiresAt = &expires
+
+	return hold, nil
+}
+
+// ReleaseHolds releases the holds of the students on the lesson
+func (l *Lessons) ReleaseHolds(lesson *store.LessonMgo, students []bson.ObjectId) {
+	for _, student := range students {
+		hold := lessonHold(lesson, student)
+		if !hold.IsHeld() {
+			continue
+		}
+
+		releaseHold(hold)
+		l.SaveCharges(lesson, student, hold)
+	}
+}
+
+// RenewHolds places the holds expiring before their confirmed lesson ends again, and
+// releases the old ones once the new ones are held
+func (l *Lessons) RenewHolds() {
+	now := time.Now()
+
+	var lessons []store.LessonMgo
+	if err := store.GetCollection("lessons").Find(bson.M{
+		"state":   store.LessonConfirmed,
+		"ends_at": bson.M{"$gt": now},
+		"charges": bson.M{"$exists": true},
+	}).All(&lessons); err != nil {
+		logger.Get().Errorf("couldn't get lessons to renew holds: %v", err)
+		return
+	}
+
+	for i := range lessons {
+		lesson := &lessons[i]
+
+		for _, studentID := range lesson.Students {
+			hold := lessonHold(lesson, studentID)
+			if !hold.IsHeld() || hold.AuthorizationExpiresAt == nil {
+				continue
+			}
+
+			expires := *hold.AuthorizationExpiresAt
+			if expires.After(lesson.EndsAt) || expires.After(now.Add(holdRenewal)) {
+				continue
+			}
+
+			student, ok := NewUsers().ByID(studentID)
+			if !ok {
+				continue
+			}
+
+			tutor, ok := NewUsers().ByID(lesson.Tutor)
+			if !ok {
+				continue
+			}
+
+			renewed, err := l.authorizeStudent(student, tutor, lesson)
+			if err != nil {
+				logger.Get().Errorf("couldn't renew hold of student %s on lesson %s: %v", student.Name(), lesson.ID.Hex(), err)
+				continue
+			}
+
+			// packages or credits may cover the lesson now, the old hold is released all the same
+			releaseHold(hold)
+			if renewed == nil {
+				renewed = hold
+			}
+
+			l.SaveCharges(lesson, studentID, renewed)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/services/models"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+// reloadLesson gets the lesson as saved, with the charges saved on it
+func reloadLesson(t *testing.T, lesson *store.LessonMgo) *store.LessonMgo {
+	saved, ok := store.GetLessonsStore().Get(lesson.ID)
+	if !ok {
+		t.Fatal("lesson not found")
+	}
+	return saved
+}
+
+// confirmedGroupLesson inserts a confirmed group lesson of the tutor, two days from now
+func confirmedGroupLesson(t *testing.T, tutor *store.UserMgo, students ...bson.ObjectId) *store.LessonMgo {
+	starts := time.Now().Add(48 * time.Hour)
+	lesson := &store.LessonMgo{
+		ID:       bson.NewObjectId(),
+		Tutor:    tutor.ID,
+		Students: students,
+		Accepted: append([]bson.ObjectId{tutor.ID}, students...),
+		Capacity: 3,
+		Rate:     1050,
+		State:    store.LessonConfirmed,
+		StartsAt: starts,
+		EndsAt:   starts.Add(time.Hour),
+	}
+
+	if err := store.GetCollection("lessons").Insert(lesson); err != nil {
+		t.Fatal(err)
+	}
+	return lesson
+}
+
+func TestJoinConfirmedLessonHolds(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	student := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, student)
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+	if err := tutor.SaveNew(); err != nil {
+		t.Fatal("could not create needed for test:", err)
+	}
+	defer cleanupUser(t, tutor)
+
+	lesson := confirmedGroupLesson(t, tutor, bson.NewObjectId())
+	defer store.GetCollection("lessons").RemoveId(lesson.ID)
+	defer cleanupLedger(t, lesson.ID)
+
+	// the lesson was confirmed before the student joined, their card holds it from now
+	if err := GetLessons().Join(lesson, student); err != nil {
+		t.Fatal(err)
+	}
+
+	hold := lessonHold(reloadLesson(t, lesson), student.ID)
+	if !hold.IsHeld() || hold.AuthorizedAmount != 1365 {
+		t.Fatalf("expected 1365 held on the student's card, got %+v", hold)
+	}
+
+	// the hold is what the lesson is charged with when it completes
+	charge, err := GetPayments().ChargeForLesson(student, tutor, 60, "today", lesson.ID.Hex(), false, lesson.Rate, lesson.Currency, hold, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if charge.ChargeID != hold.AuthorizationID || charge.AuthorizationStatus != models.AuthorizationCaptured || len(g.Charges()) != 1 {
+		t.Errorf("expected the hold captured, got %+v", charge)
+	}
+}
+
+func TestLeaveLessonReleasesHold(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	student := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, student)
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+	if err := tutor.SaveNew(); err != nil {
+		t.Fatal("could not create needed for test:", err)
+	}
+	defer cleanupUser(t, tutor)
+
+	lesson := confirmedGroupLesson(t, tutor, student.ID, bson.NewObjectId())
+	defer store.GetCollection("lessons").RemoveId(lesson.ID)
+	defer cleanupLedger(t, lesson.ID)
+
+	GetLessons().AuthorizeLesson(lesson)
+	lesson = reloadLesson(t, lesson)
+
+	hold := lessonHold(lesson, student.ID)
+	if !hold.IsHeld() {
+		t.Fatalf("expected the student's card held, got %+v", hold)
+	}
+
+	// leaving two days before is free, nothing stays held on the card
+	if _, err := GetLessons().Leave(lesson, student); err != nil {
+		t.Fatal(err)
+	}
+
+	if ch, _ := g.GetCharge(hold.AuthorizationID); ch.AmountCaptured != 0 || ch.Refunded != ch.Amount {
+		t.Errorf("expected the hold released, got %+v", ch)
+	}
+
+	if lessonHold(reloadLesson(t, lesson), student.ID).IsHeld() {
+		t.Error("expected the lesson's charge of the student released")
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"gitlab.com/learnt/api/pkg/store"
 	"gitlab.com/learnt/api/pkg/utils"
 	m "gitlab.com/learnt/api/pkg/utils/messaging"
+	"gitlab.com/learnt/api/pkg/utils/rrule"
 	"gitlab.com/learnt/api/pkg/ws"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -36,6 +37,9 @@ const (
 	errDatabase
 	errInvalidProposal
 	errInvalidMeetingPlace
+	errInvalidCapacity
+	errInvalidRecurrence
+	errInvalidPromoCode
 )
 
 // LessonErr is the HTTP response for a lesson error
@@ -72,6 +76,7 @@ func GetLessons() *Lessons {
 		nextTimeout: time.Second,
 		nextTick:    make(chan int),
 	}
+	lessonInst.registerStateHooks()
 
 	return lessonInst
 }
@@ -179,6 +184,10 @@ func (l *Lessons) GetCurrentRunningLessons(offset int, limit int) (*store.Pagina
 	return store.GetLessonsStore().GetCurrentRunningLessons(offset, limit)
 }
 
+func (l *Lessons) GetOpenGroupLessons(subject bson.ObjectId, offset int, limit int) (*store.PaginatedLessons, error) {
+	return store.GetLessonsStore().GetOpenGroupLessons(subject, offset, limit)
+}
+
 func (l *Lessons) GetDefaultLessons() []store.LessonMgo {
 	return store.GetLessonsStore().GetDefaultLessons()
 }
@@ -203,10 +212,12 @@ func (l *Lessons) Start(ctx context.Context) {
 		case "start":
 			lesson := marker.Lesson
 
-			// cancel if not confirmed and still pending
+			// expire if not confirmed and still pending
 			if lesson.State == store.LessonBooked {
-				if err := lesson.SetState(store.LessonCancelled, nil); err != nil {
-					logger.Get().Errorf("failed to set lesson state to CANCELLED: %v", err)
+				if err := lesson.SetState(store.LessonExpired, store.StateChange{
+					Reason: "lesson wasn't confirmed before it started",
+				}); err != nil {
+					logger.Get().Errorf("failed to set lesson state to EXPIRED: %v", err)
 				}
 				return
 			}
@@ -216,14 +227,12 @@ func (l *Lessons) Start(ctx context.Context) {
 				return
 			}
 
-			if err := marker.Lesson.SetState(store.LessonProgress, nil); err != nil {
+			if err := marker.Lesson.SetState(store.LessonProgress, store.StateChange{
+				Reason: "lesson started",
+			}); err != nil {
 				logger.Get().Errorf("failed to set lesson state to PROGRESS: %v", err)
 				return
 			}
-
-			title := "Lesson just started"
-			message := fmt.Sprintf("Lesson with tutor %s and students %s just started", marker.Lesson.GetTutor().Name(), marker.Lesson.StudentsNames(true))
-			l.NotifyAll(marker.Lesson, notifications.LessonStarted, title, message)
 		}
 	})
 
@@ -234,18 +243,74 @@ func (l *Lessons) Start(ctx context.Context) {
 	go func() { <-u.Run() }()
 }
 
+// maxLessonCapacity is the largest group a tutor can open a lesson for
+const maxLessonCapacity = 12
+
+// maxSeriesLessons is the largest number of lessons booked at once in a series
+const maxSeriesLessons = 104
+
 // CreateLessonRequest is the HTTP body used for creating a new lesson
 type CreateLessonRequest struct {
-	Tutor          bson.ObjectId `json:"tutor" binding:"required"`
-	Student        bson.ObjectId `json:"student" binding:"required"`
+	Tutor   bson.ObjectId `json:"tutor" binding:"required"`
+	Student bson.ObjectId `json:"student"`
+	// Students are the students booked in a group lesson, on top of Student.
+	Students []bson.ObjectId `json:"students"`
+	// Capacity is the number of seats of a group lesson. Only the tutor can set it.
+	Capacity       int           `json:"capacity"`
 	Subject        bson.ObjectId `json:"subject" binding:"required"`
 	When           time.Time     `json:"when" binding:"required"`
 	Duration       string        `json:"duration" binding:"required"`
 	Meet           store.Meet    `json:"meet" binding:"required"`
 	Location       string        `json:"location"`
 	RecurrentCount int           `json:"recurrent_count"`
-	Recurrent      bool          `json:"recurrent"`
-	Instant        bool          `json:"instant"`
+	// RRule is the RFC 5545 recurrence rule of a lesson series, e.g.
+	// FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=8. It takes precedence over RecurrentCount.
+	RRule string `json:"rrule"`
+	// ExDates are the start times of the occurrences of RRule that aren't booked,
+	// the EXDATEs of the series.
+	ExDates   []time.Time `json:"exdates"`
+	Recurrent bool        `json:"recurrent"`
+	Instant   bool        `json:"instant"`
+	// PromoCode is the promo code the student books with, redeemed when each lesson is charged
+	PromoCode string `json:"promo_code"`
+}
+
+// StudentIDs returns the unique students booked by the request.
+func (r *CreateLessonRequest) StudentIDs() []bson.ObjectId {
+	ids := make([]bson.ObjectId, 0, len(r.Students)+1)
+	seen := make(map[string]bool)
+	for _, id := range append([]bson.ObjectId{r.Student}, r.Students...) {
+		if id == "" || seen[id.Hex()] {
+			continue
+		}
+		seen[id.Hex()] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Rule returns the recurrence rule of the booked series, or nil for a single lesson.
+// RecurrentCount is kept as a weekly rule for clients that don't send a rule.
+func (r *CreateLessonRequest) Rule() (*rrule.Rule, error) {
+	if r.RRule != "" {
+		return rrule.Parse(r.RRule)
+	}
+
+	if r.RecurrentCount > 1 {
+		return &rrule.Rule{Freq: rrule.Weekly, Interval: 1, Count: r.RecurrentCount}, nil
+	}
+
+	return nil, nil
+}
+
+// HasStudent tells if the student is booked by the request.
+func (r *CreateLessonRequest) HasStudent(id bson.ObjectId) bool {
+	for _, studentID := range r.StudentIDs() {
+		if studentID.Hex() == id.Hex() {
+			return true
+		}
+	}
+	return false
 }
 
 /*type authorization struct {
@@ -257,7 +322,6 @@ type CreateLessonRequest struct {
 }*/
 
 func (l *Lessons) AuthorizeCharges(lesson *store.LessonMgo) {
-	p := GetPayments()
 	duration := math.Ceil(lesson.Duration().Minutes())
 
 	tutor, ok := NewUsers().ByID(lesson.Tutor)
@@ -274,19 +338,17 @@ func (l *Lessons) AuthorizeCharges(lesson *store.LessonMgo) {
 		}
 
 		if !student.IsTestStudent() {
-			charge, err := p.ChargeForLesson(student, tutor, duration, lesson.StartsAtDateTimeFormatted(), lesson.ID.Hex(), false, lesson.Rate)
+			charge, err := l.chargeStudent(student, tutor, lesson, duration)
 			if err != nil {
 				logger.Get().Errorf("couldn't charge student %s on lesson %v: %v\n", student.Name(), lesson.ID.Hex(), err)
-				return
 			}
-			l.SaveCharges(lesson.ID, charge)
+			l.SaveCharges(lesson, student.ID, charge)
 		}
 	}
 }
 
 // Per-Student AuthorizeCharge.
 func (l *Lessons) AuthorizeCharge(student *store.UserMgo, lesson *store.LessonMgo) {
-	p := GetPayments()
 	duration := math.Ceil(lesson.Duration().Minutes())
 
 	tutor, ok := NewUsers().ByID(lesson.Tutor)
@@ -295,15 +357,58 @@ func (l *Lessons) AuthorizeCharge(student *store.UserMgo, lesson *store.LessonMg
 	}
 
 	if !student.IsTestStudent() {
-		charge, err := p.ChargeForLesson(student, tutor, duration, lesson.StartsAtDateTimeFormatted(), lesson.ID.Hex(), false, lesson.Rate)
+		charge, err := l.chargeStudent(student, tutor, lesson, duration)
 		if err != nil {
 			logger.Get().Errorf("couldn't charge student %s on lesson %v: %v\n", student.Name(), lesson.ID.Hex(), err)
 		}
-		l.SaveCharges(lesson.ID, charge)
+		l.SaveCharges(lesson, student.ID, charge)
 	}
 }
 
-func (l *Lessons) CreateInstantSession(student *store.UserMgo, tutor *store.UserMgo, subject *store.Subject) (err error) {
+// chargeStudent draws the lesson from the student's packages with the tutor first,
+// and charges the minutes they don't cover, capturing the student's hold on the lesson
+// when there's one. The charge has what was drawn even when charging the rest fails.
+func (l *Lessons) chargeStudent(student, tutor *store.UserMgo, lesson *store.LessonMgo, duration float64) (*models.ChargeData, error) {
+	hold := lessonHold(lesson, student.ID)
+
+	// course lessons are priced by the course, packages don't cover them
+	var charge *models.ChargeData
+	left := duration
+	if lesson.Course == nil {
+		charge, left = GetPackages().ChargeForLesson(student, tutor, lesson, duration)
+	}
+
+	if left <= 0 {
+		releaseHold(hold)
+		if charge != nil {
+			charge.KeepHold(hold)
+		}
+		return charge, nil
+	}
+
+	rest, err := GetPayments().ChargeForLesson(student, tutor, left, lesson.StartsAtDateTimeFormatted(), lesson.ID.Hex(), false, lesson.Rate, lesson.Currency, hold, lesson.PromoCodes[student.ID.Hex()])
+	if err != nil {
+		return charge, err
+	}
+
+	if charge == nil {
+		return rest, nil
+	}
+
+	charge.TutorPay += rest.TutorPay
+	charge.PlatformFee += rest.PlatformFee
+	charge.StudentCost += rest.StudentCost
+	charge.Discount += rest.Discount
+	charge.PromoCode = rest.PromoCode
+	charge.PromoCodeError = rest.PromoCodeError
+	charge.ChargeID = rest.ChargeID
+	charge.TransferID = rest.TransferID
+	charge.KeepHold(rest)
+
+	return charge, nil
+}
+
+func (l *Lessons) CreateInstantSession(student *store.UserMgo, tutor *store.UserMgo, subject *store.Subject) (*store.LessonMgo, error) {
 	lesson := &store.LessonMgo{
 		ID:        bson.NewObjectId(),
 		Tutor:     tutor.ID,
@@ -311,6 +416,7 @@ func (l *Lessons) CreateInstantSession(student *store.UserMgo, tutor *store.User
 		StartsAt:  time.Now(),
 		EndsAt:    time.Now().Add(time.Hour * 1),
 		Rate:      tutor.Tutoring.Rate,
+		Currency:  tutor.Tutoring.Currency.OrDefault(),
 		Meet:      store.MeetOnline,
 		State:     store.LessonConfirmed,
 		Subject:   subject.ID,
@@ -320,14 +426,14 @@ func (l *Lessons) CreateInstantSession(student *store.UserMgo, tutor *store.User
 	}
 
 	if errIns := store.GetCollection("lessons").Insert(lesson); errIns != nil {
-		return newLessonErr(errDatabase, "couldn't insert the lesson")
+		return nil, newLessonErr(errDatabase, "couldn't insert the lesson")
 	}
 
 	room, err := VCRInstance().GetRoomForLesson(lesson)
 
 	if err != nil {
 		go store.GetCollection("lessons").RemoveId(lesson.ID)
-		return errors.Wrap(err, "Failed to create room for instant session lesson")
+		return nil, errors.Wrap(err, "Failed to create room for instant session lesson")
 	}
 
 	for _, participant := range lesson.GetParticipants() {
@@ -341,7 +447,7 @@ func (l *Lessons) CreateInstantSession(student *store.UserMgo, tutor *store.User
 		}
 	}
 
-	return nil
+	return lesson, nil
 }
 
 func (l *Lessons) Create(user *store.UserMgo, request *CreateLessonRequest) (store.LessonMgo, error) {
@@ -357,13 +463,58 @@ func (l *Lessons) Create(user *store.UserMgo, request *CreateLessonRequest) (sto
 		return store.LessonMgo{}, newLessonErr(errInvalidUser, "tutor does not exist")
 	}
 
-	student, studentExist := users.ByID(request.Student)
-	if !studentExist {
+	if !tutor.IsTutor() {
+		return store.LessonMgo{}, newLessonErr(errInvalidRole, "tutor is pending")
+	}
+
+	studentIDs := request.StudentIDs()
+	isTutorBooking := user.ID.Hex() == tutor.ID.Hex()
+
+	if !isTutorBooking && !request.HasStudent(user.ID) {
+		return store.LessonMgo{}, newLessonErr(errInvalidUser, "lessons can only be booked by their tutor or students")
+	}
+
+	capacity := len(studentIDs)
+	if request.Capacity > 0 {
+		if !isTutorBooking {
+			return store.LessonMgo{}, newLessonErr(errInvalidRole, "only the tutor can set the lesson capacity")
+		}
+
+		if request.Capacity > maxLessonCapacity {
+			return store.LessonMgo{}, newLessonErr(errInvalidCapacity, fmt.Sprintf("lesson capacity can't be more than %d", maxLessonCapacity))
+		}
+
+		if request.Capacity < len(studentIDs) {
+			return store.LessonMgo{}, newLessonErr(errInvalidCapacity, "lesson capacity is lower than the number of students")
+		}
+
+		capacity = request.Capacity
+	}
+
+	if len(studentIDs) == 0 && capacity <= 1 {
 		return store.LessonMgo{}, newLessonErr(errInvalidUser, "student does not exist")
 	}
 
-	if !tutor.IsTutor() {
-		return store.LessonMgo{}, newLessonErr(errInvalidRole, "tutor is pending")
+	students := make([]*store.UserMgo, 0, len(studentIDs))
+	for _, studentID := range studentIDs {
+		student, studentExist := users.ByID(studentID)
+		if !studentExist {
+			return store.LessonMgo{}, newLessonErr(errInvalidUser, "student does not exist")
+		}
+		students = append(students, student)
+	}
+
+	var promoCodes map[string]string
+	if request.PromoCode != "" {
+		if isTutorBooking {
+			return store.LessonMgo{}, newLessonErr(errInvalidPromoCode, "only students can book with a promo code")
+		}
+
+		promo, err := GetPayments().CheckPromoCode(request.PromoCode, user, tutor, subject.ID)
+		if err != nil {
+			return store.LessonMgo{}, newLessonErr(errInvalidPromoCode, err.Error())
+		}
+		promoCodes = map[string]string{user.ID.Hex(): promo.Code}
 	}
 
 	if tutor.Tutoring.Meet != store.MeetBoth && tutor.Tutoring.Meet != request.Meet {
@@ -398,21 +549,53 @@ func (l *Lessons) Create(user *store.UserMgo, request *CreateLessonRequest) (sto
 		return store.LessonMgo{}, newLessonErr(errInvalidTime, "tutor doesn't have availability set")
 	}
 
-	if !tutor.IsFree(lessonDate, requestedDuration) {
-		return store.LessonMgo{}, newLessonErr(errInvalidTime, "tutor is not free")
+	rule, err := request.Rule()
+	if err != nil {
+		return store.LessonMgo{}, newLessonErr(errInvalidRecurrence, fmt.Sprintf("invalid recurrence rule -- %s", err.Error()))
 	}
 
-	if !student.IsFree(lessonDate, requestedDuration) {
-		return store.LessonMgo{}, newLessonErr(errInvalidTime, "student is not free")
+	// series are expanded in the tutor's timezone, so every lesson keeps the same local time
+	occurrences := []time.Time{lessonDate}
+	if rule != nil {
+		if !rule.IsBounded() {
+			return store.LessonMgo{}, newLessonErr(errInvalidRecurrence, "recurrence rule must end with COUNT or UNTIL")
+		}
+
+		for _, ex := range request.ExDates {
+			if ex.Equal(lessonDate) {
+				return store.LessonMgo{}, newLessonErr(errInvalidRecurrence, "the first lesson of a series can't be excluded")
+			}
+		}
+
+		occurrences = rule.All(lessonDate.In(tutor.TimezoneLocation()), request.ExDates...)
+		if len(occurrences) == 0 {
+			return store.LessonMgo{}, newLessonErr(errInvalidRecurrence, "recurrence rule has no lessons")
+		}
+
+		if len(occurrences) > maxSeriesLessons {
+			return store.LessonMgo{}, newLessonErr(errInvalidRecurrence, fmt.Sprintf("a lesson series can't have more than %d lessons", maxSeriesLessons))
+		}
+	}
+
+	for i, occurrence := range occurrences {
+		if i > 0 && !tutor.IsAvailable(occurrence, occurrence.Add(requestedDuration), request.Recurrent) {
+			return store.LessonMgo{}, newLessonErr(errInvalidTime, fmt.Sprintf("tutor doesn't have availability set on %s", occurrence.Format(time.RFC1123)))
+		}
+
+		if !tutor.IsFreeFor(occurrence, requestedDuration, request.Meet, nil) {
+			return store.LessonMgo{}, newLessonErr(errInvalidTime, fmt.Sprintf("tutor is not free on %s", occurrence.Format(time.RFC1123)))
+		}
+
+		for _, student := range students {
+			if !student.IsFree(occurrence, requestedDuration) {
+				return store.LessonMgo{}, newLessonErr(errInvalidTime, fmt.Sprintf("student %s is not free on %s", student.GetFirstName(), occurrence.Format(time.RFC1123)))
+			}
+		}
 	}
 
-	state := store.LessonBooked
-	notificationType := notifications.LessonBooked
 	acceptedUsers := []bson.ObjectId{user.ID}
 
-	if tutor.Tutoring.InstantBooking {
-		state = store.LessonConfirmed
-		notificationType = notifications.LessonAccepted
+	if tutor.Tutoring.InstantBooking && !isTutorBooking {
 		acceptedUsers = append(acceptedUsers, tutor.ID)
 	}
 
@@ -421,50 +604,64 @@ func (l *Lessons) Create(user *store.UserMgo, request *CreateLessonRequest) (sto
 
 	baseLesson := store.LessonMgo{
 		Tutor:         tutor.ID,
-		Students:      []bson.ObjectId{student.ID},
+		Students:      studentIDs,
 		StartsAt:      startsAtDate,
 		EndsAt:        endsAtDate,
-		Rate:          tutor.Tutoring.Rate,
+		Rate:          tutor.Tutoring.SeatRate(capacity),
+		Currency:      tutor.Tutoring.Currency.OrDefault(),
 		Meet:          request.Meet,
 		Location:      request.Location,
-		State:         state,
+		State:         store.LessonBooked,
 		Subject:       subject.ID,
 		StateTimeline: []store.LessonStateData{},
 		CreatedAt:     time.Now(),
 		Accepted:      acceptedUsers,
 		Recurrent:     request.Recurrent,
+		PromoCodes:    promoCodes,
 	}
 
-	if request.RecurrentCount == 0 {
-		request.RecurrentCount = 1
+	if capacity > 1 {
+		baseLesson.Capacity = capacity
 	}
 
-	recurrentID := bson.NewObjectId()
-	if request.RecurrentCount > 1 {
-		baseLesson.RecurrentID = &recurrentID
+	// an open group lesson the tutor books alone, or a lesson booked with a tutor
+	// that has instant booking, doesn't wait for anyone else to accept it
+	notificationType := notifications.LessonBooked
+	if baseLesson.EveryoneAccepted() {
+		baseLesson.State = store.LessonConfirmed
+		notificationType = notifications.LessonAccepted
+	}
+
+	if len(occurrences) > 1 {
+		series := store.NewLessonSeries(rule, occurrences[0])
+		series.ExDates = append(series.ExDates, request.ExDates...)
+		if err := series.Insert(); err != nil {
+			return store.LessonMgo{}, newLessonErr(errDatabase, fmt.Sprintf("couldn't insert the lesson series -- %s", err.Error()))
+		}
+		baseLesson.RecurrentID = &series.ID
 	}
 
 	insertedLessons := make([]store.LessonMgo, 0)
-	for i := 1; i <= request.RecurrentCount; i++ {
+	for _, occurrence := range occurrences {
 		insertLesson := baseLesson
 		insertLesson.ID = bson.NewObjectId()
+		insertLesson.StartsAt = occurrence.UTC()
+		insertLesson.EndsAt = occurrence.Add(requestedDuration).UTC()
 
 		if errIns := store.GetCollection("lessons").Insert(insertLesson); errIns != nil {
 			return store.LessonMgo{}, newLessonErr(errDatabase, fmt.Sprintf("couldn't insert the lesson -- %s", errIns.Error()))
 		}
 
 		insertedLessons = append(insertedLessons, insertLesson)
-		baseLesson.StartsAt = baseLesson.StartsAt.AddDate(0, 0, 7)
-		baseLesson.EndsAt = baseLesson.EndsAt.AddDate(0, 0, 7)
 	}
 
 	notifyTitle := "New lesson created"
-	if request.RecurrentCount > 1 {
+	if len(insertedLessons) > 1 {
 		notifyTitle = "New recurring lesson created"
 	}
 
 	notifyAction := fmt.Sprintf("/main/account/calendar/details/%s", insertedLessons[0].ID.Hex())
-	for _, userID := range []bson.ObjectId{tutor.ID, user.ID} {
+	for _, userID := range append([]bson.ObjectId{tutor.ID}, baseLesson.Students...) {
 		notifyMsg := fmt.Sprintf("New lesson created with %s", tutor.Name())
 		if userID.Hex() == insertedLessons[0].Tutor.Hex() {
 			notifyMsg = fmt.Sprintf("New lesson created with %s ", insertedLessons[0].StudentsNames(false))
@@ -483,7 +680,7 @@ func (l *Lessons) Create(user *store.UserMgo, request *CreateLessonRequest) (sto
 	if baseLesson.Tutor.Hex() == user.ID.Hex() {
 		// if the user who booked is the tutor
 		for _, studentID := range baseLesson.Students {
-			if student, exist := NewUsers().ByID(studentID); !exist {
+			if student, exist := NewUsers().ByID(studentID); exist {
 				notifyActionURL, err := core.AppURL(notifyAction)
 				if err != nil {
 					return store.LessonMgo{}, err
@@ -630,13 +827,22 @@ func (l *Lessons) Accept(lesson *store.LessonMgo, user *store.UserMgo) (err erro
 	}
 
 	if everyone {
-		_ = lesson.SetState(store.LessonConfirmed, nil)
+		_ = lesson.SetState(store.LessonConfirmed, store.StateChange{
+			Actor:  user,
+			Reason: "lesson accepted by everyone",
+		})
 	}
 
 	return
 }
 
 func (l *Lessons) Reject(user *store.UserMgo, lesson *store.LessonMgo, reason string) (err error) {
+	// a student cancelling a group lesson only gives up their seat
+	if lesson.IsGroup() && lesson.HasStudent(user.ID) {
+		_, err := l.Leave(lesson, user)
+		return err
+	}
+
 	if err := lesson.Reject(user, reason); err != nil {
 		return errors.Wrap(err, "Failed to reject the lesson")
 	}
@@ -683,6 +889,109 @@ func (l *Lessons) Reject(user *store.UserMgo, lesson *store.LessonMgo, reason st
 	return nil
 }
 
+// Join adds the student to an open seat of a group lesson
+func (l *Lessons) Join(lesson *store.LessonMgo, student *store.UserMgo) error {
+	if !student.IsStudent() {
+		return newLessonErr(errInvalidRole, "only students can join a lesson")
+	}
+
+	if !student.IsFree(lesson.StartsAt, lesson.Duration()) {
+		return newLessonErr(errInvalidTime, "student is not free")
+	}
+
+	if err := lesson.Join(student); err != nil {
+		return newLessonErr(errInvalidCapacity, err.Error())
+	}
+
+	switch {
+	case lesson.IsConfirmed():
+		// the others' cards hold the lesson since it was confirmed, the student's does from now
+		if tutor, ok := NewUsers().ByID(lesson.Tutor); ok {
+			l.authorizeSeat(student, tutor, lesson)
+		} else {
+			logger.Get().Errorf("couldn't get tutor to authorize lesson %s", lesson.ID.Hex())
+		}
+	case lesson.EveryoneAccepted():
+		_ = lesson.SetState(store.LessonConfirmed, store.StateChange{
+			Actor:  student,
+			Reason: "student joined the lesson",
+		})
+	}
+
+	message := fmt.Sprintf("%s joined the lesson on %s", student.GetFirstName(), lesson.WhenFormatted())
+
+	return l.NotifyExcept(lesson, student, notifications.LessonStudentJoined, "Student joined the lesson", message)
+}
+
+// ApplyPromoCode sets the promo code the student's seat of the lesson is charged with.
+// An empty code removes the one set before.
+func (l *Lessons) ApplyPromoCode(lesson *store.LessonMgo, student *store.UserMgo, code string) error {
+	if !lesson.HasStudent(student.ID) {
+		return newLessonErr(errInvalidUser, "not a student of the lesson")
+	}
+
+	if !lesson.CanBeModified() || lesson.Charges[student.ID.Hex()] != nil {
+		return newLessonErr(errInvalidPromoCode, "promo code can't be changed anymore")
+	}
+
+	if code == "" {
+		return lesson.SetPromoCode(student.ID, "")
+	}
+
+	tutor, ok := NewUsers().ByID(lesson.Tutor)
+	if !ok {
+		return newLessonErr(errInvalidUser, "tutor does not exist")
+	}
+
+	promo, err := GetPayments().CheckPromoCode(code, student, tutor, lesson.Subject)
+	if err != nil {
+		return newLessonErr(errInvalidPromoCode, err.Error())
+	}
+
+	return lesson.SetPromoCode(student.ID, promo.Code)
+}
+
+// Leave frees the student's seat in a group lesson and applies the cancellation policy to it.
+// The student is only billed once the seat is freed.
+func (l *Lessons) Leave(lesson *store.LessonMgo, student *store.UserMgo) (*store.LessonCancellation, error) {
+	// the policy is the one of a student of the lesson, it's worked out before they leave
+	c := newCancellation(lesson, student)
+
+	if err := lesson.Leave(student); err != nil {
+		return nil, newLessonErr(errInvalidUser, err.Error())
+	}
+
+	c, err := l.applyCancellation(lesson, c)
+
+	// the hooks of the lesson only release the holds of its students, the student isn't one anymore
+	l.ReleaseHolds(lesson, []bson.ObjectId{student.ID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("%s left the lesson on %s", student.GetFirstName(), lesson.WhenFormatted())
+
+	return c, l.NotifyExcept(lesson, student, notifications.LessonStudentLeft, "Student left the lesson", message)
+}
+
+// SetCapacity changes the number of seats of a lesson. Only the tutor can do it.
+func (l *Lessons) SetCapacity(lesson *store.LessonMgo, user *store.UserMgo, capacity int) error {
+	if lesson.Tutor.Hex() != user.ID.Hex() {
+		return newLessonErr(errInvalidRole, "only the tutor can change the lesson capacity")
+	}
+
+	if capacity > maxLessonCapacity {
+		return newLessonErr(errInvalidCapacity, fmt.Sprintf("lesson capacity can't be more than %d", maxLessonCapacity))
+	}
+
+	if err := lesson.SetCapacity(capacity); err != nil {
+		return newLessonErr(errInvalidCapacity, err.Error())
+	}
+
+	return nil
+}
+
 func (l *Lessons) NotifyAll(lesson *store.LessonMgo, notifyKind notifications.NotifyKind, title, message string) {
 	users := make([]*store.UserMgo, 0)
 
@@ -851,7 +1160,14 @@ func (l *Lessons) Notify(user *store.UserMgo, lesson *store.LessonMgo, notifyKin
 }
 
 func (l *Lessons) CompleteUnAuthorized(lesson *store.LessonMgo) error {
-	if err := lesson.SetState(store.LessonCompleted, nil); err != nil {
+	if lesson.State == store.LessonCompleted {
+		return nil
+	}
+
+	if err := lesson.SetState(store.LessonCompleted, store.StateChange{
+		Reason: "lesson completed without charges",
+		Waive:  true,
+	}); err != nil {
 		return fmt.Errorf("couldn't set complete state: %s", err)
 	}
 
@@ -861,12 +1177,14 @@ func (l *Lessons) CompleteUnAuthorized(lesson *store.LessonMgo) error {
 }
 
 func (l *Lessons) Complete(lesson *store.LessonMgo) error {
-	if err := lesson.SetState(store.LessonCompleted, nil); err != nil {
-		return fmt.Errorf("couldn't set complete state: %s", err)
+	if lesson.State == store.LessonCompleted {
+		return nil
 	}
 
+	// lessons are charged for the time they lasted: instant sessions and lessons ended early
+	// end now, extended lessons already end later
 	now := time.Now()
-	if lesson.IsInstantSession() {
+	if lesson.IsInstantSession() || (now.After(lesson.StartsAt) && now.Before(lesson.EndsAt)) {
 		if err := lesson.SetEndsAt(now); err != nil {
 			return fmt.Errorf("couldn't set complete state: %s", err)
 		}
@@ -874,6 +1192,12 @@ func (l *Lessons) Complete(lesson *store.LessonMgo) error {
 		lesson.EndsAt = now
 	}
 
+	if err := lesson.SetState(store.LessonCompleted, store.StateChange{
+		Reason: "lesson completed",
+	}); err != nil {
+		return fmt.Errorf("couldn't set complete state: %s", err)
+	}
+
 	referLinks, err := GetRefers().NeedPayment()
 	if err != nil {
 		return fmt.Errorf("couldn't get refer links: %s", err)
@@ -896,7 +1220,13 @@ func (l *Lessons) Complete(lesson *store.LessonMgo) error {
 		StartsAt: lesson.StartsAt,
 	}
 
-	amount := float64(tutor.Tutoring.Rate/60) * lesson.Duration().Minutes()
+	// referrals are paid in the default currency
+	rate, err := inDefaultCurrency(tutor.Tutoring.Rate, tutor.Tutoring.Currency)
+	if err != nil {
+		return fmt.Errorf("couldn't price lesson for referrals: %s", err)
+	}
+
+	amount := float64(rate) / 100 / 60 * lesson.Duration().Minutes()
 
 	for _, link := range referLinks {
 		// check for students' link completion
@@ -924,6 +1254,10 @@ func (l *Lessons) Complete(lesson *store.LessonMgo) error {
 
 		// check for tutor's link completion
 		if link.Referral.Hex() == lesson.Tutor.Hex() {
+			if len(lesson.Students) == 0 {
+				continue
+			}
+
 			student, ok := NewUsers().ByID(lesson.Students[0])
 			if !ok {
 				return fmt.Errorf("couldn't get lesson's student")
@@ -943,14 +1277,56 @@ func (l *Lessons) Complete(lesson *store.LessonMgo) error {
 		}
 	}
 
-	logger.Get().Debugf("Authorizing charges for lesson %v", lesson.ID)
-	l.AuthorizeCharges(lesson)
+	return nil
+}
+
+// registerStateHooks adds the charging and notifications that follow lesson state changes
+func (l *Lessons) registerStateHooks() {
+	store.AfterLessonState(store.LessonProgress, func(t *store.LessonTransition) error {
+		title := "Lesson just started"
+		message := fmt.Sprintf("Lesson with tutor %s and students %s just started", t.Lesson.GetTutor().Name(), t.Lesson.StudentsNames(true))
+		l.NotifyAll(t.Lesson, notifications.LessonStarted, title, message)
+		return nil
+	})
+
+	// the students' cards hold the price of the lesson from when it's confirmed until it's charged
+	store.AfterLessonState(store.LessonConfirmed, func(t *store.LessonTransition) error {
+		l.AuthorizeLesson(t.Lesson)
+		return nil
+	})
+
+	store.AfterLessonState(store.LessonCompleted, func(t *store.LessonTransition) error {
+		if t.Waive {
+			l.ReleaseHolds(t.Lesson, t.Lesson.Students)
+			return nil
+		}
 
-	if err := checkForReview(lesson); err != nil {
-		// todo: handle error
+		logger.Get().Debugf("Authorizing charges for lesson %v", t.Lesson.ID)
+		l.AuthorizeCharges(t.Lesson)
+
+		return checkForReview(t.Lesson)
+	})
+
+	store.AfterLessonState(store.LessonNoShow, l.onNoShow)
+
+	// the enrollments of course lessons progress as the lessons end
+	for _, state := range []store.LessonState{store.LessonCompleted, store.LessonCancelled, store.LessonExpired, store.LessonNoShow} {
+		store.AfterLessonState(state, GetCourses().onLessonEnded)
 	}
 
-	return nil
+	for _, state := range []store.LessonState{store.LessonCancelled, store.LessonExpired} {
+		store.AfterLessonState(state, func(t *store.LessonTransition) error {
+			l.ReleaseHolds(t.Lesson, t.Lesson.Students)
+			return nil
+		})
+	}
+
+	store.AfterLessonState(store.LessonExpired, func(t *store.LessonTransition) error {
+		title := "Lesson expired"
+		message := fmt.Sprintf("The lesson on %s wasn't confirmed before it started.", t.Lesson.WhenFormatted())
+		l.NotifyAll(t.Lesson, notifications.LessonSystemCancelled, title, message)
+		return nil
+	})
 }
 
 type lessonInterface struct {
@@ -1000,39 +1376,15 @@ func completeLinkAndPay(link *store.ReferLink, amount float64, details string, l
 			return fmt.Errorf("lesson hasn't ended")
 		}
 
-		reason := "credit"
-		amount := int64(referrerCredit) * 100
-		var creditReferrerParams, creditReferralParams CreditParams
-		creditReferrerParams.Notes = fmt.Sprintf("Add %d credits to %s", reason, referrerCredit, referrer.Name())
-		creditReferrerParams.Reason = reason
-		creditReferrerParams.Amount = amount
-		if err := p.AddCredits(referrer, creditReferrerParams); err != nil {
-			return err
-		}
-
-		if _, err := GetTransactions().New(&store.TransactionMgo{
-			User:    *link.Referrer,
-			Amount:  referrerCredit,
-			Lesson:  &lesson.ID,
-			Details: details,
-		}); err != nil {
-			return fmt.Errorf("couldn't create transaction for referrer: %s", err)
-		}
-
-		creditReferralParams.Notes = fmt.Sprintf("Add %d credits to %s", reason, referrerCredit, referral.Name())
-		creditReferralParams.Reason = reason
-		creditReferralParams.Amount = amount
-		if err := p.AddCredits(referral, creditReferralParams); err != nil {
-			return err
-		}
-
-		if _, err := GetTransactions().New(&store.TransactionMgo{
-			User:    *link.Referral,
-			Amount:  referrerCredit,
-			Lesson:  &lesson.ID,
-			Details: details,
-		}); err != nil {
-			return fmt.Errorf("couldn't create transaction for referral: %s", err)
+		for _, user := range []*store.UserMgo{referrer, referral} {
+			if err := p.AddCredits(user, CreditParams{
+				Amount: int64(math.Round(referrerCredit * 100)),
+				Reason: string(store.EntryReferral),
+				Notes:  fmt.Sprintf("Referral credit of $%.2f to %s", referrerCredit, user.Name()),
+				Lesson: &lesson.ID,
+			}); err != nil {
+				return err
+			}
 		}
 
 		return link.Complete()
@@ -1045,30 +1397,13 @@ func completeLinkAndPay(link *store.ReferLink, amount float64, details string, l
 	}
 
 	if total >= 10 {
-		if err := p.CreditForReferral(referrer, referrerCredit); err != nil {
-			return fmt.Errorf("couldn't add balance to referrer: %s", err)
-		}
-
-		if _, err := GetTransactions().New(&store.TransactionMgo{
-			User:    *link.Referrer,
-			Amount:  referrerCredit,
-			Lesson:  &lesson.ID,
-			Details: details,
-		}); err != nil {
-			return fmt.Errorf("couldn't create transaction for referrer: %s", err)
-		}
-
-		if err := p.CreditForReferree(referral, 10); err != nil {
+		if err := p.CreditForReferral(referrer, referrerCredit, lesson.ID, details); err != nil {
 			return fmt.Errorf("couldn't add balance to referrer: %s", err)
 		}
 
-		if _, err := GetTransactions().New(&store.TransactionMgo{
-			User:    *link.Referral,
-			Amount:  10, // $10
-			Lesson:  &lesson.ID,
-			Details: details,
-		}); err != nil {
-			return fmt.Errorf("couldn't create transaction for referral: %s", err)
+		// $10 to the tutor referred
+		if err := p.CreditForReferral(referral, 10, lesson.ID, details); err != nil {
+			return fmt.Errorf("couldn't add balance to referral: %s", err)
 		}
 
 		if err = link.Complete(); err != nil {
@@ -1112,21 +1447,10 @@ func completeLinkAndPayAffiliate(link *store.ReferLink, amount float64, details
 	}
 
 	p := GetPayments()
-	if err := p.CreditForReferral(referrer, referCredit); err != nil {
+	if err := p.CreditForReferral(referrer, referCredit, lesson.ID, details); err != nil {
 		return fmt.Errorf("couldn't add balance to referrer: %s", err)
 	}
 
-	t := &store.TransactionMgo{
-		User:    *link.Referrer,
-		Amount:  referCredit,
-		Lesson:  &lesson.ID,
-		Details: details,
-	}
-
-	if _, err := GetTransactions().New(t); err != nil {
-		return fmt.Errorf("couldn't create transaction for referrer: %s", err)
-	}
-
 	if link.Affiliate {
 		if err := link.SetAmount(link.Amount + referCredit); err != nil {
 			return fmt.Errorf("couldn't update refer link amount: %s", err)
@@ -1222,14 +1546,26 @@ func (l *Lessons) GetCompletedLessonsForUser(id bson.ObjectId, from, to time.Tim
 	return lessons, nil
 }
 
-func (l *Lessons) SaveCharges(id bson.ObjectId, charge *models.ChargeData) {
-	if err := store.GetCollection("lessons").UpdateId(id, bson.M{
-		"$set": bson.M{
-			"charge": charge,
-		},
+// SaveCharges stores the charge of a student on the lesson. Group lessons keep
+// every student's charge under "charges", keyed by the student's id.
+func (l *Lessons) SaveCharges(lesson *store.LessonMgo, student bson.ObjectId, charge *models.ChargeData) {
+	if charge == nil {
+		return
+	}
+
+	set := bson.M{
+		"charges." + student.Hex(): charge,
+	}
+
+	if !lesson.IsGroup() {
+		set["charge"] = charge
+	}
+
+	if err := store.GetCollection("lessons").UpdateId(lesson.ID, bson.M{
+		"$set": set,
 	}); err != nil {
 		logger.Get().Errorf("failed to save lesson charges: %v", err)
 		return
 	}
-	logger.Get().Infof("saved charges for lesson %v: charge: %v", id, charge.ChargeID)
+	logger.Get().Infof("saved charges for lesson %v: student: %v charge: %v", lesson.ID, student.Hex(), charge.ChargeID)
 }
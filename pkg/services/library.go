@@ -57,3 +57,14 @@ func (l *Library) FilesByUser(user *store.UserMgo) ([]*store.FilesDto, error) {
 	}
 	return files, nil
 }
+
+// Files gets the files in the library by ID. The ones missing or deleted are left out.
+func (l *Library) Files(ids []bson.ObjectId) []*store.FilesDto {
+	files := make([]*store.FilesDto, 0, len(ids))
+	for _, id := range ids {
+		if f, exist := l.ByID(id); exist && f != nil && !f.Deleted {
+			files = append(files, f)
+		}
+	}
+	return files
+}
@@ -1,10 +1,70 @@
 package models
 
+import "time"
+
+// Authorization statuses of the holds placed on the students' cards for their lessons
+const (
+	AuthorizationHeld     = "authorized"
+	AuthorizationCaptured = "captured"
+	AuthorizationReleased = "released"
+	AuthorizationFailed   = "failed"
+)
+
 type ChargeData struct {
-	TutorPay    int64   `json:"tutor_pay,omitempty" bson:"tutor_pay,omitempty"`
-	TutorRate   float32 `json:"tutor_rate,omitempty" bson:"tutor_rate,omitempty"`
-	PlatformFee int64   `json:"platform_fee,omitempty" bson:"platform_fee,omitempty"`
-	StudentCost int64   `json:"student_cost,omitempty" bson:"student_cost,omitempty"`
-	ChargeID    string  `json:"charge_id,omitempty" bson:"charge_id,omitempty"`
+	TutorPay    int64  `json:"tutor_pay,omitempty" bson:"tutor_pay,omitempty"`
+	TutorRate   int64  `json:"tutor_rate,omitempty" bson:"tutor_rate,omitempty"`
+	PlatformFee int64  `json:"platform_fee,omitempty" bson:"platform_fee,omitempty"`
+	StudentCost int64  `json:"student_cost,omitempty" bson:"student_cost,omitempty"`
+	ChargeID    string `json:"charge_id,omitempty" bson:"charge_id,omitempty"`
+	// Currency is what the amounts are in, the tutor's when the lesson was booked
+	Currency string `json:"currency,omitempty" bson:"currency,omitempty"`
+	// Discount is what PromoCode took off the student's cost, the platform paid it
+	Discount  int64  `json:"discount,omitempty" bson:"discount,omitempty"`
+	PromoCode string `json:"promo_code,omitempty" bson:"promo_code,omitempty"`
+	// PromoCodeError is why the promo code the student booked with wasn't applied, the
+	// lesson was charged in full
+	PromoCodeError string `json:"promo_code_error,omitempty" bson:"promo_code_error,omitempty"`
+	// TransferID is the platform's charge that paid the tutor the part the student's credits covered,
+	// when tutors were paid at charge time.
+	TransferID string `json:"transfer_id,omitempty" bson:"transfer_id,omitempty"`
+	// Status is the state of ChargeID Stripe last told about: succeeded, failed, refunded or disputed.
+	Status string `json:"status,omitempty" bson:"status,omitempty"`
+	// PackageID is the prepaid package the lesson was drawn from, for PackageMinutes.
+	PackageID      string `json:"package_id,omitempty" bson:"package_id,omitempty"`
+	PackageMinutes int    `json:"package_minutes,omitempty" bson:"package_minutes,omitempty"`
+	// AuthorizationID is the uncaptured charge holding the lesson's price on the student's card
+	// from when the lesson is confirmed. AuthorizationStatus is authorized while it's held, and
+	// captured, released or failed after.
+	AuthorizationID        string     `json:"authorization_id,omitempty" bson:"authorization_id,omitempty"`
+	AuthorizationStatus    string     `json:"authorization_status,omitempty" bson:"authorization_status,omitempty"`
+	AuthorizedAmount       int64      `json:"authorized_amount,omitempty" bson:"authorized_amount,omitempty"`
+	AuthorizedAt           *time.Time `json:"authorized_at,omitempty" bson:"authorized_at,omitempty"`
+	AuthorizationExpiresAt *time.Time `json:"authorization_expires_at,omitempty" bson:"authorization_expires_at,omitempty"`
+	// AuthorizedByPlatform is set on holds for the platform's account. The holds placed before
+	// tutors were paid out in batches pay the tutor when they're captured.
+	AuthorizedByPlatform bool `json:"authorized_by_platform,omitempty" bson:"authorized_by_platform,omitempty"`
+}
+
+// IsHeld tells if the charge holds an authorization that can still be captured
+func (c *ChargeData) IsHeld() bool {
+	if c == nil || c.AuthorizationStatus != AuthorizationHeld {
+		return false
+	}
+
+	return c.AuthorizationExpiresAt == nil || time.Now().Before(*c.AuthorizationExpiresAt)
+}
+
+// KeepHold carries over the authorization of the hold the charge settled
+func (c *ChargeData) KeepHold(hold *ChargeData) {
+	if hold == nil || hold.AuthorizationID == "" {
+		return
+	}
+
+	c.AuthorizationID = hold.AuthorizationID
+	c.AuthorizationStatus = hold.AuthorizationStatus
+	c.AuthorizedAmount = hold.AuthorizedAmount
+	c.AuthorizedAt = hold.AuthorizedAt
+	c.AuthorizationExpiresAt = hold.AuthorizationExpiresAt
+	c.AuthorizedByPlatform = hold.AuthorizedByPlatform
 }
 
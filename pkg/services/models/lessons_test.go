@@ -0,0 +1,45 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChargeDataIsHeld(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		charge *ChargeData
+		held   bool
+	}{
+		{"no charge", nil, false},
+		{"no hold", &ChargeData{ChargeID: "ch_1"}, false},
+		{"held", &ChargeData{AuthorizationStatus: AuthorizationHeld, AuthorizationExpiresAt: &future}, true},
+		{"expired", &ChargeData{AuthorizationStatus: AuthorizationHeld, AuthorizationExpiresAt: &past}, false},
+		{"captured", &ChargeData{AuthorizationStatus: AuthorizationCaptured, AuthorizationExpiresAt: &future}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.charge.IsHeld(); got != tt.held {
+			t.Errorf("%s: expected held %v, got %v", tt.name, tt.held, got)
+		}
+	}
+}
+
+func TestChargeDataKeepHold(t *testing.T) {
+	charge := &ChargeData{ChargeID: "ch_2", StudentCost: 1365}
+	charge.KeepHold(nil)
+	if charge.AuthorizationStatus != "" {
+		t.Errorf("expected nothing kept without a hold, got %+v", charge)
+	}
+
+	charge.KeepHold(&ChargeData{AuthorizationID: "ch_1", AuthorizationStatus: AuthorizationCaptured, AuthorizedAmount: 1500, AuthorizedByPlatform: true})
+	if charge.AuthorizationID != "ch_1" || charge.AuthorizationStatus != AuthorizationCaptured || charge.AuthorizedAmount != 1500 || !charge.AuthorizedByPlatform {
+		t.Errorf("expected hold kept, got %+v", charge)
+	}
+
+	if charge.ChargeID != "ch_2" || charge.StudentCost != 1365 {
+		t.Errorf("expected charge left alone, got %+v", charge)
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/config"
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/notifications"
+	"gitlab.com/learnt/api/pkg/services/delivery"
+	"gitlab.com/learnt/api/pkg/store"
+	m "gitlab.com/learnt/api/pkg/utils/messaging"
+	"gitlab.com/learnt/api/pkg/utils/messaging/mail"
+)
+
+// noShowReport checks the classroom activity of the lesson. It returns nil when
+// the tutor and every student entered the classroom.
+func noShowReport(lesson *store.LessonMgo, room *store.RoomEntity) *store.NoShowReport {
+	entered := func(user bson.ObjectId) bool {
+		return room != nil && room.EnteredBy(user)
+	}
+
+	if !entered(lesson.Tutor) {
+		return &store.NoShowReport{
+			Party:      store.NoShowTutor,
+			Absent:     []bson.ObjectId{lesson.Tutor},
+			DetectedAt: time.Now(),
+		}
+	}
+
+	absent := make([]bson.ObjectId, 0)
+	for _, student := range lesson.Students {
+		if !entered(student) {
+			absent = append(absent, student)
+		}
+	}
+
+	if len(absent) == 0 {
+		return nil
+	}
+
+	return &store.NoShowReport{
+		Party:      store.NoShowStudent,
+		Absent:     absent,
+		DetectedAt: time.Now(),
+	}
+}
+
+// DetectNoShow checks who entered the lesson's classroom. When the tutor didn't,
+// the lesson is a no-show and the students aren't billed. When none of the
+// students did, the lesson is a no-show and the students are billed. Students
+// missing from a group lesson are only recorded.
+func (l *Lessons) DetectNoShow(lesson *store.LessonMgo) error {
+	room, _ := store.GetRoomForLesson(lesson.ID)
+	report := noShowReport(lesson, room)
+
+	if err := lesson.SetAttendance(report); err != nil {
+		return err
+	}
+
+	if report == nil {
+		return nil
+	}
+
+	change := store.StateChange{Data: report}
+
+	switch {
+	case report.Party == store.NoShowTutor:
+		change.Reason = "tutor didn't enter the classroom"
+		change.Waive = true
+	case len(report.Absent) == len(lesson.Students):
+		change.Reason = "students didn't enter the classroom"
+	default:
+		logger.Get().Infof("%d students didn't enter the classroom of lesson %s", len(report.Absent), lesson.ID.Hex())
+		return nil
+	}
+
+	return errors.Wrap(lesson.SetState(store.LessonNoShow, change), "couldn't mark lesson as no-show")
+}
+
+// onNoShow bills or credits the students of a no-show lesson and tells everyone about it
+func (l *Lessons) onNoShow(t *store.LessonTransition) error {
+	lesson := t.Lesson
+
+	if t.Waive {
+		l.ReleaseHolds(lesson, lesson.Students)
+		l.creditCharges(lesson)
+	} else {
+		logger.Get().Debugf("Authorizing charges for no-show lesson %v", lesson.ID)
+		l.AuthorizeCharges(lesson)
+	}
+
+	tutor := lesson.GetTutor()
+	if tutor == nil {
+		return errors.New("couldn't get the tutor of the lesson")
+	}
+
+	students := NewUsers().ByIDs(lesson.Students)
+	studentsNames := lesson.StudentsNames(false)
+
+	conf := config.GetConfig()
+	d := delivery.New(conf)
+
+	tutorNoShow := lesson.NoShow == nil || lesson.NoShow.Party == store.NoShowTutor
+
+	if tutorNoShow {
+		for _, student := range students {
+			go d.Send(student, m.TPL_LESSON_NO_SHOW_TUTOR, &m.P{
+				"TUTOR_NAME":   tutor.Name(),
+				"STUDENT_NAME": student.Name(),
+			})
+		}
+	} else {
+		go d.Send(tutor, m.TPL_LESSON_NO_SHOW_STUDENT, &m.P{
+			"STUDENT_NAME": studentsNames,
+		})
+	}
+
+	go mail.GetSender(conf).SendTo(m.HIRING_EMAIL, m.TPL_LESSON_NO_SHOW_ADMIN, &m.P{
+		"TUTOR_NAME":           tutor.Name(),
+		"STUDENT_NAME":         studentsNames,
+		"MERGE_LESSON_DETAILS": lesson.FetchSubjectName(),
+	})
+
+	message := fmt.Sprintf("The students didn't attend the lesson on %s.", lesson.WhenFormatted())
+	if tutorNoShow {
+		message = fmt.Sprintf("The tutor didn't attend the lesson on %s, the students won't be charged.", lesson.WhenFormatted())
+	}
+
+	l.NotifyAll(lesson, notifications.LessonNoShow, "Lesson missed", message)
+
+	return nil
+}
+
+// creditCharges gives the students back, as credits, what they were already charged for the lesson
+func (l *Lessons) creditCharges(lesson *store.LessonMgo) {
+	for hex, charge := range lesson.Charges {
+		if charge == nil || charge.StudentCost == 0 || !bson.IsObjectIdHex(hex) {
+			continue
+		}
+
+		student, ok := NewUsers().ByID(bson.ObjectIdHex(hex))
+		if !ok {
+			logger.Get().Errorf("couldn't get student %s to credit lesson %s", hex, lesson.ID.Hex())
+			continue
+		}
+
+		if err := GetPayments().AddCredits(student, CreditParams{
+			Amount: charge.StudentCost,
+			Reason: "refund",
+			Notes:  fmt.Sprintf("Tutor didn't attend the lesson on %s", lesson.WhenFormatted()),
+			Lesson: &lesson.ID,
+		}); err != nil {
+			logger.Get().Errorf("couldn't credit student %s for lesson %s: %v", hex, lesson.ID.Hex(), err)
+		}
+	}
+}
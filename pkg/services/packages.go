@@ -0,0 +1,279 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/notifications"
+	"gitlab.com/learnt/api/pkg/services/models"
+	"gitlab.com/learnt/api/pkg/services/stripe"
+	"gitlab.com/learnt/api/pkg/store"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const packagePrefix = "Learnt Lesson Package"
+
+type packages struct{}
+
+func GetPackages() *packages {
+	return &packages{}
+}
+
+// Buy charges the student for the tutor's package offer. The money stays with the
+// platform and is paid to the tutor as the package's lessons complete.
+func (p *packages) Buy(student, tutor *store.UserMgo, offerID bson.ObjectId) (*store.LessonPackage, error) {
+	if !student.IsStudent() {
+		return nil, errors.New("only students can buy packages")
+	}
+
+	if student.Payments == nil || student.Payments.CustomerID == "" {
+		return nil, errors.New("student has no payment method")
+	}
+
+	if !tutor.IsTutor() || tutor.Payments == nil || tutor.Payments.ConnectID == "" {
+		return nil, errors.New("tutor can't be paid for packages")
+	}
+
+	offer, ok := tutor.GetPackageOffer(offerID)
+	if !ok {
+		return nil, errors.New("package offer not found")
+	}
+
+	tutorPay, _, studentCost := lessonAmounts(tutor.Tutoring.Rate, float64(offer.Hours*60))
+	pkg := store.NewLessonPackage(offer, tutor, student, studentCost, tutorPay)
+
+	description := fmt.Sprintf("%d hours package with %s (%s)", offer.Hours, tutor.Name(), pkg.ID.Hex())
+	metadata := map[string]string{"packageID": pkg.ID.Hex(), "student": student.Name(), "tutor": tutor.Name()}
+
+	// the package is saved before the card is charged, so a charge is never left without one
+	pkg.Status = store.PackagePending
+	if err := pkg.Insert(); err != nil {
+		return nil, err
+	}
+
+	chargeID, err := gateway.ChargePlatform(student.Payments.CustomerID, pkg.Price, pkg.Currency, description, packagePrefix, metadata)
+	if err != nil {
+		if errRemove := pkg.Remove(); errRemove != nil {
+			logger.Get().Errorf("couldn't remove unpaid package %s: %v", pkg.ID.Hex(), errRemove)
+		}
+		return nil, errors.Wrap(err, "couldn't charge for package")
+	}
+
+	if err := pkg.Activate(chargeID); err != nil {
+		// the package can't be used, the student gets their money back
+		refundMetadata := map[string]string{"packageID": pkg.ID.Hex(), stripe.MetadataRecorded: "true"}
+		if _, errRefund := gateway.RefundCharge(chargeID, pkg.Price, refundMetadata); errRefund != nil {
+			logger.Get().Errorf("package %s was charged with %s but couldn't be activated or refunded: %v, %v", pkg.ID.Hex(), chargeID, err, errRefund)
+		}
+		return nil, err
+	}
+
+	// the money is held for the student until the lessons draw it
+	entry := store.NewLedgerEntry(store.EntryPackage, description,
+		store.Debit(store.AccountStudentCash, student.ID, pkg.Price),
+		store.Credit(store.AccountStudentPrepaid, student.ID, pkg.Price),
+	).In(pkg.Currency).WithStripe(chargeID)
+	entry.Package = &pkg.ID
+
+	if err := entry.Post(); err != nil {
+		logger.Get().Errorf("couldn't post package %s to the ledger: %v", pkg.ID.Hex(), err)
+	}
+
+	return pkg, nil
+}
+
+// ChargeForLesson draws the lesson's minutes from the student's packages with the
+// tutor and pays the tutor their share. It returns the minutes the packages didn't cover.
+func (p *packages) ChargeForLesson(student, tutor *store.UserMgo, lesson *store.LessonMgo, minutes float64) (*models.ChargeData, float64) {
+	usable, err := store.GetUsablePackages(tutor.ID, student.ID)
+	if err != nil {
+		logger.Get().Errorf("couldn't get packages of student %s: %v", student.Name(), err)
+		return nil, minutes
+	}
+
+	left := int(minutes)
+	var charge *models.ChargeData
+
+	for i := range usable {
+		if left == 0 {
+			break
+		}
+
+		pkg := &usable[i]
+
+		// packages bought before the tutor changed currency don't pay lessons in the new one
+		if pkg.Currency.OrDefault() != lesson.Currency.OrDefault() {
+			continue
+		}
+
+		drawn, err := drawPackage(pkg, left)
+		if err != nil {
+			logger.Get().Errorf("couldn't draw lesson %s from package %s: %v", lesson.ID.Hex(), pkg.ID.Hex(), err)
+			continue
+		}
+
+		if drawn == 0 {
+			continue
+		}
+
+		left -= drawn
+
+		studentCost, tutorPay := pkg.Value(drawn)
+		p.payTutor(student, tutor, lesson, pkg, drawn, studentCost, tutorPay)
+
+		if charge == nil {
+			charge = &models.ChargeData{TutorRate: pkg.Rate, Currency: string(pkg.Currency.OrDefault()), PackageID: pkg.ID.Hex()}
+		}
+
+		charge.TutorPay += tutorPay
+		charge.PlatformFee += studentCost - tutorPay
+		charge.PackageMinutes += drawn
+	}
+
+	return charge, float64(left)
+}
+
+// drawRetries is how many times a draw is tried again when the package's balance
+// changed since it was read
+const drawRetries = 3
+
+// drawPackage draws up to minutes from the package, reading it again when another draw
+// changed its balance meanwhile
+func drawPackage(pkg *store.LessonPackage, minutes int) (int, error) {
+	for i := 0; ; i++ {
+		drawn, err := pkg.Draw(minutes)
+		if err == nil || i == drawRetries {
+			return drawn, err
+		}
+
+		fresh, ok := store.GetLessonPackage(pkg.ID)
+		if !ok {
+			return 0, err
+		}
+		*pkg = *fresh
+	}
+}
+
+// payTutor records the minutes drawn from the package on the lesson, the tutor is paid
+// them with their next payout
+func (p *packages) payTutor(student, tutor *store.UserMgo, lesson *store.LessonMgo, pkg *store.LessonPackage, minutes int, studentCost, tutorPay int64) {
+	details := fmt.Sprintf("%d minutes from package for lesson of %s with %s at %s (%s)", minutes, student.Name(), tutor.Name(), lesson.StartsAtDateTimeFormatted(), lesson.ID.Hex())
+	entry := store.NewLedgerEntry(store.EntryPackage, details,
+		store.Debit(store.AccountStudentPrepaid, student.ID, studentCost),
+		store.Credit(store.AccountTutorPayable, tutor.ID, tutorPay),
+		store.Credit(store.AccountPlatformRevenue, "", studentCost-tutorPay),
+	).In(pkg.Currency)
+	entry.Lesson = &lesson.ID
+	entry.Package = &pkg.ID
+
+	if err := entry.Post(); err != nil {
+		logger.Get().Errorf("couldn't post package lesson %s to the ledger: %v", lesson.ID.Hex(), err)
+	}
+}
+
+// Refund refunds the unused minutes of the package. Students can refund their
+// active packages, admins can also refund the expired ones.
+func (p *packages) Refund(pkg *store.LessonPackage, user *store.UserMgo) error {
+	switch {
+	case user.IsAdmin():
+		if pkg.Status != store.PackageActive && pkg.Status != store.PackageExpired {
+			return errors.New("package can't be refunded")
+		}
+	case user.ID == pkg.Student:
+		if pkg.Status != store.PackageActive {
+			return errors.New("only active packages can be refunded")
+		}
+	default:
+		return errors.New("package isn't yours")
+	}
+
+	if pkg.MinutesLeft == 0 {
+		return errors.New("package has no minutes left")
+	}
+
+	amount, _ := pkg.Value(pkg.MinutesLeft)
+	minutes := pkg.MinutesLeft
+
+	debit := store.Debit(store.AccountStudentPrepaid, pkg.Student, amount)
+	if pkg.Status == store.PackageExpired {
+		// the minutes of expired packages were already taken as revenue
+		debit = store.Debit(store.AccountPlatformRevenue, "", amount)
+	}
+
+	// the package is claimed before the card is refunded, a refund made at the same time fails
+	if err := pkg.ClaimRefund(); err != nil {
+		return err
+	}
+
+	metadata := map[string]string{"packageID": pkg.ID.Hex(), stripe.MetadataRecorded: "true"}
+	refundID, err := gateway.RefundCharge(pkg.ChargeID, amount, metadata)
+	if err != nil {
+		if err := pkg.ReleaseRefund(); err != nil {
+			logger.Get().Errorf("couldn't release refund of package %s: %v", pkg.ID.Hex(), err)
+		}
+		return errors.Wrap(err, "couldn't refund package")
+	}
+
+	// a package left refunding isn't refunded again before someone looks at it
+	if err := pkg.SetRefunded(refundID); err != nil {
+		logger.Get().Errorf("package %s was refunded with %s but couldn't be saved: %v", pkg.ID.Hex(), refundID, err)
+		return err
+	}
+
+	entry := store.NewLedgerEntry(store.EntryRefund,
+		fmt.Sprintf("Refund of %d unused minutes from package (%s)", minutes, pkg.ID.Hex()),
+		debit,
+		store.Credit(store.AccountStudentCash, pkg.Student, amount),
+	).In(pkg.Currency).WithStripe(refundID)
+	entry.Package = &pkg.ID
+
+	if err := entry.Post(); err != nil {
+		logger.Get().Errorf("couldn't post refund of package %s to the ledger: %v", pkg.ID.Hex(), err)
+	}
+
+	return nil
+}
+
+// ExpirePackages expires the packages that weren't used up in time
+func (p *packages) ExpirePackages() {
+	expired, err := store.GetExpiredPackages()
+	if err != nil {
+		logger.Get().Errorf("couldn't get expired packages: %v", err)
+		return
+	}
+
+	for i := range expired {
+		pkg := &expired[i]
+
+		if err := pkg.Expire(); err != nil {
+			logger.Get().Errorf("couldn't expire package %s: %v", pkg.ID.Hex(), err)
+			continue
+		}
+
+		// the minutes left aren't owed to the student anymore
+		if value, _ := pkg.Value(pkg.MinutesLeft); value > 0 {
+			entry := store.NewLedgerEntry(store.EntryPackage,
+				fmt.Sprintf("%d unused minutes of expired package (%s)", pkg.MinutesLeft, pkg.ID.Hex()),
+				store.Debit(store.AccountStudentPrepaid, pkg.Student, value),
+				store.Credit(store.AccountPlatformRevenue, "", value),
+			).In(pkg.Currency)
+			entry.Package = &pkg.ID
+
+			if err := entry.Post(); err != nil {
+				logger.Get().Errorf("couldn't post expiry of package %s to the ledger: %v", pkg.ID.Hex(), err)
+			}
+		}
+
+		notifications.Notify(&notifications.NotifyRequest{
+			User:    pkg.Student,
+			Type:    notifications.LessonPackageExpired,
+			Title:   "Lesson package expired",
+			Message: fmt.Sprintf("Your package expired with %d minutes left.", pkg.MinutesLeft),
+			Data: map[string]interface{}{
+				"package": pkg,
+			},
+		})
+	}
+}
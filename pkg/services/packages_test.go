@@ -0,0 +1,35 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+func TestDrawPackageReadsAgain(t *testing.T) {
+	dbSetup(t)
+
+	pkg := &store.LessonPackage{ID: bson.NewObjectId(), Minutes: 600, MinutesLeft: 300, Status: store.PackageActive, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.GetCollection("lesson_packages").Insert(pkg); err != nil {
+		t.Fatal(err)
+	}
+	defer store.GetCollection("lesson_packages").RemoveId(pkg.ID)
+
+	// another lesson drew from the package after this one read it
+	stale, other := *pkg, *pkg
+	if _, err := other.Draw(60); err != nil {
+		t.Fatal(err)
+	}
+
+	drawn, err := drawPackage(&stale, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if saved, _ := store.GetLessonPackage(pkg.ID); drawn != 60 || saved.MinutesLeft != 180 {
+		t.Errorf("expected 60 minutes drawn with 180 left, got %d with %d left", drawn, saved.MinutesLeft)
+	}
+}
@@ -8,13 +8,14 @@ import (
 	"time"
 
 	stripeGo "github.com/stripe/stripe-go"
+	"gitlab.com/learnt/api/config"
 	"gitlab.com/learnt/api/pkg/logger"
 	"gitlab.com/learnt/api/pkg/services/models"
 
-	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 	"gitlab.com/learnt/api/pkg/services/stripe"
 	"gitlab.com/learnt/api/pkg/store"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -52,88 +53,33 @@ type CreditParams struct {
 	Amount int64  `json:"amount"`
 	Reason string `json:"reason"`
 	Notes  string `json:"notes"`
+	// Lesson is the lesson the credits are for, if any
+	Lesson *bson.ObjectId `json:"-"`
+	// GiftCard is the gift card the credits were redeemed from, if any
+	GiftCard *bson.ObjectId `json:"-"`
+	// Currency is what the amount is in. Students' credits are in the default currency,
+	// tutors are paid theirs in the currency of what they're for.
+	Currency store.Currency `json:"-"`
 }
 
-type payments struct {
-	hooks map[WebHookEventType]func(event *WebHookEvent)
-}
+type payments struct{}
 
 // GetPayments returns an configured struct that holds functions for working with payments
 func GetPayments() *payments {
 	return &payments{}
 }
 
-// WebHookEventType are type events
-type WebHookEventType string
-
-const (
-	accountUpdated              WebHookEventType = "account.updated"
-	customerSubscriptionUpdated WebHookEventType = "customer.subscription.updated"
-	invoicePaymentSucceeded     WebHookEventType = "invoice.payment_succeeded"
-)
-
-// WebHookEvent is a stripe wehbook https://stripe.com/docs/api/webhook_endpoints
-type WebHookEvent struct {
-	ID      string           `json:"id"`
-	Type    WebHookEventType `json:"type"`
-	Object  string           `json:"object"`
-	Created uint64           `json:"created"`
-	Data    interface{}      `json:"data"`
-}
-
+// Init sets up the payment gateway set in the config, Stripe when none is set
 func (p *payments) Init(ctx context.Context) {
-	stripe.Init()
-	p.hooks = make(map[WebHookEventType]func(event *WebHookEvent))
-
-	p.hooks[customerSubscriptionUpdated] = p.HookCustomerSubscriptionUpdated
-	p.hooks[invoicePaymentSucceeded] = p.HookInvoicePaymentSucceeded
-}
-
-func getSentTransactions(user *store.UserMgo) ([]*store.TransactionMgo, error) {
-	var t []*store.TransactionMgo
-
-	tr := GetTransactions()
-	if t == nil {
-		return t, errors.New("couldn't get transactions collection")
-	}
-
-	err := tr.Find(bson.M{
-		"user":  user.ID,
-		"state": store.TransactionSent,
-	}).All(&t)
-
-	return t, errors.Wrap(err, "couldn't get transactions for user "+user.ID.Hex())
-}
-
-func clearTransactions(user *store.UserMgo) error {
-	return GetTransactions().Update(bson.M{
-		"user":  user.ID,
-		"state": store.TransactionSent,
-	}, bson.M{
-		"state": store.TransactionApproved,
-	})
-}
-
-func (p *payments) HookCustomerSubscriptionUpdated(event *WebHookEvent) {
-	logger.Get().Info("HookCustomerSubscriptionUpdated")
-}
-
-func (p *payments) HookInvoicePaymentSucceeded(event *WebHookEvent) {
-	logger.Get().Info("HookInvoicePaymentSucceeded")
-}
-
-func (p *payments) WebHook(c *gin.Context) {
-	event := WebHookEvent{}
-
-	if err := c.BindJSON(&event); err != nil {
-		logger.Get().Errorf("Fail to bind stripe webhook event data: %v", err)
-		return
-	}
-
-	if fn, exist := p.hooks[event.Type]; exist {
-		fn(&event)
-	} else {
-		logger.Get().Errorf("No hooks for: %v", event.Type)
+	switch name := config.GetConfig().Payments.Gateway; name {
+	case "", gatewayStripe:
+		stripe.Init()
+		gateway = stripeGateway{}
+	case gatewayMemory:
+		logger.Get().Warn("payments go through the memory gateway, nothing is charged")
+		gateway = NewMemoryGateway()
+	default:
+		logger.Get().Fatalf("unknown payment gateway %q", name)
 	}
 }
 
@@ -148,7 +94,7 @@ func (p *payments) NewCardToken(user *store.UserMgo, params CardParams) (string,
 	if err := p.EnsureCustomer(user); err != nil {
 		return "", errors.Wrapf(err, "couldn't ensure customer to add create card token (userID:%s)", user.ID.Hex())
 	}
-	token, err := stripe.NewCardToken(user.Payments.CustomerID,
+	token, err := gateway.NewCardToken(user.Payments.CustomerID,
 		params.Name, params.Number, params.Month, params.Year, params.CVC)
 	if err != nil {
 		return "", errors.Wrap(err, "couldn't create card token")
@@ -157,7 +103,7 @@ func (p *payments) NewCardToken(user *store.UserMgo, params CardParams) (string,
 }
 
 func updateUserCards(user *store.UserMgo) error {
-	cards, err := stripe.ListCards(user.Payments.CustomerID)
+	cards, err := gateway.ListCards(user.Payments.CustomerID)
 	if err != nil {
 		return errors.Wrap(err, "could not list cards to update user")
 	}
@@ -226,7 +172,7 @@ func (p *payments) AddCard(user *store.UserMgo, token string) (*stripe.Card, err
 		return nil, errors.Wrapf(err, "couldn't ensure customer to add card (userID:%s)", user.ID.Hex())
 	}
 
-	card, err := stripe.NewCard(user.Payments.CustomerID, token)
+	card, err := gateway.NewCard(user.Payments.CustomerID, token)
 	if err != nil {
 		return nil, errors.Wrap(err, "couldn't add card from token")
 	}
@@ -244,7 +190,7 @@ func (p *payments) DeleteCard(user *store.UserMgo, id string) (*stripe.Card, err
 		return nil, errors.New("user does not have a payment account")
 	}
 
-	cards, err := stripe.ListCards(user.Payments.CustomerID)
+	cards, err := gateway.ListCards(user.Payments.CustomerID)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not list cards to see if there are enough to delete one")
 	}
@@ -253,10 +199,9 @@ func (p *payments) DeleteCard(user *store.UserMgo, id string) (*stripe.Card, err
 		return nil, errors.New("can't delete the only card")
 	}
 
-	card, err := stripe.DeleteCard(user.Payments.CustomerID, id)
+	card, err := gateway.DeleteCard(user.Payments.CustomerID, id)
 	if err != nil {
-		stripeError := err.(*stripeGo.Error)
-		if stripeError != nil {
+		if stripeError, ok := errors.Cause(err).(*stripeGo.Error); ok {
 			if stripeError.Code == "resource_missing" && strings.Contains(stripeError.Msg, "No such customer") {
 				logger.Get().Errorf("Customer %s does not exist in stripe. Deleting card from database.\n", user.Payments.CustomerID)
 				return deleteUserCard(user, id)
@@ -278,7 +223,7 @@ func (p *payments) DeleteBankAccount(user *store.UserMgo, id string) error {
 		return errors.New("user does not have a payment account")
 	}
 
-	accounts, err := stripe.ListBankAccounts(user.Payments.CustomerID)
+	accounts, err := gateway.ListBankAccounts(user.Payments.CustomerID)
 	if err != nil {
 		return errors.Wrap(err, "could not list bank accounts to see if there are enough to delete one")
 	}
@@ -287,7 +232,7 @@ func (p *payments) DeleteBankAccount(user *store.UserMgo, id string) error {
 		return errors.New("no bank accounts in stripe to delete")
 	}
 
-	err = stripe.DeleteBankAccount(user.Payments.CustomerID, id)
+	err = gateway.DeleteBankAccount(user.Payments.CustomerID, id)
 	if err != nil {
 		return errors.Wrap(err, "could not delete bank account")
 	}
@@ -306,7 +251,7 @@ func (p *payments) GetCards(user *store.UserMgo) ([]*stripe.Card, error) {
 		return nil, errors.New("user does not have a payment account")
 	}
 
-	return stripe.ListCards(user.Payments.CustomerID)
+	return gateway.ListCards(user.Payments.CustomerID)
 }
 
 // NewCustomer creates a new customer and adds it to a user.
@@ -316,7 +261,7 @@ func (p *payments) NewCustomer(user *store.UserMgo) (err error) {
 		return errors.Wrap(err, "couldn't get main email")
 	}
 
-	id, err := stripe.NewCustomer(user.Name(), email, map[string]string{stripe.MetadataLearntAccountID: user.ID.Hex()})
+	id, err := gateway.NewCustomer(user.Name(), email, map[string]string{stripe.MetadataLearntAccountID: user.ID.Hex()})
 	if err != nil {
 		return err
 	}
@@ -324,35 +269,21 @@ func (p *payments) NewCustomer(user *store.UserMgo) (err error) {
 	return errors.Wrap(user.SetPaymentsCustomer(id), "couldn't set payment customer to user")
 }
 
-// CreditForReferral adds credit to the specified user's customer account
-func (p *payments) CreditForReferral(user *store.UserMgo, balance float64) error {
-	amount := int64(balance * 100)
-	if user.Payments != nil && user.Payments.ConnectID != "" {
-		description := fmt.Sprintf("Referral credit to %s", user.ID.Hex())
-		_, err := stripe.ChargeCorporateCardCompany(user.Payments.ConnectID, amount, description, "", "")
-		return err
-	}
-
-	if err := p.EnsureCustomer(user); err != nil {
-		return errors.Wrapf(err, "couldn't ensure customer to add referral credit (userID:%s)", user.ID.Hex())
+// CreditForReferral adds the referral credit for the lesson to what the user is paid out,
+// when they have a connect account. Users without one get it as credits to spend on lessons.
+func (p *payments) CreditForReferral(user *store.UserMgo, balance float64, lesson bson.ObjectId, details string) error {
+	amount := int64(math.Round(balance * 100))
+	if user.Payments == nil || user.Payments.ConnectID == "" {
+		return p.AddCredits(user, CreditParams{Amount: amount, Reason: string(store.EntryReferral), Notes: details, Lesson: &lesson})
 	}
-	_, err := stripe.CustomerAddToBalance(user.Payments.CustomerID, amount)
-	return err
-}
 
-func (p *payments) CreditForReferree(user *store.UserMgo, balance float64) error {
-	amount := int64(balance * 100)
-	if user.Payments != nil && user.Payments.ConnectID != "" {
-		description := fmt.Sprintf("Referral credit to %s", user.ID.Hex())
-		_, err := stripe.ChargeCorporateCardCompany(user.Payments.ConnectID, amount, description, "", "")
-		return err
-	}
+	entry := store.NewLedgerEntry(store.EntryReferral, details,
+		store.Debit(store.AccountPromotionalExpense, "", amount),
+		store.Credit(store.AccountTutorPayable, user.ID, amount),
+	)
+	entry.Lesson = &lesson
 
-	if err := p.EnsureCustomer(user); err != nil {
-		return errors.Wrapf(err, "couldn't ensure customer to add referral credit (userID:%s)", user.ID.Hex())
-	}
-	_, err := stripe.CustomerAddToBalance(user.Payments.CustomerID, amount)
-	return err
+	return entry.Post()
 }
 
 // // GetBalance retrieves the balance of the specified user
@@ -365,12 +296,12 @@ func (p *payments) GetBalance(user *store.UserMgo) (int64, error) {
 		return user.Payments.Credits, nil
 	}
 
-	return stripe.CustomerGetBalance(user.Payments.CustomerID)
+	return gateway.CustomerBalance(user.Payments.CustomerID)
 }
 
-//lessonAmounts takes the tutor's rate in DOLLARS and returns the breakdown in CENTS
-func lessonAmounts(ratePerHour, durationMinutes float64) (tutorPay, platformFee, studentCost int64) {
-	ratePerMinute := (ratePerHour / time.Hour.Minutes()) * 100
+// lessonAmounts takes the tutor's rate and returns the breakdown, both in minor units of the tutor's currency
+func lessonAmounts(ratePerHour int64, durationMinutes float64) (tutorPay, platformFee, studentCost int64) {
+	ratePerMinute := float64(ratePerHour) / time.Hour.Minutes()
 	tutorPay = int64(math.Round(ratePerMinute * durationMinutes))
 	platformFee = int64(math.Round(platformFeePercentage * float64(tutorPay)))
 	if platformFee < 100 {
@@ -380,6 +311,26 @@ func lessonAmounts(ratePerHour, durationMinutes float64) (tutorPay, platformFee,
 	return tutorPay, platformFee, studentCost
 }
 
+// inDefaultCurrency converts the amount to the default currency, the one credits and referrals
+// are paid in, with the FX rates the admins set
+func inDefaultCurrency(amount int64, currency store.Currency) (int64, error) {
+	if currency.OrDefault() == store.DefaultCurrency {
+		return amount, nil
+	}
+
+	fx, err := store.GetFXTable()
+	if err != nil {
+		return 0, err
+	}
+
+	converted, ok := fx.Convert(amount, currency, store.DefaultCurrency)
+	if !ok {
+		return 0, errors.Errorf("no fx rate from %s to %s", currency, store.DefaultCurrency)
+	}
+
+	return converted, nil
+}
+
 func tutorSuffix(tutor *store.UserMgo) (name string) {
 	if len(tutor.Name())+6 <= stripe.MAX_DESCRIPTOR_LENGTH {
 		name = fmt.Sprintf(" with %s", tutor.Name())
@@ -390,138 +341,135 @@ func tutorSuffix(tutor *store.UserMgo) (name string) {
 	return
 }
 
-// ChargeForLesson will create a charge for a lesson that will be confirmed at a future date
-func (p *payments) ChargeForLesson(student, tutor *store.UserMgo, duration float64, startDateTime, lessonID string, instant bool, rate float32) (*models.ChargeData, error) {
+// ChargeForLesson will create a charge for a lesson that will be confirmed at a future date.
+// The card part is captured from the student's hold on the lesson when it covers it, and the
+// hold is released otherwise. The student pays the platform, the tutor is paid what they
+// earned with their next payout. The lesson is charged in the currency of its rate, and the
+// student's credits, which are in the default currency, only pay lessons in it. The promo
+// code the student booked with, if any, takes its discount off first, the platform pays it.
+func (p *payments) ChargeForLesson(student, tutor *store.UserMgo, duration float64, startDateTime, lessonID string, instant bool, rate int64, currency store.Currency, hold *models.ChargeData, promoCode string) (*models.ChargeData, error) {
 	if student.Payments == nil {
 		return nil, fmt.Errorf("student %v has no payment method for lesson (%s)", student.ID, lessonID)
 	}
 
-	tutorPay, platformFee, studentCost := lessonAmounts(float64(rate), duration)
+	currency = currency.OrDefault()
+	tutorPay, platformFee, studentCost := lessonAmounts(rate, duration)
 	metadata := map[string]string{"lessonID": lessonID, "student": student.Name(), "tutor": tutor.Name()}
 	chargePrefix := prefix
-	creditPrefix := "Credit for Lesson with"
 
 	if instant {
 		chargePrefix = instantPrefix
-		creditPrefix = "Credit for Instant Lesson with"
 	}
 
-	var creditsBalance int64
 	var toBeDeductedFromCredits int64
-	var amountFromLearnt int64
-	var description, creditDescription string
-
-	adjustedStudentCost := studentCost
-	adjustedFee := platformFee
-	adjustedTutorPay := tutorPay
-
-	if student.Payments != nil {
-		creditsBalance = student.Payments.Credits
-	}
-
-	// Charge to credits first
-	if creditsBalance > 0 {
-		if creditsBalance >= studentCost {
-			// if student credits are greater than the amount to pay,
-			// the amount will be deducted from the credits then Learnt will pay the tutor
-			toBeDeductedFromCredits = studentCost
-			adjustedStudentCost = 0
-			adjustedFee = 0
-			amountFromLearnt = tutorPay
+	var cardChargeID string
+	var released []string
+	var discount int64
+	var redemption *store.PromoRedemption
+	var promoErr error
+
+	if promoCode != "" {
+		if redemption, promoErr = p.redeemPromoCode(promoCode, student, tutor, lessonID, studentCost, currency); promoErr != nil {
+			logger.Get().Warnf("promo code %s not applied to lesson (%s): %v", promoCode, lessonID, promoErr)
 		} else {
-			// if the student's amount to pay is greater than the credits,
-			// consume all the credits by deducting from the student's amount to pay
-			toBeDeductedFromCredits = creditsBalance
-			adjustedStudentCost = studentCost - creditsBalance
-
-			// determine the platform fee and the amount that Learnt will shoulder
-			// platform fee will be deducted by the credits first
-			if creditsBalance >= platformFee {
-				adjustedFee = 0
-				amountFromLearnt = tutorPay - adjustedStudentCost
-				adjustedTutorPay = adjustedStudentCost
-			} else {
-				adjustedFee = platformFee - creditsBalance
-				amountFromLearnt = 0
-			}
+			discount = redemption.Amount
 		}
+	}
+
+	due := studentCost - discount
 
-		creditsParams := CreditParams{}
-		creditsParams.Amount = -1 * toBeDeductedFromCredits
-		creditsParams.Reason = "debit"
-		creditsParams.Notes = fmt.Sprintf("%s with %s at %s (%s). Charged with %.2f credits", chargePrefix, tutor.Name(), startDateTime, lessonID, float64(toBeDeductedFromCredits)/100)
-		if err := GetPayments().AddCredits(student, creditsParams); err != nil {
+	// Charge to credits first, as much of the lesson as they cover. They're given back when
+	// the card can't be charged for the rest, and posted to the ledger with the rest of the
+	// lesson's charge otherwise.
+	if currency == store.DefaultCurrency && due > 0 {
+		var err error
+		if toBeDeductedFromCredits, err = takeCredits(student, due); err != nil {
+			releaseRedemption(redemption, lessonID)
 			return nil, fmt.Errorf("couldn't charge credits for this session: %w", err)
 		}
 	}
 
+	adjustedStudentCost := due - toBeDeductedFromCredits
+
 	charge := &models.ChargeData{
-		TutorPay:    adjustedTutorPay,
+		TutorPay:    tutorPay,
 		TutorRate:   rate,
+		Currency:    string(currency),
 		PlatformFee: platformFee,
 		StudentCost: adjustedStudentCost,
+		Discount:    discount,
+	}
+
+	if redemption != nil {
+		charge.PromoCode = redemption.Code
 	}
 
-	tr := GetTransactions()
+	// the student saw the discount when they booked, they're told why they're charged in full
+	if promoErr != nil {
+		charge.PromoCodeError = promoErr.Error()
+		notifyPromoCodeNotApplied(student, tutor, promoCode, startDateTime, lessonID, promoErr)
+	}
 
 	logger.Get().Debugf("charge created for lesson %s: %+v", lessonID, charge)
 
-	if adjustedStudentCost > 0 {
-		description = fmt.Sprintf("%s with %s at %s (%s)", chargePrefix, tutor.Name(), startDateTime, lessonID)
-		chargeID, err := stripe.Charge(student.Payments.CustomerID, tutor.Payments.ConnectID, adjustedStudentCost, adjustedFee, description, chargePrefix, tutorSuffix(tutor), metadata)
+	// holds placed for the tutor's account would pay them on top of their payout, they're
+	// released and the card charged instead
+	if adjustedStudentCost > 0 && hold.IsHeld() && hold.AuthorizedByPlatform && adjustedStudentCost <= hold.AuthorizedAmount && store.Currency(hold.Currency).OrDefault() == currency {
+		// the hold is captured for what the lesson came to, the rest of it is released
+		chargeID, refunds, err := gateway.CaptureCharge(hold.AuthorizationID, adjustedStudentCost, 0, metadata)
 		if err != nil {
-			return nil, fmt.Errorf("could not charge for lesson (%s): %w", lessonID, err)
+			logger.Get().Warnf("couldn't capture hold %s for lesson (%s), charging the card: %v", hold.AuthorizationID, lessonID, err)
+			hold.AuthorizationStatus = models.AuthorizationFailed
+		} else {
+			hold.AuthorizationStatus = models.AuthorizationCaptured
+			charge.ChargeID = chargeID
+			cardChargeID = chargeID
+			released = refunds
 		}
+	}
 
-		logger.Get().Debugf("successfully created charge %s", chargeID)
-
-		charge.ChargeID = chargeID
+	// a hold that wasn't captured isn't needed anymore
+	releaseHold(hold)
+	charge.KeepHold(hold)
 
-		t := &store.TransactionMgo{
-			User:      student.ID,
-			Amount:    float64(adjustedStudentCost) / 100,
-			Details:   description,
-			Reference: chargeID,
-		}
-		if _, err := tr.New(t); err != nil {
-			return nil, fmt.Errorf("couldn't create transaction for student %s on lesson %v: %w", student.Name(), lessonID, err)
+	if adjustedStudentCost > 0 && cardChargeID == "" {
+		description := fmt.Sprintf("%s with %s at %s (%s)", chargePrefix, tutor.Name(), startDateTime, lessonID)
+		chargeID, err := gateway.ChargePlatform(student.Payments.CustomerID, adjustedStudentCost, currency, description, chargePrefix, metadata)
+		if err != nil {
+			releaseRedemption(redemption, lessonID)
+			if errCredits := incCredits(student, toBeDeductedFromCredits); errCredits != nil {
+				logger.Get().Errorf("couldn't give back %d credits of student %s for lesson (%s): %v", toBeDeductedFromCredits, student.ID.Hex(), lessonID, errCredits)
+			}
+			return nil, fmt.Errorf("could not charge for lesson (%s): %w", lessonID, err)
 		}
-		logger.Get().Debugf("created transaction for student %s on lesson %v", student.Name(), lessonID)
 
-		creditDescription = fmt.Sprintf("%s %s at %s (%s)", creditPrefix, student.Name(), startDateTime, lessonID)
+		logger.Get().Debugf("successfully created charge %s", chargeID)
 
-		t = &store.TransactionMgo{
-			User:    tutor.ID,
-			Amount:  float64(adjustedTutorPay) / 100,
-			Details: creditDescription,
-			State:   store.TransactionSent,
-		}
-		if _, err = tr.New(t); err != nil {
-			return nil, fmt.Errorf("couldn't create transaction for tutor for session: %w", err)
-		}
-		logger.Get().Debugf("created transaction for tutor for session")
+		charge.ChargeID = chargeID
+		cardChargeID = chargeID
 	}
 
-	if amountFromLearnt > 0 {
-		description = fmt.Sprintf("Cover customer balance to tutor (lesson:%s)", lessonID)
-		chargeID, err := stripe.ChargeCorporateCardCompany(tutor.Payments.ConnectID, amountFromLearnt, description, chargePrefix, tutorSuffix(tutor))
-		if err != nil {
-			return nil, fmt.Errorf("could not charge amount (%v) for tutor (%s) for lesson (%s) after student credit: %w", amountFromLearnt, tutor.ID.Hex(), lessonID, err)
-		}
-
-		charge.ChargeID = chargeID
+	// the student pays the lesson with credits and card, and the platform the discount. The
+	// tutor earns their pay and the platform keeps the fee. The refunds releasing the rest of the hold are kept
+	// with it, they didn't give back anything paid.
+	entry := store.NewLedgerEntry(store.EntryLesson,
+		fmt.Sprintf("%s of %s with %s at %s (%s)", chargePrefix, student.Name(), tutor.Name(), startDateTime, lessonID),
+		store.Debit(store.AccountStudentCredits, student.ID, toBeDeductedFromCredits),
+		store.Debit(store.AccountStudentCash, student.ID, adjustedStudentCost),
+		store.Debit(store.AccountPromotionalExpense, "", discount),
+		store.Credit(store.AccountTutorPayable, tutor.ID, tutorPay),
+		store.Credit(store.AccountPlatformRevenue, "", platformFee),
+	).In(currency).WithStripe(append([]string{cardChargeID}, released...)...)
 
-		creditDescription = fmt.Sprintf("%s %s at %s (%s)", creditPrefix, student.Name(), startDateTime, lessonID)
+	if bson.IsObjectIdHex(lessonID) {
+		id := bson.ObjectIdHex(lessonID)
+		entry.Lesson = &id
+	}
 
-		t := &store.TransactionMgo{
-			User:    tutor.ID,
-			Amount:  float64(amountFromLearnt) / 100,
-			Details: creditDescription,
-			State:   store.TransactionSent,
-		}
-		if _, err := tr.New(t); err != nil {
-			err = errors.Wrap(err, "couldn't create transaction for tutor for session")
-		}
+	// the student was charged, the charge is kept even when it couldn't be posted so the
+	// lesson isn't charged again
+	if err := entry.Post(); err != nil {
+		logger.Get().Errorf("lesson (%s) was charged %s but couldn't be posted to the ledger: %v", lessonID, cardChargeID, err)
 	}
 
 	return charge, nil
@@ -533,17 +481,17 @@ func (p *payments) SetDefaultCreditCard(user *store.UserMgo, cardID string) (*st
 		return nil, errors.New("user does not have a payment account")
 	}
 
-	if err := stripe.CustomerSetDefaultSource(user.Payments.CustomerID, cardID); err != nil {
+	if err := gateway.SetDefaultSource(user.Payments.CustomerID, cardID); err != nil {
 		return nil, errors.Wrap(err, "could not set default card")
 	}
 
 	go func() {
 		if err := updateUserCards(user); err != nil {
-			logger.Get().Errorf("Could not update user cards", err)
+			logger.Get().Errorf("Could not update user cards: %v", err)
 		}
 	}()
 
-	return stripe.GetCard(user.Payments.CustomerID, cardID)
+	return gateway.GetCard(user.Payments.CustomerID, cardID)
 }
 
 // SetBankAccount adds or replaces the main bank account of a connect account
@@ -553,14 +501,19 @@ func (p *payments) SetBankAccount(user *store.UserMgo, bap *BankAccountParams) (
 	}
 
 	if bap.Token == "" {
-		token, err := stripe.NewExternalBankAccountToken(bap.BankAccountName, bap.BankAccountNumber, bap.BankAccountRouting, bap.BankAccountType)
+		var currency store.Currency
+		if user.Tutoring != nil {
+			currency = user.Tutoring.Currency
+		}
+
+		token, err := gateway.NewBankAccountToken(bap.BankAccountName, bap.BankAccountNumber, bap.BankAccountRouting, bap.BankAccountType, currency)
 		if err != nil {
 			return nil, errors.Wrap(err, "could not create token for bank account")
 		}
 		bap.Token = token
 	}
 
-	ba, err := stripe.ReplaceBankAccount(user.Payments.ConnectID, bap.Token)
+	ba, err := gateway.ReplaceBankAccount(user.Payments.ConnectID, bap.Token)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not replace back account in SetBankAccount")
 	}
@@ -578,44 +531,144 @@ func (p *payments) SetBankAccount(user *store.UserMgo, bap *BankAccountParams) (
 	return ba, nil
 }
 
+// AddCredits adds the credits to the user, negative amounts take them away. Tutors
+// are paid the credits with their next payout instead, and debits are taken from it.
+// The reason is the kind of the ledger entry: "refund" credits come out of the platform's
+// revenue, "gift_card" credits out of what was paid for the gift card, the other credits
+// are promotional, and "debit" takes them back to the platform.
 func (p *payments) AddCredits(user *store.UserMgo, creditParams CreditParams) error {
+	if !user.IsTutor() && creditParams.Currency.OrDefault() != store.DefaultCurrency {
+		return errors.Errorf("credits are in %s, not %s", store.DefaultCurrency, creditParams.Currency)
+	}
 
-	// Add credit to stripe account if user is tutor
-	if creditParams.Reason != "debit" && user.IsTutor() {
-		if user.Payments != nil && user.Payments.ConnectID != "" {
-			if _, err := stripe.ChargeCorporateCardCompany(user.Payments.ConnectID, creditParams.Amount, creditParams.Notes, "", ""); err != nil {
-				return errors.Wrap(err, "couldn't charge corporate company card in stripe")
-			}
+	if !user.IsTutor() {
+		if err := incCredits(user, creditParams.Amount); err != nil {
+			return err
 		}
-	} else {
-		var creditsBalance int64
-		if user.Payments != nil {
-			creditsBalance = user.Payments.Credits
+	}
+
+	entry := creditsEntry(user, creditParams).In(creditParams.Currency)
+	entry.Lesson = creditParams.Lesson
+	entry.GiftCard = creditParams.GiftCard
+
+	err := entry.Post()
+	if user.IsTutor() {
+		return errors.Wrap(err, "couldn't create credit transaction for this user")
+	}
+
+	// the student's credits were given, they're kept when they couldn't be posted
+	if err != nil {
+		logger.Get().Errorf("credits of %d were given to %s but couldn't be posted: %v", creditParams.Amount, user.ID.Hex(), err)
+	}
+
+	return nil
+}
+
+// incCredits adds the amount to the credits balance of the user, or takes it off when negative
+func incCredits(user *store.UserMgo, amount int64) error {
+	if amount == 0 {
+		return nil
+	}
+
+	err := store.GetCollection("users").UpdateId(user.ID, bson.M{
+		"$inc": bson.M{
+			"payments.credits": amount,
+		},
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't update credits field for this user")
+	}
+
+	if user.Payments != nil {
+		user.Payments.Credits += amount
+	}
+
+	return nil
+}
+
+// takeCredits takes up to the amount off the credits balance of the user and returns what it
+// took. The balance is read again each time it changed meanwhile, so credits added or spent
+// at the same time aren't lost.
+func takeCredits(user *store.UserMgo, upTo int64) (int64, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		var u store.UserMgo
+		if err := store.GetCollection("users").FindId(user.ID).Select(bson.M{"payments.credits": 1}).One(&u); err != nil {
+			return 0, errors.Wrap(err, "couldn't get credits of this user")
+		}
+
+		var balance int64
+		if u.Payments != nil {
+			balance = u.Payments.Credits
+		}
+
+		if balance <= 0 {
+			return 0, nil
+		}
+
+		taken := upTo
+		if balance < taken {
+			taken = balance
 		}
-		totalCredits := creditsBalance + creditParams.Amount
 
-		err := store.GetCollection("users").UpdateId(user.ID, bson.M{
-			"$set": bson.M{
-				"payments.credits": totalCredits,
-			},
+		err := store.GetCollection("users").Update(bson.M{
+			"_id":              user.ID,
+			"payments.credits": balance,
+		}, bson.M{
+			"$inc": bson.M{"payments.credits": -taken},
 		})
 
+		if err == mgo.ErrNotFound {
+			continue
+		}
+
 		if err != nil {
-			return errors.Wrap(err, "couldn't update credits field for this user")
+			return 0, errors.Wrap(err, "couldn't update credits field for this user")
+		}
+
+		if user.Payments != nil {
+			user.Payments.Credits = balance - taken
 		}
+
+		return taken, nil
 	}
 
-	t := &store.TransactionMgo{
-		User:    user.ID,
-		Amount:  math.Abs(float64(creditParams.Amount) / 100),
-		Details: creditParams.Notes,
-		State:   store.TransactionSent,
-		Status:  creditParams.Reason,
+	return 0, errors.New("credits changed meanwhile, try again")
+}
+
+// creditsEntry is the ledger entry of the credits given to or taken from the user.
+// Tutors' credits are what they're paid, students' what they can spend.
+func creditsEntry(user *store.UserMgo, creditParams CreditParams) *store.LedgerEntry {
+	account := store.AccountStudentCredits
+	if user.IsTutor() {
+		account = store.AccountTutorPayable
 	}
 
-	if _, err := GetTransactions().New(t); err != nil {
-		return errors.Wrap(err, "couldn't create credit transaction for this user")
+	kind := store.LedgerEntryKind(creditParams.Reason)
+	switch kind {
+	case store.EntryCredit, store.EntryDebit, store.EntryRefund, store.EntryReferral, store.EntryGiftCard:
+	default:
+		kind = store.EntryCredit
 	}
 
-	return nil
+	amount := creditParams.Amount
+	if amount < 0 {
+		return store.NewLedgerEntry(kind, creditParams.Notes,
+			store.Debit(account, user.ID, -amount),
+			store.Credit(store.AccountPlatformRevenue, "", -amount),
+		)
+	}
+
+	from := store.AccountPromotionalExpense
+	switch kind {
+	case store.EntryRefund:
+		from = store.AccountPlatformRevenue
+	case store.EntryGiftCard:
+		from = store.AccountGiftCards
+	}
+
+	return store.NewLedgerEntry(kind, creditParams.Notes,
+		store.Debit(from, "", amount),
+		store.Credit(account, user.ID, amount),
+	)
 }
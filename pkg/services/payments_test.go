@@ -1,17 +1,23 @@
 package services
 
 import (
+	"sync"
 	"testing"
 	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/services/models"
+	"gitlab.com/learnt/api/pkg/store"
 )
 
 func TestLessonAmounts(t *testing.T) {
 
-	tutorRate := 10.50 // Dollars per hour
+	tutorRate := int64(1050) // Cents per hour
 	duration := (time.Hour).Minutes()
 
 	// expectedTutor value only work if the duration is 1 hour
-	expectedTutor := int64(tutorRate * 100)                              //1050
+	expectedTutor := tutorRate                                           //1050
 	expectedFee := int64(float64(expectedTutor) * platformFeePercentage) //315
 	expectedStudent := expectedTutor + expectedFee                       //1365
 
@@ -29,3 +35,407 @@ func TestLessonAmounts(t *testing.T) {
 		t.Error("student cost cents didn't match", studentCost, expectedStudent)
 	}
 }
+
+// paymentsStudent saves a student paying with a card on the memory gateway
+func paymentsStudent(t *testing.T, g *MemoryGateway, credits int64) *store.UserMgo {
+	student := &store.UserMgo{
+		ID:       bson.NewObjectId(),
+		Username: "student:" + time.Now().Format(time.RFC3339Nano),
+		Role:     store.RoleStudent,
+		Payments: &store.Payments{CustomerID: memoryCardCustomer(t, g), Credits: credits},
+	}
+
+	if err := student.SaveNew(); err != nil {
+		t.Fatal("could not create needed for test:", err)
+	}
+	return student
+}
+
+func cleanupLedger(t *testing.T, lesson bson.ObjectId) {
+	if _, err := store.GetCollection("ledger_entries").RemoveAll(bson.M{"lesson": lesson}); err != nil {
+		t.Error("could not remove ledger entries:", err)
+	}
+}
+
+func TestChargeForLesson(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	student := paymentsStudent(t, g, 500)
+	defer cleanupUser(t, student)
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+
+	lesson := bson.NewObjectId()
+	defer cleanupLedger(t, lesson)
+
+	// the credits pay part of the lesson and the card the rest, the tutor is paid out later
+	charge, err := GetPayments().ChargeForLesson(student, tutor, 60, "today", lesson.Hex(), false, 1050, store.CurrencyUSD, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if charge.StudentCost != 865 || charge.TutorPay != 1050 || student.Payments.Credits != 0 {
+		t.Errorf("expected 865 charged to the card after the credits, got %+v", charge)
+	}
+
+	charges := g.Charges()
+	if len(charges) != 1 || charges[0].ID != charge.ChargeID || charges[0].Payee != "" || charges[0].Amount != 865 {
+		t.Errorf("expected card charged to the platform, got %+v", charges)
+	}
+
+	entries, err := store.GetLessonLedger(lesson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := store.NewLessonPayment(entries, student.ID)
+	if p.Credits != 500 || p.Cash != 865 || p.TutorPay != 1050 || p.Fee != 315 {
+		t.Errorf("expected lesson posted to the ledger, got %+v", p)
+	}
+}
+
+func TestChargeForLessonDeclinedKeepsCredits(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	student := paymentsStudent(t, g, 500)
+	defer cleanupUser(t, student)
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+
+	lesson := bson.NewObjectId()
+	defer cleanupLedger(t, lesson)
+
+	// credits added since the student was read aren't lost
+	if err := store.GetCollection("users").UpdateId(student.ID, bson.M{"$inc": bson.M{"payments.credits": 300}}); err != nil {
+		t.Fatal(err)
+	}
+
+	g.Decline(student.Payments.CustomerID)
+	if _, err := GetPayments().ChargeForLesson(student, tutor, 60, "today", lesson.Hex(), false, 1050, store.CurrencyUSD, nil, ""); err == nil {
+		t.Fatal("expected declined card to fail the charge")
+	}
+
+	saved, _ := NewUsers().ByID(student.ID)
+	if saved.Payments.Credits != 800 {
+		t.Errorf("expected the credits given back when the card was declined, got %d", saved.Payments.Credits)
+	}
+}
+
+func TestChargeForLessonInTutorCurrency(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	student := paymentsStudent(t, g, 500)
+	defer cleanupUser(t, student)
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+
+	lesson := bson.NewObjectId()
+	defer cleanupLedger(t, lesson)
+
+	// the credits are in dollars, they don't pay a lesson in pounds
+	charge, err := GetPayments().ChargeForLesson(student, tutor, 60, "today", lesson.Hex(), false, 1050, store.CurrencyGBP, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if charge.StudentCost != 1365 || charge.Currency != "gbp" || student.Payments.Credits != 500 {
+		t.Errorf("expected 1365 charged to the card in gbp, got %+v", charge)
+	}
+
+	if charges := g.Charges(); len(charges) != 1 || charges[0].Currency != store.CurrencyGBP {
+		t.Errorf("expected card charged in gbp, got %+v", charges)
+	}
+
+	entries, err := store.GetLessonLedger(lesson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Currency != store.CurrencyGBP {
+		t.Errorf("expected lesson posted in gbp, got %+v", entries)
+	}
+}
+
+func TestChargeForLessonWithPromoCode(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	student := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, student)
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+
+	promo := &store.PromoCode{Code: "five-" + bson.NewObjectId().Hex(), Kind: store.PromoFixed, Amount: 500, MaxPerUser: 1}
+	if err := promo.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := promo.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.GetCollection("promo_codes").RemoveId(promo.ID)
+	defer store.GetCollection("promo_redemptions").RemoveAll(bson.M{"promo": promo.ID})
+
+	lesson, next := bson.NewObjectId(), bson.NewObjectId()
+	defer cleanupLedger(t, lesson)
+	defer cleanupLedger(t, next)
+
+	// the platform pays the discount, the tutor is paid the same
+	charge, err := GetPayments().ChargeForLesson(student, tutor, 60, "today", lesson.Hex(), false, 1050, store.CurrencyUSD, nil, promo.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if charge.StudentCost != 865 || charge.Discount != 500 || charge.PromoCode != promo.Code || charge.TutorPay != 1050 {
+		t.Errorf("expected 500 off the card charge, got %+v", charge)
+	}
+
+	entries, err := store.GetLessonLedger(lesson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := store.NewLessonPayment(entries, student.ID); p.Cash != 865 || p.Discount != 500 || p.TutorPay != 1050 || p.Fee != 315 {
+		t.Errorf("expected discount posted to the ledger, got %+v", p)
+	}
+
+	// the student used the code up, the next lesson is charged in full
+	charge, err = GetPayments().ChargeForLesson(student, tutor, 60, "today", next.Hex(), false, 1050, store.CurrencyUSD, nil, promo.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if charge.StudentCost != 1365 || charge.Discount != 0 {
+		t.Errorf("expected code not to be redeemed twice, got %+v", charge)
+	}
+
+	if redemptions, _ := store.GetPromoRedemptions(bson.M{"promo": promo.ID}); len(redemptions) != 1 || redemptions[0].Amount != 500 {
+		t.Errorf("expected one redemption of 500, got %+v", redemptions)
+	}
+}
+
+func TestPromoCodeRedeemedOncePerStudent(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	student := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, student)
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+
+	promo := &store.PromoCode{Code: "first-" + bson.NewObjectId().Hex(), Kind: store.PromoPercent, Percent: 50, FirstLessonOnly: true}
+	if err := promo.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := promo.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.GetCollection("promo_codes").RemoveId(promo.ID)
+	defer store.GetCollection("promo_redemptions").RemoveAll(bson.M{"promo": promo.ID})
+
+	// two lessons charged at once both pass the first lesson check, one of them gets the discount
+	lessons := []bson.ObjectId{bson.NewObjectId(), bson.NewObjectId()}
+	charges := make([]*models.ChargeData, len(lessons))
+
+	var wg sync.WaitGroup
+	for i, lesson := range lessons {
+		defer cleanupLedger(t, lesson)
+
+		wg.Add(1)
+		go func(i int, lesson bson.ObjectId) {
+			defer wg.Done()
+			charge, err := GetPayments().ChargeForLesson(student, tutor, 60, "today", lesson.Hex(), false, 1050, store.CurrencyUSD, nil, promo.Code)
+			if err != nil {
+				t.Error(err)
+			}
+			charges[i] = charge
+		}(i, lesson)
+	}
+	wg.Wait()
+
+	var discounted, refused int
+	for _, charge := range charges {
+		if charge != nil && charge.Discount > 0 {
+			discounted++
+		}
+		if charge != nil && charge.PromoCodeError != "" {
+			refused++
+		}
+	}
+
+	if discounted != 1 || refused != 1 {
+		t.Errorf("expected one lesson discounted and the other told why it wasn't, got %+v", charges)
+	}
+}
+
+func TestChargeForLessonCapturesHold(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	student := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, student)
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+
+	lesson := bson.NewObjectId()
+	defer cleanupLedger(t, lesson)
+
+	id, err := g.AuthorizePlatform(student.Payments.CustomerID, 1365, store.CurrencyUSD, "Lesson", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hold := &models.ChargeData{AuthorizationID: id, AuthorizationStatus: models.AuthorizationHeld, AuthorizedAmount: 1365, AuthorizedByPlatform: true}
+
+	// the lesson ended early, the hold is captured for 45 minutes
+	charge, err := GetPayments().ChargeForLesson(student, tutor, 45, "today", lesson.Hex(), false, 1050, store.CurrencyUSD, hold, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if charge.ChargeID != id || charge.AuthorizationStatus != models.AuthorizationCaptured || len(g.Charges()) != 1 {
+		t.Errorf("expected hold captured instead of a new charge, got %+v", charge)
+	}
+
+	if ch, _ := g.GetCharge(id); ch.AmountCaptured != 1024 || ch.Refunded != 341 {
+		t.Errorf("expected 1024 captured and the rest released, got %+v", ch)
+	}
+
+	entries, err := store.GetLessonLedger(lesson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || len(entries[0].Stripe) != 2 {
+		t.Errorf("expected lesson entry to keep the charge and the release, got %+v", entries)
+	}
+}
+
+func TestChargeForLessonReleasesTutorHold(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	student := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, student)
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+
+	lesson := bson.NewObjectId()
+	defer cleanupLedger(t, lesson)
+
+	// a hold placed when tutors were paid at charge time would pay them twice
+	id, err := g.AuthorizeCharge(student.Payments.CustomerID, tutor.Payments.ConnectID, 1365, 315, "Lesson", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hold := &models.ChargeData{AuthorizationID: id, AuthorizationStatus: models.AuthorizationHeld, AuthorizedAmount: 1365}
+
+	charge, err := GetPayments().ChargeForLesson(student, tutor, 60, "today", lesson.Hex(), false, 1050, store.CurrencyUSD, hold, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ch, _ := g.GetCharge(id); ch.AmountCaptured != 0 || ch.Refunded != 1365 {
+		t.Errorf("expected tutor's hold released, got %+v", ch)
+	}
+
+	if ch, _ := g.GetCharge(charge.ChargeID); ch.Payee != "" || ch.AmountCaptured != 1365 {
+		t.Errorf("expected card charged to the platform instead, got %+v", ch)
+	}
+}
+
+func TestCreditForReferral(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	student := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, student)
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+
+	lesson := bson.NewObjectId()
+	defer cleanupLedger(t, lesson)
+
+	// tutors are paid it with their next payout, students get credits
+	if err := GetPayments().CreditForReferral(tutor, 10, lesson, "Referral"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := GetPayments().CreditForReferral(student, 10, lesson, "Referral"); err != nil {
+		t.Fatal(err)
+	}
+
+	if charges := g.Charges(); len(charges) != 0 {
+		t.Errorf("expected nothing paid before the payout, got %+v", charges)
+	}
+
+	if student.Payments.Credits != 1000 {
+		t.Errorf("expected student credited 1000, got %d", student.Payments.Credits)
+	}
+
+	entries, err := store.GetLessonLedger(lesson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Errorf("expected both referrals posted to the ledger, got %d entries", len(entries))
+	}
+}
+
+func TestAddCreditsInLessonCurrency(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	student := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, student)
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+
+	lesson := bson.NewObjectId()
+	defer cleanupLedger(t, lesson)
+
+	// the penalty of a tutor paid in dollars canadian is taken from their earnings in them
+	if err := GetPayments().AddCredits(tutor, CreditParams{Amount: -300, Reason: "debit", Lesson: &lesson, Currency: store.CurrencyCAD}); err != nil {
+		t.Fatal(err)
+	}
+
+	// students' credits are only in the default currency
+	if err := GetPayments().AddCredits(student, CreditParams{Amount: 300, Reason: "refund", Lesson: &lesson, Currency: store.CurrencyGBP}); err == nil {
+		t.Error("expected credits in gbp refused")
+	}
+
+	if student.Payments.Credits != 0 {
+		t.Errorf("expected no credits given, got %d", student.Payments.Credits)
+	}
+
+	entries, err := store.GetLessonLedger(lesson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Currency != store.CurrencyCAD {
+		t.Errorf("expected the penalty posted in cad, got %+v", entries)
+	}
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/config"
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/notifications"
+	"gitlab.com/learnt/api/pkg/services/stripe"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+// payoutSlack keeps a late scheduled batch from pushing the next one a whole interval back
+const payoutSlack = 24 * time.Hour
+
+// payoutStuckAfter is how long a batch can be paying before it's taken as stopped midway and
+// can be retried. Its transfers keep their keys, the ones that went through aren't sent again.
+const payoutStuckAfter = time.Hour
+
+type payouts struct{}
+
+// GetPayouts returns the struct paying the tutors what they earned in batches
+func GetPayouts() *payouts {
+	return &payouts{}
+}
+
+// payoutSettings returns the least a tutor is paid out, in minor units of any currency, and when
+// the earnings paid out now were made by: the ones made during the hold period wait for the next batch
+func payoutSettings(now time.Time) (minimum int64, cutoff time.Time, err error) {
+	cfg := config.GetConfig().Payments
+
+	hold, err := cfg.ParsePayoutHold()
+	if err != nil {
+		return 0, now, errors.Wrap(err, "invalid payout hold")
+	}
+
+	return int64(cfg.PayoutMinimum) * 100, now.Add(-hold), nil
+}
+
+// Preview returns the batch that would be made now, without claiming the earnings it pays
+func (p *payouts) Preview() (*store.PayoutBatch, error) {
+	batch, _, err := p.preview(time.Now())
+	return batch, err
+}
+
+func (p *payouts) preview(now time.Time) (*store.PayoutBatch, int64, error) {
+	minimum, cutoff, err := payoutSettings(now)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	earnings, err := store.GetPayableEarnings(cutoff)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	batch := &store.PayoutBatch{
+		Status:  store.PayoutBatchPending,
+		Cutoff:  cutoff,
+		Payouts: make([]store.TutorPayout, 0, len(earnings)),
+	}
+
+	for _, e := range earnings {
+		if e.Amount == 0 {
+			continue
+		}
+		batch.Payouts = append(batch.Payouts, newTutorPayout(e, minimum))
+	}
+
+	return batch, minimum, nil
+}
+
+// newTutorPayout is the payout of the tutor's earnings, skipped when it can't be paid yet
+func newTutorPayout(e store.TutorEarnings, minimum int64) store.TutorPayout {
+	payout := store.TutorPayout{Tutor: e.Tutor, Amount: e.Amount, Currency: e.Currency.OrDefault(), Status: store.PayoutSkipped}
+
+	switch {
+	case e.Amount < 0:
+		payout.Error = "tutor owes more than they earned"
+	case e.Amount < minimum:
+		payout.Error = fmt.Sprintf("below the %s minimum", payout.Currency.Format(minimum))
+	default:
+		tutor, ok := NewUsers().ByID(e.Tutor)
+		if !ok || tutor.Payments == nil || tutor.Payments.ConnectID == "" {
+			payout.Error = "tutor has no connect account"
+			break
+		}
+		payout.Status = store.PayoutPending
+	}
+
+	return payout
+}
+
+// CreateBatch creates the batch paying the tutors what they earned until the hold period,
+// for an admin to approve. Its earnings are claimed, no later batch pays them again. There's
+// one pending batch at a time.
+func (p *payouts) CreateBatch() (*store.PayoutBatch, error) {
+	pending, err := store.GetPayoutBatches(store.PayoutBatchPending, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pending) > 0 {
+		return nil, errors.Errorf("payout batch %s is waiting for approval", pending[0].ID.Hex())
+	}
+
+	batch, minimum, err := p.preview(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := batch.Insert(); err != nil {
+		return nil, err
+	}
+
+	for i := range batch.Payouts {
+		payout := &batch.Payouts[i]
+		if payout.Status != store.PayoutPending {
+			continue
+		}
+
+		// the earnings may have changed since they were summed, the batch pays what it claimed
+		amount, err := store.ClaimEarnings(payout.Tutor, payout.Currency, batch.Cutoff, batch.ID)
+		payout.Amount = amount
+
+		switch {
+		case err != nil:
+			logger.Get().Errorf("couldn't claim earnings of tutor %s for payout batch %s: %v", payout.Tutor.Hex(), batch.ID.Hex(), err)
+			payout.Status = store.PayoutSkipped
+			payout.Error = "couldn't claim the earnings"
+		case amount < minimum || amount <= 0:
+			payout.Status = store.PayoutSkipped
+			payout.Error = fmt.Sprintf("below the %s minimum", payout.Currency.Format(minimum))
+		default:
+			continue
+		}
+
+		if err := store.ReleaseEarnings(payout.Tutor, payout.Currency, batch.ID); err != nil {
+			logger.Get().Errorf("couldn't release earnings of tutor %s from payout batch %s: %v", payout.Tutor.Hex(), batch.ID.Hex(), err)
+		}
+	}
+
+	return batch, batch.Save()
+}
+
+// Approve pays the tutors of the pending batch, one transfer each
+func (p *payouts) Approve(id bson.ObjectId, admin *store.UserMgo) (*store.PayoutBatch, error) {
+	batch, ok := store.GetPayoutBatch(id)
+	if !ok {
+		return nil, errors.New("payout batch not found")
+	}
+
+	if batch.Status != store.PayoutBatchPending {
+		return nil, errors.Errorf("batch is %s, only pending batches can be approved", batch.Status)
+	}
+
+	now := time.Now()
+	batch.ApprovedBy = &admin.ID
+	batch.ApprovedAt = &now
+
+	if err := batch.Claim(store.PayoutBatchPending, now); err != nil {
+		return nil, err
+	}
+
+	return batch, p.pay(batch)
+}
+
+// Retry pays the payouts of the batch that failed again, or of the batch that stopped
+// midway through being paid
+func (p *payouts) Retry(id bson.ObjectId) (*store.PayoutBatch, error) {
+	batch, ok := store.GetPayoutBatch(id)
+	if !ok {
+		return nil, errors.New("payout batch not found")
+	}
+
+	if batch.Status != store.PayoutBatchFailed && batch.Status != store.PayoutBatchPaying {
+		return nil, errors.Errorf("batch is %s, only failed batches can be retried", batch.Status)
+	}
+
+	if err := batch.Claim(batch.Status, time.Now().Add(-payoutStuckAfter)); err != nil {
+		return nil, err
+	}
+
+	for i := range batch.Payouts {
+		if batch.Payouts[i].Status == store.PayoutFailed {
+			batch.Payouts[i].Status = store.PayoutPending
+		}
+	}
+
+	return batch, p.pay(batch)
+}
+
+// pay transfers the pending payouts of the batch. The batch is saved after each of them,
+// so a transfer that went through isn't made again.
+func (p *payouts) pay(batch *store.PayoutBatch) error {
+	for i := range batch.Payouts {
+		payout := &batch.Payouts[i]
+		if payout.Status != store.PayoutPending {
+			continue
+		}
+
+		if err := p.payTutor(batch, payout); err != nil {
+			logger.Get().Errorf("couldn't pay out tutor %s in batch %s: %v", payout.Tutor.Hex(), batch.ID.Hex(), err)
+		}
+
+		if err := batch.Save(); err != nil {
+			return err
+		}
+	}
+
+	batch.SetStatus()
+	return batch.Save()
+}
+
+// payTutor transfers the payout to the tutor's connect account and posts it to the ledger
+func (p *payouts) payTutor(batch *store.PayoutBatch, payout *store.TutorPayout) error {
+	payout.Attempts++
+	payout.Status = store.PayoutFailed
+
+	tutor, ok := NewUsers().ByID(payout.Tutor)
+	if !ok {
+		payout.Error = "tutor not found"
+		return errors.New(payout.Error)
+	}
+
+	if tutor.Payments == nil || tutor.Payments.ConnectID == "" {
+		payout.Error = "tutor has no connect account"
+		return errors.New(payout.Error)
+	}
+
+	description := fmt.Sprintf("Learnt payout to %s of the earnings until %s", tutor.Name(), batch.Cutoff.Format("Jan 2, 2006"))
+	metadata := map[string]string{"batch": batch.ID.Hex(), "tutor": tutor.ID.Hex()}
+
+	// the key is kept until a transfer definitely fails, a retry of a transfer that may
+	// have gone through gets it back. Stripe answers a failed one's key with its failure.
+	key := fmt.Sprintf("payout_%s_%s_%s", batch.ID.Hex(), tutor.ID.Hex(), payout.Currency)
+	if payout.Failures > 0 {
+		key = fmt.Sprintf("%s_%d", key, payout.Failures)
+	}
+
+	transferID, err := gateway.Transfer(tutor.Payments.ConnectID, payout.Amount, payout.Currency, description, "payout_"+batch.ID.Hex(), key, metadata)
+	if err != nil {
+		if stripe.IsFailed(err) {
+			payout.Failures++
+		}
+		payout.Error = err.Error()
+		return err
+	}
+
+	now := time.Now()
+	payout.Status = store.PayoutPaid
+	payout.Error = ""
+	payout.TransferID = transferID
+	payout.PaidAt = &now
+
+	// a transfer sent before paying the batch stopped was posted already
+	if posted, ok := store.GetLedgerEntryByStripe(transferID); ok {
+		payout.Entry = &posted.ID
+		return nil
+	}
+
+	// the payout settles the lines the batch claimed
+	entry := store.NewLedgerEntry(store.EntryPayout, description,
+		store.Debit(store.AccountTutorPayable, tutor.ID, payout.Amount).Settled(),
+		store.Credit(store.AccountTutorPayouts, "", payout.Amount),
+	).In(payout.Currency).WithStripe(transferID)
+
+	if err := entry.Post(); err != nil {
+		logger.Get().Errorf("tutor %s was paid out %s in batch %s but it couldn't be posted: %v", tutor.ID.Hex(), transferID, batch.ID.Hex(), err)
+	} else {
+		payout.Entry = &entry.ID
+	}
+
+	notifications.Notify(&notifications.NotifyRequest{
+		User:    tutor.ID,
+		Type:    notifications.PayoutPaid,
+		Title:   "Payout sent",
+		Message: fmt.Sprintf("We sent you %s for your earnings until %s.", payout.Currency.Format(payout.Amount), batch.Cutoff.Format("Jan 2")),
+		Data:    map[string]interface{}{"batch": batch.ID, "payout": payout},
+	})
+
+	return nil
+}
+
+// RunSchedule creates the batch when the last one is a payout interval old. It waits for
+// an admin to approve it.
+func (p *payouts) RunSchedule() {
+	interval := config.GetConfig().Payments.PayoutInterval()
+	if last, ok := store.GetLastPayoutBatch(); ok && time.Since(last.CreatedAt) < interval-payoutSlack {
+		return
+	}
+
+	batch, err := p.CreateBatch()
+	if err != nil {
+		logger.Get().Errorf("couldn't create scheduled payout batch: %v", err)
+		return
+	}
+
+	logger.Get().Infof("payout batch %s of %v is waiting for approval", batch.ID.Hex(), batch.Totals())
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+func TestNewTutorPayout(t *testing.T) {
+	tutor := bson.NewObjectId()
+
+	if payout := newTutorPayout(store.TutorEarnings{Tutor: tutor, Amount: -500}, 2500); payout.Status != store.PayoutSkipped {
+		t.Errorf("expected tutor owing the platform skipped, got %+v", payout)
+	}
+
+	if payout := newTutorPayout(store.TutorEarnings{Tutor: tutor, Amount: 2499, Currency: store.CurrencyGBP}, 2500); payout.Status != store.PayoutSkipped || payout.Error != "below the £25.00 minimum" {
+		t.Errorf("expected payout below the minimum skipped, got %+v", payout)
+	}
+}
+
+// postEarnings posts what the tutor earned at the time
+func postEarnings(t *testing.T, tutor bson.ObjectId, amount int64, at time.Time) *store.LedgerEntry {
+	entry := store.NewLedgerEntry(store.EntryCredit, "Earnings",
+		store.Debit(store.AccountPromotionalExpense, "", amount),
+		store.Credit(store.AccountTutorPayable, tutor, amount),
+	)
+
+	if err := entry.Post(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.GetCollection("ledger_entries").UpdateId(entry.ID, bson.M{"$set": bson.M{"created_at": at}}); err != nil {
+		t.Fatal(err)
+	}
+
+	return entry
+}
+
+func TestPayoutBatch(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+	if err := tutor.SaveNew(); err != nil {
+		t.Fatal("could not create needed for test:", err)
+	}
+	defer cleanupUser(t, tutor)
+	defer store.GetCollection("ledger_entries").RemoveAll(bson.M{"lines.owner": tutor.ID})
+
+	// the earnings of the last days are held for the next batch
+	postEarnings(t, tutor.ID, 6000, time.Now().Add(-30*24*time.Hour))
+	postEarnings(t, tutor.ID, 1050, time.Now())
+
+	batch, err := GetPayouts().CreateBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.GetCollection("payout_batches").RemoveId(batch.ID)
+	defer store.GetCollection("payout_claims").RemoveAll(bson.M{"batch": batch.ID})
+
+	payout := func(batch *store.PayoutBatch) *store.TutorPayout {
+		for i := range batch.Payouts {
+			if batch.Payouts[i].Tutor == tutor.ID {
+				return &batch.Payouts[i]
+			}
+		}
+		t.Fatalf("expected tutor in batch %+v", batch)
+		return nil
+	}
+
+	if p := payout(batch); p.Amount != 6000 || p.Status != store.PayoutPending {
+		t.Errorf("expected 6000 to be paid out, got %+v", p)
+	}
+
+	// the batch claims the line apart from the posted entry, which isn't changed
+	if n, _ := store.GetCollection("payout_claims").Find(bson.M{"batch": batch.ID}).Count(); n != 1 {
+		t.Errorf("expected the earned line claimed, got %d claims", n)
+	}
+
+	if _, err := GetPayouts().CreateBatch(); err == nil {
+		t.Error("expected one pending batch at a time")
+	}
+
+	// the transfer fails until the tutor's account can be paid again
+	g.SetPayoutsEnabled(tutor.Payments.ConnectID, false)
+	admin := &store.UserMgo{ID: bson.NewObjectId(), Role: store.RoleAdmin}
+	batch, err = GetPayouts().Approve(batch.ID, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the transfer definitely failed, the retry is sent with a new key
+	if p := payout(batch); batch.Status != store.PayoutBatchFailed || p.Status != store.PayoutFailed || p.Failures != 1 {
+		t.Errorf("expected payout to fail, got %s %+v", batch.Status, p)
+	}
+
+	g.SetPayoutsEnabled(tutor.Payments.ConnectID, true)
+	batch, err = GetPayouts().Retry(batch.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := payout(batch)
+	if p.Status != store.PayoutPaid || p.Attempts != 2 || p.Entry == nil {
+		t.Errorf("expected payout paid on retry, got %+v", p)
+	}
+
+	if transfers := g.Transfers(); len(transfers) != 1 || transfers[0].ID != p.TransferID || transfers[0].Amount != 6000 {
+		t.Errorf("expected one transfer of 6000, got %+v", transfers)
+	}
+
+	balances, err := store.GetLedgerBalances(tutor.ID, store.DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balances[store.AccountTutorPayable] != 1050 {
+		t.Errorf("expected the held earnings left to pay, got %d", balances[store.AccountTutorPayable])
+	}
+}
+
+func TestPayoutBatchPaidOnce(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+	if err := tutor.SaveNew(); err != nil {
+		t.Fatal("could not create needed for test:", err)
+	}
+	defer cleanupUser(t, tutor)
+	defer store.GetCollection("ledger_entries").RemoveAll(bson.M{"lines.owner": tutor.ID})
+
+	postEarnings(t, tutor.ID, 6000, time.Now().Add(-30*24*time.Hour))
+
+	batch, err := GetPayouts().CreateBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.GetCollection("payout_batches").RemoveId(batch.ID)
+	defer store.GetCollection("payout_claims").RemoveAll(bson.M{"batch": batch.ID})
+
+	// the same batch approved twice at once is paid by one of the approvals
+	admin := &store.UserMgo{ID: bson.NewObjectId(), Role: store.RoleAdmin}
+	errs := make([]error, 2)
+
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = GetPayouts().Approve(batch.ID, admin)
+		}(i)
+	}
+	wg.Wait()
+
+	if (errs[0] == nil) == (errs[1] == nil) {
+		t.Errorf("expected one approval to fail, got %v", errs)
+	}
+
+	if transfers := g.Transfers(); len(transfers) != 1 {
+		t.Fatalf("expected one transfer, got %+v", transfers)
+	}
+
+	// paying stopped after the transfer went through and before the batch was saved
+	paid, _ := store.GetPayoutBatch(batch.ID)
+	stuck := time.Now().Add(-2 * payoutStuckAfter)
+	for i := range paid.Payouts {
+		if paid.Payouts[i].Tutor == tutor.ID {
+			paid.Payouts[i].Status = store.PayoutPending
+		}
+	}
+	if err := store.GetCollection("payout_batches").UpdateId(batch.ID, bson.M{"$set": bson.M{"status": store.PayoutBatchPaying, "paying_at": stuck, "payouts": paid.Payouts}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetPayouts().Retry(batch.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if transfers := g.Transfers(); len(transfers) != 1 {
+		t.Errorf("expected the retry not to transfer again, got %+v", transfers)
+	}
+
+	if n, _ := store.GetCollection("ledger_entries").Find(bson.M{"kind": store.EntryPayout, "lines.owner": tutor.ID}).Count(); n != 1 {
+		t.Errorf("expected the payout posted once, got %d", n)
+	}
+}
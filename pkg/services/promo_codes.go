@@ -0,0 +1,124 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/notifications"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+// promoUse is the student's lesson with the tutor, to check promo codes against
+func promoUse(student, tutor, subject bson.ObjectId, currency store.Currency) (store.PromoUse, error) {
+	paid, err := store.HasPaidLessons(student)
+	if err != nil {
+		return store.PromoUse{}, err
+	}
+
+	return store.PromoUse{
+		Student:     student,
+		Tutor:       tutor,
+		Subject:     subject,
+		Currency:    currency.OrDefault(),
+		FirstLesson: !paid,
+	}, nil
+}
+
+// CheckPromoCode gets the promo code when the student can book a lesson of the subject
+// with the tutor with it. It's checked again when the lesson is charged.
+func (p *payments) CheckPromoCode(code string, student, tutor *store.UserMgo, subject bson.ObjectId) (*store.PromoCode, error) {
+	promo, ok := store.GetPromoCode(code)
+	if !ok {
+		return nil, errors.New("promo code doesn't exist")
+	}
+
+	currency := store.DefaultCurrency
+	if tutor.Tutoring != nil {
+		currency = tutor.Tutoring.Currency.OrDefault()
+	}
+
+	use, err := promoUse(student.ID, tutor.ID, subject, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := promo.Check(use, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return promo, nil
+}
+
+// redeemPromoCode redeems the student's promo code for its discount off the cost of the lesson
+func (p *payments) redeemPromoCode(code string, student, tutor *store.UserMgo, lessonID string, cost int64, currency store.Currency) (*store.PromoRedemption, error) {
+	promo, ok := store.GetPromoCode(code)
+	if !ok {
+		return nil, errors.Errorf("promo code %s doesn't exist", code)
+	}
+
+	var lesson *bson.ObjectId
+	var subject bson.ObjectId
+	if bson.IsObjectIdHex(lessonID) {
+		id := bson.ObjectIdHex(lessonID)
+		lesson = &id
+		if l, exists := store.GetLessonsStore().Get(id); exists {
+			subject = l.Subject
+		}
+	}
+
+	use, err := promoUse(student.ID, tutor.ID, subject, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := promo.Check(use, time.Now()); err != nil {
+		return nil, err
+	}
+
+	discount := promo.Discount(cost)
+	if discount <= 0 {
+		return nil, errors.New("promo code takes nothing off the lesson")
+	}
+
+	return promo.Redeem(student.ID, lesson, discount, currency)
+}
+
+// releasePromoCodes gives back the promo codes the student redeemed for the lesson
+func releasePromoCodes(lesson, student bson.ObjectId) {
+	redemptions, err := store.GetPromoRedemptions(bson.M{"lesson": lesson, "user": student})
+	if err != nil {
+		logger.Get().Errorf("couldn't get promo codes of lesson (%s): %v", lesson.Hex(), err)
+		return
+	}
+
+	for i := range redemptions {
+		releaseRedemption(&redemptions[i], lesson.Hex())
+	}
+}
+
+// releaseRedemption gives the promo code back when the lesson couldn't be charged
+func releaseRedemption(redemption *store.PromoRedemption, lessonID string) {
+	if redemption == nil {
+		return
+	}
+
+	if err := redemption.Release(); err != nil {
+		logger.Get().Errorf("couldn't release promo code %s of lesson (%s): %v", redemption.Code, lessonID, err)
+	}
+}
+
+// notifyPromoCodeNotApplied tells the student the promo code they booked the lesson with
+// couldn't be redeemed when it was charged
+func notifyPromoCodeNotApplied(student, tutor *store.UserMgo, code, startDateTime, lessonID string, err error) {
+	notifications.Notify(&notifications.NotifyRequest{
+		User:    student.ID,
+		Type:    notifications.PromoCodeNotApplied,
+		Title:   "Promo code not applied",
+		Message: fmt.Sprintf("Your promo code %s couldn't be applied to the lesson with %s at %s, it was charged in full: %v.", code, tutor.GetFirstName(), startDateTime, err),
+		Data:    map[string]interface{}{"lesson": lessonID, "promo_code": code},
+	})
+}
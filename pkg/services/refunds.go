@@ -0,0 +1,352 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/notifications"
+	"gitlab.com/learnt/api/pkg/services/stripe"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+// RefundParams are the params of a refund of what a student paid for a lesson
+type RefundParams struct {
+	Student bson.ObjectId `json:"student" binding:"required"`
+	// Amount is in cents. Zero refunds all that's left.
+	Amount int64 `json:"amount"`
+	// AsCredits refunds to the student's credits instead of their card
+	AsCredits bool   `json:"as_credits"`
+	Reason    string `json:"reason"`
+}
+
+// LessonRefund is a refund made of a lesson's charge. Amounts are in cents.
+type LessonRefund struct {
+	Entry   bson.ObjectId `json:"entry"`
+	Lesson  bson.ObjectId `json:"lesson"`
+	Student bson.ObjectId `json:"student"`
+	Amount  int64         `json:"amount"`
+	// Card, Packages and Credits are how the amount was refunded: to the card the lesson was
+	// charged to, to the cards its packages were bought with and as credits
+	Card     int64 `json:"card"`
+	Packages int64 `json:"packages"`
+	Credits  int64 `json:"credits"`
+	// TutorReversed and FeeReversed are what was taken back from the tutor and the platform
+	TutorReversed int64 `json:"tutor_reversed"`
+	FeeReversed   int64 `json:"fee_reversed"`
+	// DiscountReversed is what the platform got back of the promo code's discount
+	DiscountReversed int64    `json:"discount_reversed"`
+	Stripe           []string `json:"stripe,omitempty"`
+}
+
+// refundStuckAfter is how long a refund can run before it's taken as stopped midway, and
+// the lesson can be refunded again
+const refundStuckAfter = time.Hour
+
+// packageRefund is a part of a lesson refund that goes back to the card a package was bought with
+type packageRefund struct {
+	pkg    *store.LessonPackage
+	amount int64
+	tutor  int64
+}
+
+// packagesLeft is what's left to refund of the minutes drawn from each of the student's
+// packages for the lesson, in the order they were drawn
+func packagesLeft(entries []store.LedgerEntry, student bson.ObjectId) ([]bson.ObjectId, map[bson.ObjectId]int64) {
+	var order []bson.ObjectId
+	left := make(map[bson.ObjectId]int64)
+
+	for _, entry := range entries {
+		if entry.Package == nil {
+			continue
+		}
+
+		for _, line := range entry.Lines {
+			if line.Owner == nil || *line.Owner != student {
+				continue
+			}
+
+			switch {
+			case entry.Kind == store.EntryPackage && line.Account == store.AccountStudentPrepaid:
+				if _, ok := left[*entry.Package]; !ok {
+					order = append(order, *entry.Package)
+				}
+				left[*entry.Package] += line.Amount
+			case entry.Kind == store.EntryRefund && line.Amount < 0:
+				left[*entry.Package] += line.Amount
+			}
+		}
+	}
+
+	return order, left
+}
+
+// RefundLesson refunds what the student paid for the lesson, all of it or part. The
+// refund goes to the card the lesson was charged to, then to the cards the packages it was
+// drawn from were bought with, and what the cards didn't pay goes back as credits, unless
+// it's all asked as credits. The tutor's pay and the platform's fee are taken back in the
+// proportions the lesson was paid in. One refund of the student's payment runs at a time.
+func (p *payments) RefundLesson(lesson *store.LessonMgo, admin *store.UserMgo, params RefundParams) (*LessonRefund, error) {
+	if params.Student == lesson.Tutor || !lesson.HasUserID(params.Student) {
+		return nil, errors.New("student isn't in the lesson")
+	}
+
+	return p.refundStudent(lesson, params)
+}
+
+// refundStudent refunds what the student paid for the lesson, whether they're still in it
+// or left it. Only what the ledger holds they paid can be refunded.
+func (p *payments) refundStudent(lesson *store.LessonMgo, params RefundParams) (refund *LessonRefund, err error) {
+	if params.Student == lesson.Tutor {
+		return nil, errors.New("the tutor didn't pay for the lesson")
+	}
+
+	student, ok := NewUsers().ByID(params.Student)
+	if !ok {
+		return nil, errors.New("student not found")
+	}
+
+	tutor, ok := NewUsers().ByID(lesson.Tutor)
+	if !ok {
+		return nil, errors.New("tutor not found")
+	}
+
+	if err := lesson.ClaimRefund(student.ID, time.Now().Add(-refundStuckAfter)); err != nil {
+		return nil, err
+	}
+
+	// a refund that moved money but couldn't be saved is held, so it isn't refunded again
+	// before someone reconciles it
+	moved := false
+	defer func() {
+		if moved && err != nil {
+			logger.Get().Errorf("lesson %s was refunded but it couldn't be saved, it's held: %v", lesson.ID.Hex(), err)
+			if err := lesson.HoldRefund(student.ID); err != nil {
+				logger.Get().Errorf("couldn't hold refund of lesson %s: %v", lesson.ID.Hex(), err)
+			}
+			return
+		}
+		if err := lesson.ReleaseRefund(student.ID); err != nil {
+			logger.Get().Errorf("couldn't release refund of lesson %s: %v", lesson.ID.Hex(), err)
+		}
+	}()
+
+	// the ledger is read once the refund is claimed, no other refund changes it meanwhile
+	entries, err := store.GetLessonLedger(lesson.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	paid := store.NewLessonPayment(entries, student.ID)
+
+	amount := params.Amount
+	if amount == 0 {
+		amount = paid.Refundable()
+	}
+
+	switch {
+	case paid.Refundable() <= 0:
+		return nil, errors.New("nothing left to refund")
+	case amount < 0:
+		return nil, errors.New("amount can't be negative")
+	case amount > paid.Refundable():
+		return nil, errors.Errorf("only %s is left to refund", lesson.Currency.OrDefault().Format(paid.Refundable()))
+	}
+
+	refund = &LessonRefund{Lesson: lesson.ID, Student: student.ID, Amount: amount}
+	refund.TutorReversed, refund.FeeReversed, refund.DiscountReversed = paid.Split(amount)
+
+	rest := amount
+	var packages []packageRefund
+
+	if !params.AsCredits {
+		refund.Card = paid.Cash - paid.CashRefunded
+		if refund.Card > rest {
+			refund.Card = rest
+		}
+		rest -= refund.Card
+
+		order, left := packagesLeft(entries, student.ID)
+		for _, id := range order {
+			if rest == 0 {
+				break
+			}
+
+			part := left[id]
+			if part > rest {
+				part = rest
+			}
+			if part <= 0 {
+				continue
+			}
+
+			pkg, ok := store.GetLessonPackage(id)
+			if !ok || pkg.ChargeID == "" {
+				continue
+			}
+
+			// the package's part of the tutor's pay and the fee is in the proportion of what it refunds
+			share := int64(math.Round(float64(refund.TutorReversed) * float64(part) / float64(amount)))
+			packages = append(packages, packageRefund{pkg: pkg, amount: part, tutor: share})
+			rest -= part
+		}
+	}
+	refund.Credits = rest
+
+	// credits are in the default currency, lessons in others are only refunded to the card
+	if refund.Credits > 0 && lesson.Currency.OrDefault() != store.DefaultCurrency {
+		return nil, errors.Errorf("lessons in %s can only be refunded to the card they were paid with", lesson.Currency)
+	}
+
+	charge := lesson.Charge
+	if c, ok := lesson.Charges[student.ID.Hex()]; ok {
+		charge = c
+	}
+
+	description := fmt.Sprintf("Refund for lesson with %s on %s", tutor.Name(), lesson.WhenFormatted())
+	if reason := strings.TrimSpace(params.Reason); reason != "" {
+		description = fmt.Sprintf("%s: %s", description, reason)
+	}
+
+	if refund.Card > 0 && (charge == nil || charge.ChargeID == "") {
+		return nil, errors.New("lesson has no card charge to refund")
+	}
+
+	metadata := map[string]string{"lessonID": lesson.ID.Hex(), stripe.MetadataRecorded: "true"}
+
+	var lessonStripe []string
+	if refund.Card > 0 {
+		refundID, err := gateway.RefundCharge(charge.ChargeID, refund.Card, metadata)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't refund the card charge")
+		}
+		moved = true
+		lessonStripe = append(lessonStripe, refundID)
+	}
+
+	var posting []*store.LedgerEntry
+	tutorLeft, feeLeft := refund.TutorReversed, refund.FeeReversed
+
+	for _, part := range packages {
+		// the part that couldn't be refunded isn't, what was refunded so far is still posted
+		refundID, err := gateway.RefundCharge(part.pkg.ChargeID, part.amount, metadata)
+		if err != nil {
+			logger.Get().Errorf("couldn't refund package %s for lesson %s: %v", part.pkg.ID.Hex(), lesson.ID.Hex(), err)
+			refund.Amount -= part.amount
+			refund.TutorReversed -= part.tutor
+			refund.FeeReversed -= part.amount - part.tutor
+			tutorLeft -= part.tutor
+			feeLeft -= part.amount - part.tutor
+			continue
+		}
+		moved = true
+
+		entry := store.NewLedgerEntry(store.EntryRefund, description,
+			store.Debit(store.AccountTutorPayable, tutor.ID, part.tutor),
+			store.Debit(store.AccountPlatformRevenue, "", part.amount-part.tutor),
+			store.Credit(store.AccountStudentCash, student.ID, part.amount),
+		).In(lesson.Currency).WithStripe(refundID)
+		entry.Lesson = &lesson.ID
+		entry.Package = &part.pkg.ID
+		posting = append(posting, entry)
+
+		refund.Stripe = append(refund.Stripe, refundID)
+		refund.Packages += part.amount
+		tutorLeft -= part.tutor
+		feeLeft -= part.amount - part.tutor
+	}
+
+	// the tutor's share is taken back from what they were paid for the lesson when they were
+	// paid at charge time. What the transfers don't have left is taken from their next payout.
+	var reversed int64
+	if charge != nil && tutorLeft > 0 {
+		left := tutorLeft
+		for _, id := range []string{charge.ChargeID, charge.TransferID} {
+			if id == "" || left == 0 {
+				continue
+			}
+
+			reversalID, took, err := gateway.ReverseChargeTransfer(id, left, description)
+			if err != nil {
+				logger.Get().Errorf("couldn't reverse tutor transfer of %s for refund of lesson %s: %v", id, lesson.ID.Hex(), err)
+				continue
+			}
+
+			if reversalID != "" {
+				moved = true
+				lessonStripe = append(lessonStripe, reversalID)
+			}
+			left -= took
+		}
+		reversed = tutorLeft - left
+	}
+	refund.Stripe = append(lessonStripe, refund.Stripe...)
+
+	entry := store.NewLedgerEntry(store.EntryRefund, description,
+		store.Debit(store.AccountTutorPayable, tutor.ID, reversed).Settled(),
+		store.Debit(store.AccountTutorPayable, tutor.ID, tutorLeft-reversed),
+		store.Debit(store.AccountPlatformRevenue, "", feeLeft),
+		store.Credit(store.AccountPromotionalExpense, "", refund.DiscountReversed),
+		store.Credit(store.AccountStudentCash, student.ID, refund.Card),
+		store.Credit(store.AccountStudentCredits, student.ID, refund.Credits),
+	).In(lesson.Currency).WithStripe(lessonStripe...)
+	entry.Lesson = &lesson.ID
+
+	if len(entry.Lines) > 0 {
+		posting = append([]*store.LedgerEntry{entry}, posting...)
+	}
+
+	if len(posting) == 0 {
+		return nil, errors.New("couldn't refund the packages the lesson was paid with")
+	}
+
+	for _, e := range posting {
+		if err := e.Post(); err != nil {
+			return nil, err
+		}
+	}
+
+	// the credits are given once the refund is posted, a refund that isn't saved doesn't give any
+	if refund.Credits > 0 {
+		moved = true
+		if err := incCredits(student, refund.Credits); err != nil {
+			return nil, err
+		}
+	}
+
+	refund.Entry = posting[0].ID
+
+	// a lesson refunded in full gives the student's promo code back
+	if refund.Amount == paid.Refundable() {
+		releasePromoCodes(lesson.ID, student.ID)
+	}
+
+	amountText := lesson.Currency.OrDefault().Format(refund.Amount)
+	how := "to your card"
+	if refund.Card == 0 && refund.Packages == 0 {
+		how = "as credits"
+	}
+
+	notifications.Notify(&notifications.NotifyRequest{
+		User:    student.ID,
+		Type:    notifications.LessonRefunded,
+		Title:   "Lesson refunded",
+		Message: fmt.Sprintf("You were refunded %s %s for the lesson with %s on %s.", amountText, how, tutor.GetFirstName(), lesson.WhenFormatted()),
+		Data:    map[string]interface{}{"lesson": lesson.ID, "refund": refund},
+	})
+
+	notifications.Notify(&notifications.NotifyRequest{
+		User:    tutor.ID,
+		Type:    notifications.LessonRefunded,
+		Title:   "Lesson refunded",
+		Message: fmt.Sprintf("%s was refunded %s for the lesson on %s, %s of it from your pay.", student.GetFirstName(), amountText, lesson.WhenFormatted(), lesson.Currency.OrDefault().Format(refund.TutorReversed)),
+		Data:    map[string]interface{}{"lesson": lesson.ID, "refund": refund},
+	})
+
+	return refund, nil
+}
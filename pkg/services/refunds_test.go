@@ -0,0 +1,118 @@
+package services
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/services/models"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+func TestRefundLessonOnce(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	student := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, student)
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+	if err := tutor.SaveNew(); err != nil {
+		t.Fatal("could not create needed for test:", err)
+	}
+	defer cleanupUser(t, tutor)
+
+	starts := time.Now().Add(-2 * time.Hour)
+	lesson := &store.LessonMgo{
+		ID:       bson.NewObjectId(),
+		Tutor:    tutor.ID,
+		Students: []bson.ObjectId{student.ID},
+		StartsAt: starts,
+		EndsAt:   starts.Add(time.Hour),
+	}
+	defer cleanupLedger(t, lesson.ID)
+
+	charge, err := GetPayments().ChargeForLesson(student, tutor, 60, "today", lesson.ID.Hex(), false, 1050, store.CurrencyUSD, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lesson.Charges = map[string]*models.ChargeData{student.ID.Hex(): charge}
+
+	if err := store.GetCollection("lessons").Insert(lesson); err != nil {
+		t.Fatal(err)
+	}
+	defer store.GetCollection("lessons").RemoveId(lesson.ID)
+
+	// two admins refund the lesson at once, it's refunded once
+	admin := &store.UserMgo{ID: bson.NewObjectId(), Role: store.RoleAdmin}
+	errs := make([]error, 2)
+
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = GetPayments().RefundLesson(lesson, admin, RefundParams{Student: student.ID})
+		}(i)
+	}
+	wg.Wait()
+
+	if (errs[0] == nil) == (errs[1] == nil) {
+		t.Errorf("expected one refund to fail, got %v", errs)
+	}
+
+	if ch, _ := g.GetCharge(charge.ChargeID); ch.Refunded != 1365 {
+		t.Errorf("expected the charge refunded once, got %d", ch.Refunded)
+	}
+
+	if _, err := GetPayments().RefundLesson(lesson, admin, RefundParams{Student: student.ID}); err == nil {
+		t.Error("expected nothing left to refund")
+	}
+}
+
+func TestRefundStudentWhoLeft(t *testing.T) {
+	dbSetup(t)
+	g, restore := useMemoryGateway()
+	defer restore()
+
+	student := paymentsStudent(t, g, 0)
+	defer cleanupUser(t, student)
+
+	tutor := happyTutor()
+	tutor.Payments.ConnectID = memoryAccount(t, g)
+	if err := tutor.SaveNew(); err != nil {
+		t.Fatal("could not create needed for test:", err)
+	}
+	defer cleanupUser(t, tutor)
+
+	lesson := confirmedGroupLesson(t, tutor, bson.NewObjectId())
+	defer store.GetCollection("lessons").RemoveId(lesson.ID)
+	defer cleanupLedger(t, lesson.ID)
+
+	// the student paid their seat in CAD, then left the lesson
+	charge, err := GetPayments().ChargeForLesson(student, tutor, 60, "today", lesson.ID.Hex(), false, lesson.Rate, store.CurrencyCAD, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lesson.Currency = store.CurrencyCAD
+	lesson.Charges = map[string]*models.ChargeData{student.ID.Hex(): charge}
+
+	admin := &store.UserMgo{ID: bson.NewObjectId(), Role: store.RoleAdmin}
+	if _, err := GetPayments().RefundLesson(lesson, admin, RefundParams{Student: student.ID}); err == nil {
+		t.Fatal("expected students out of the lesson not refunded by admins")
+	}
+
+	// settling the cancellation of their seat refunds them all the same
+	refund, err := GetPayments().refundStudent(lesson, RefundParams{Student: student.ID, Amount: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ch, _ := g.GetCharge(charge.ChargeID); refund.Card != 500 || ch.Refunded != 500 {
+		t.Errorf("expected 500 refunded to the card, got %+v", refund)
+	}
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/config"
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/notifications"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+// maxRescheduleRounds is how many times a reschedule can be countered
+const maxRescheduleRounds = 6
+
+// checkRescheduleSlots validates the slots offered to move the lesson to, and that
+// the tutor and the students are available and free for all of them.
+func (l *Lessons) checkRescheduleSlots(lesson *store.LessonMgo, slots []store.RescheduleSlot) error {
+	max := config.GetConfig().Lessons.MaxRescheduleSlots()
+	if len(slots) == 0 || len(slots) > max {
+		return newLessonErr(errInvalidProposal, fmt.Sprintf("propose between 1 and %d time slots", max))
+	}
+
+	tutor, ok := NewUsers().ByID(lesson.Tutor)
+	if !ok {
+		return newLessonErr(errInvalidUser, "couldn't get tutor by id")
+	}
+
+	students := NewUsers().ByIDs(lesson.Students)
+	ignore := []bson.ObjectId{lesson.ID}
+
+	for _, slot := range slots {
+		if !slot.StartsAt.After(time.Now()) || !slot.EndsAt.After(slot.StartsAt) {
+			return newLessonErr(errInvalidTime, "time slots must be in the future and end after they start")
+		}
+
+		when := slot.StartsAt.In(tutor.TimezoneLocation()).Format(time.RFC1123)
+
+		if !tutor.IsAvailable(slot.StartsAt, slot.EndsAt, false) || !tutor.IsFreeFor(slot.StartsAt, slot.Duration(), lesson.Meet, ignore) {
+			return newLessonErr(errInvalidTime, fmt.Sprintf("tutor isn't available on %s", when))
+		}
+
+		for _, student := range students {
+			if !student.IsFreeIgnoreIDs(slot.StartsAt, slot.Duration(), ignore) {
+				return newLessonErr(errInvalidTime, fmt.Sprintf("%s isn't free on %s", student.GetFirstName(), when))
+			}
+		}
+	}
+
+	return nil
+}
+
+// ProposeReschedule offers time slots to move the lesson to. Only one proposal can
+// wait for an answer, the other side has to counter it to propose other slots.
+func (l *Lessons) ProposeReschedule(lesson *store.LessonMgo, user *store.UserMgo, slots []store.RescheduleSlot) (*store.RescheduleProposal, error) {
+	if !lesson.CanBeModified() {
+		return nil, newLessonErr(errInvalidProposal, "lesson can't be modified")
+	}
+
+	if _, pending := store.GetPendingReschedule(lesson.ID); pending {
+		return nil, newLessonErr(errInvalidProposal, "a reschedule is already proposed, answer or counter it")
+	}
+
+	if err := l.checkRescheduleSlots(lesson, slots); err != nil {
+		return nil, err
+	}
+
+	return l.proposeReschedule(lesson, user, nil, slots)
+}
+
+// CounterReschedule answers a proposal with other time slots
+func (l *Lessons) CounterReschedule(lesson *store.LessonMgo, proposal *store.RescheduleProposal, user *store.UserMgo, slots []store.RescheduleSlot) (*store.RescheduleProposal, error) {
+	if err := l.canAnswerReschedule(lesson, proposal, user); err != nil {
+		return nil, err
+	}
+
+	if proposal.Round >= maxRescheduleRounds {
+		return nil, newLessonErr(errInvalidProposal, "too many counter proposals, pick a slot or decline")
+	}
+
+	if err := l.checkRescheduleSlots(lesson, slots); err != nil {
+		return nil, err
+	}
+
+	if err := proposal.Respond(store.RescheduleCountered, nil); err != nil {
+		return nil, newLessonErr(errInvalidProposal, err.Error())
+	}
+
+	return l.proposeReschedule(lesson, user, proposal, slots)
+}
+
+func (l *Lessons) proposeReschedule(lesson *store.LessonMgo, user *store.UserMgo, parent *store.RescheduleProposal, slots []store.RescheduleSlot) (*store.RescheduleProposal, error) {
+	expiry, err := config.GetConfig().Lessons.ParseRescheduleExpiry()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid reschedule expiry")
+	}
+
+	proposal := &store.RescheduleProposal{
+		Lesson:     lesson.ID,
+		Round:      1,
+		ProposedBy: user.ID,
+		Slots:      slots,
+		ExpiresAt:  time.Now().Add(expiry),
+	}
+
+	if parent != nil {
+		proposal.Parent = &parent.ID
+		proposal.Round = parent.Round + 1
+	}
+
+	// a proposal can't outlive the lesson it moves
+	if lesson.StartsAt.Before(proposal.ExpiresAt) {
+		proposal.ExpiresAt = lesson.StartsAt
+	}
+
+	if err := proposal.Insert(); err != nil {
+		return nil, newLessonErr(errDatabase, err.Error())
+	}
+
+	if err := l.postRescheduleMessage(lesson, user, proposal); err != nil {
+		logger.Get().Errorf("couldn't post reschedule proposal %s to the lesson thread: %v", proposal.ID.Hex(), err)
+	}
+
+	title := fmt.Sprintf("%s proposed new times for a lesson", user.Name())
+	message := fmt.Sprintf("%s proposed %d new times for the lesson %s", user.Name(), len(slots), lesson.WhenFormatted())
+	_ = l.NotifyExcept(lesson, user, notifications.LessonChangeRequest, title, message)
+
+	return proposal, nil
+}
+
+// postRescheduleMessage adds the proposal to the lesson's thread, with the data
+// clients need to accept, counter or decline it from the conversation.
+func (l *Lessons) postRescheduleMessage(lesson *store.LessonMgo, user *store.UserMgo, proposal *store.RescheduleProposal) error {
+	room, err := VCRInstance().GetRoomForLesson(lesson)
+	if err != nil {
+		return errors.Wrap(err, "couldn't get lesson room")
+	}
+
+	thread, err := store.GetThreads().WithID(room.Thread)
+	if err != nil {
+		return errors.Wrap(err, "couldn't get lesson thread")
+	}
+
+	title := fmt.Sprintf("%s proposed new times for the lesson", user.GetFirstName())
+	message := store.GetMessages().NewMessage(user, thread.ID, store.TypeNotification, title, &store.MessageData{
+		Type:    store.DataTypeReschedule,
+		Title:   title,
+		Content: fmt.Sprintf("Pick one of the times before %s, or propose others.", proposal.ExpiresAt.Format(time.RFC1123)),
+		Data: map[string]interface{}{
+			"lesson_id":   lesson.ID.Hex(),
+			"proposal_id": proposal.ID.Hex(),
+			"round":       proposal.Round,
+			"slots":       proposal.Slots,
+			"status":      proposal.Status,
+			"expires_at":  proposal.ExpiresAt,
+		},
+	})
+
+	if err := thread.AddMessage(message); err != nil {
+		return err
+	}
+
+	return proposal.SetMessage(message.ID)
+}
+
+func (l *Lessons) canAnswerReschedule(lesson *store.LessonMgo, proposal *store.RescheduleProposal, user *store.UserMgo) error {
+	if proposal.Lesson != lesson.ID {
+		return newLessonErr(errInvalidProposal, "proposal isn't for this lesson")
+	}
+
+	if !proposal.IsPending() {
+		return newLessonErr(errInvalidProposal, "proposal was already answered or expired")
+	}
+
+	if proposal.ProposedBy == user.ID {
+		return newLessonErr(errInvalidProposal, "can't answer your own proposal")
+	}
+
+	if !lesson.CanBeModified() {
+		return newLessonErr(errInvalidProposal, "lesson can't be modified")
+	}
+
+	if proposal.AcceptedByUser(user.ID) {
+		return newLessonErr(errInvalidProposal, "you already accepted the proposal")
+	}
+
+	return nil
+}
+
+// rescheduleAgreed tells if the proposal was accepted by enough users to move the
+// lesson: the tutor, or every student but the one who proposed it.
+func rescheduleAgreed(lesson *store.LessonMgo, proposal *store.RescheduleProposal) bool {
+	if proposal.AcceptedByUser(lesson.Tutor) {
+		return true
+	}
+
+	for _, student := range lesson.Students {
+		if student != proposal.ProposedBy && !proposal.AcceptedByUser(student) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AcceptReschedule accepts the picked slot of the proposal. The lesson moves once the
+// tutor or every student accepted it, until then it keeps its time.
+func (l *Lessons) AcceptReschedule(lesson *store.LessonMgo, proposal *store.RescheduleProposal, user *store.UserMgo, slot int) (*store.LessonMgo, error) {
+	if err := l.canAnswerReschedule(lesson, proposal, user); err != nil {
+		return nil, err
+	}
+
+	if slot < 0 || slot >= len(proposal.Slots) {
+		return nil, newLessonErr(errInvalidProposal, "invalid time slot")
+	}
+
+	picked := proposal.Slots[slot]
+
+	// availability could have changed since the slot was proposed
+	if err := l.checkRescheduleSlots(lesson, []store.RescheduleSlot{picked}); err != nil {
+		return nil, err
+	}
+
+	if err := proposal.Accept(user.ID, slot); err != nil {
+		return nil, newLessonErr(errInvalidProposal, err.Error())
+	}
+
+	if !rescheduleAgreed(lesson, proposal) {
+		title := fmt.Sprintf("%s accepted a new lesson time", user.Name())
+		message := fmt.Sprintf("The lesson moves to %s once everyone accepts it.", picked.StartsAt.Format(time.RFC1123))
+		_ = l.NotifyExcept(lesson, user, notifications.LessonChangeRequestAccepted, title, message)
+		return lesson, nil
+	}
+
+	if err := proposal.Respond(store.RescheduleAccepted, &slot); err != nil {
+		return nil, newLessonErr(errInvalidProposal, err.Error())
+	}
+
+	change := store.LessonChangeProposal{
+		User:      proposal.ProposedBy,
+		Subject:   lesson.Subject,
+		Meet:      lesson.Meet,
+		Location:  lesson.Location,
+		StartsAt:  picked.StartsAt,
+		EndsAt:    picked.EndsAt,
+		CreatedAt: proposal.CreatedAt,
+	}
+
+	updated, err := change.Apply(lesson.ID, store.ScopeOccurrence)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't move the lesson")
+	}
+
+	l.DetectNextTimeout(true)
+
+	title := fmt.Sprintf("%s accepted the new lesson time", user.Name())
+	message := fmt.Sprintf("The lesson was moved to %s.", updated.WhenFormatted())
+	_ = l.NotifyExcept(lesson, user, notifications.LessonChangeRequestAccepted, title, message)
+
+	return updated, nil
+}
+
+// DeclineReschedule ends the negotiation, the lesson keeps its time
+func (l *Lessons) DeclineReschedule(lesson *store.LessonMgo, proposal *store.RescheduleProposal, user *store.UserMgo) error {
+	if err := l.canAnswerReschedule(lesson, proposal, user); err != nil {
+		return err
+	}
+
+	if err := proposal.Respond(store.RescheduleDeclined, nil); err != nil {
+		return newLessonErr(errInvalidProposal, err.Error())
+	}
+
+	title := fmt.Sprintf("%s declined the new lesson times", user.Name())
+	message := fmt.Sprintf("The lesson stays on %s.", lesson.WhenFormatted())
+	_ = l.NotifyExcept(lesson, user, notifications.LessonChangeRequestDeclined, title, message)
+
+	return nil
+}
+
+// ExpireReschedules expires the proposals nobody answered in time
+func (l *Lessons) ExpireReschedules() {
+	proposals, err := store.GetExpiredReschedules()
+	if err != nil {
+		logger.Get().Errorf("couldn't get expired reschedule proposals: %v", err)
+		return
+	}
+
+	for i := range proposals {
+		proposal := &proposals[i]
+
+		if err := proposal.Respond(store.RescheduleExpired, nil); err != nil {
+			logger.Get().Errorf("couldn't expire reschedule proposal %s: %v", proposal.ID.Hex(), err)
+			continue
+		}
+
+		lesson, ok := l.store.Get(proposal.Lesson)
+		if !ok {
+			continue
+		}
+
+		title := "Lesson reschedule expired"
+		message := fmt.Sprintf("Nobody answered the new times proposed for the lesson on %s, it keeps its time.", lesson.WhenFormatted())
+		l.NotifyAll(lesson, notifications.LessonRescheduleExpired, title, message)
+	}
+}
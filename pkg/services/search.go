@@ -22,6 +22,8 @@ type search struct {
 	match          bson.M
 	timezone       string
 	availabilities []store.AvailabilitySlot
+	// price matches the rates in each currency, it's added to the query's $and
+	price []bson.M
 }
 
 var s *search
@@ -55,6 +57,7 @@ func (s *search) Clear() {
 	}
 
 	s.availabilities = make([]store.AvailabilitySlot, 0)
+	s.price = nil
 }
 
 // Timezone sets the timezone for time filtering.
@@ -92,16 +95,34 @@ func (s *search) InstantSession(active bool) *search {
 	return s
 }
 
-// Price sets the price range for the tutor's rate per hour.
-func (s *search) Price(min, max int) *search {
-	s.match["tutoring.rate"] = bson.M{
-		"$exists": true,
-		"$gte":    min,
-		"$lte":    max,
-	}
+// Price sets the price range for the tutor's rate per hour, in minor units of the currency.
+// Tutors charging in other currencies match when their rate converted is in the range.
+func (s *search) Price(min, max int64, currency store.Currency, fx store.FXTable) *search {
+	s.price = priceMatch("tutoring.rate", "tutoring.currency", min, max, currency, fx)
 	return s
 }
 
+// priceMatch matches the prices of the field in the range, converting it to each currency
+// there's an FX rate for. The currency of the price is in currencyField.
+func priceMatch(field, currencyField string, min, max int64, currency store.Currency, fx store.FXTable) []bson.M {
+	match := make([]bson.M, 0, len(store.Currencies))
+
+	for _, c := range store.Currencies {
+		from, ok := fx.Convert(min, currency, c)
+		if !ok {
+			continue
+		}
+
+		to, _ := fx.Convert(max, currency, c)
+		match = append(match, bson.M{
+			field:         bson.M{"$gte": from, "$lte": to},
+			currencyField: c.Query(),
+		})
+	}
+
+	return match
+}
+
 // ExcludeTestAccounts excludes test accounts in query.
 // FIXME: this doesn't work with search as expected
 func (s *search) ExcludeTestAccounts() *search {
@@ -395,6 +416,10 @@ func (s *search) Do(meetInPerson, meetOnline bool) ([]store.UserMgo, error) {
 		}},
 	}
 
+	if len(s.price) > 0 {
+		publicProfiles = append(publicProfiles, bson.M{"$or": s.price})
+	}
+
 	s.match["$and"] = publicProfiles
 
 	if meetInPerson {
@@ -479,6 +504,68 @@ func (s *search) Do(meetInPerson, meetOnline bool) ([]store.UserMgo, error) {
 	return sortSearchResults(results), nil
 }
 
+// CourseFilter is what courses are searched by. Empty fields don't filter.
+type CourseFilter struct {
+	Query   string
+	Subject bson.ObjectId
+	Tutor   bson.ObjectId
+	// MaxPrice is in minor units of Currency
+	MaxPrice int64
+	Currency store.Currency
+	FX       store.FXTable
+	Meet     store.Meet
+}
+
+// Courses searches the open courses by their title, description and subject. The
+// most enrolled courses come first.
+func (s *search) Courses(filter CourseFilter) ([]store.Course, error) {
+	match := bson.M{"status": store.CourseOpen}
+
+	if filter.Subject.Valid() {
+		match["subject"] = filter.Subject
+	}
+
+	if filter.Tutor.Valid() {
+		match["tutor"] = filter.Tutor
+	}
+
+	// the query takes the $or, the prices in each currency go in an $and
+	if filter.MaxPrice > 0 {
+		match["$and"] = []bson.M{{"$or": priceMatch("price", "currency", 0, filter.MaxPrice, filter.Currency, filter.FX)}}
+	}
+
+	if filter.Meet != 0 {
+		match["meet"] = filter.Meet
+	}
+
+	if filter.Query != "" {
+		pattern := bson.RegEx{Pattern: regexp.QuoteMeta(filter.Query), Options: "i"}
+		or := []bson.M{
+			{"title": pattern},
+			{"description": pattern},
+			{"sessions.title": pattern},
+		}
+
+		var subjects []store.Subject
+		if err := store.GetCollection("subjects").Find(bson.M{"subject": pattern}).All(&subjects); err == nil && len(subjects) > 0 {
+			ids := make([]bson.ObjectId, len(subjects))
+			for i, subject := range subjects {
+				ids[i] = subject.ID
+			}
+			or = append(or, bson.M{"subject": bson.M{"$in": ids}})
+		}
+
+		match["$or"] = or
+	}
+
+	courses := make([]store.Course, 0)
+	if err := store.GetCollection("courses").Find(match).Sort("-enrolled", "-created_at").All(&courses); err != nil {
+		return courses, errors.Wrap(err, "error in course search query")
+	}
+
+	return courses, nil
+}
+
 func sortSearchResults(r []store.UserMgo) []store.UserMgo {
 	sort.SliceStable(r, func(i, j int) bool {
 		return r[i].Online > r[j].Online
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"gitlab.com/learnt/api/config"
+	"gitlab.com/learnt/api/pkg/logger"
 	"gitlab.com/learnt/api/pkg/store"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -29,14 +30,14 @@ func happyTutor() *store.UserMgo {
 		Profile:        store.Profile{Avatar: &store.Upload{ID: bson.NewObjectId()}},
 		Tutoring: &store.Tutoring{
 			Meet: store.MeetInPerson,
-			Rate: 10,
+			Rate: 1000,
 			Subjects: []store.TutoringSubject{store.TutoringSubject{
 				ID:      bson.NewObjectId(),
 				Subject: bson.NewObjectId(),
 			}},
 			Degrees: []store.TutoringDegree{store.TutoringDegree{
 				ID:         bson.NewObjectId(),
-				University: bson.NewObjectId(),
+				University: bson.NewObjectId().Hex(),
 			}},
 			Availability: &store.Availability{
 				Recurrent: []*store.AvailabilitySlot{
@@ -59,6 +60,10 @@ func cleanupUser(t *testing.T, u *store.UserMgo) {
 }
 
 func TestMain(m *testing.M) {
+	if _, err := logger.Init(os.DevNull, logger.ERROR, "test"); err != nil {
+		panic(err)
+	}
+
 	c := m.Run()
 	os.Exit(c)
 }
@@ -7,7 +7,7 @@ var happyBankRoutingNumber = "110000000"
 
 func addBankAccount(t *testing.T, accountID, accountHolderName string) string {
 	// https://stripe.com/docs/ach#testing-ach https://stripe.com/docs/connect/testing
-	token, err := NewExternalBankAccountToken(accountHolderName, happyBankAccountNumber, happyBankRoutingNumber, "")
+	token, err := NewExternalBankAccountToken(accountHolderName, happyBankAccountNumber, happyBankRoutingNumber, "", "US", "usd")
 	if err != nil {
 		t.Fatal("Could not create token", err)
 	}
@@ -84,7 +84,7 @@ func TestReplaceBankAccount(t *testing.T) {
 
 	addBankAccount(t, id, "")
 
-	token, err := NewExternalBankAccountToken("accountHolderName", "000111111116", happyBankRoutingNumber, "")
+	token, err := NewExternalBankAccountToken("accountHolderName", "000111111116", happyBankRoutingNumber, "", "US", "usd")
 	if err != nil {
 		t.Fatal("Could not create token", err)
 	}
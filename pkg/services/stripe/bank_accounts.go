@@ -42,16 +42,17 @@ func convertBankAccount(ba *stripe.BankAccount) *BankAccount {
 }
 
 // NewExternalBankAccountToken creates a token to use in the creation of a new bank account
+// in the country and currency the tutor is paid out in.
 // This could be created in the UI so the parameters are never sent to the API
-func NewExternalBankAccountToken(accountHolderName, accountNumber, routingNumber, accountHolderType string) (string, error) {
+func NewExternalBankAccountToken(accountHolderName, accountNumber, routingNumber, accountHolderType, country, currency string) (string, error) {
 	params := &stripe.TokenParams{
 		BankAccount: &stripe.BankAccountParams{
 			AccountHolderName: stringPointerIfNotEmpty(accountHolderName),
 			AccountHolderType: stringPointerIfNotEmpty(accountHolderType),
 			AccountNumber:     stripe.String(accountNumber),
 			RoutingNumber:     stripe.String(routingNumber),
-			Country:           stripe.String("US"),
-			Currency:          stripe.String("USD"),
+			Country:           stripe.String(country),
+			Currency:          stripe.String(currency),
 		},
 	}
 
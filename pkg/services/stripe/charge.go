@@ -6,6 +6,7 @@ import (
 	stripe "github.com/stripe/stripe-go"
 	"github.com/stripe/stripe-go/charge"
 	"github.com/stripe/stripe-go/refund"
+	"github.com/stripe/stripe-go/reversal"
 	"gitlab.com/learnt/api/config"
 )
 
@@ -122,11 +123,15 @@ func CaptureCharge(chargeID string) (string, error) {
 	return ch.ID, nil
 }
 
-// UpdateAndCaptureCharge update the charge before capturing it
-func UpdateAndCaptureCharge(chargeID string, amount, fee int64, metadata map[string]string) (string, error) {
+// UpdateAndCaptureCharge update the charge before capturing it. Capturing less than was authorized
+// releases the rest with a refund, whose ids are returned with the charge's. Holds on the
+// platform's account take no fee, it's left out when it's zero.
+func UpdateAndCaptureCharge(chargeID string, amount, fee int64, metadata map[string]string) (string, []string, error) {
 	params := &stripe.CaptureParams{
-		Amount:               stripe.Int64(amount),
-		ApplicationFeeAmount: stripe.Int64(fee),
+		Amount: stripe.Int64(amount),
+	}
+	if fee > 0 {
+		params.ApplicationFeeAmount = stripe.Int64(fee)
 	}
 	for k, v := range metadata {
 		params.AddMetadata(k, v)
@@ -141,9 +146,16 @@ func UpdateAndCaptureCharge(chargeID string, amount, fee int64, metadata map[str
 		return nil
 	}
 	if err := backoff.Retry(backoffOperation, exponentialBackOff()); err != nil {
-		return "", wrap(err, "could not update the charge")
+		return "", nil, wrap(err, "could not update the charge")
 	}
-	return ch.ID, nil
+
+	var released []string
+	if ch.Refunds != nil {
+		for _, r := range ch.Refunds.Data {
+			released = append(released, r.ID)
+		}
+	}
+	return ch.ID, released, nil
 }
 
 // CancelCharge will call the refund api to cancel a charge. This should be used instead of capturing
@@ -198,3 +210,122 @@ func ChargeCorporateCardCompany(payeeAccount string, amount int64, description,
 
 	return ch.ID, nil
 }
+
+// ChargePlatform charges the customer to the platform's account, for money that is paid to tutors later.
+// Amount is in minor units of the currency
+func ChargePlatform(customer string, amount int64, currency, description, prefix string, metadata map[string]string) (string, error) {
+	if len(prefix) > MAX_DESCRIPTOR_LENGTH {
+		return "", errors.New("statement descriptor prefix longer than required length")
+	}
+
+	params := &stripe.ChargeParams{
+		Amount:              stripe.Int64(amount),
+		Currency:            stripe.String(currency),
+		Customer:            stripe.String(customer),
+		Description:         stripe.String(description),
+		StatementDescriptor: stringPointerIfNotEmpty(prefix),
+	}
+
+	for k, v := range metadata {
+		params.AddMetadata(k, v)
+	}
+
+	ch, err := newCharge(params)
+	if err != nil {
+		return "", wrap(err, "could not charge customer")
+	}
+
+	return ch.ID, nil
+}
+
+// AuthorizePlatform holds the charge on the customer's card for the platform's account, to be
+// captured or cancelled within 7 days
+func AuthorizePlatform(customer string, amount int64, currency, description, prefix string, metadata map[string]string) (string, error) {
+	if len(prefix) > MAX_DESCRIPTOR_LENGTH {
+		return "", errors.New("statement descriptor prefix longer than required length")
+	}
+
+	params := &stripe.ChargeParams{
+		Amount:              stripe.Int64(amount),
+		Currency:            stripe.String(currency),
+		Customer:            stripe.String(customer),
+		Description:         stripe.String(description),
+		StatementDescriptor: stringPointerIfNotEmpty(prefix),
+		Capture:             stripe.Bool(false),
+	}
+
+	for k, v := range metadata {
+		params.AddMetadata(k, v)
+	}
+
+	ch, err := newCharge(params)
+	if err != nil {
+		return "", wrap(err, "could not authorize charge")
+	}
+
+	return ch.ID, nil
+}
+
+// RefundCharge refunds part of a captured charge. Amount is in cents
+func RefundCharge(chargeID string, amount int64, metadata map[string]string) (string, error) {
+	if amount <= 0 {
+		return "", errors.New("attempting to refund a zero or negative amount")
+	}
+
+	params := &stripe.RefundParams{
+		Charge: stripe.String(chargeID),
+		Amount: stripe.Int64(amount),
+	}
+
+	for k, v := range metadata {
+		params.AddMetadata(k, v)
+	}
+
+	r, err := refund.New(params)
+	if err != nil {
+		return "", wrap(err, "could not refund charge")
+	}
+
+	return r.ID, nil
+}
+
+// ReverseChargeTransfer takes back up to amount of what the destination charge transferred
+// to the connect account. It returns the reversal and how much it took back, which is less
+// than the amount when the transfer doesn't have as much left.
+func ReverseChargeTransfer(chargeID string, amount int64, description string) (string, int64, error) {
+	if amount <= 0 {
+		return "", 0, errors.New("attempting to reverse a zero or negative amount")
+	}
+
+	params := &stripe.ChargeParams{}
+	params.AddExpand("transfer")
+
+	ch, err := charge.Get(chargeID, params)
+	if err != nil {
+		return "", 0, wrap(err, "could not get charge")
+	}
+
+	if ch.Transfer == nil {
+		return "", 0, nil
+	}
+
+	left := ch.Transfer.Amount - ch.Transfer.AmountReversed
+	if left <= 0 {
+		return "", 0, nil
+	}
+
+	if amount > left {
+		amount = left
+	}
+
+	r, err := reversal.New(&stripe.ReversalParams{
+		Transfer:    stripe.String(ch.Transfer.ID),
+		Amount:      stripe.Int64(amount),
+		Description: stripe.String(description),
+	})
+	if err != nil {
+		return "", 0, wrap(err, "could not reverse transfer")
+	}
+
+	return r.ID, amount, nil
+}
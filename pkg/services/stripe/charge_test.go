@@ -157,10 +157,15 @@ func TestUpdateAndCaptureCharge(t *testing.T) {
 
 	reducedAmount := int64(800)
 	reducedFee := int64(90)
-	if _, err := UpdateAndCaptureCharge(chargeID, reducedAmount, reducedFee, nil); err != nil {
+	_, released, err := UpdateAndCaptureCharge(chargeID, reducedAmount, reducedFee, nil)
+	if err != nil {
 		t.Fatal("Could not update and capture charge", err)
 	}
 
+	if len(released) != 1 {
+		t.Fatal("Rest of the authorization should be released with a refund", released)
+	}
+
 	ch, err := charge.Get(chargeID, nil)
 	if err != nil {
 		t.Fatal("Could not get charge", err)
@@ -23,9 +23,29 @@ func Init() {
 	}
 }
 
+// FailedError is an error Stripe answered a request with, the request definitely failed.
+// Requests that timed out or errored on Stripe's side may have gone through.
+type FailedError struct {
+	Msg string
+}
+
+func (e *FailedError) Error() string {
+	return e.Msg
+}
+
+// IsFailed tells if the request definitely failed, so it can be made again with a new
+// idempotency key. Stripe answers a key it saw with the same response for a day.
+func IsFailed(err error) bool {
+	_, ok := errors.Cause(err).(*FailedError)
+	return ok
+}
+
 func wrap(err error, msg string) error {
 	if se, ok := err.(*stripe.Error); ok {
 		err = errors.New(se.Msg)
+		if isFailure(se) {
+			err = &FailedError{Msg: se.Msg}
+		}
 	}
 	return errors.Wrap(err, msg)
 }
@@ -61,6 +81,16 @@ func exponentialBackOff() *backoff.ExponentialBackOff {
 	return eb
 }
 
+// isFailure tells if Stripe refused the request, retrying it as it is fails the same way.
+// A key reused with other params isn't a failure of the request made with it first.
+func isFailure(se *stripe.Error) bool {
+	switch se.HTTPStatusCode {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusPaymentRequired:
+		return se.Type != "idempotency_error"
+	}
+	return false
+}
+
 func checkPermanentFailure(err error) error {
 	if se, ok := err.(*stripe.Error); ok {
 		switch se.HTTPStatusCode {
@@ -0,0 +1,50 @@
+package stripe
+
+import (
+	"github.com/cenkalti/backoff"
+	"github.com/pkg/errors"
+	stripe "github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/transfer"
+)
+
+// Transfer sends the amount from the platform's balance to the connect account. Transfers of
+// the same group are the ones of a payout batch. Amount is in minor units of the currency.
+// Transfers made again with the same key aren't sent twice, Stripe returns the first one.
+func Transfer(payeeAccount string, amount int64, currency, description, group, key string, metadata map[string]string) (string, error) {
+	if amount <= 0 {
+		return "", errors.New("attempting to transfer a zero or negative amount")
+	}
+
+	params := &stripe.TransferParams{
+		Amount:        stripe.Int64(amount),
+		Currency:      stripe.String(currency),
+		Destination:   stripe.String(payeeAccount),
+		Description:   stringPointerIfNotEmpty(description),
+		TransferGroup: stringPointerIfNotEmpty(group),
+	}
+
+	for k, v := range metadata {
+		params.AddMetadata(k, v)
+	}
+
+	// the retries share the key, so a transfer that went through isn't made twice
+	if key != "" {
+		params.SetIdempotencyKey(key)
+	} else {
+		setIdempotencyKey(params)
+	}
+
+	var tr *stripe.Transfer
+	backoffOperation := func() error {
+		var err error
+		if tr, err = transfer.New(params); err != nil {
+			return checkPermanentFailure(err)
+		}
+		return nil
+	}
+	if err := backoff.Retry(backoffOperation, exponentialBackOff()); err != nil {
+		return "", wrap(err, "could not transfer to account")
+	}
+
+	return tr.ID, nil
+}
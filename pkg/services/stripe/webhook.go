@@ -0,0 +1,28 @@
+package stripe
+
+import (
+	"github.com/pkg/errors"
+	stripe "github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/webhook"
+	"gitlab.com/learnt/api/config"
+)
+
+// MetadataRecorded marks the Stripe objects the API records itself, so their webhook
+// events don't record them again
+const MetadataRecorded = "LearntRecorded"
+
+// ConstructEvent verifies the webhook payload was signed by Stripe with the endpoint's
+// secret, and parses the event
+func ConstructEvent(payload []byte, signature string) (*stripe.Event, error) {
+	secret := config.GetConfig().GetString("service.stripe.webhook_secret")
+	if secret == "" {
+		return nil, errors.New("no webhook secret set to verify the event")
+	}
+
+	event, err := webhook.ConstructEvent(payload, signature, secret)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not verify webhook event")
+	}
+
+	return &event, nil
+}
@@ -1,10 +1,9 @@
 package services
 
 import (
-	"fmt"
+	"sort"
 	"time"
 
-	"github.com/pkg/errors"
 	"gitlab.com/learnt/api/pkg/logger"
 	"gitlab.com/learnt/api/pkg/store"
 	"gopkg.in/mgo.v2"
@@ -19,12 +18,48 @@ func GetTransactions() *transactionService {
 	return &transactionService{}
 }
 
-func (s *transactionService) GetAmount(user *store.UserMgo) (amount float64) {
+// GetEarnings is what the user earned between from and to in each currency, with their
+// transactions from before the ledger in the default currency. Zero times don't limit the period.
+func (s *transactionService) GetEarnings(user *store.UserMgo, from, to time.Time) ([]store.Money, error) {
+	earnings, err := store.GetLedgerEarnings(user.ID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	legacy := store.MinorUnits(s.legacyAmount(user, from, to))
+	if legacy == 0 {
+		return earnings, nil
+	}
+
+	for i := range earnings {
+		if earnings[i].Currency == store.DefaultCurrency {
+			earnings[i].Amount += legacy
+			return earnings, nil
+		}
+	}
+
+	return append(earnings, store.Money{Amount: legacy, Currency: store.DefaultCurrency}), nil
+}
+
+func (s *transactionService) legacyAmount(user *store.UserMgo, from, to time.Time) (amount float64) {
 	c := store.GetCollection("transactions")
 
+	match := bson.M{"user": user.ID}
+
+	period := bson.M{}
+	if !from.IsZero() {
+		period["$gte"] = from
+	}
+	if !to.IsZero() {
+		period["$lte"] = to
+	}
+	if len(period) > 0 {
+		match["time"] = period
+	}
+
 	pipe := c.Pipe([]bson.M{
 		{
-			"$match": bson.M{"user": user.ID},
+			"$match": match,
 		}, {
 			"$group": bson.M{
 				"_id":      1,
@@ -96,9 +131,14 @@ func (s *transactionService) GetTransactions(user *store.UserMgo, from, to time.
 		transactions = append(transactions, m.Dto())
 	}
 
-	return
+	return mergeTransactions(transactions, ledgerTransactions(bson.M{
+		"lines.owner": user.ID,
+		"created_at":  bson.M{"$gte": from, "$lte": to},
+	}, 0, 0))
 }
 
+// GetCreditSummaryTransactions gets the credits given to the users, and the discounts of
+// the promo codes they redeemed
 func (s *transactionService) GetCreditSummaryTransactions(from, to time.Time) (transactions []*store.TransactionDto) {
 	c := store.GetCollection("transactions")
 
@@ -141,10 +181,36 @@ func (s *transactionService) GetCreditSummaryTransactions(from, to time.Time) (t
 		transactions = append(transactions, m.Dto())
 	}
 
-	return
+	transactions = mergeTransactions(transactions, ledgerTransactions(bson.M{
+		"kind":        store.EntryCredit,
+		"lines.owner": bson.M{"$exists": true},
+		"created_at":  bson.M{"$gte": from, "$lte": to},
+	}, 0, 0))
+
+	return mergeTransactions(transactions, promoTransactions(from, to))
+}
+
+// promoTransactions are the promo code redemptions made between the times
+func promoTransactions(from, to time.Time) []*store.TransactionDto {
+	redemptions, err := store.GetPromoRedemptions(bson.M{"created_at": bson.M{"$gte": from, "$lte": to}})
+	if err != nil {
+		logger.Get().Errorf("error getting promo code redemptions: %v", err)
+	}
+
+	transactions := make([]*store.TransactionDto, len(redemptions))
+	for i := range redemptions {
+		transactions[i] = redemptions[i].Dto()
+	}
+
+	return transactions
 }
 
+// GetTransactionsPaged gets a page of the user's transactions, newest first
 func (s *transactionService) GetTransactionsPaged(user *store.UserMgo, from time.Time, to time.Time, page int, limit int) (count int, transactions []*store.TransactionDto) {
+	if page < 1 {
+		page = 1
+	}
+
 	collection := store.GetCollection("transactions")
 	query := collection.Find(bson.M{
 		"user": user.ID,
@@ -154,8 +220,21 @@ func (s *transactionService) GetTransactionsPaged(user *store.UserMgo, from time
 		},
 	}).Sort("-time")
 	count, _ = query.Count()
+
+	match := bson.M{
+		"lines.owner": user.ID,
+		"created_at":  bson.M{"$gte": from, "$lte": to},
+	}
+
+	ledgerCount, err := store.CountLedgerStatements(match)
+	if err != nil {
+		logger.Get().Errorf("error GetTransactions(): %v", err)
+		return 0, nil
+	}
+
+	// both lists are newest first, so the page is within the first page*limit of each
 	var transactionsMgo []*store.TransactionMgo
-	if err := query.Skip((page - 1) * limit).Limit(limit).All(&transactionsMgo); err != nil {
+	if err := query.Limit(page * limit).All(&transactionsMgo); err != nil {
 		logger.Get().Errorf("error GetTransactions(): %v", err)
 		return 0, nil
 	}
@@ -164,35 +243,41 @@ func (s *transactionService) GetTransactionsPaged(user *store.UserMgo, from time
 	for _, m := range transactionsMgo {
 		transactions = append(transactions, m.Dto())
 	}
-	return count, transactions
-}
 
-func (s *transactionService) New(t *store.TransactionMgo) (*store.TransactionMgo, error) {
-	if !t.ID.Valid() {
-		t.ID = bson.NewObjectId()
-	}
+	transactions = mergeTransactions(transactions, ledgerTransactions(match, 0, page*limit))
 
-	if t.Amount == 0 {
-		return nil, errors.New("amount can't be zero")
+	start, end := (page-1)*limit, page*limit
+	if start > len(transactions) {
+		start = len(transactions)
 	}
-
-	if t.Details == "" {
-		return nil, errors.New("details or reference can't be blank")
+	if end > len(transactions) {
+		end = len(transactions)
 	}
 
-	now := time.Now()
-	if t.Time.IsZero() {
-		t.Time = now
+	return count + ledgerCount, transactions[start:end]
+}
+
+// ledgerTransactions gets the ledger lines matching as transactions
+func ledgerTransactions(match bson.M, skip, limit int) []*store.TransactionDto {
+	statements, err := store.GetLedgerStatements(match, skip, limit)
+	if err != nil {
+		logger.Get().Errorf("error getting ledger transactions: %v", err)
 	}
 
-	collection := store.GetCollection("transactions")
-	if t.Reference == "" {
-		c, err := collection.Count()
-		if err != nil {
-			return t, errors.Wrap(err, "couldn't count transactions collection")
-		}
-		t.Reference = fmt.Sprintf("INV-%d-%d%d%d", c+1, now.Year(), now.Month(), now.Day())
+	transactions := make([]*store.TransactionDto, len(statements))
+	for i := range statements {
+		transactions[i] = statements[i].Dto()
 	}
 
-	return t, collection.Insert(t)
+	return transactions
+}
+
+// mergeTransactions merges the transactions from before the ledger with the
+// ledger's, newest first
+func mergeTransactions(legacy, ledger []*store.TransactionDto) []*store.TransactionDto {
+	transactions := append(legacy, ledger...)
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].Time.After(transactions[j].Time)
+	})
+	return transactions
 }
@@ -74,6 +74,18 @@ func (u *users) ByIDs(id []bson.ObjectId) (users []*store.UserMgo) {
 	return
 }
 
+// ByCustomerID searches for a user by their Stripe customer.
+func (u *users) ByCustomerID(id string) (user *store.UserMgo, exist bool) {
+	exist = u.Find(bson.M{"payments.customer": id}).One(&user) == nil
+	return
+}
+
+// ByConnectID searches for a user by their Stripe connect account.
+func (u *users) ByConnectID(id string) (user *store.UserMgo, exist bool) {
+	exist = u.Find(bson.M{"payments.connect": id}).One(&user) == nil
+	return
+}
+
 // ByReferralCode searches for a user by its referral code.
 func (u *users) ByReferralCode(r string) (user *store.UserMgo, exist bool) {
 	exist = u.Find(bson.M{"refer.referral_code": r}).One(&user) == nil
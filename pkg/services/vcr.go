@@ -148,6 +148,17 @@ type Session struct {
 	mux sync.Mutex
 }
 
+// Agenda gets the agenda of the room's lesson. It's read when it's sent, so changes
+// made while the room is open are in it.
+func (r *Session) Agenda() *store.LessonAgendaDto {
+	lesson := r.Room.GetLesson()
+	if lesson == nil {
+		return nil
+	}
+
+	return GetLessons().Agenda(lesson)
+}
+
 func (r *Session) UserConnected(user store.UserMgo) bool {
 
 	for _, c := range r.Connections() {
@@ -222,8 +233,9 @@ func (r *Session) isEmpty() bool {
 	return len(r.Connections()) == 0
 }
 
+// Ready tells if the tutor and at least one student are in the room
 func (r *Session) Ready() bool {
-	return len(r.Connections()) == 2
+	return r.Tutor != nil && len(r.Students) > 0
 }
 
 // VCR is the engine that holds all virtual classroms
@@ -601,12 +613,7 @@ func (vcr *VCR) onExtendTime(event ws.Event, room *Session, engine *ws.Engine) {
 func (vcr *VCR) onWait(event ws.Event, room *Session, engine *ws.Engine) {
 	logger.Get().Info("On wait: ", FiveMinutes)
 
-	var other int
-	for i, participant := range room.Room.GetLesson().GetParticipants() {
-		if participant.ID.Hex() != event.Source.GetUser().ID.Hex() {
-			other = i
-		}
-	}
+	otherName := waitingFor(room.Room.GetLesson(), event.Source.GetUser())
 
 	conf := config.GetConfig()
 	d := delivery.New(conf)
@@ -618,7 +625,7 @@ func (vcr *VCR) onWait(event ws.Event, room *Session, engine *ws.Engine) {
 
 	go d.Send(event.Source.GetUser(), m.TPL_FIVE_MINUTES_LATE, &m.P{
 		"FIRST_NAME":    event.Source.GetUser().GetFirstName(),
-		"OTHER_NAME":    room.Room.GetLesson().GetParticipants()[other].Name(),
+		"OTHER_NAME":    otherName,
 		"CLASSROOM_URL": roomURL,
 	})
 }
@@ -639,37 +646,29 @@ func (vcr *VCR) onWaitCancel(event ws.Event, room *Session, engine *ws.Engine) {
 		c.Close()
 	}
 
-	var isTutor bool
-	var other int
-
-	for i, participant := range room.Room.GetLesson().GetParticipants() {
-		if participant.ID.Hex() != event.Source.GetUser().ID.Hex() {
-			other = i
-		}
-	}
-
-	isTutor = event.Source.GetUser().ID.Hex() == tutor.ID.Hex()
+	isTutor := event.Source.GetUser().ID.Hex() == tutor.ID.Hex()
+	otherName := waitingFor(room.Room.GetLesson(), event.Source.GetUser())
 
 	conf := config.GetConfig()
 	d := delivery.New(conf)
 
 	if isTutor {
 		d.Send(event.Source.GetUser(), m.TPL_LESSON_NO_SHOW_STUDENT, &m.P{
-			"STUDENT_NAME": room.Room.GetLesson().GetParticipants()[other].Name(),
+			"STUDENT_NAME": otherName,
 		})
 
 		mail.GetSender(conf).SendTo(m.HIRING_EMAIL, m.TPL_LESSON_NO_SHOW_ADMIN, &m.P{
 			"TUTOR_NAME":   event.Source.GetUser().Name(),
-			"STUDENT_NAME": room.Room.GetLesson().GetParticipants()[other].Name(),
+			"STUDENT_NAME": otherName,
 		})
 	} else {
 		d.Send(event.Source.GetUser(), m.TPL_LESSON_NO_SHOW_TUTOR, &m.P{
-			"TUTOR_NAME":   room.Room.GetLesson().GetParticipants()[other].Name(),
+			"TUTOR_NAME":   otherName,
 			"STUDENT_NAME": event.Source.GetUser().Name(),
 		})
 
 		mail.GetSender(conf).SendTo(m.HIRING_EMAIL, m.TPL_LESSON_NO_SHOW_ADMIN, &m.P{
-			"TUTOR_NAME":           room.Room.GetLesson().GetParticipants()[other].Name(),
+			"TUTOR_NAME":           otherName,
 			"STUDENT_NAME":         event.Source.GetUser().Name(),
 			"MERGE_LESSON_DETAILS": room.Room.GetLesson().FetchSubjectName(),
 		})
@@ -678,6 +677,20 @@ func (vcr *VCR) onWaitCancel(event ws.Event, room *Session, engine *ws.Engine) {
 	logger.Get().Infof("Virtual class room %s completed", room.Room.ID.Hex())
 }
 
+// waitingFor returns the names of who the user is waiting for in the lesson: the
+// students when the user is the tutor, the tutor otherwise.
+func waitingFor(lesson *store.LessonMgo, user *store.UserMgo) string {
+	if lesson.Tutor.Hex() == user.ID.Hex() {
+		return lesson.StudentsNames(false)
+	}
+
+	if tutor := lesson.GetTutor(); tutor != nil {
+		return tutor.Name()
+	}
+
+	return ""
+}
+
 func (vcr *VCR) onWaitReject(event ws.Event, room *Session, engine *ws.Engine) {
 	logger.Get().Info("On wait cancelled.")
 
@@ -1068,6 +1081,7 @@ func (vcr *VCR) Join(event ws.Event, room *Session, engine *ws.Engine) {
 		"tutor":    tutor,
 		"students": students,
 		"module":   room.Module,
+		"agenda":   room.Agenda(),
 	}
 
 	if !room.Ready() {
@@ -0,0 +1,363 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	stripeGo "github.com/stripe/stripe-go"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/logger"
+	"gitlab.com/learnt/api/pkg/notifications"
+	"gitlab.com/learnt/api/pkg/services/stripe"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+// Charge statuses kept on the lessons' charges, as Stripe tells about them
+const (
+	chargeSucceeded = "succeeded"
+	chargeFailed    = "failed"
+	chargeRefunded  = "refunded"
+	chargeDisputed  = "disputed"
+)
+
+// stripeEventStuckAfter is how long an event can be processed before it's taken as stopped
+// midway, and can be processed again
+const stripeEventStuckAfter = 15 * time.Minute
+
+// stripeHooks handle the Stripe events by type. Events of other types are stored and ignored.
+var stripeHooks = map[string]func(p *payments, event *stripeGo.Event) error{
+	"charge.succeeded":       (*payments).hookChargeSucceeded,
+	"charge.captured":        (*payments).hookChargeSucceeded,
+	"charge.failed":          (*payments).hookChargeFailed,
+	"charge.refunded":        (*payments).hookChargeRefunded,
+	"charge.dispute.created": (*payments).hookDisputeCreated,
+	"charge.dispute.closed":  (*payments).hookDisputeClosed,
+	"account.updated":        (*payments).hookAccountUpdated,
+}
+
+// WebHook receives the Stripe events. Events not signed with the endpoint's secret are
+// refused, and events received before are acknowledged without processing them again,
+// unless their processing stopped midway.
+func (p *payments) WebHook(c *gin.Context) {
+	payload, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	event, err := stripe.ConstructEvent(payload, c.GetHeader("Stripe-Signature"))
+	if err != nil {
+		logger.GetCtx(c).Warnf("refused stripe webhook event: %v", err)
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	stored := &store.StripeEvent{ID: event.ID, Type: event.Type, Payload: string(payload)}
+	created, err := stored.Insert()
+	if err != nil {
+		logger.GetCtx(c).Errorf("couldn't store stripe event %s: %v", event.ID, err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if created {
+		p.processEvent(stored, event)
+		c.Status(http.StatusOK)
+		return
+	}
+
+	// Stripe retries the events it wasn't told were received, as when the API stopped midway
+	if received, ok := store.GetStripeEvent(event.ID); ok && received.State == store.StripeEventReceived {
+		if err := received.Claim(time.Now().Add(-stripeEventStuckAfter)); err == nil {
+			p.processEvent(received, event)
+		}
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// ReplayEvent processes a failed event again, or one whose processing stopped midway
+func (p *payments) ReplayEvent(id string) (*store.StripeEvent, error) {
+	stored, ok := store.GetStripeEvent(id)
+	if !ok {
+		return nil, errors.New("event not found")
+	}
+
+	if stored.State != store.StripeEventFailed && stored.State != store.StripeEventReceived {
+		return nil, errors.Errorf("event is %s, only failed events can be replayed", stored.State)
+	}
+
+	if err := stored.Claim(time.Now().Add(-stripeEventStuckAfter)); err != nil {
+		return nil, err
+	}
+
+	var event stripeGo.Event
+	if err := json.Unmarshal([]byte(stored.Payload), &event); err != nil {
+		return nil, errors.Wrap(err, "couldn't parse stored event")
+	}
+
+	p.processEvent(stored, &event)
+	return stored, nil
+}
+
+// processEvent runs the event's hook and saves how it went
+func (p *payments) processEvent(stored *store.StripeEvent, event *stripeGo.Event) {
+	state := store.StripeEventProcessed
+
+	var err error
+	if hook, ok := stripeHooks[event.Type]; ok {
+		if err = hook(p, event); err != nil {
+			state = store.StripeEventFailed
+			logger.Get().Errorf("couldn't process stripe event %s (%s): %v", event.ID, event.Type, err)
+		}
+	} else {
+		state = store.StripeEventIgnored
+	}
+
+	if err := stored.SetProcessed(state, err); err != nil {
+		logger.Get().Errorf("couldn't save stripe event %s: %v", event.ID, err)
+	}
+}
+
+// eventCharge gets the charge of a charge event
+func eventCharge(event *stripeGo.Event) (*stripeGo.Charge, error) {
+	var ch stripeGo.Charge
+	err := json.Unmarshal(event.Data.Raw, &ch)
+	return &ch, errors.Wrap(err, "couldn't parse charge")
+}
+
+// setLessonChargeStatus saves the status on the lesson's charges of the card charge.
+// Charges that aren't a lesson's are left alone.
+func setLessonChargeStatus(chargeID, lessonHex, status string) error {
+	if !bson.IsObjectIdHex(lessonHex) {
+		return nil
+	}
+
+	lesson, ok := store.GetLessonsStore().Get(bson.ObjectIdHex(lessonHex))
+	if !ok {
+		return nil
+	}
+
+	set := bson.M{}
+	for hex, charge := range lesson.Charges {
+		if charge != nil && charge.ChargeID == chargeID {
+			set["charges."+hex+".status"] = status
+		}
+	}
+
+	if lesson.Charge != nil && lesson.Charge.ChargeID == chargeID {
+		set["charge.status"] = status
+	}
+
+	if len(set) == 0 {
+		return nil
+	}
+
+	return errors.Wrap(store.GetCollection("lessons").UpdateId(lesson.ID, bson.M{"$set": set}), "couldn't set lesson charge status")
+}
+
+func (p *payments) hookChargeSucceeded(event *stripeGo.Event) error {
+	ch, err := eventCharge(event)
+	if err != nil {
+		return err
+	}
+
+	return setLessonChargeStatus(ch.ID, ch.Metadata["lessonID"], chargeSucceeded)
+}
+
+// hookChargeFailed tells the student their card couldn't be charged
+func (p *payments) hookChargeFailed(event *stripeGo.Event) error {
+	ch, err := eventCharge(event)
+	if err != nil {
+		return err
+	}
+
+	if err := setLessonChargeStatus(ch.ID, ch.Metadata["lessonID"], chargeFailed); err != nil {
+		return err
+	}
+
+	if ch.Customer == nil {
+		return nil
+	}
+
+	user, ok := NewUsers().ByCustomerID(ch.Customer.ID)
+	if !ok {
+		return nil
+	}
+
+	notifications.Notify(&notifications.NotifyRequest{
+		User:    user.ID,
+		Type:    notifications.PaymentFailed,
+		Title:   "Payment failed",
+		Message: fmt.Sprintf("We couldn't charge %s to your card: %s", store.Currency(ch.Currency).Format(ch.Amount), ch.FailureMessage),
+		Data:    map[string]interface{}{"charge": ch.ID, "lesson": ch.Metadata["lessonID"]},
+	})
+
+	return nil
+}
+
+// hookChargeRefunded records the refunds made outside the API, from the Stripe dashboard.
+// The platform pays for those, the tutor's transfer isn't reversed.
+func (p *payments) hookChargeRefunded(event *stripeGo.Event) error {
+	ch, err := eventCharge(event)
+	if err != nil {
+		return err
+	}
+
+	paid, ok := store.GetLedgerEntryByStripe(ch.ID)
+	if !ok {
+		return nil
+	}
+
+	student := entryOwner(paid, store.AccountStudentCash)
+	if student == "" {
+		return nil
+	}
+
+	if ch.Refunds != nil {
+		for _, r := range ch.Refunds.Data {
+			if _, recorded := r.Metadata[stripe.MetadataRecorded]; recorded {
+				continue
+			}
+
+			if _, posted := store.GetLedgerEntryByStripe(r.ID); posted {
+				continue
+			}
+
+			// a refunded gift card can't be redeemed for what was refunded
+			fromCard := takeBackGiftCard(paid, r.Amount, "charge refunded")
+
+			entry := store.NewLedgerEntry(store.EntryRefund, fmt.Sprintf("Refund of %s from Stripe", ch.ID),
+				store.Debit(store.AccountGiftCards, "", fromCard),
+				store.Debit(store.AccountPlatformRevenue, "", r.Amount-fromCard),
+				store.Credit(store.AccountStudentCash, student, r.Amount),
+			).In(paid.Currency).WithStripe(r.ID)
+			entry.Lesson = paid.Lesson
+			entry.Package = paid.Package
+			entry.GiftCard = paid.GiftCard
+
+			if err := entry.Post(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !ch.Refunded {
+		return nil
+	}
+
+	return setLessonChargeStatus(ch.ID, ch.Metadata["lessonID"], chargeRefunded)
+}
+
+// eventDispute gets the dispute of a dispute event, and the ledger entry of its charge
+func eventDispute(event *stripeGo.Event) (*stripeGo.Dispute, *store.LedgerEntry, error) {
+	var dispute stripeGo.Dispute
+	if err := json.Unmarshal(event.Data.Raw, &dispute); err != nil {
+		return nil, nil, errors.Wrap(err, "couldn't parse dispute")
+	}
+
+	if dispute.Charge == nil {
+		return &dispute, nil, nil
+	}
+
+	paid, _ := store.GetLedgerEntryByStripe(dispute.Charge.ID)
+	return &dispute, paid, nil
+}
+
+func (p *payments) hookDisputeCreated(event *stripeGo.Event) error {
+	dispute, paid, err := eventDispute(event)
+	if err != nil || paid == nil || paid.Lesson == nil {
+		return err
+	}
+
+	logger.Get().Warnf("charge %s of lesson %s is disputed: %s", dispute.Charge.ID, paid.Lesson.Hex(), dispute.Reason)
+	return setLessonChargeStatus(dispute.Charge.ID, paid.Lesson.Hex(), chargeDisputed)
+}
+
+// hookDisputeClosed records the money the student got back when the dispute was lost
+func (p *payments) hookDisputeClosed(event *stripeGo.Event) error {
+	dispute, paid, err := eventDispute(event)
+	if err != nil || paid == nil {
+		return err
+	}
+
+	var lessonHex string
+	if paid.Lesson != nil {
+		lessonHex = paid.Lesson.Hex()
+	}
+
+	if dispute.Status != stripeGo.DisputeStatusLost {
+		return setLessonChargeStatus(dispute.Charge.ID, lessonHex, chargeSucceeded)
+	}
+
+	if _, posted := store.GetLedgerEntryByStripe(dispute.ID); !posted {
+		student := entryOwner(paid, store.AccountStudentCash)
+		if student == "" {
+			return nil
+		}
+
+		fromCard := takeBackGiftCard(paid, dispute.Amount, "dispute lost")
+
+		entry := store.NewLedgerEntry(store.EntryDispute, fmt.Sprintf("Dispute of %s lost", dispute.Charge.ID),
+			store.Debit(store.AccountGiftCards, "", fromCard),
+			store.Debit(store.AccountPlatformRevenue, "", dispute.Amount-fromCard),
+			store.Credit(store.AccountStudentCash, student, dispute.Amount),
+		).In(paid.Currency).WithStripe(dispute.ID)
+		entry.Lesson = paid.Lesson
+		entry.Package = paid.Package
+		entry.GiftCard = paid.GiftCard
+
+		if err := entry.Post(); err != nil {
+			return err
+		}
+	}
+
+	return setLessonChargeStatus(dispute.Charge.ID, lessonHex, chargeRefunded)
+}
+
+// hookAccountUpdated saves what the tutor's connect account can do, and tells them
+// when it can't be paid out anymore
+func (p *payments) hookAccountUpdated(event *stripeGo.Event) error {
+	var account stripeGo.Account
+	if err := json.Unmarshal(event.Data.Raw, &account); err != nil {
+		return errors.Wrap(err, "couldn't parse account")
+	}
+
+	user, ok := NewUsers().ByConnectID(account.ID)
+	if !ok {
+		return nil
+	}
+
+	payoutsWere := user.Payments.PayoutsEnabled
+	if err := user.SetConnectStatus(account.ChargesEnabled, account.PayoutsEnabled); err != nil {
+		return err
+	}
+
+	if payoutsWere && !account.PayoutsEnabled {
+		notifications.Notify(&notifications.NotifyRequest{
+			User:    user.ID,
+			Type:    notifications.PayoutsDisabled,
+			Title:   "Payouts paused",
+			Message: "Stripe paused the payouts to your account until it gets more details. Please check your payout settings.",
+			Data:    map[string]interface{}{"account": account.ID},
+		})
+	}
+
+	return nil
+}
+
+// entryOwner is the owner of the entry's line of the account
+func entryOwner(entry *store.LedgerEntry, account store.LedgerAccount) bson.ObjectId {
+	for _, line := range entry.Lines {
+		if line.Account == account && line.Owner != nil {
+			return *line.Owner
+		}
+	}
+	return ""
+}
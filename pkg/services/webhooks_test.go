@@ -0,0 +1,108 @@
+package services
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/webhook"
+
+	"gitlab.com/learnt/api/config"
+	"gitlab.com/learnt/api/pkg/store"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// useWebhookSecret sets the secret the webhook events are signed with
+func useWebhookSecret() (restore func()) {
+	before := config.GetConfig().GetString("service.stripe.webhook_secret")
+	config.GetConfig().Set("service.stripe.webhook_secret", testWebhookSecret)
+	return func() { config.GetConfig().Set("service.stripe.webhook_secret", before) }
+}
+
+// postWebhook sends the payload to the webhook signed with the secret, and returns the status
+func postWebhook(payload []byte, secret string) int {
+	now := time.Now()
+	signature := fmt.Sprintf("t=%d,v1=%s", now.Unix(), hex.EncodeToString(webhook.ComputeSignature(now, payload, secret)))
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/payments/webhook", bytes.NewReader(payload))
+	c.Request.Header.Set("Stripe-Signature", signature)
+
+	GetPayments().WebHook(c)
+	return c.Writer.Status()
+}
+
+func testEvent(id string) []byte {
+	return []byte(fmt.Sprintf(`{"id":%q,"object":"event","type":"customer.created","data":{"object":{}}}`, id))
+}
+
+func TestWebHookRefusesUnsigned(t *testing.T) {
+	defer useWebhookSecret()()
+
+	if code := postWebhook(testEvent("evt_unsigned"), "whsec_other"); code != http.StatusBadRequest {
+		t.Errorf("expected an event signed with another secret refused, got %d", code)
+	}
+}
+
+func TestWebHookProcessesOnce(t *testing.T) {
+	dbSetup(t)
+	defer useWebhookSecret()()
+
+	id := "evt_" + time.Now().Format("20060102150405.000000000")
+	defer store.GetCollection("stripe_events").RemoveId(id)
+
+	// Stripe sends the event again when it doesn't hear back in time
+	for i := 0; i < 2; i++ {
+		if code := postWebhook(testEvent(id), testWebhookSecret); code != http.StatusOK {
+			t.Fatalf("expected event received, got %d", code)
+		}
+	}
+
+	stored, ok := store.GetStripeEvent(id)
+	if !ok {
+		t.Fatal("event not stored")
+	}
+
+	if stored.State != store.StripeEventIgnored || stored.Attempts != 1 {
+		t.Errorf("expected the event processed once, got %s after %d attempts", stored.State, stored.Attempts)
+	}
+}
+
+func TestWebHookReclaimsStuckEvent(t *testing.T) {
+	dbSetup(t)
+	defer useWebhookSecret()()
+
+	id := "evt_" + time.Now().Format("20060102150405.000000000")
+	defer store.GetCollection("stripe_events").RemoveId(id)
+
+	// the API stopped while processing the event, it was left received
+	stuck := &store.StripeEvent{ID: id, Type: "customer.created", Payload: string(testEvent(id))}
+	if _, err := stuck.Insert(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetPayments().ReplayEvent(id); err == nil {
+		t.Error("expected an event being processed not replayed")
+	}
+
+	if err := store.GetCollection("stripe_events").UpdateId(id, map[string]interface{}{
+		"$set": map[string]interface{}{"claimed_at": time.Now().Add(-2 * stripeEventStuckAfter)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := postWebhook(testEvent(id), testWebhookSecret); code != http.StatusOK {
+		t.Fatalf("expected event received, got %d", code)
+	}
+
+	if stored, _ := store.GetStripeEvent(id); stored.State != store.StripeEventIgnored || stored.Attempts != 1 {
+		t.Errorf("expected the stuck event processed when Stripe sent it again, got %+v", stored)
+	}
+}
@@ -0,0 +1,390 @@
+package store
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// AssignmentStatus is how far the students got with an assignment
+type AssignmentStatus string
+
+const (
+	AssignmentAssigned  AssignmentStatus = "assigned"
+	AssignmentSubmitted AssignmentStatus = "submitted"
+	AssignmentGraded    AssignmentStatus = "graded"
+	AssignmentOverdue   AssignmentStatus = "overdue"
+)
+
+// maxGrade is the best grade of a submission, grades go from 0 to it
+const maxGrade = 100
+
+// Assignment is homework the tutor gives the students of a lesson. The attachments
+// are files of the tutor's library.
+type Assignment struct {
+	ID       bson.ObjectId   `json:"_id" bson:"_id"`
+	Lesson   bson.ObjectId   `json:"lesson" bson:"lesson"`
+	Tutor    bson.ObjectId   `json:"tutor" bson:"tutor"`
+	Students []bson.ObjectId `json:"students" bson:"students"`
+
+	Title        string          `json:"title" bson:"title" binding:"required"`
+	Instructions string          `json:"instructions" bson:"instructions"`
+	DueAt        time.Time       `json:"due_at" bson:"due_at" binding:"required"`
+	Attachments  []bson.ObjectId `json:"attachments" bson:"attachments"`
+
+	// Submitted and Graded are the students who handed in their work, and the ones
+	// whose work was graded.
+	Submitted []bson.ObjectId `json:"submitted" bson:"submitted"`
+	Graded    []bson.ObjectId `json:"graded" bson:"graded"`
+
+	RemindedAt *time.Time `json:"-" bson:"reminded_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+// AssignmentSummary is the status of an assignment shown with its lesson
+type AssignmentSummary struct {
+	ID        bson.ObjectId    `json:"_id"`
+	Title     string           `json:"title"`
+	DueAt     time.Time        `json:"due_at"`
+	Status    AssignmentStatus `json:"status"`
+	Submitted int              `json:"submitted"`
+	Graded    int              `json:"graded"`
+}
+
+// AssignmentDto is an assignment with its attachments filled out
+type AssignmentDto struct {
+	*Assignment
+	Status      AssignmentStatus `json:"status"`
+	Attachments []*FilesMgo      `json:"attachments"`
+}
+
+// AssignmentSubmission is the work a student handed in for an assignment. The files
+// are uploads of the student.
+type AssignmentSubmission struct {
+	ID         bson.ObjectId `json:"_id" bson:"_id"`
+	Assignment bson.ObjectId `json:"assignment" bson:"assignment"`
+	Student    bson.ObjectId `json:"student" bson:"student"`
+
+	Text  string    `json:"text" bson:"text"`
+	Files []*Upload `json:"files" bson:"files"`
+
+	SubmittedAt time.Time `json:"submitted_at" bson:"submitted_at"`
+
+	Grade    *int       `json:"grade,omitempty" bson:"grade,omitempty"`
+	Comment  string     `json:"comment,omitempty" bson:"comment,omitempty"`
+	GradedAt *time.Time `json:"graded_at,omitempty" bson:"graded_at,omitempty"`
+}
+
+func hasID(ids []bson.ObjectId, id bson.ObjectId) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the details the tutor gives the assignment
+func (a *Assignment) Validate() error {
+	a.Title = strings.TrimSpace(a.Title)
+	if a.Title == "" {
+		return errors.New("assignment needs a title")
+	}
+
+	if a.DueAt.IsZero() {
+		return errors.New("assignment needs a due date")
+	}
+
+	if a.Attachments == nil {
+		a.Attachments = make([]bson.ObjectId, 0)
+	}
+
+	return nil
+}
+
+// StatusFor is how far the student got with the assignment
+func (a *Assignment) StatusFor(student bson.ObjectId, now time.Time) AssignmentStatus {
+	switch {
+	case hasID(a.Graded, student):
+		return AssignmentGraded
+	case hasID(a.Submitted, student):
+		return AssignmentSubmitted
+	case now.After(a.DueAt):
+		return AssignmentOverdue
+	}
+	return AssignmentAssigned
+}
+
+// Status is how far the students got with the assignment: it's only submitted or
+// graded once every student got there, and overdue when someone didn't hand in
+// their work in time.
+func (a *Assignment) Status(now time.Time) AssignmentStatus {
+	if len(a.Students) == 0 {
+		return AssignmentAssigned
+	}
+
+	status := AssignmentGraded
+	for _, student := range a.Students {
+		switch a.StatusFor(student, now) {
+		case AssignmentOverdue:
+			return AssignmentOverdue
+		case AssignmentAssigned:
+			status = AssignmentAssigned
+		case AssignmentSubmitted:
+			if status == AssignmentGraded {
+				status = AssignmentSubmitted
+			}
+		}
+	}
+
+	return status
+}
+
+// Summary is the status of the assignment shown with its lesson
+func (a *Assignment) Summary() AssignmentSummary {
+	return AssignmentSummary{
+		ID:        a.ID,
+		Title:     a.Title,
+		DueAt:     a.DueAt,
+		Status:    a.Status(time.Now()),
+		Submitted: len(a.Submitted),
+		Graded:    len(a.Graded),
+	}
+}
+
+// DTO fills out the assignment's attachments. The ones deleted from the library are left out.
+func (a *Assignment) DTO() (*AssignmentDto, error) {
+	files := make([]*FilesMgo, 0, len(a.Attachments))
+	if len(a.Attachments) > 0 {
+		if err := GetCollection("files").Find(bson.M{
+			"_id":     bson.M{"$in": a.Attachments},
+			"deleted": bson.M{"$ne": true},
+		}).All(&files); err != nil {
+			return nil, errors.Wrap(err, "couldn't get assignment attachments")
+		}
+	}
+
+	return &AssignmentDto{Assignment: a, Status: a.Status(time.Now()), Attachments: files}, nil
+}
+
+func (a *Assignment) Insert() error {
+	if !a.ID.Valid() {
+		a.ID = bson.NewObjectId()
+	}
+
+	a.CreatedAt = time.Now()
+	a.Submitted = make([]bson.ObjectId, 0)
+	a.Graded = make([]bson.ObjectId, 0)
+
+	return errors.Wrap(GetCollection("assignments").Insert(a), "couldn't insert assignment")
+}
+
+// Save updates the details of the assignment. A new due date can be reminded of again.
+func (a *Assignment) Save() error {
+	now := time.Now()
+	a.UpdatedAt = &now
+	a.RemindedAt = nil
+
+	err := GetCollection("assignments").UpdateId(a.ID, bson.M{
+		"$set": bson.M{
+			"title":        a.Title,
+			"instructions": a.Instructions,
+			"due_at":       a.DueAt,
+			"attachments":  a.Attachments,
+			"updated_at":   now,
+		},
+		"$unset": bson.M{"reminded_at": 1},
+	})
+
+	return errors.Wrap(err, "couldn't save assignment")
+}
+
+// Remove deletes the assignment and the work submitted for it
+func (a *Assignment) Remove() error {
+	if err := GetCollection("assignments").RemoveId(a.ID); err != nil {
+		return errors.Wrap(err, "couldn't remove assignment")
+	}
+
+	if _, err := GetCollection("assignment_submissions").RemoveAll(bson.M{"assignment": a.ID}); err != nil {
+		return errors.Wrap(err, "couldn't remove assignment submissions")
+	}
+
+	return nil
+}
+
+// SetReminded records that the students were reminded of the due date
+func (a *Assignment) SetReminded() error {
+	now := time.Now()
+	if err := GetCollection("assignments").UpdateId(a.ID, bson.M{"$set": bson.M{"reminded_at": now}}); err != nil {
+		return errors.Wrap(err, "couldn't set assignment reminded")
+	}
+
+	a.RemindedAt = &now
+	return nil
+}
+
+var errGradedAlready = errors.New("your work was graded already")
+
+// Submit hands in the student's work, replacing what they submitted before. Work
+// that was graded can't be replaced. Students who joined the lesson after the
+// assignment was given are added to it.
+func (a *Assignment) Submit(student bson.ObjectId, text string, files []*Upload) (*AssignmentSubmission, error) {
+	if hasID(a.Graded, student) {
+		return nil, errGradedAlready
+	}
+
+	if files == nil {
+		files = make([]*Upload, 0)
+	}
+
+	var submission AssignmentSubmission
+	_, err := GetCollection("assignment_submissions").Find(bson.M{
+		"assignment": a.ID,
+		"student":    student,
+		"graded_at":  bson.M{"$exists": false},
+	}).Apply(mgo.Change{
+		Update: bson.M{
+			"$set": bson.M{
+				"text":         text,
+				"files":        files,
+				"submitted_at": time.Now(),
+			},
+			"$setOnInsert": bson.M{"_id": bson.NewObjectId()},
+		},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &submission)
+
+	// a student has one submission, the upsert clashes with it when it was graded meanwhile
+	if mgo.IsDup(err) {
+		return nil, errGradedAlready
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't submit assignment")
+	}
+
+	if err := GetCollection("assignments").UpdateId(a.ID, bson.M{"$addToSet": bson.M{
+		"submitted": student,
+		"students":  student,
+	}}); err != nil {
+		return nil, errors.Wrap(err, "couldn't record assignment submission")
+	}
+
+	if !hasID(a.Submitted, student) {
+		a.Submitted = append(a.Submitted, student)
+	}
+
+	if !hasID(a.Students, student) {
+		a.Students = append(a.Students, student)
+	}
+
+	return &submission, nil
+}
+
+// Grade grades the student's submission, with a comment for them
+func (a *Assignment) Grade(submission *AssignmentSubmission, grade int, comment string) error {
+	if grade < 0 || grade > maxGrade {
+		return errors.Errorf("grade must be between 0 and %d", maxGrade)
+	}
+
+	now := time.Now()
+	if err := GetCollection("assignment_submissions").UpdateId(submission.ID, bson.M{"$set": bson.M{
+		"grade":     grade,
+		"comment":   strings.TrimSpace(comment),
+		"graded_at": now,
+	}}); err != nil {
+		return errors.Wrap(err, "couldn't grade submission")
+	}
+
+	if err := GetCollection("assignments").UpdateId(a.ID, bson.M{"$addToSet": bson.M{"graded": submission.Student}}); err != nil {
+		return errors.Wrap(err, "couldn't record assignment grade")
+	}
+
+	submission.Grade = &grade
+	submission.Comment = strings.TrimSpace(comment)
+	submission.GradedAt = &now
+
+	if !hasID(a.Graded, submission.Student) {
+		a.Graded = append(a.Graded, submission.Student)
+	}
+
+	return nil
+}
+
+// GetAssignment gets an assignment by ID
+func GetAssignment(id bson.ObjectId) (*Assignment, bool) {
+	var a Assignment
+	if err := GetCollection("assignments").FindId(id).One(&a); err != nil {
+		return nil, false
+	}
+	return &a, true
+}
+
+// GetLessonAssignments gets the assignments of the lesson, by due date
+func GetLessonAssignments(lesson bson.ObjectId) (assignments []Assignment, err error) {
+	err = GetCollection("assignments").Find(bson.M{"lesson": lesson}).Sort("due_at").All(&assignments)
+	return assignments, errors.Wrap(err, "couldn't get lesson assignments")
+}
+
+// GetAssignmentSummaries gets the status of the lessons' assignments, by lesson
+func GetAssignmentSummaries(lessons []bson.ObjectId) (map[bson.ObjectId][]AssignmentSummary, error) {
+	summaries := make(map[bson.ObjectId][]AssignmentSummary)
+	if len(lessons) == 0 {
+		return summaries, nil
+	}
+
+	var assignments []Assignment
+	if err := GetCollection("assignments").Find(bson.M{"lesson": bson.M{"$in": lessons}}).
+		Sort("due_at").All(&assignments); err != nil {
+		return nil, errors.Wrap(err, "couldn't get lesson assignments")
+	}
+
+	for i := range assignments {
+		lesson := assignments[i].Lesson
+		summaries[lesson] = append(summaries[lesson], assignments[i].Summary())
+	}
+
+	return summaries, nil
+}
+
+// assignmentSummaries gets the lesson's summaries, an empty list when it has no assignments
+func assignmentSummaries(summaries map[bson.ObjectId][]AssignmentSummary, lesson bson.ObjectId) []AssignmentSummary {
+	if s, ok := summaries[lesson]; ok {
+		return s
+	}
+	return make([]AssignmentSummary, 0)
+}
+
+// GetAssignmentsDue gets the assignments due before the time whose students
+// weren't reminded yet
+func GetAssignmentsDue(before time.Time) (assignments []Assignment, err error) {
+	err = GetCollection("assignments").Find(bson.M{
+		"due_at":      bson.M{"$gt": time.Now(), "$lte": before},
+		"reminded_at": bson.M{"$exists": false},
+	}).All(&assignments)
+	return assignments, errors.Wrap(err, "couldn't get assignments due")
+}
+
+// GetSubmission gets the student's submission for the assignment
+func GetSubmission(assignment, student bson.ObjectId) (*AssignmentSubmission, bool) {
+	var s AssignmentSubmission
+	if err := GetCollection("assignment_submissions").Find(bson.M{
+		"assignment": assignment,
+		"student":    student,
+	}).One(&s); err != nil {
+		return nil, false
+	}
+	return &s, true
+}
+
+// GetSubmissions gets the work submitted for the assignment
+func GetSubmissions(assignment bson.ObjectId) (submissions []AssignmentSubmission, err error) {
+	err = GetCollection("assignment_submissions").Find(bson.M{"assignment": assignment}).
+		Sort("submitted_at").All(&submissions)
+	return submissions, errors.Wrap(err, "couldn't get assignment submissions")
+}
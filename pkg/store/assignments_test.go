@@ -0,0 +1,101 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestAssignmentValidate(t *testing.T) {
+	a := Assignment{Title: "  Chapter 3 exercises ", DueAt: time.Now()}
+	if err := a.Validate(); err != nil {
+		t.Fatalf("expected valid assignment, got %v", err)
+	}
+
+	if a.Title != "Chapter 3 exercises" || a.Attachments == nil {
+		t.Errorf("expected title trimmed and attachments set, got %+v", a)
+	}
+
+	if err := (&Assignment{Title: " ", DueAt: time.Now()}).Validate(); err == nil {
+		t.Error("expected assignment without title to be invalid")
+	}
+
+	if err := (&Assignment{Title: "Essay"}).Validate(); err == nil {
+		t.Error("expected assignment without due date to be invalid")
+	}
+}
+
+func TestAssignmentStatus(t *testing.T) {
+	now := time.Now()
+	first, second := bson.NewObjectId(), bson.NewObjectId()
+
+	a := Assignment{
+		Students: []bson.ObjectId{first, second},
+		DueAt:    now.Add(time.Hour),
+	}
+
+	tests := []struct {
+		name      string
+		submitted []bson.ObjectId
+		graded    []bson.ObjectId
+		now       time.Time
+		first     AssignmentStatus
+		status    AssignmentStatus
+	}{
+		{"nothing handed in", nil, nil, now, AssignmentAssigned, AssignmentAssigned},
+		{"one submitted", []bson.ObjectId{first}, nil, now, AssignmentSubmitted, AssignmentAssigned},
+		{"all submitted", []bson.ObjectId{first, second}, nil, now, AssignmentSubmitted, AssignmentSubmitted},
+		{"one graded", []bson.ObjectId{first, second}, []bson.ObjectId{first}, now, AssignmentGraded, AssignmentSubmitted},
+		{"all graded", []bson.ObjectId{first, second}, []bson.ObjectId{first, second}, now, AssignmentGraded, AssignmentGraded},
+		{"one missing after due", []bson.ObjectId{first}, nil, now.Add(2 * time.Hour), AssignmentSubmitted, AssignmentOverdue},
+	}
+
+	for _, tt := range tests {
+		a.Submitted, a.Graded = tt.submitted, tt.graded
+
+		if got := a.StatusFor(first, tt.now); got != tt.first {
+			t.Errorf("%s: expected first student %s, got %s", tt.name, tt.first, got)
+		}
+
+		if got := a.Status(tt.now); got != tt.status {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.status, got)
+		}
+	}
+}
+
+func TestAssignmentSubmitAfterGrade(t *testing.T) {
+	dbSetup(t)
+
+	student := bson.NewObjectId()
+	a := &Assignment{Title: "Essay", DueAt: time.Now().Add(time.Hour), Students: []bson.ObjectId{student}}
+	if err := a.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Remove()
+
+	// the student reads the assignment before the tutor grades their work
+	stale := *a
+
+	submission, err := a.Submit(student, "first draft", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Grade(submission, 8, "good"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := stale.Submit(student, "second draft", nil); err == nil {
+		t.Error("expected the graded work not replaced")
+	}
+
+	count, err := GetCollection("assignment_submissions").Find(bson.M{"assignment": a.ID, "student": student}).Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Errorf("expected the one graded submission, got %d", count)
+	}
+}
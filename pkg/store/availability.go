@@ -14,6 +14,24 @@ type AvailabilitySlot struct {
 	ID   bson.ObjectId `json:"_id" bson:"_id"`
 	From time.Time     `json:"from" bson:"from"`
 	To   time.Time     `json:"to" bson:"to"`
+	// Timezone is the IANA zone the slot was set in. Recurrent slots keep their
+	// wall clock time in it, across daylight saving time changes.
+	Timezone string `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	// Source is the external calendar the slot was imported from, imported slots are read-only.
+	Source *bson.ObjectId `json:"source,omitempty" bson:"source,omitempty"`
+}
+
+// Location returns the slot's timezone, or fallback for slots saved without one
+func (slot *AvailabilitySlot) Location(fallback *time.Location) *time.Location {
+	if slot.Timezone == "" {
+		return fallback
+	}
+
+	if loc, err := time.LoadLocation(slot.Timezone); err == nil {
+		return loc
+	}
+
+	return fallback
 }
 
 func (slot *AvailabilitySlot) SetLocation(loc *time.Location) {
@@ -119,19 +137,28 @@ func (a *Availability) Add(slot *AvailabilitySlot, recurrent bool) error {
 	return nil
 }
 
-func (a *Availability) GetTimeline(recurrent bool, booked ...timeline.SlotProvider) *timeline.Availability {
+// GetTimeline builds the timeline of the availability. Recurrent slots are expanded
+// in their timezone, or in loc when they have none, and so are the weekly booked slots.
+func (a *Availability) GetTimeline(loc *time.Location, recurrent bool, booked ...timeline.SlotProvider) *timeline.Availability {
 	tl := timeline.Timeline{}
 	if a.Recurrent != nil {
 		for _, slot := range a.Recurrent {
+			slotLoc := slot.Location(loc)
 			_ = tl.Add(&timeline.Slot{
 				ID:        slot.ID.Hex(),
-				From:      slot.From.Add(0),
-				To:        slot.To.Add(0),
+				From:      slot.From.In(slotLoc),
+				To:        slot.To.In(slotLoc),
 				Occurence: timeline.Weekly,
 			})
 		}
 	}
 
+	for _, slot := range booked {
+		if slot.GetOccurence() == timeline.Weekly {
+			slot.SetTimezone(loc)
+		}
+	}
+
 	if recurrent {
 		return tl.GetAvailability(booked...)
 	}
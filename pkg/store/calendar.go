@@ -134,13 +134,10 @@ func (u *UserMgo) GetAvailability(recurrent bool, includeLessons bool) (availabi
 
 	if includeLessons {
 		var lessons = u.GetLessonsTimelineSlots()
-		return u.Tutoring.Availability.GetTimeline(recurrent, lessons...)
+		return u.Tutoring.Availability.GetTimeline(u.TimezoneLocation(), recurrent, lessons...)
 	}
-	// var lessons = u.GetLessonsTimelineSlots()
-
-	// u.Tutoring.Availability.SetLocation(u.TimezoneLocation())
 
-	return u.Tutoring.Availability.GetTimeline(recurrent)
+	return u.Tutoring.Availability.GetTimeline(u.TimezoneLocation(), recurrent)
 }
 
 func (u *UserMgo) GetAvailabilityWithBlackout(recurrent bool, blackout ...timeline.SlotProvider) (availability *timeline.Availability) {
@@ -153,16 +150,47 @@ func (u *UserMgo) GetAvailabilityWithBlackout(recurrent bool, blackout ...timeli
 
 	// u.Tutoring.Availability.SetLocation(u.TimezoneLocation())
 
-	return u.Tutoring.Availability.GetTimeline(recurrent, blackout...)
+	return u.Tutoring.Availability.GetTimeline(u.TimezoneLocation(), recurrent, blackout...)
 }
 
-// Get blackout - lessons
+// Get blackout - lessons. The busy time imported from external calendars is part of
+// the blackout that doesn't repeat.
 func (u *UserMgo) GetBlackout(recurrent bool) (availability *timeline.Availability) {
-	if u.Tutoring == nil || u.Tutoring.Blackout == nil {
+	if u.Tutoring == nil || (u.Tutoring.Blackout == nil && len(u.Tutoring.ExternalBusy) == 0) {
 		return availability
 	}
 
-	return u.Tutoring.Blackout.GetTimeline(recurrent)
+	blackout := &Availability{Slots: u.Tutoring.ExternalBusy}
+	if u.Tutoring.Blackout != nil {
+		blackout.Recurrent = u.Tutoring.Blackout.Recurrent
+		blackout.Slots = append(append([]*AvailabilitySlot{}, u.Tutoring.Blackout.Slots...), u.Tutoring.ExternalBusy...)
+	}
+
+	return blackout.GetTimeline(u.TimezoneLocation(), recurrent)
+}
+
+// blackoutSlots expands the blackout between from and to into slots that don't repeat
+func (u *UserMgo) blackoutSlots(from, to time.Time) []timeline.SlotProvider {
+	blackout := u.GetBlackout(false)
+	if blackout == nil {
+		return nil
+	}
+
+	slots, err := blackout.Get(from, to)
+	if err != nil {
+		return nil
+	}
+
+	for i, slot := range slots {
+		slots[i] = &timeline.Slot{
+			ID:        slot.GetID(),
+			From:      slot.GetFrom(),
+			To:        slot.GetTo(),
+			Occurence: timeline.None,
+		}
+	}
+
+	return slots
 }
 
 func (u *UserMgo) IsAvailable(from, to time.Time, recurrentOny bool) bool {
@@ -172,10 +200,14 @@ func (u *UserMgo) IsAvailable(from, to time.Time, recurrentOny bool) bool {
 	}
 
 	// This gets availability and excludes availability if lesson is booked for time slot
-	av := u.GetAvailability(recurrentOny, true)
+	// or the tutor blacked it out
+	booked := append(u.GetLessonsTimelineSlots(), u.blackoutSlots(from, to)...)
+	av := u.Tutoring.Availability.GetTimeline(u.TimezoneLocation(), recurrentOny, booked...)
 
 	if recurrentOny {
-		return av.IsAvailableRecurrent(from, to)
+		// weeks are added to the interval, keep it on the tutor's wall clock
+		loc := u.TimezoneLocation()
+		return av.IsAvailableRecurrent(from.In(loc), to.In(loc))
 	}
 
 	return av.IsAvailable(from, to)
@@ -196,6 +228,10 @@ func (u *UserMgo) AddAvailability(slot *AvailabilitySlot, recurrent bool) error
 		u.Tutoring.Availability = &Availability{}
 	}
 
+	if slot.Timezone == "" {
+		slot.Timezone = u.TimezoneLocation().String()
+	}
+
 	if err := u.Tutoring.Availability.Add(slot, recurrent); err != nil {
 		return errors.Wrap(err, "Failed to add availability")
 	}
@@ -240,6 +276,10 @@ func (u *UserMgo) AddBlackout(slot *AvailabilitySlot, recurrent bool) error {
 		u.Tutoring.Blackout = &Availability{}
 	}
 
+	if slot.Timezone == "" {
+		slot.Timezone = u.TimezoneLocation().String()
+	}
+
 	if err := u.Tutoring.Blackout.Add(slot, recurrent); err != nil {
 		return errors.Wrap(err, "Failed to add availability")
 	}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// CalendarFeed is the secret of the user's lessons feed. Calendar apps can't send
+// a bearer token, so the secret in the feed's URL is what authenticates them.
+type CalendarFeed struct {
+	Secret    string    `json:"-" bson:"secret"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// RotateCalendarFeed gives the user a new feed secret. The URL with the old one stops working.
+func (u *UserMgo) RotateCalendarFeed() (*CalendarFeed, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.Wrap(err, "couldn't generate calendar feed secret")
+	}
+
+	feed := &CalendarFeed{
+		Secret:    hex.EncodeToString(secret),
+		CreatedAt: time.Now(),
+	}
+
+	if err := GetCollection("users").UpdateId(u.ID, bson.M{"$set": bson.M{"calendar_feed": feed}}); err != nil {
+		return nil, errors.Wrap(err, "couldn't save calendar feed")
+	}
+
+	u.CalendarFeed = feed
+	return feed, nil
+}
+
+// RevokeCalendarFeed removes the user's feed secret, until they ask for a new one
+func (u *UserMgo) RevokeCalendarFeed() error {
+	if err := GetCollection("users").UpdateId(u.ID, bson.M{"$unset": bson.M{"calendar_feed": ""}}); err != nil {
+		return errors.Wrap(err, "couldn't revoke calendar feed")
+	}
+
+	u.CalendarFeed = nil
+	return nil
+}
+
+// GetUserByCalendarFeed gets the user the feed secret belongs to
+func GetUserByCalendarFeed(secret string) (*UserMgo, bool) {
+	if secret == "" {
+		return nil, false
+	}
+
+	var u UserMgo
+	if err := GetCollection("users").Find(bson.M{"calendar_feed.secret": secret, "disabled": false}).One(&u); err != nil {
+		return nil, false
+	}
+
+	return &u, true
+}
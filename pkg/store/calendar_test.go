@@ -64,3 +64,55 @@ func TestComparableDay(t *testing.T) {
 		})
 	}
 }
+
+func TestRecurrentAvailabilityKeepsLocalTime(t *testing.T) {
+	zone, err := time.LoadLocation("Europe/Bucharest")
+	if err != nil {
+		t.Fatal("could not load timezone")
+	}
+
+	// Mondays 9-11am, saved in UTC as it comes back from the database
+	av := &Availability{Recurrent: []*AvailabilitySlot{{
+		From:     time.Date(2021, time.March, 22, 9, 0, 0, 0, zone).UTC(),
+		To:       time.Date(2021, time.March, 22, 11, 0, 0, 0, zone).UTC(),
+		Timezone: zone.String(),
+	}}}
+
+	// daylight saving time starts on March 28 2021
+	slots, err := av.GetTimeline(time.UTC, true).Get(
+		time.Date(2021, time.March, 29, 0, 0, 0, 0, zone),
+		time.Date(2021, time.March, 30, 0, 0, 0, 0, zone),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(slots) != 1 {
+		t.Fatalf("expected one slot, got %d", len(slots))
+	}
+
+	if from := slots[0].GetFrom().In(zone); from.Hour() != 9 || from.Weekday() != time.Monday {
+		t.Fatalf("expected slot on Monday 9am, got %s", from)
+	}
+}
+
+func TestLessonBuffer(t *testing.T) {
+	tutor := &UserMgo{Tutoring: &Tutoring{LessonBuffer: 15, TravelBuffer: 60}}
+
+	if b := tutor.lessonBuffer(MeetOnline, MeetOnline); b != 15*time.Minute {
+		t.Fatalf("expected lesson buffer between online lessons, got %s", b)
+	}
+
+	if b := tutor.lessonBuffer(MeetOnline, MeetInPerson); b != time.Hour {
+		t.Fatalf("expected travel buffer next to an in-person lesson, got %s", b)
+	}
+
+	tutor.Tutoring.TravelBuffer = 0
+	if b := tutor.lessonBuffer(MeetInPerson, MeetInPerson); b != defaultTravelBuffer*time.Minute {
+		t.Fatalf("expected default travel buffer, got %s", b)
+	}
+
+	if b := (&UserMgo{}).lessonBuffer(MeetInPerson, MeetInPerson); b != 0 {
+		t.Fatalf("expected no buffer for students, got %s", b)
+	}
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// CancellationTier applies to lessons cancelled at least MinHours before they start
+type CancellationTier struct {
+	MinHours float64 `json:"min_hours" bson:"min_hours"`
+	// Percent is the share of the lesson's cost refunded to the students for
+	// student tiers, or the share of the tutor's pay taken as penalty for tutor tiers.
+	Percent int `json:"percent" bson:"percent"`
+}
+
+// CancellationPolicy decides how much students get back when they cancel a lesson,
+// and how much tutors are penalized when they do.
+type CancellationPolicy struct {
+	Name         string             `json:"name" bson:"name"`
+	StudentTiers []CancellationTier `json:"student_tiers" bson:"student_tiers"`
+	TutorTiers   []CancellationTier `json:"tutor_tiers" bson:"tutor_tiers"`
+}
+
+// DefaultCancellationPolicy is used when neither the tutor nor the platform set one:
+// students get a full refund up to 24 hours before the lesson, and tutors aren't penalized.
+var DefaultCancellationPolicy = CancellationPolicy{
+	Name: "default",
+	StudentTiers: []CancellationTier{
+		{MinHours: 24, Percent: 100},
+		{MinHours: 0, Percent: 0},
+	},
+}
+
+func validateTiers(tiers []CancellationTier) error {
+	for _, tier := range tiers {
+		if tier.MinHours < 0 {
+			return fmt.Errorf("tier hours can't be negative")
+		}
+		if tier.Percent < 0 || tier.Percent > 100 {
+			return fmt.Errorf("tier percent must be between 0 and 100")
+		}
+	}
+	return nil
+}
+
+// Validate checks the tiers of the policy
+func (p *CancellationPolicy) Validate() error {
+	if len(p.StudentTiers) == 0 {
+		return fmt.Errorf("cancellation policy needs at least one student tier")
+	}
+
+	if err := validateTiers(p.StudentTiers); err != nil {
+		return errors.Wrap(err, "invalid student tier")
+	}
+
+	return errors.Wrap(validateTiers(p.TutorTiers), "invalid tutor tier")
+}
+
+// tierPercent returns the percent of the tier with the most hours that's still
+// under hoursBefore, or fallback when no tier applies.
+func tierPercent(tiers []CancellationTier, hoursBefore float64, fallback int) int {
+	sorted := append([]CancellationTier(nil), tiers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MinHours > sorted[j].MinHours })
+
+	for _, tier := range sorted {
+		if hoursBefore >= tier.MinHours {
+			return tier.Percent
+		}
+	}
+
+	return fallback
+}
+
+// RefundPercent is the share of the cost refunded to a student cancelling hoursBefore the lesson
+func (p *CancellationPolicy) RefundPercent(hoursBefore float64) int {
+	return tierPercent(p.StudentTiers, hoursBefore, 0)
+}
+
+// PenaltyPercent is the share of the pay taken from a tutor cancelling hoursBefore the lesson
+func (p *CancellationPolicy) PenaltyPercent(hoursBefore float64) int {
+	return tierPercent(p.TutorTiers, hoursBefore, 0)
+}
+
+// CancellationOverride is set by admins to replace what the policy computes
+type CancellationOverride struct {
+	RefundPercent  *int `json:"refund_percent"`
+	PenaltyPercent *int `json:"penalty_percent"`
+}
+
+// Validate checks the override percents
+func (o *CancellationOverride) Validate() error {
+	for _, percent := range []*int{o.RefundPercent, o.PenaltyPercent} {
+		if percent != nil && (*percent < 0 || *percent > 100) {
+			return fmt.Errorf("override percent must be between 0 and 100")
+		}
+	}
+	return nil
+}
+
+// Where the policy applied to a cancellation comes from
+const (
+	PolicySourceTutor    = "tutor"
+	PolicySourcePlatform = "platform"
+	PolicySourceDefault  = "default"
+)
+
+// LessonCancellation records the policy applied when a lesson was cancelled and
+// the amounts it computed, in cents.
+type LessonCancellation struct {
+	Policy       CancellationPolicy `json:"policy" bson:"policy"`
+	PolicySource string             `json:"policy_source" bson:"policy_source"`
+
+	By          bson.ObjectId `json:"by" bson:"by"`
+	Role        string        `json:"role" bson:"role"`
+	HoursBefore float64       `json:"hours_before" bson:"hours_before"`
+
+	RefundPercent  int   `json:"refund_percent" bson:"refund_percent"`
+	PenaltyPercent int   `json:"penalty_percent" bson:"penalty_percent"`
+	StudentCharge  int64 `json:"student_charge" bson:"student_charge"`
+	StudentRefund  int64 `json:"student_refund" bson:"student_refund"`
+	TutorPenalty   int64 `json:"tutor_penalty" bson:"tutor_penalty"`
+
+	OverriddenBy *bson.ObjectId `json:"overridden_by,omitempty" bson:"overridden_by,omitempty"`
+	At           time.Time      `json:"at" bson:"at"`
+}
+
+// NewLessonCancellation applies the policy to a lesson cancelled now. studentCost and
+// tutorPay are what a single seat of the lesson costs and pays, in cents.
+func NewLessonCancellation(policy CancellationPolicy, source string, lesson *LessonMgo, by *UserMgo, studentCost, tutorPay int64) *LessonCancellation {
+	hoursBefore := math.Max(0, lesson.StartsAt.Sub(time.Now()).Hours())
+
+	c := &LessonCancellation{
+		Policy:       policy,
+		PolicySource: source,
+		By:           by.ID,
+		Role:         "student",
+		HoursBefore:  hoursBefore,
+		At:           time.Now(),
+	}
+
+	switch {
+	case lesson.Tutor == by.ID:
+		c.Role = "tutor"
+		c.RefundPercent = 100
+		c.PenaltyPercent = policy.PenaltyPercent(hoursBefore)
+	case lesson.HasStudent(by.ID):
+		c.RefundPercent = policy.RefundPercent(hoursBefore)
+	default:
+		// lessons cancelled by admins are fully refunded, unless overridden
+		c.Role = "admin"
+		c.RefundPercent = 100
+	}
+
+	c.computeAmounts(studentCost, tutorPay)
+
+	return c
+}
+
+// Override replaces the percents computed by the policy and recomputes the amounts
+func (c *LessonCancellation) Override(admin *UserMgo, o *CancellationOverride, studentCost, tutorPay int64) {
+	if o.RefundPercent != nil {
+		c.RefundPercent = *o.RefundPercent
+	}
+	if o.PenaltyPercent != nil {
+		c.PenaltyPercent = *o.PenaltyPercent
+	}
+
+	c.OverriddenBy = &admin.ID
+	c.computeAmounts(studentCost, tutorPay)
+}
+
+func (c *LessonCancellation) computeAmounts(studentCost, tutorPay int64) {
+	c.StudentRefund = int64(math.Round(float64(studentCost) * float64(c.RefundPercent) / 100))
+	c.StudentCharge = studentCost - c.StudentRefund
+	c.TutorPenalty = int64(math.Round(float64(tutorPay) * float64(c.PenaltyPercent) / 100))
+}
+
+// SetCancellation saves the cancellation applied to the lesson
+func (l *LessonMgo) SetCancellation(c *LessonCancellation) error {
+	l.Cancellation = c
+
+	err := GetCollection("lessons").UpdateId(l.ID, bson.M{"$set": bson.M{"cancellation": c}})
+	return errors.Wrap(err, "couldn't save lesson cancellation")
+}
+
+// SetSeatCancellation saves the cancellation applied to a student leaving a group lesson
+func (l *LessonMgo) SetSeatCancellation(student bson.ObjectId, c *LessonCancellation) error {
+	if l.SeatCancellations == nil {
+		l.SeatCancellations = make(map[string]*LessonCancellation)
+	}
+	l.SeatCancellations[student.Hex()] = c
+
+	err := GetCollection("lessons").UpdateId(l.ID, bson.M{"$set": bson.M{"seat_cancellations." + student.Hex(): c}})
+	return errors.Wrap(err, "couldn't save seat cancellation")
+}
+
+type platformPolicy struct {
+	ID                 string `bson:"_id"`
+	CancellationPolicy `bson:",inline"`
+}
+
+const platformPolicyID = "platform"
+
+// GetPlatformCancellationPolicy gets the policy for tutors without their own
+func GetPlatformCancellationPolicy() (*CancellationPolicy, bool) {
+	var p platformPolicy
+	if err := GetCollection("cancellation_policies").FindId(platformPolicyID).One(&p); err != nil {
+		return nil, false
+	}
+	return &p.CancellationPolicy, true
+}
+
+// SetPlatformCancellationPolicy saves the policy for tutors without their own
+func SetPlatformCancellationPolicy(policy *CancellationPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	_, err := GetCollection("cancellation_policies").UpsertId(platformPolicyID, platformPolicy{
+		ID:                 platformPolicyID,
+		CancellationPolicy: *policy,
+	})
+
+	return errors.Wrap(err, "couldn't save platform cancellation policy")
+}
+
+// CancellationPolicyFor returns the tutor's policy, falling back to the platform's
+// and then to the default one, with where it comes from.
+func CancellationPolicyFor(tutor *UserMgo) (CancellationPolicy, string) {
+	if tutor != nil && tutor.Tutoring != nil && tutor.Tutoring.CancellationPolicy != nil {
+		return *tutor.Tutoring.CancellationPolicy, PolicySourceTutor
+	}
+
+	if policy, ok := GetPlatformCancellationPolicy(); ok {
+		return *policy, PolicySourcePlatform
+	}
+
+	return DefaultCancellationPolicy, PolicySourceDefault
+}
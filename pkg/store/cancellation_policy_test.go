@@ -0,0 +1,80 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestCancellationPolicyPercents(t *testing.T) {
+	policy := CancellationPolicy{
+		StudentTiers: []CancellationTier{
+			{MinHours: 0, Percent: 0},
+			{MinHours: 48, Percent: 100},
+			{MinHours: 24, Percent: 50},
+		},
+		TutorTiers: []CancellationTier{
+			{MinHours: 0, Percent: 25},
+			{MinHours: 24, Percent: 0},
+		},
+	}
+
+	tests := []struct {
+		hours   float64
+		refund  int
+		penalty int
+	}{
+		{hours: 72, refund: 100, penalty: 0},
+		{hours: 48, refund: 100, penalty: 0},
+		{hours: 30, refund: 50, penalty: 0},
+		{hours: 12, refund: 0, penalty: 25},
+		{hours: 0, refund: 0, penalty: 25},
+	}
+
+	for _, tt := range tests {
+		if got := policy.RefundPercent(tt.hours); got != tt.refund {
+			t.Errorf("RefundPercent(%v) = %d, want %d", tt.hours, got, tt.refund)
+		}
+		if got := policy.PenaltyPercent(tt.hours); got != tt.penalty {
+			t.Errorf("PenaltyPercent(%v) = %d, want %d", tt.hours, got, tt.penalty)
+		}
+	}
+}
+
+func TestNewLessonCancellation(t *testing.T) {
+	tutor := &UserMgo{ID: bson.NewObjectId()}
+	student := &UserMgo{ID: bson.NewObjectId()}
+	admin := &UserMgo{ID: bson.NewObjectId()}
+
+	lesson := &LessonMgo{
+		Tutor:    tutor.ID,
+		Students: []bson.ObjectId{student.ID},
+		StartsAt: time.Now().Add(30 * time.Hour),
+	}
+
+	policy := CancellationPolicy{
+		StudentTiers: []CancellationTier{{MinHours: 48, Percent: 100}, {MinHours: 24, Percent: 50}},
+		TutorTiers:   []CancellationTier{{MinHours: 0, Percent: 10}},
+	}
+
+	c := NewLessonCancellation(policy, PolicySourceTutor, lesson, student, 5000, 4000)
+	if c.Role != "student" || c.StudentRefund != 2500 || c.StudentCharge != 2500 || c.TutorPenalty != 0 {
+		t.Errorf("unexpected student cancellation %+v", c)
+	}
+
+	c = NewLessonCancellation(policy, PolicySourceTutor, lesson, tutor, 5000, 4000)
+	if c.Role != "tutor" || c.StudentRefund != 5000 || c.StudentCharge != 0 || c.TutorPenalty != 400 {
+		t.Errorf("unexpected tutor cancellation %+v", c)
+	}
+
+	refund := 0
+	c.Override(admin, &CancellationOverride{RefundPercent: &refund}, 5000, 4000)
+	if c.OverriddenBy == nil || c.StudentCharge != 5000 || c.TutorPenalty != 400 {
+		t.Errorf("unexpected overridden cancellation %+v", c)
+	}
+
+	if err := (&CancellationPolicy{}).Validate(); err == nil {
+		t.Error("policy without student tiers should be invalid")
+	}
+}
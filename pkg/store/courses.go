@@ -0,0 +1,506 @@
+package store
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/utils/rrule"
+)
+
+// maxCourseSessions is how many sessions a course can have
+const maxCourseSessions = 52
+
+// CourseStatus tells if students can enroll in a course
+type CourseStatus string
+
+const (
+	CourseOpen   CourseStatus = "open"
+	CourseClosed CourseStatus = "closed"
+)
+
+// CourseSession is a session of a course, taught in a lesson of its own
+type CourseSession struct {
+	ID          bson.ObjectId `json:"_id" bson:"_id"`
+	Title       string        `json:"title" bson:"title"`
+	Description string        `json:"description" bson:"description"`
+	// Materials are files of the tutor's library used in the session.
+	Materials []bson.ObjectId `json:"materials" bson:"materials"`
+}
+
+// Course is a curriculum a tutor sells as a whole: an ordered list of sessions taught
+// on a schedule, for one price. Enrolling books a lesson for every session.
+type Course struct {
+	ID          bson.ObjectId `json:"_id" bson:"_id"`
+	Tutor       bson.ObjectId `json:"tutor" bson:"tutor"`
+	Subject     bson.ObjectId `json:"subject" bson:"subject" binding:"required"`
+	Title       string        `json:"title" bson:"title" binding:"required"`
+	Description string        `json:"description" bson:"description"`
+
+	Sessions []*CourseSession `json:"sessions" bson:"sessions"`
+
+	// Price is what the student pays for the whole course, in minor units of the tutor's Currency.
+	Price    int64    `json:"price" bson:"price"`
+	Currency Currency `json:"currency,omitempty" bson:"currency,omitempty"`
+
+	// RRule is how the sessions repeat from the start the student picks, e.g.
+	// FREQ=WEEKLY;BYDAY=TU,TH. It ends with the sessions, so it has no COUNT or UNTIL.
+	RRule    string `json:"rrule" bson:"rrule"`
+	Duration string `json:"duration" bson:"duration"`
+	Meet     Meet   `json:"meet" bson:"meet"`
+	Location string `json:"location,omitempty" bson:"location,omitempty"`
+
+	Status CourseStatus `json:"status" bson:"status"`
+	// Enrolled is how many students enrolled in the course.
+	Enrolled int `json:"enrolled" bson:"enrolled"`
+
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+// CourseDto is a course with its tutor and subject
+type CourseDto struct {
+	*Course
+	Tutor   *PublicUserDto `json:"tutor"`
+	Subject *Subject       `json:"subject"`
+	// DisplayPrice is the price in the viewer's currency, when it isn't the course's
+	DisplayPrice *Money `json:"display_price,omitempty"`
+}
+
+// Validate checks the course and sets the IDs of its new sessions
+func (c *Course) Validate() error {
+	c.Title = strings.TrimSpace(c.Title)
+	if c.Title == "" {
+		return errors.New("course needs a title")
+	}
+
+	if len(c.Sessions) == 0 || len(c.Sessions) > maxCourseSessions {
+		return errors.Errorf("course must have between 1 and %d sessions", maxCourseSessions)
+	}
+
+	for i, session := range c.Sessions {
+		if session == nil || strings.TrimSpace(session.Title) == "" {
+			return errors.Errorf("session %d needs a title", i+1)
+		}
+
+		if !session.ID.Valid() {
+			session.ID = bson.NewObjectId()
+		}
+
+		if session.Materials == nil {
+			session.Materials = make([]bson.ObjectId, 0)
+		}
+	}
+
+	if c.Price <= 0 {
+		return errors.New("course needs a price")
+	}
+
+	duration, err := time.ParseDuration(c.Duration)
+	if err != nil || duration < 30*time.Minute || duration > 4*time.Hour {
+		return errors.New("sessions must last between 30 minutes and 4 hours")
+	}
+
+	if len(c.Sessions) > 1 {
+		rule, err := rrule.Parse(c.RRule)
+		if err != nil {
+			return errors.Wrap(err, "invalid course schedule")
+		}
+
+		if rule.IsBounded() {
+			return errors.New("course schedule ends with its sessions, it can't have COUNT or UNTIL")
+		}
+	}
+
+	if c.Meet != MeetOnline && c.Meet != MeetInPerson {
+		return errors.New("course must meet online or in person")
+	}
+
+	if c.Status == "" {
+		c.Status = CourseOpen
+	}
+
+	return nil
+}
+
+// Rule is the schedule of the course's sessions, nil when it has a single session
+func (c *Course) Rule() (*rrule.Rule, error) {
+	if len(c.Sessions) < 2 {
+		return nil, nil
+	}
+
+	rule, err := rrule.Parse(c.RRule)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid course schedule")
+	}
+
+	rule.Count = len(c.Sessions)
+	return rule, nil
+}
+
+// HourlyRate is the rate of the course's lessons, so they add up to its price
+func (c *Course) HourlyRate() int64 {
+	duration, err := time.ParseDuration(c.Duration)
+	if err != nil || len(c.Sessions) == 0 {
+		return 0
+	}
+
+	hours := duration.Hours() * float64(len(c.Sessions))
+	return int64(math.Round(float64(c.Price) / hours))
+}
+
+func (c *Course) Insert() error {
+	if !c.ID.Valid() {
+		c.ID = bson.NewObjectId()
+	}
+
+	c.CreatedAt = time.Now()
+
+	return errors.Wrap(GetCollection("courses").Insert(c), "couldn't insert course")
+}
+
+// Save updates the course. The students enrolled keep the lessons booked already.
+func (c *Course) Save() error {
+	now := time.Now()
+	c.UpdatedAt = &now
+
+	err := GetCollection("courses").UpdateId(c.ID, bson.M{"$set": bson.M{
+		"subject":     c.Subject,
+		"title":       c.Title,
+		"description": c.Description,
+		"sessions":    c.Sessions,
+		"price":       c.Price,
+		"rrule":       c.RRule,
+		"duration":    c.Duration,
+		"meet":        c.Meet,
+		"location":    c.Location,
+		"status":      c.Status,
+		"updated_at":  now,
+	}})
+
+	return errors.Wrap(err, "couldn't save course")
+}
+
+// AddEnrolled counts a student that enrolled in the course
+func (c *Course) AddEnrolled() error {
+	if err := GetCollection("courses").UpdateId(c.ID, bson.M{"$inc": bson.M{"enrolled": 1}}); err != nil {
+		return errors.Wrap(err, "couldn't count course enrollment")
+	}
+
+	c.Enrolled++
+	return nil
+}
+
+// DTO fills out the course's tutor and subject
+func (c *Course) DTO() (*CourseDto, error) {
+	var tutor UserMgo
+	if err := GetCollection("users").FindId(c.Tutor).One(&tutor); err != nil {
+		return nil, errors.Wrap(err, "couldn't get tutor")
+	}
+
+	subject, ok := GetSubject(c.Subject)
+	if !ok {
+		return nil, errors.New("couldn't get subject")
+	}
+
+	return &CourseDto{Course: c, Tutor: tutor.ToPublicDto(), Subject: subject}, nil
+}
+
+// GetCourse gets a course by ID
+func GetCourse(id bson.ObjectId) (*Course, bool) {
+	var c Course
+	if err := GetCollection("courses").FindId(id).One(&c); err != nil {
+		return nil, false
+	}
+	return &c, true
+}
+
+// GetTutorCourses gets the tutor's courses, newest first. Closed ones are left out
+// unless all is set.
+func GetTutorCourses(tutor bson.ObjectId, all bool) (courses []Course, err error) {
+	query := bson.M{"tutor": tutor}
+	if !all {
+		query["status"] = CourseOpen
+	}
+
+	err = GetCollection("courses").Find(query).Sort("-created_at").All(&courses)
+	return courses, errors.Wrap(err, "couldn't get tutor courses")
+}
+
+// EnrollmentStatus is the state of a student's enrollment in a course
+type EnrollmentStatus string
+
+const (
+	EnrollmentActive    EnrollmentStatus = "active"
+	EnrollmentCompleted EnrollmentStatus = "completed"
+)
+
+// CourseEnrollment is a student taking a course. It has a lesson for every session
+// of the course, in the same order, and is completed when they all ended.
+type CourseEnrollment struct {
+	ID      bson.ObjectId `json:"_id" bson:"_id"`
+	Course  bson.ObjectId `json:"course" bson:"course"`
+	Tutor   bson.ObjectId `json:"tutor" bson:"tutor"`
+	Student bson.ObjectId `json:"student" bson:"student"`
+
+	Lessons []bson.ObjectId `json:"lessons" bson:"lessons"`
+	// Completed are the lessons that were taught, Missed the ones cancelled,
+	// expired or missed by someone.
+	Completed []bson.ObjectId `json:"completed" bson:"completed"`
+	Missed    []bson.ObjectId `json:"missed" bson:"missed"`
+
+	Status      EnrollmentStatus `json:"status" bson:"status"`
+	CreatedAt   time.Time        `json:"created_at" bson:"created_at"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+	// Active keys the enrollment by course and student until it's over. Its index is unique,
+	// a student has one active enrollment in a course.
+	Active string `json:"-" bson:"active,omitempty"`
+}
+
+// EnrollmentSession is a session of the course with the lesson it's taught in
+type EnrollmentSession struct {
+	*CourseSession
+	Lesson   bson.ObjectId `json:"lesson"`
+	StartsAt time.Time     `json:"starts_at"`
+	EndsAt   time.Time     `json:"ends_at"`
+	State    LessonState   `json:"state"`
+}
+
+// EnrollmentDto is an enrollment with its course, student and the state of its sessions
+type EnrollmentDto struct {
+	*CourseEnrollment
+	Course   *CourseDto          `json:"course"`
+	Student  *PublicUserDto      `json:"student"`
+	Progress float64             `json:"progress"`
+	Sessions []EnrollmentSession `json:"sessions"`
+}
+
+// DTO fills out the enrollment's course, student and lessons. The sessions are
+// matched to the lessons in order.
+func (e *CourseEnrollment) DTO() (*EnrollmentDto, error) {
+	course, ok := GetCourse(e.Course)
+	if !ok {
+		return nil, errors.New("couldn't get course")
+	}
+
+	courseDto, err := course.DTO()
+	if err != nil {
+		return nil, err
+	}
+
+	var student UserMgo
+	if err := GetCollection("users").FindId(e.Student).One(&student); err != nil {
+		return nil, errors.Wrap(err, "couldn't get student")
+	}
+
+	var lessons []LessonMgo
+	if err := GetCollection("lessons").Find(bson.M{"_id": bson.M{"$in": e.Lessons}}).All(&lessons); err != nil {
+		return nil, errors.Wrap(err, "couldn't get course lessons")
+	}
+
+	byID := make(map[bson.ObjectId]*LessonMgo, len(lessons))
+	for i := range lessons {
+		byID[lessons[i].ID] = &lessons[i]
+	}
+
+	sessions := make([]EnrollmentSession, 0, len(e.Lessons))
+	for i, id := range e.Lessons {
+		lesson, ok := byID[id]
+		if !ok || i >= len(course.Sessions) {
+			continue
+		}
+
+		sessions = append(sessions, EnrollmentSession{
+			CourseSession: course.Sessions[i],
+			Lesson:        id,
+			StartsAt:      lesson.StartsAt,
+			EndsAt:        lesson.EndsAt,
+			State:         lesson.State,
+		})
+	}
+
+	return &EnrollmentDto{
+		CourseEnrollment: e,
+		Course:           courseDto,
+		Student:          student.ToPublicDto(),
+		Progress:         e.Progress(),
+		Sessions:         sessions,
+	}, nil
+}
+
+// Progress is the share of the course's lessons that were taught
+func (e *CourseEnrollment) Progress() float64 {
+	if len(e.Lessons) == 0 {
+		return 0
+	}
+	return float64(len(e.Completed)) / float64(len(e.Lessons))
+}
+
+// IsOver tells if every lesson of the enrollment ended
+func (e *CourseEnrollment) IsOver() bool {
+	return len(e.Completed)+len(e.Missed) >= len(e.Lessons)
+}
+
+// Insert claims the student's active enrollment in the course. It fails when they're
+// already enrolled in it.
+func (e *CourseEnrollment) Insert() error {
+	if !e.ID.Valid() {
+		e.ID = bson.NewObjectId()
+	}
+
+	e.Status = EnrollmentActive
+	e.Active = e.Course.Hex() + ":" + e.Student.Hex()
+	e.CreatedAt = time.Now()
+
+	if e.Lessons == nil {
+		e.Lessons = make([]bson.ObjectId, 0)
+	}
+
+	if e.Completed == nil {
+		e.Completed = make([]bson.ObjectId, 0)
+	}
+
+	if e.Missed == nil {
+		e.Missed = make([]bson.ObjectId, 0)
+	}
+
+	err := GetCollection("course_enrollments").Insert(e)
+	if mgo.IsDup(err) {
+		return errors.New("you are already enrolled in this course")
+	}
+
+	return errors.Wrap(err, "couldn't insert course enrollment")
+}
+
+// SetLessons sets the lessons booked for the enrollment
+func (e *CourseEnrollment) SetLessons(lessons []bson.ObjectId) error {
+	if err := GetCollection("course_enrollments").UpdateId(e.ID, bson.M{"$set": bson.M{"lessons": lessons}}); err != nil {
+		return errors.Wrap(err, "couldn't set course enrollment lessons")
+	}
+
+	e.Lessons = lessons
+	return nil
+}
+
+// Remove removes the enrollment whose lessons couldn't be booked
+func (e *CourseEnrollment) Remove() error {
+	return errors.Wrap(GetCollection("course_enrollments").RemoveId(e.ID), "couldn't remove course enrollment")
+}
+
+// RecordLesson records the lesson as completed or missed, and completes the enrollment
+// when it was its last one. A lesson is only recorded once.
+func (e *CourseEnrollment) RecordLesson(lesson bson.ObjectId, completed bool) error {
+	field := "missed"
+	if completed {
+		field = "completed"
+	}
+
+	var updated CourseEnrollment
+	_, err := GetCollection("course_enrollments").Find(bson.M{
+		"_id":       e.ID,
+		"lessons":   lesson,
+		"completed": bson.M{"$ne": lesson},
+		"missed":    bson.M{"$ne": lesson},
+	}).Apply(mgo.Change{
+		Update:    bson.M{"$addToSet": bson.M{field: lesson}},
+		ReturnNew: true,
+	}, &updated)
+
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't record course lesson")
+	}
+
+	e.Completed, e.Missed = updated.Completed, updated.Missed
+
+	if e.Status != EnrollmentActive || !e.IsOver() {
+		return nil
+	}
+
+	now := time.Now()
+	if err := GetCollection("course_enrollments").UpdateId(e.ID, bson.M{
+		"$set": bson.M{
+			"status":       EnrollmentCompleted,
+			"completed_at": now,
+		},
+		"$unset": bson.M{"active": ""},
+	}); err != nil {
+		return errors.Wrap(err, "couldn't complete course enrollment")
+	}
+
+	e.Status = EnrollmentCompleted
+	e.CompletedAt = &now
+	e.Active = ""
+
+	return nil
+}
+
+// GetEnrollment gets an enrollment by ID
+func GetEnrollment(id bson.ObjectId) (*CourseEnrollment, bool) {
+	var e CourseEnrollment
+	if err := GetCollection("course_enrollments").FindId(id).One(&e); err != nil {
+		return nil, false
+	}
+	return &e, true
+}
+
+// GetLessonEnrollment gets the enrollment the lesson was booked for
+func GetLessonEnrollment(lesson bson.ObjectId) (*CourseEnrollment, bool) {
+	var e CourseEnrollment
+	if err := GetCollection("course_enrollments").Find(bson.M{"lessons": lesson}).One(&e); err != nil {
+		return nil, false
+	}
+	return &e, true
+}
+
+// GetActiveEnrollment gets the student's enrollment in the course that isn't over
+func GetActiveEnrollment(course, student bson.ObjectId) (*CourseEnrollment, bool) {
+	var e CourseEnrollment
+	err := GetCollection("course_enrollments").Find(bson.M{
+		"course":  course,
+		"student": student,
+		"status":  EnrollmentActive,
+	}).One(&e)
+
+	if err != nil {
+		return nil, false
+	}
+
+	return &e, true
+}
+
+// GetCourseEnrollments gets the enrollments of the course, newest first
+func GetCourseEnrollments(course bson.ObjectId) (enrollments []CourseEnrollment, err error) {
+	err = GetCollection("course_enrollments").Find(bson.M{"course": course}).Sort("-created_at").All(&enrollments)
+	return enrollments, errors.Wrap(err, "couldn't get course enrollments")
+}
+
+// GetUserEnrollments gets the enrollments the user teaches or takes, newest first
+func GetUserEnrollments(user bson.ObjectId) (enrollments []CourseEnrollment, err error) {
+	err = GetCollection("course_enrollments").Find(bson.M{
+		"$or": []bson.M{
+			{"tutor": user},
+			{"student": user},
+		},
+	}).Sort("-created_at").All(&enrollments)
+
+	return enrollments, errors.Wrap(err, "couldn't get course enrollments")
+}
+
+// SetCourseLessons links the lessons to the course and prices them at its rate, in its currency
+func SetCourseLessons(lessons []bson.ObjectId, course *Course) error {
+	_, err := GetCollection("lessons").UpdateAll(bson.M{"_id": bson.M{"$in": lessons}}, bson.M{"$set": bson.M{
+		"course":   course.ID,
+		"rate":     course.HourlyRate(),
+		"currency": course.Currency.OrDefault(),
+	}})
+
+	return errors.Wrap(err, "couldn't link lessons to course")
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func testCourse(sessions int) Course {
+	course := Course{
+		Title:    "Algebra from scratch",
+		Price:    30000,
+		RRule:    "FREQ=WEEKLY;BYDAY=TU,TH",
+		Duration: "1h30m",
+		Meet:     MeetOnline,
+	}
+
+	for i := 0; i < sessions; i++ {
+		course.Sessions = append(course.Sessions, &CourseSession{Title: "Session"})
+	}
+
+	return course
+}
+
+func TestCourseValidate(t *testing.T) {
+	course := testCourse(4)
+	if err := course.Validate(); err != nil {
+		t.Fatalf("expected valid course, got %v", err)
+	}
+
+	if course.Status != CourseOpen || !course.Sessions[0].ID.Valid() || course.Sessions[0].Materials == nil {
+		t.Errorf("expected status and session ids to be set, got %+v", course)
+	}
+
+	invalid := map[string]func(c *Course){
+		"no title":       func(c *Course) { c.Title = " " },
+		"no sessions":    func(c *Course) { c.Sessions = nil },
+		"untitled":       func(c *Course) { c.Sessions[1].Title = "" },
+		"free":           func(c *Course) { c.Price = 0 },
+		"short sessions": func(c *Course) { c.Duration = "15m" },
+		"bounded rule":   func(c *Course) { c.RRule = "FREQ=WEEKLY;COUNT=4" },
+		"invalid rule":   func(c *Course) { c.RRule = "FREQ=HOURLY" },
+		"no meet":        func(c *Course) { c.Meet = MeetBoth },
+	}
+
+	for name, change := range invalid {
+		c := testCourse(4)
+		change(&c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected course with %s to be invalid", name)
+		}
+	}
+
+	single := testCourse(1)
+	single.RRule = ""
+	if err := single.Validate(); err != nil {
+		t.Errorf("expected single session course without schedule to be valid, got %v", err)
+	}
+}
+
+func TestCourseRule(t *testing.T) {
+	course := testCourse(4)
+
+	rule, err := course.Rule()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rule.Count != 4 {
+		t.Errorf("expected a lesson for each of the 4 sessions, got %d", rule.Count)
+	}
+
+	// 4 sessions of 1h30m for $300
+	if rate := course.HourlyRate(); rate != 5000 {
+		t.Errorf("expected hourly rate of 5000, got %v", rate)
+	}
+
+	single := testCourse(1)
+	if rule, _ := single.Rule(); rule != nil {
+		t.Errorf("expected no schedule for a single session, got %v", rule)
+	}
+}
+
+func TestCourseEnrollmentProgress(t *testing.T) {
+	lessons := []bson.ObjectId{bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()}
+	e := CourseEnrollment{Lessons: lessons, Completed: lessons[:2]}
+
+	if e.Progress() != 0.5 || e.IsOver() {
+		t.Errorf("expected half progress and not over, got %v", e.Progress())
+	}
+
+	e.Missed = lessons[2:]
+	if !e.IsOver() {
+		t.Error("expected enrollment to be over when every lesson ended")
+	}
+}
+
+func TestCourseEnrollmentClaimedOnce(t *testing.T) {
+	dbSetup(t)
+
+	course, student := bson.NewObjectId(), bson.NewObjectId()
+	defer GetCollection("course_enrollments").RemoveAll(bson.M{"course": course})
+
+	first := &CourseEnrollment{Course: course, Student: student}
+	if err := first.Insert(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second enrollment made before the first booked its lessons
+	if err := (&CourseEnrollment{Course: course, Student: student}).Insert(); err == nil {
+		t.Fatal("expected the student enrolled once")
+	}
+
+	// once the first one is over, the student can take the course again
+	lesson := bson.NewObjectId()
+	if err := first.SetLessons([]bson.ObjectId{lesson}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.RecordLesson(lesson, true); err != nil || first.Status != EnrollmentCompleted {
+		t.Fatalf("expected the enrollment completed, got %s: %v", first.Status, err)
+	}
+
+	if err := (&CourseEnrollment{Course: course, Student: student}).Insert(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"fmt"
+	"math"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Currency is the lowercase ISO 4217 code of the money an amount is in, the way Stripe
+// takes it. Amounts are kept in its minor units: cents, or pence.
+type Currency string
+
+// Currencies tutors can price their lessons in
+const (
+	CurrencyUSD Currency = "usd"
+	CurrencyCAD Currency = "cad"
+	CurrencyGBP Currency = "gbp"
+)
+
+// Currencies lists the currencies tutors can price their lessons in, in the order they are offered
+var Currencies = []Currency{CurrencyUSD, CurrencyCAD, CurrencyGBP}
+
+// DefaultCurrency is the currency of the amounts kept before tutors had one, and of credits
+const DefaultCurrency = CurrencyUSD
+
+var currencySymbols = map[Currency]string{
+	CurrencyUSD: "$",
+	CurrencyCAD: "CA$",
+	CurrencyGBP: "£",
+}
+
+// currencyCountries are the countries of the bank accounts tutors are paid out to in each currency
+var currencyCountries = map[Currency]string{
+	CurrencyUSD: "US",
+	CurrencyCAD: "CA",
+	CurrencyGBP: "GB",
+}
+
+// Valid checks the currency is one tutors can price their lessons in
+func (c Currency) Valid() bool {
+	_, ok := currencySymbols[c]
+	return ok
+}
+
+// OrDefault is the currency, or the default one when it isn't set
+func (c Currency) OrDefault() Currency {
+	if c == "" {
+		return DefaultCurrency
+	}
+	return c
+}
+
+// Query matches the documents in the currency. The default one also matches the documents
+// from before they had a currency.
+func (c Currency) Query() interface{} {
+	if c.OrDefault() == DefaultCurrency {
+		return bson.M{"$in": []interface{}{DefaultCurrency, nil}}
+	}
+	return c
+}
+
+// Country is the ISO 3166 code of the country bank accounts in the currency are from
+func (c Currency) Country() string {
+	return currencyCountries[c.OrDefault()]
+}
+
+// Symbol is what prices in the currency are written with
+func (c Currency) Symbol() string {
+	if symbol, ok := currencySymbols[c.OrDefault()]; ok {
+		return symbol
+	}
+	return string(c) + " "
+}
+
+// Format writes the amount, in minor units, the way it's shown to users, e.g. -£12.50
+func (c Currency) Format(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%s%d.%02d", sign, c.Symbol(), amount/100, amount%100)
+}
+
+// MinorUnits converts an amount in major units, like the dollars users type in, to minor units
+func MinorUnits(major float64) int64 {
+	return int64(math.Round(major * 100))
+}
+
+// Money is an amount in minor units of a currency
+type Money struct {
+	Amount   int64    `json:"amount" bson:"amount"`
+	Currency Currency `json:"currency" bson:"currency"`
+}
+
+// String writes the money the way it's shown to users
+func (m Money) String() string {
+	return m.Currency.Format(m.Amount)
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// maxExternalCalendars is how many external calendars a tutor can import
+const maxExternalCalendars = 5
+
+// ExternalCalendar is an ICS feed of a tutor's other calendar, like Google Calendar
+// or iCloud. Its busy events are imported as read-only blackout.
+type ExternalCalendar struct {
+	ID   bson.ObjectId `json:"_id" bson:"_id"`
+	Name string        `json:"name" bson:"name"`
+	URL  string        `json:"url" bson:"url"`
+
+	// Events is how many busy times the last sync imported.
+	Events   int        `json:"events" bson:"events"`
+	SyncedAt *time.Time `json:"synced_at,omitempty" bson:"synced_at,omitempty"`
+	// Error is why the last sync failed, empty when it worked.
+	Error     string    `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// NewExternalCalendar checks the feed URL. webcal URLs are fetched over https.
+func NewExternalCalendar(name, feed string) (*ExternalCalendar, error) {
+	feed = strings.TrimSpace(feed)
+	if strings.HasPrefix(feed, "webcal://") {
+		feed = "https://" + strings.TrimPrefix(feed, "webcal://")
+	}
+
+	u, err := url.Parse(feed)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("calendar url must be an http, https or webcal url")
+	}
+
+	if name == "" {
+		name = u.Host
+	}
+
+	return &ExternalCalendar{
+		ID:        bson.NewObjectId(),
+		Name:      name,
+		URL:       u.String(),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// GetExternalCalendar gets one of the tutor's external calendars
+func (u *UserMgo) GetExternalCalendar(id bson.ObjectId) (*ExternalCalendar, bool) {
+	for _, cal := range u.ExternalCalendars {
+		if cal.ID == id {
+			return cal, true
+		}
+	}
+	return nil, false
+}
+
+// AddExternalCalendar adds an external calendar to the tutor
+func (u *UserMgo) AddExternalCalendar(cal *ExternalCalendar) error {
+	if !u.IsTutor() {
+		return errors.New("user is not a tutor")
+	}
+
+	if len(u.ExternalCalendars) >= maxExternalCalendars {
+		return errors.Errorf("can't import more than %d calendars", maxExternalCalendars)
+	}
+
+	for _, existing := range u.ExternalCalendars {
+		if existing.URL == cal.URL {
+			return errors.New("calendar is already imported")
+		}
+	}
+
+	if err := GetCollection("users").UpdateId(u.ID, bson.M{"$push": bson.M{"external_calendars": cal}}); err != nil {
+		return errors.Wrap(err, "couldn't add external calendar")
+	}
+
+	u.ExternalCalendars = append(u.ExternalCalendars, cal)
+	return nil
+}
+
+// RemoveExternalCalendar removes the external calendar and the busy time imported from it.
+// Only the calendar's entries are pulled, what changed meanwhile is kept.
+func (u *UserMgo) RemoveExternalCalendar(id bson.ObjectId) error {
+	if _, ok := u.GetExternalCalendar(id); !ok {
+		return errors.New("calendar not found")
+	}
+
+	err := GetCollection("users").UpdateId(u.ID, bson.M{"$pull": bson.M{
+		"external_calendars":     bson.M{"_id": id},
+		"tutoring.external_busy": bson.M{"source": id},
+	}})
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't remove external calendar")
+	}
+
+	calendars := make([]*ExternalCalendar, 0, len(u.ExternalCalendars))
+	for _, cal := range u.ExternalCalendars {
+		if cal.ID != id {
+			calendars = append(calendars, cal)
+		}
+	}
+	u.ExternalCalendars = calendars
+
+	if u.Tutoring != nil {
+		u.Tutoring.ExternalBusy = withoutSource(u.Tutoring.ExternalBusy, id, nil)
+	}
+
+	return nil
+}
+
+// SetExternalCalendarSynced saves how the sync of the calendar went and, unless busy is nil,
+// replaces the busy time imported from it. The other calendars and their busy time are
+// left as they are, a calendar added or removed meanwhile isn't overwritten.
+func (u *UserMgo) SetExternalCalendarSynced(cal *ExternalCalendar, busy []*AvailabilitySlot) error {
+	if !u.IsTutor() || u.Tutoring == nil {
+		return errors.New("user is not a tutor")
+	}
+
+	update := bson.M{"$set": bson.M{
+		"external_calendars.$.events":    cal.Events,
+		"external_calendars.$.synced_at": cal.SyncedAt,
+		"external_calendars.$.error":     cal.Error,
+	}}
+
+	keep := make([]bson.ObjectId, len(busy))
+	for i, slot := range busy {
+		keep[i] = slot.ID
+	}
+
+	if busy != nil {
+		update["$push"] = bson.M{"tutoring.external_busy": bson.M{"$each": busy}}
+	}
+
+	// a calendar removed meanwhile is left removed
+	err := GetCollection("users").Update(bson.M{"_id": u.ID, "external_calendars._id": cal.ID}, update)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't save external calendar")
+	}
+
+	// the busy time imported before is pulled once the new one is in
+	if busy != nil {
+		if err := GetCollection("users").UpdateId(u.ID, bson.M{"$pull": bson.M{
+			"tutoring.external_busy": bson.M{"source": cal.ID, "_id": bson.M{"$nin": keep}},
+		}}); err != nil {
+			return errors.Wrap(err, "couldn't remove busy time imported before")
+		}
+
+		u.Tutoring.ExternalBusy = append(withoutSource(u.Tutoring.ExternalBusy, cal.ID, keep), busy...)
+	}
+
+	for i, existing := range u.ExternalCalendars {
+		if existing.ID == cal.ID {
+			u.ExternalCalendars[i] = cal
+		}
+	}
+
+	return nil
+}
+
+// withoutSource leaves out the slots imported from the calendar, but the ones kept
+func withoutSource(slots []*AvailabilitySlot, source bson.ObjectId, keep []bson.ObjectId) []*AvailabilitySlot {
+	left := make([]*AvailabilitySlot, 0, len(slots))
+	for _, slot := range slots {
+		if slot.Source == nil || *slot.Source != source || hasID(keep, slot.ID) {
+			left = append(left, slot)
+		}
+	}
+	return left
+}
+
+// GetUsersWithExternalCalendars gets the tutors that import external calendars
+func GetUsersWithExternalCalendars() (users []*UserMgo, err error) {
+	err = GetCollection("users").Find(bson.M{
+		"role":                 RoleTutor,
+		"disabled":             false,
+		"external_calendars.0": bson.M{"$exists": true},
+	}).All(&users)
+
+	return users, errors.Wrap(err, "couldn't get users with external calendars")
+}
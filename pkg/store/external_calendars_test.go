@@ -0,0 +1,78 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestExternalCalendarSyncKeepsRemoval(t *testing.T) {
+	dbSetup(t)
+
+	first, _ := NewExternalCalendar("Work", "https://example.com/work.ics")
+	second, _ := NewExternalCalendar("Home", "https://example.com/home.ics")
+
+	tutor := &UserMgo{
+		ID:                bson.NewObjectId(),
+		Username:          "tutor:" + time.Now().Format(time.RFC3339Nano),
+		Role:              RoleTutor,
+		Tutoring:          &Tutoring{},
+		ExternalCalendars: []*ExternalCalendar{first, second},
+	}
+	addUser(t, tutor)
+	defer removeUser(t, tutor)
+
+	// the sync read the tutor before they removed the second calendar
+	syncing := *tutor
+	syncing.ExternalCalendars = []*ExternalCalendar{first, second}
+
+	if err := tutor.RemoveExternalCalendar(second.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cal := range []*ExternalCalendar{first, second} {
+		synced := *cal
+		synced.Events = 1
+		busy := []*AvailabilitySlot{{ID: bson.NewObjectId(), From: time.Now(), To: time.Now().Add(time.Hour), Source: &synced.ID}}
+
+		if err := syncing.SetExternalCalendarSynced(&synced, busy); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var saved UserMgo
+	if err := GetCollection("users").FindId(tutor.ID).One(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(saved.ExternalCalendars) != 1 || saved.ExternalCalendars[0].ID != first.ID || saved.ExternalCalendars[0].Events != 1 {
+		t.Errorf("expected only the first calendar synced, got %+v", saved.ExternalCalendars)
+	}
+
+	if len(saved.Tutoring.ExternalBusy) != 1 || *saved.Tutoring.ExternalBusy[0].Source != first.ID {
+		t.Errorf("expected only the busy time of the first calendar, got %+v", saved.Tutoring.ExternalBusy)
+	}
+}
+
+func TestRemoveExternalCalendarWithoutTutoring(t *testing.T) {
+	dbSetup(t)
+
+	cal, _ := NewExternalCalendar("Work", "https://example.com/work.ics")
+	user := &UserMgo{
+		ID:                bson.NewObjectId(),
+		Username:          "user:" + time.Now().Format(time.RFC3339Nano),
+		Role:              RoleStudent,
+		ExternalCalendars: []*ExternalCalendar{cal},
+	}
+	addUser(t, user)
+	defer removeUser(t, user)
+
+	if err := user.RemoveExternalCalendar(cal.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(user.ExternalCalendars) != 0 {
+		t.Errorf("expected the calendar removed, got %+v", user.ExternalCalendars)
+	}
+}
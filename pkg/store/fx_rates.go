@@ -0,0 +1,129 @@
+package store
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// FXRate is how much of To one unit of From buys. The admins keep the rates to show prices
+// in the student's currency, charges are always made in the tutor's currency.
+type FXRate struct {
+	// ID is the pair, as in usd:gbp
+	ID   string   `json:"_id" bson:"_id"`
+	From Currency `json:"from" bson:"from"`
+	To   Currency `json:"to" bson:"to"`
+	Rate float64  `json:"rate" bson:"rate"`
+
+	UpdatedBy bson.ObjectId `json:"updated_by" bson:"updated_by"`
+	UpdatedAt time.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+// SetFXRate sets the rate of the pair, replacing the one set before
+func SetFXRate(from, to Currency, rate float64, admin bson.ObjectId) (*FXRate, error) {
+	if !from.Valid() || !to.Valid() || from == to {
+		return nil, errors.Errorf("invalid currency pair %s:%s", from, to)
+	}
+
+	if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return nil, errors.New("rate must be positive")
+	}
+
+	r := &FXRate{
+		ID:        string(from) + ":" + string(to),
+		From:      from,
+		To:        to,
+		Rate:      rate,
+		UpdatedBy: admin,
+		UpdatedAt: time.Now(),
+	}
+
+	_, err := GetCollection("fx_rates").UpsertId(r.ID, r)
+	return r, errors.Wrap(err, "couldn't set fx rate")
+}
+
+// GetFXRates gets the rates the admins set
+func GetFXRates() (rates []FXRate, err error) {
+	rates = make([]FXRate, 0)
+	err = GetCollection("fx_rates").Find(nil).Sort("_id").All(&rates)
+	return rates, errors.Wrap(err, "couldn't get fx rates")
+}
+
+// FXTable converts amounts between currencies with the rates the admins set
+type FXTable map[Currency]map[Currency]float64
+
+// NewFXTable makes the table of the rates
+func NewFXTable(rates []FXRate) FXTable {
+	t := make(FXTable)
+	for _, r := range rates {
+		if t[r.From] == nil {
+			t[r.From] = make(map[Currency]float64)
+		}
+		t[r.From][r.To] = r.Rate
+	}
+	return t
+}
+
+// GetFXTable gets the table of the rates the admins set
+func GetFXTable() (FXTable, error) {
+	rates, err := GetFXRates()
+	if err != nil {
+		return nil, err
+	}
+	return NewFXTable(rates), nil
+}
+
+// rate is how much of to one unit of from buys. Pairs without a rate of their own use the
+// inverse of the opposite one, or go through the default currency.
+func (t FXTable) rate(from, to Currency) (float64, bool) {
+	if from == to {
+		return 1, true
+	}
+
+	if rate, ok := t[from][to]; ok {
+		return rate, true
+	}
+
+	if rate, ok := t[to][from]; ok {
+		return 1 / rate, true
+	}
+
+	if from == DefaultCurrency || to == DefaultCurrency {
+		return 0, false
+	}
+
+	toDefault, ok := t.rate(from, DefaultCurrency)
+	if !ok {
+		return 0, false
+	}
+
+	fromDefault, ok := t.rate(DefaultCurrency, to)
+	return toDefault * fromDefault, ok
+}
+
+// Convert converts the amount, in minor units, between the currencies. It tells false when
+// there's no rate to do it with.
+func (t FXTable) Convert(amount int64, from, to Currency) (int64, bool) {
+	rate, ok := t.rate(from.OrDefault(), to.OrDefault())
+	if !ok {
+		return 0, false
+	}
+	return int64(math.Round(float64(amount) * rate)), true
+}
+
+// Display is the amount converted to show it in the viewer's currency, nil when it's in
+// that currency already or there's no rate to convert it with
+func (t FXTable) Display(amount int64, from, to Currency) *Money {
+	if to == "" || to == from.OrDefault() {
+		return nil
+	}
+
+	converted, ok := t.Convert(amount, from, to)
+	if !ok {
+		return nil
+	}
+
+	return &Money{Amount: converted, Currency: to}
+}
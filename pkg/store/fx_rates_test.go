@@ -0,0 +1,51 @@
+package store
+
+import "testing"
+
+func TestFXTableConvert(t *testing.T) {
+	table := NewFXTable([]FXRate{
+		{From: CurrencyUSD, To: CurrencyCAD, Rate: 1.25},
+		{From: CurrencyGBP, To: CurrencyUSD, Rate: 1.6},
+	})
+
+	tests := []struct {
+		name     string
+		amount   int64
+		from, to Currency
+		expected int64
+		ok       bool
+	}{
+		{"same currency", 4000, CurrencyGBP, CurrencyGBP, 4000, true},
+		{"direct rate", 4000, CurrencyUSD, CurrencyCAD, 5000, true},
+		{"inverse rate", 5000, CurrencyCAD, CurrencyUSD, 4000, true},
+		{"through the default", 1000, CurrencyGBP, CurrencyCAD, 2000, true},
+		{"unset is the default", 4000, "", CurrencyCAD, 5000, true},
+		{"no rate", 4000, CurrencyUSD, "eur", 0, false},
+	}
+
+	for _, tt := range tests {
+		amount, ok := table.Convert(tt.amount, tt.from, tt.to)
+		if amount != tt.expected || ok != tt.ok {
+			t.Errorf("%s: expected %d %v, got %d %v", tt.name, tt.expected, tt.ok, amount, ok)
+		}
+	}
+}
+
+func TestCurrencyFormat(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency Currency
+		expected string
+	}{
+		{1050, CurrencyUSD, "$10.50"},
+		{-905, CurrencyGBP, "-£9.05"},
+		{3000, CurrencyCAD, "CA$30.00"},
+		{7, "", "$0.07"},
+	}
+
+	for _, tt := range tests {
+		if s := tt.currency.Format(tt.amount); s != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, s)
+		}
+	}
+}
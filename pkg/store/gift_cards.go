@@ -0,0 +1,455 @@
+package store
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// MinGiftCardAmount and MaxGiftCardAmount bound what a gift card can be bought for, in cents
+	MinGiftCardAmount int64 = 1000
+	MaxGiftCardAmount int64 = 50000
+
+	// giftCardValidYears is how long a gift card can be redeemed after it's bought
+	giftCardValidYears = 5
+
+	// giftCardAlphabet leaves out the characters that are read as others, like O and 0
+	giftCardAlphabet   = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	giftCardCodeLength = 16
+)
+
+// GiftCardStatus is the state of a bought gift card
+type GiftCardStatus string
+
+const (
+	// GiftCardPending is a card waiting for the purchaser's card to be charged for it
+	GiftCardPending GiftCardStatus = "pending"
+	GiftCardActive  GiftCardStatus = "active"
+	// GiftCardRedeemed is a card with nothing left to redeem
+	GiftCardRedeemed GiftCardStatus = "redeemed"
+	GiftCardExpired  GiftCardStatus = "expired"
+	// GiftCardVoided is a card an admin voided, what was left of it can't be redeemed
+	GiftCardVoided GiftCardStatus = "voided"
+)
+
+// GiftCardRedemption is a part of a gift card's balance redeemed into a student's credits
+type GiftCardRedemption struct {
+	ID         bson.ObjectId `json:"_id" bson:"_id"`
+	User       bson.ObjectId `json:"user" bson:"user"`
+	Amount     int64         `json:"amount" bson:"amount"`
+	RedeemedAt time.Time     `json:"redeemed_at" bson:"redeemed_at"`
+}
+
+// GiftCard is credits a user bought for someone else. Its code is redeemed into the
+// credits of the student that has it, all at once or a part at a time. Amounts are in
+// cents of the default currency, the one credits are in.
+type GiftCard struct {
+	ID        bson.ObjectId `json:"_id" bson:"_id"`
+	Code      string        `json:"code" bson:"code"`
+	Purchaser bson.ObjectId `json:"purchaser" bson:"purchaser"`
+
+	RecipientName  string `json:"recipient_name" bson:"recipient_name"`
+	RecipientEmail string `json:"recipient_email" bson:"recipient_email"`
+	Message        string `json:"message,omitempty" bson:"message,omitempty"`
+
+	// Amount is what the card was bought for, Balance what's left of it to redeem
+	Amount   int64    `json:"amount" bson:"amount"`
+	Balance  int64    `json:"balance" bson:"balance"`
+	Currency Currency `json:"currency" bson:"currency"`
+	ChargeID string   `json:"charge_id" bson:"charge_id"`
+
+	Status      GiftCardStatus       `json:"status" bson:"status"`
+	Redemptions []GiftCardRedemption `json:"redemptions" bson:"redemptions"`
+
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+
+	VoidedBy   *bson.ObjectId `json:"voided_by,omitempty" bson:"voided_by,omitempty"`
+	VoidedAt   *time.Time     `json:"voided_at,omitempty" bson:"voided_at,omitempty"`
+	VoidReason string         `json:"void_reason,omitempty" bson:"void_reason,omitempty"`
+	// RefundID is the refund of the balance left to the purchaser's card when the card was voided
+	RefundID string `json:"refund_id,omitempty" bson:"refund_id,omitempty"`
+}
+
+// NewGiftCard makes the gift card the purchaser buys for the recipient, with a new code. It's
+// pending until it's paid for.
+func NewGiftCard(purchaser *UserMgo, amount int64, recipientName, recipientEmail, message string) (*GiftCard, error) {
+	if amount < MinGiftCardAmount || amount > MaxGiftCardAmount {
+		return nil, errors.Errorf("gift cards must be between %s and %s", DefaultCurrency.Format(MinGiftCardAmount), DefaultCurrency.Format(MaxGiftCardAmount))
+	}
+
+	recipientEmail = strings.ToLower(strings.TrimSpace(recipientEmail))
+	if recipientEmail == "" {
+		return nil, errors.New("recipient email is required")
+	}
+
+	code, err := newGiftCardCode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &GiftCard{
+		ID:             bson.NewObjectId(),
+		Code:           code,
+		Purchaser:      purchaser.ID,
+		RecipientName:  strings.TrimSpace(recipientName),
+		RecipientEmail: recipientEmail,
+		Message:        strings.TrimSpace(message),
+		Amount:         amount,
+		Balance:        amount,
+		Currency:       DefaultCurrency,
+		Status:         GiftCardPending,
+		Redemptions:    []GiftCardRedemption{},
+		ExpiresAt:      time.Now().AddDate(giftCardValidYears, 0, 0),
+	}, nil
+}
+
+// newGiftCardCode makes a random code, grouped by four characters like XXXX-XXXX-XXXX-XXXX
+func newGiftCardCode() (string, error) {
+	max := big.NewInt(int64(len(giftCardAlphabet)))
+
+	code := make([]byte, giftCardCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", errors.Wrap(err, "couldn't make gift card code")
+		}
+		code[i] = giftCardAlphabet[n.Int64()]
+	}
+
+	return NormalizeGiftCardCode(string(code)), nil
+}
+
+// NormalizeGiftCardCode is the code the way it's kept, users can type it in any case,
+// with or without the dashes
+func NormalizeGiftCardCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	groups := make([]string, 0, len(code)/4+1)
+	for len(code) > 4 {
+		groups = append(groups, code[:4])
+		code = code[4:]
+	}
+
+	return strings.Join(append(groups, code), "-")
+}
+
+// Redeemable tells why the card can't be redeemed, nil when it can
+func (g *GiftCard) Redeemable(now time.Time) error {
+	switch {
+	case g.Status == GiftCardVoided:
+		return errors.New("gift card was voided")
+	case g.Status == GiftCardPending:
+		return errors.New("gift card wasn't paid for")
+	case g.Status == GiftCardExpired || !now.Before(g.ExpiresAt):
+		return errors.New("gift card has expired")
+	case g.Status != GiftCardActive || g.Balance <= 0:
+		return errors.New("gift card was already redeemed")
+	}
+
+	return nil
+}
+
+// Insert stores the new card. A code that's taken already is made again.
+func (g *GiftCard) Insert() error {
+	g.CreatedAt = time.Now()
+
+	for attempt := 0; ; attempt++ {
+		err := GetCollection("gift_cards").Insert(g)
+		if err == nil || !mgo.IsDup(err) || attempt == 2 {
+			return errors.Wrap(err, "couldn't insert gift card")
+		}
+
+		if g.Code, err = newGiftCardCode(); err != nil {
+			return err
+		}
+	}
+}
+
+// Activate makes the pending card redeemable once the purchaser was charged for it
+func (g *GiftCard) Activate(chargeID string) error {
+	err := GetCollection("gift_cards").Update(bson.M{
+		"_id":    g.ID,
+		"status": GiftCardPending,
+	}, bson.M{"$set": bson.M{"status": GiftCardActive, "charge_id": chargeID}})
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't activate gift card")
+	}
+
+	g.Status = GiftCardActive
+	g.ChargeID = chargeID
+	return nil
+}
+
+// Remove removes the pending card the purchaser couldn't be charged for
+func (g *GiftCard) Remove() error {
+	return errors.Wrap(GetCollection("gift_cards").Remove(bson.M{"_id": g.ID, "status": GiftCardPending}), "couldn't remove gift card")
+}
+
+// Redeem takes the amount off the card's balance for the user. It fails when the balance
+// changed since the card was read, so it can't be redeemed twice.
+func (g *GiftCard) Redeem(user bson.ObjectId, amount int64) (*GiftCardRedemption, error) {
+	now := time.Now()
+	if err := g.Redeemable(now); err != nil {
+		return nil, err
+	}
+
+	if amount <= 0 || amount > g.Balance {
+		return nil, errors.Errorf("only %s is left on the gift card", g.Currency.Format(g.Balance))
+	}
+
+	redemption := GiftCardRedemption{ID: bson.NewObjectId(), User: user, Amount: amount, RedeemedAt: now}
+
+	set := bson.M{"balance": g.Balance - amount}
+	if amount == g.Balance {
+		set["status"] = GiftCardRedeemed
+	}
+
+	err := GetCollection("gift_cards").Update(bson.M{
+		"_id":     g.ID,
+		"status":  GiftCardActive,
+		"balance": g.Balance,
+	}, bson.M{
+		"$set":  set,
+		"$push": bson.M{"redemptions": redemption},
+	})
+
+	if err == mgo.ErrNotFound {
+		return nil, errors.New("gift card balance changed, try again")
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't redeem gift card")
+	}
+
+	g.Balance -= amount
+	if g.Balance == 0 {
+		g.Status = GiftCardRedeemed
+	}
+	g.Redemptions = append(g.Redemptions, redemption)
+
+	return &redemption, nil
+}
+
+// Unredeem gives the redemption back to the card's balance, for redemptions whose credits
+// couldn't be added. A card voided or expired meanwhile keeps its status.
+func (g *GiftCard) Unredeem(redemption GiftCardRedemption) error {
+	err := GetCollection("gift_cards").Update(bson.M{
+		"_id":             g.ID,
+		"redemptions._id": redemption.ID,
+	}, bson.M{
+		"$inc":  bson.M{"balance": redemption.Amount},
+		"$pull": bson.M{"redemptions": bson.M{"_id": redemption.ID}},
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't give back gift card redemption")
+	}
+
+	// the card was redeemed by this redemption, it can be redeemed again
+	if err := GetCollection("gift_cards").Update(bson.M{
+		"_id":     g.ID,
+		"status":  GiftCardRedeemed,
+		"balance": bson.M{"$gt": 0},
+	}, bson.M{"$set": bson.M{"status": GiftCardActive}}); err != nil && err != mgo.ErrNotFound {
+		return errors.Wrap(err, "couldn't give back gift card redemption")
+	}
+
+	g.Balance += redemption.Amount
+	if g.Status == GiftCardRedeemed {
+		g.Status = GiftCardActive
+	}
+
+	for i := range g.Redemptions {
+		if g.Redemptions[i].ID == redemption.ID {
+			g.Redemptions = append(g.Redemptions[:i], g.Redemptions[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// TakeBack takes up to the amount off the card's balance, for the charge of the card that was
+// refunded or disputed outside the API, and returns what it took. A card left with nothing
+// is voided with the reason.
+func (g *GiftCard) TakeBack(amount int64, reason string) (int64, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			if err := GetCollection("gift_cards").FindId(g.ID).One(g); err != nil {
+				return 0, errors.Wrap(err, "couldn't get gift card")
+			}
+		}
+
+		if g.Status != GiftCardActive && g.Status != GiftCardExpired {
+			return 0, nil
+		}
+
+		taken := amount
+		if g.Balance < taken {
+			taken = g.Balance
+		}
+
+		set := bson.M{"balance": g.Balance - taken}
+		if taken == g.Balance {
+			set["status"] = GiftCardVoided
+			set["void_reason"] = reason
+			set["voided_at"] = time.Now()
+		}
+
+		err := GetCollection("gift_cards").Update(bson.M{
+			"_id":     g.ID,
+			"status":  g.Status,
+			"balance": g.Balance,
+		}, bson.M{"$set": set})
+
+		if err == mgo.ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return 0, errors.Wrap(err, "couldn't take back gift card balance")
+		}
+
+		g.Balance -= taken
+		if g.Balance == 0 {
+			g.Status = GiftCardVoided
+			g.VoidReason = reason
+		}
+
+		return taken, nil
+	}
+
+	return 0, errors.New("gift card balance changed meanwhile, try again")
+}
+
+// Expire ends a card that wasn't redeemed before it expired
+func (g *GiftCard) Expire() error {
+	err := GetCollection("gift_cards").Update(bson.M{
+		"_id":     g.ID,
+		"status":  GiftCardActive,
+		"balance": g.Balance,
+	}, bson.M{"$set": bson.M{"status": GiftCardExpired}})
+
+	if err == mgo.ErrNotFound {
+		return errors.New("gift card isn't active")
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't expire gift card")
+	}
+
+	g.Status = GiftCardExpired
+	return nil
+}
+
+// Void stops the card from being redeemed. It fails when the card was redeemed meanwhile, its
+// balance is refunded only once it's voided.
+func (g *GiftCard) Void(admin bson.ObjectId, reason string) error {
+	now := time.Now()
+
+	err := GetCollection("gift_cards").Update(bson.M{
+		"_id":     g.ID,
+		"status":  bson.M{"$in": []GiftCardStatus{GiftCardActive, GiftCardExpired}},
+		"balance": g.Balance,
+	}, bson.M{"$set": bson.M{
+		"status":      GiftCardVoided,
+		"voided_by":   admin,
+		"voided_at":   now,
+		"void_reason": reason,
+	}})
+
+	if err == mgo.ErrNotFound {
+		return errors.New("gift card was already voided or its balance changed")
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't void gift card")
+	}
+
+	g.Status = GiftCardVoided
+	g.VoidedBy = &admin
+	g.VoidedAt = &now
+	g.VoidReason = reason
+
+	return nil
+}
+
+// Unvoid puts the voided card back in the status, for cards whose refund failed
+func (g *GiftCard) Unvoid(status GiftCardStatus) error {
+	err := GetCollection("gift_cards").Update(bson.M{
+		"_id":       g.ID,
+		"status":    GiftCardVoided,
+		"refund_id": bson.M{"$exists": false},
+	}, bson.M{
+		"$set":   bson.M{"status": status},
+		"$unset": bson.M{"voided_by": "", "voided_at": "", "void_reason": ""},
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't unvoid gift card")
+	}
+
+	g.Status = status
+	g.VoidedBy, g.VoidedAt, g.VoidReason = nil, nil, ""
+	return nil
+}
+
+// SetRefund saves the refund of the voided card's balance to the purchaser's card
+func (g *GiftCard) SetRefund(refundID string) error {
+	g.RefundID = refundID
+	return errors.Wrap(GetCollection("gift_cards").UpdateId(g.ID, bson.M{"$set": bson.M{"refund_id": refundID}}), "couldn't save gift card refund")
+}
+
+// GetGiftCard gets the card by its code, typed in any case and with or without the dashes
+func GetGiftCard(code string) (*GiftCard, bool) {
+	var g GiftCard
+	if err := GetCollection("gift_cards").Find(bson.M{"code": NormalizeGiftCardCode(code)}).One(&g); err != nil {
+		return nil, false
+	}
+	return &g, true
+}
+
+// GetGiftCardByID gets the card by id
+func GetGiftCardByID(id bson.ObjectId) (*GiftCard, bool) {
+	var g GiftCard
+	if err := GetCollection("gift_cards").FindId(id).One(&g); err != nil {
+		return nil, false
+	}
+	return &g, true
+}
+
+// GetGiftCards gets the cards matching, newest first
+func GetGiftCards(match bson.M, limit int) ([]GiftCard, error) {
+	cards := make([]GiftCard, 0)
+	err := GetCollection("gift_cards").Find(match).Sort("-created_at").Limit(limit).All(&cards)
+	return cards, errors.Wrap(err, "couldn't get gift cards")
+}
+
+// GetUserGiftCards gets the cards the user bought or redeemed, newest first
+func GetUserGiftCards(user bson.ObjectId) ([]GiftCard, error) {
+	return GetGiftCards(bson.M{
+		"$or": []bson.M{
+			{"purchaser": user},
+			{"redemptions.user": user},
+		},
+	}, 0)
+}
+
+// GetExpiredGiftCards gets the active cards past their expiry
+func GetExpiredGiftCards() (cards []GiftCard, err error) {
+	err = GetCollection("gift_cards").Find(bson.M{
+		"status":     GiftCardActive,
+		"expires_at": bson.M{"$lte": time.Now()},
+	}).All(&cards)
+
+	return cards, errors.Wrap(err, "couldn't get expired gift cards")
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestNormalizeGiftCardCode(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"ABCD-EFGH-JKLM-NPQR", "ABCD-EFGH-JKLM-NPQR"},
+		{"abcdefghjklmnpqr", "ABCD-EFGH-JKLM-NPQR"},
+		{" abcd efgh-jklm npqr ", "ABCD-EFGH-JKLM-NPQR"},
+		{"ABCDEF", "ABCD-EF"},
+	}
+
+	for _, tt := range tests {
+		if code := NormalizeGiftCardCode(tt.code); code != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, code)
+		}
+	}
+}
+
+func TestNewGiftCard(t *testing.T) {
+	purchaser := &UserMgo{ID: bson.NewObjectId()}
+
+	if _, err := NewGiftCard(purchaser, MinGiftCardAmount-1, "Sam", "sam@example.com", ""); err == nil {
+		t.Error("expected gift card under the minimum to fail")
+	}
+
+	if _, err := NewGiftCard(purchaser, MaxGiftCardAmount+1, "Sam", "sam@example.com", ""); err == nil {
+		t.Error("expected gift card over the maximum to fail")
+	}
+
+	if _, err := NewGiftCard(purchaser, 5000, "Sam", " ", ""); err == nil {
+		t.Error("expected gift card without a recipient to fail")
+	}
+
+	card, err := NewGiftCard(purchaser, 5000, "Sam", " Sam@Example.com ", "Happy birthday")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if card.Balance != 5000 || card.Currency != DefaultCurrency || card.Status != GiftCardPending || card.RecipientEmail != "sam@example.com" {
+		t.Errorf("expected pending card with all of its amount left, got %+v", card)
+	}
+
+	if len(card.Code) != 19 || strings.Count(card.Code, "-") != 3 || strings.ContainsAny(card.Code, "O0I1") {
+		t.Errorf("expected code grouped by four without ambiguous characters, got %s", card.Code)
+	}
+
+	other, err := NewGiftCard(purchaser, 5000, "Sam", "sam@example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other.Code == card.Code {
+		t.Error("expected every card to get its own code")
+	}
+}
+
+func TestGiftCardRedeemable(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	tests := []struct {
+		name string
+		card GiftCard
+		ok   bool
+	}{
+		{"active", GiftCard{Status: GiftCardActive, Balance: 500, ExpiresAt: later}, true},
+		{"past its expiry", GiftCard{Status: GiftCardActive, Balance: 500, ExpiresAt: now}, false},
+		{"expired", GiftCard{Status: GiftCardExpired, Balance: 500, ExpiresAt: later}, false},
+		{"voided", GiftCard{Status: GiftCardVoided, Balance: 500, ExpiresAt: later}, false},
+		{"not paid for", GiftCard{Status: GiftCardPending, Balance: 500, ExpiresAt: later}, false},
+		{"redeemed", GiftCard{Status: GiftCardRedeemed, ExpiresAt: later}, false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.card.Redeemable(now); (err == nil) != tt.ok {
+			t.Errorf("%s: expected redeemable %v, got %v", tt.name, tt.ok, err)
+		}
+	}
+}
@@ -172,6 +172,122 @@ func Init() {
 				Key:  []string{"user", "amount"},
 			},
 		},
+
+		"stripe_events": {
+			{
+				Name: "stripe_events_state",
+				Key:  []string{"state", "-received_at"},
+			},
+		},
+
+		"ledger_entries": {
+			{
+				Name: "ledger_owner",
+				Key:  []string{"lines.owner", "-created_at"},
+			},
+			{
+				Name: "ledger_lesson",
+				Key:  []string{"lesson"},
+			},
+			{
+				Name: "ledger_stripe",
+				Key:  []string{"stripe"},
+			},
+		},
+
+		"payout_claims": {
+			{
+				Name:   "payout_claims_line",
+				Unique: true,
+				Key:    []string{"line"},
+			},
+			{
+				Name: "payout_claims_batch",
+				Key:  []string{"batch", "tutor", "currency"},
+			},
+		},
+
+		"payout_batches": {
+			{
+				Name: "payout_batches_status",
+				Key:  []string{"status", "-created_at"},
+			},
+		},
+
+		"promo_codes": {
+			{
+				Name:   "promo_codes_code",
+				Unique: true,
+				Key:    []string{"code"},
+			},
+		},
+
+		"gift_cards": {
+			{
+				Name:   "gift_cards_code",
+				Unique: true,
+				Key:    []string{"code"},
+			},
+			{
+				Name: "gift_cards_purchaser",
+				Key:  []string{"purchaser", "-created_at"},
+			},
+			{
+				Name: "gift_cards_redeemed_by",
+				Key:  []string{"redemptions.user"},
+			},
+			{
+				Name: "gift_cards_expiry",
+				Key:  []string{"status", "expires_at"},
+			},
+		},
+
+		"promo_redemptions": {
+			{
+				Name:   "promo_redemptions_user_seq",
+				Key:    []string{"promo", "user", "seq"},
+				Unique: true,
+			},
+			{
+				// only redemptions of a lesson have the key
+				Name:   "promo_redemptions_lesson",
+				Unique: true,
+				Sparse: true,
+				Key:    []string{"per_lesson"},
+			},
+			{
+				Name: "promo_redemptions_created",
+				Key:  []string{"-created_at"},
+			},
+		},
+
+		"assignment_submissions": {
+			{
+				Name:   "assignment_submissions_student",
+				Unique: true,
+				Key:    []string{"assignment", "student"},
+			},
+		},
+
+		"reschedule_proposals": {
+			{
+				// only proposals waiting for an answer have the key
+				Name:   "reschedule_proposals_open",
+				Unique: true,
+				Sparse: true,
+				Key:    []string{"open"},
+			},
+		},
+
+		"course_enrollments": {
+			{
+				// only enrollments that aren't over have the key
+				Name:   "course_enrollments_active",
+				Unique: true,
+				Sparse: true,
+				Key:    []string{"active"},
+			},
+		},
 	}
 
 	for collection, indexes := range indexesToEnsure {
@@ -1,216 +1,275 @@
 package store
 
-/*
-type instantSessionsStore struct{}
+import (
+	"time"
 
-// InstantSessionsStore gets struct with instant session functions
-func InstantSessionsStore() *instantSessionsStore { return &instantSessionsStore{} }
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
 
-// InstantSessionStatus is a type for a status enum
-type InstantSessionStatus byte
+// InstantRequestStatus is the outcome of an instant session request
+type InstantRequestStatus string
 
-// enum of instant session statuses
 const (
-	InstantSessionCreated InstantSessionStatus = iota
-	InstantSessionAccepted
-	InstantSessionRejected
-	InstantSessionCompleted
-	InstantSessionFailed
+	InstantRequestPending   InstantRequestStatus = "pending"
+	InstantRequestAccepted  InstantRequestStatus = "accepted"
+	InstantRequestDeclined  InstantRequestStatus = "declined"
+	InstantRequestCancelled InstantRequestStatus = "cancelled"
+	InstantRequestTimedOut  InstantRequestStatus = "timed_out"
 )
 
-// InstantSessionEndReason is a type for a end reson enum
-type InstantSessionEndReason byte
+// InstantRequest is a student's request for an instant session, broadcast to the
+// online tutors of the subject. The first tutor to accept it gets the lesson.
+type InstantRequest struct {
+	ID      bson.ObjectId `json:"_id" bson:"_id"`
+	Student bson.ObjectId `json:"student" bson:"student"`
+	Subject bson.ObjectId `json:"subject" bson:"subject"`
+
+	// Tutors the request was broadcast to, and the ones that declined it.
+	Tutors   []bson.ObjectId `json:"tutors" bson:"tutors"`
+	Declined []bson.ObjectId `json:"declined,omitempty" bson:"declined,omitempty"`
+
+	Status     InstantRequestStatus `json:"status" bson:"status"`
+	AcceptedBy *bson.ObjectId       `json:"accepted_by,omitempty" bson:"accepted_by,omitempty"`
+	Lesson     *bson.ObjectId       `json:"lesson,omitempty" bson:"lesson,omitempty"`
+	// Escalated is set when nobody accepted the request in time.
+	Escalated bool `json:"escalated" bson:"escalated"`
+
+	ExpiresAt  time.Time  `json:"expires_at" bson:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	AnsweredAt *time.Time `json:"answered_at,omitempty" bson:"answered_at,omitempty"`
+}
 
-// enum of instant session end reasons
-const (
-	InstantSessionTutorReject InstantSessionEndReason = iota
-	InstantSessionStudentReject
-	InstantSessionSystemReject
-	InstantSessionTutorQuit
-	InstantSessionStudentQuit
-)
+// IsPending tells if the request still waits for a tutor
+func (r *InstantRequest) IsPending() bool {
+	return r.Status == InstantRequestPending && time.Now().Before(r.ExpiresAt)
+}
 
-// InstantSession is the struct of the instant lessons.
-type InstantSession struct {
-	ID        bson.ObjectId            `json:"_id" bson:"_id"`
-	CreatedAt time.Time                `json:"created_at" bson:"created_at"`
-	StartsAt  *time.Time               `json:"starts_at" bson:"starts_at"`
-	EndedAt   *time.Time               `json:"ended_at" bson:"ended_at"`
-	Tutor     bson.ObjectId            `json:"tutor" bson:"tutor"`
-	Student   bson.ObjectId            `json:"student" bson:"student"`
-	Status    InstantSessionStatus     `json:"status" bson:"status"`
-	EndReason *InstantSessionEndReason `json:"end_reason" bson:"end_reason"`
-	Room      *bson.ObjectId           `json:"room" bson:"room"`
-	Subject   bson.ObjectId            `json:"subject" bson:"subject"`
-}
-
-// InstantSessionDTO includes structs for users instead of IDs
-type InstantSessionDTO struct {
-	ID        bson.ObjectId            `json:"id"`
-	CreatedAt time.Time                `json:"created_at"`
-	StartsAt  *time.Time               `json:"starts_at"`
-	EndedAt   *time.Time               `json:"ended_at"`
-	Tutor     *UserDto                 `json:"tutor"`
-	Student   *UserDto                 `json:"student"`
-	Status    InstantSessionStatus     `json:"status"`
-	EndReason *InstantSessionEndReason `json:"end_reason"`
-	Room      *bson.ObjectId           `json:"room"`
-	Subject   bson.ObjectId            `json:"subject" bson:"subject"`
-}
-
-// DTO fills in IDs for the database object
-func (i *InstantSession) DTO() (*InstantSessionDTO, error) {
-	var tutor UserMgo
-	if err := GetCollection("users").FindId(i.Tutor).One(&tutor); err != nil {
-		return nil, errors.Wrap(err, "couldn't get tutor")
-	}
-
-	var student UserMgo
-	if err := GetCollection("users").FindId(i.Student).One(&student); err != nil {
-		return nil, errors.Wrap(err, "couldn't get student")
-	}
-
-	return &InstantSessionDTO{
-		ID: i.ID,
-
-		CreatedAt: i.CreatedAt,
-		StartsAt:  i.StartsAt,
-		EndedAt:   i.EndedAt,
-
-		Tutor:   tutor.Dto(true),
-		Student: student.Dto(true),
-
-		Status:    i.Status,
-		EndReason: i.EndReason,
-
-		Room:    i.Room,
-		Subject: i.Subject,
-	}, nil
-}
-
-// InstantSessionsToDTO does the lookup DTO lookups for many instant session caching along the way
-func InstantSessionsToDTO(instantLessons []InstantSession) []*InstantSessionDTO {
-	users := map[bson.ObjectId]*UserDto{}
-	instantSessionDTOs := make([]*InstantSessionDTO, len(instantLessons))
-
-	for i, l := range instantLessons {
-		userCollection := GetCollection("users")
-		userIDs := []bson.ObjectId{l.Student, l.Tutor}
-		for _, v := range userIDs {
-			if _, ok := users[v]; !ok {
-				var user UserMgo
-				if err := userCollection.FindId(v).One(&user); err != nil {
-					err = errors.Wrap(err, "could not get user for instant lesson")
-					core.PrintError(err, "lessonToDTO")
-					continue
-				}
-				u := user.Dto()
-				u.Tutoring = nil
-				users[v] = u
-			}
+// HasTutor tells if the request was sent to the tutor
+func (r *InstantRequest) HasTutor(tutor bson.ObjectId) bool {
+	for _, id := range r.Tutors {
+		if id == tutor {
+			return true
 		}
+	}
+	return false
+}
 
-		instantSessionDTOs[i] = &InstantSessionDTO{
-			ID: l.ID,
+func (r *InstantRequest) Insert() error {
+	if !r.ID.Valid() {
+		r.ID = bson.NewObjectId()
+	}
 
-			CreatedAt: l.CreatedAt,
-			StartsAt:  l.StartsAt,
-			EndedAt:   l.EndedAt,
+	r.Status = InstantRequestPending
+	r.CreatedAt = time.Now()
 
-			Tutor:   users[l.Tutor],
-			Student: users[l.Student],
+	return errors.Wrap(GetCollection("instant_requests").Insert(r), "couldn't insert instant request")
+}
 
-			Status:    l.Status,
-			EndReason: l.EndReason,
+// answer moves a pending request to the status. It fails when the request was
+// already answered, so only the first tutor accepting it wins.
+func (r *InstantRequest) answer(query bson.M, status InstantRequestStatus, set bson.M) error {
+	now := time.Now()
 
-			Room:    l.Room,
-			Subject: l.Subject,
-		}
+	query["_id"] = r.ID
+	query["status"] = InstantRequestPending
+
+	if set == nil {
+		set = bson.M{}
 	}
+	set["status"] = status
+	set["answered_at"] = now
 
-	return instantSessionDTOs
+	err := GetCollection("instant_requests").Update(query, bson.M{"$set": set})
+	if err == mgo.ErrNotFound {
+		return errors.New("instant request was already answered")
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't update instant request")
+	}
+
+	r.Status = status
+	r.AnsweredAt = &now
+
+	return nil
 }
 
-// HasParticipant returns whether a user is a participant in the instant session.
-func (i *InstantSession) HasParticipant(userID bson.ObjectId) bool {
-	return i.Tutor.Hex() == userID.Hex() || i.Student.Hex() == userID.Hex()
+// Accept gives the request to the tutor, if it's still pending
+func (r *InstantRequest) Accept(tutor bson.ObjectId) error {
+	query := bson.M{
+		"tutors":     tutor,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+
+	if err := r.answer(query, InstantRequestAccepted, bson.M{"accepted_by": tutor}); err != nil {
+		return err
+	}
+
+	r.AcceptedBy = &tutor
+	return nil
 }
 
-// Ended returns whether the instant session ended
-func (i *InstantSession) Ended() bool {
-	if i == nil {
-		return true
+// Reopen puts back the request the tutor accepted when their session couldn't be started,
+// so the other tutors can still accept it
+func (r *InstantRequest) Reopen(tutor bson.ObjectId) error {
+	err := GetCollection("instant_requests").Update(bson.M{
+		"_id":         r.ID,
+		"status":      InstantRequestAccepted,
+		"accepted_by": tutor,
+	}, bson.M{
+		"$set":   bson.M{"status": InstantRequestPending},
+		"$unset": bson.M{"accepted_by": 1, "answered_at": 1},
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't reopen instant request")
 	}
-	return i.EndedAt != nil || i.EndReason != nil || i.Status == InstantSessionCompleted
+
+	r.Status = InstantRequestPending
+	r.AcceptedBy = nil
+	r.AnsweredAt = nil
+
+	return nil
 }
 
-// SetStatus updates the status on an instant session
-func (i *InstantSession) SetStatus(s InstantSessionStatus, r ...InstantSessionEndReason) (*InstantSession, error) {
-	if i.Ended() {
-		return i, errors.New("can't alter an ended session")
+// Cancel ends the request for the student
+func (r *InstantRequest) Cancel() error {
+	return r.answer(bson.M{}, InstantRequestCancelled, nil)
+}
+
+// TimeOut ends the request nobody accepted in time, and marks it as escalated
+func (r *InstantRequest) TimeOut() error {
+	if err := r.answer(bson.M{}, InstantRequestTimedOut, bson.M{"escalated": true}); err != nil {
+		return err
 	}
 
-	i.Status = s
+	r.Escalated = true
+	return nil
+}
 
-	if len(r) > 0 {
-		i.EndReason = &r[0]
+// Decline records that the tutor won't take the request. It returns true when every
+// tutor the request was sent to declined it, and the request is then declined.
+func (r *InstantRequest) Decline(tutor bson.ObjectId) (bool, error) {
+	var updated InstantRequest
+
+	_, err := GetCollection("instant_requests").Find(bson.M{
+		"_id":    r.ID,
+		"status": InstantRequestPending,
+		"tutors": tutor,
+	}).Apply(mgo.Change{
+		Update:    bson.M{"$addToSet": bson.M{"declined": tutor}},
+		ReturnNew: true,
+	}, &updated)
+
+	if err == mgo.ErrNotFound {
+		return false, errors.New("instant request was already answered")
 	}
 
-	now := time.Now().UTC()
-	switch s {
-	case InstantSessionCreated:
-	case InstantSessionAccepted:
-		i.StartsAt = &now
-	case InstantSessionCompleted, InstantSessionFailed:
-		i.EndedAt = &now
-	case InstantSessionRejected:
-		i.EndedAt = &now
-		var reason = InstantSessionTutorReject
-		if len(r) > 0 {
-			reason = r[0]
-		}
-		i.EndReason = &reason
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't decline instant request")
 	}
 
-	return i, GetCollection("instant_sessions").UpdateId(i.ID, bson.M{"$set": bson.M{
-		"status":     i.Status,
-		"starts_at":  i.StartsAt,
-		"ended_at":   i.EndedAt,
-		"end_reason": i.EndReason,
-	}})
+	r.Declined = updated.Declined
+
+	if len(r.Declined) < len(r.Tutors) {
+		return false, nil
+	}
+
+	return true, r.answer(bson.M{}, InstantRequestDeclined, bson.M{"escalated": true})
 }
 
-// SetEndReason updates the end reason on the instant session
-func (i *InstantSession) SetEndReason(r InstantSessionEndReason) error {
-	reason := r
-	i.EndReason = &reason
-	return GetCollection("instant_sessions").UpdateId(i.ID, bson.M{"$set": bson.M{"end_reason": i.EndReason}})
+// SetLesson links the request to the lesson created for it
+func (r *InstantRequest) SetLesson(id bson.ObjectId) error {
+	r.Lesson = &id
+	err := GetCollection("instant_requests").UpdateId(r.ID, bson.M{"$set": bson.M{"lesson": id}})
+	return errors.Wrap(err, "couldn't link instant request to lesson")
 }
 
-// SetRoom updates the room on the instant session
-func (i *InstantSession) SetRoom(r *bson.ObjectId) error {
-	if i.Ended() {
-		return errors.New("can't alter an ended session")
+// GetInstantRequest gets a request by ID
+func GetInstantRequest(id bson.ObjectId) (*InstantRequest, bool) {
+	var r InstantRequest
+	if err := GetCollection("instant_requests").FindId(id).One(&r); err != nil {
+		return nil, false
 	}
+	return &r, true
+}
 
-	i.Room = r
+// GetStudentInstantRequest gets the pending request of the student
+func GetStudentInstantRequest(student bson.ObjectId) (*InstantRequest, bool) {
+	var r InstantRequest
+	err := GetCollection("instant_requests").Find(bson.M{
+		"student":    student,
+		"status":     InstantRequestPending,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).One(&r)
+
+	if err != nil {
+		return nil, false
+	}
 
-	return GetCollection("instant_sessions").UpdateId(i.ID, bson.M{"$set": bson.M{"room": i.Room}})
+	return &r, true
 }
 
-// Duration gets the duration of an instant lesson
-func (i *InstantSession) Duration() time.Duration {
-	if i.StartsAt == nil || i.EndedAt == nil {
-		return 0
-	}
+// GetTutorInstantRequests gets the pending requests sent to the tutor, which they didn't decline
+func GetTutorInstantRequests(tutor bson.ObjectId) (requests []InstantRequest, err error) {
+	err = GetCollection("instant_requests").Find(bson.M{
+		"tutors":     tutor,
+		"declined":   bson.M{"$ne": tutor},
+		"status":     InstantRequestPending,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Sort("created_at").All(&requests)
 
-	return i.EndedAt.Sub(*i.StartsAt)
+	return requests, errors.Wrap(err, "couldn't get instant requests")
 }
 
-// ByID returns the instant session with the specified id, or an error if it fails
-func (i *instantSessionsStore) ByID(id bson.ObjectId) (session *InstantSession, err error) {
-	err = GetCollection("instant_sessions").FindId(id).One(&session)
-	return
+// GetExpiredInstantRequests gets the pending requests nobody accepted in time
+func GetExpiredInstantRequests() (requests []InstantRequest, err error) {
+	err = GetCollection("instant_requests").Find(bson.M{
+		"status":     InstantRequestPending,
+		"expires_at": bson.M{"$lte": time.Now()},
+	}).All(&requests)
+
+	return requests, errors.Wrap(err, "couldn't get expired instant requests")
+}
+
+// InstantRequestDay counts the instant requests of a day by their outcome
+type InstantRequestDay struct {
+	Day       string `json:"day" bson:"_id"`
+	Requests  int    `json:"requests" bson:"requests"`
+	Accepted  int    `json:"accepted" bson:"accepted"`
+	Cancelled int    `json:"cancelled" bson:"cancelled"`
+	Escalated int    `json:"escalated" bson:"escalated"`
+}
+
+// FillRate is the share of the requests a tutor accepted. Requests the student
+// cancelled before anyone answered don't count.
+func (d InstantRequestDay) FillRate() float64 {
+	answerable := d.Requests - d.Cancelled
+	if answerable <= 0 {
+		return 0
+	}
+	return float64(d.Accepted) / float64(answerable)
 }
 
-*/
+// GetInstantRequestDays counts the instant requests made between from and to, by day
+func GetInstantRequestDays(from, to time.Time) (days []InstantRequestDay, err error) {
+	count := func(status InstantRequestStatus) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": []interface{}{bson.M{"$eq": []interface{}{"$status", status}}, 1, 0}}}
+	}
+
+	err = GetCollection("instant_requests").Pipe([]bson.M{
+		{"$match": bson.M{"created_at": bson.M{"$gte": from, "$lte": to}}},
+		{"$group": bson.M{
+			"_id":       bson.M{"$dateToString": bson.M{"format": "%Y/%m/%d", "date": "$created_at"}},
+			"requests":  bson.M{"$sum": 1},
+			"accepted":  count(InstantRequestAccepted),
+			"cancelled": count(InstantRequestCancelled),
+			"escalated": bson.M{"$sum": bson.M{"$cond": []interface{}{"$escalated", 1, 0}}},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	}).All(&days)
+
+	return days, errors.Wrap(err, "couldn't count instant requests")
+}
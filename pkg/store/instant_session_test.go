@@ -0,0 +1,55 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestInstantRequestDayFillRate(t *testing.T) {
+	tests := []struct {
+		day  InstantRequestDay
+		want float64
+	}{
+		{InstantRequestDay{}, 0},
+		{InstantRequestDay{Requests: 4, Accepted: 3}, 0.75},
+		{InstantRequestDay{Requests: 5, Accepted: 2, Cancelled: 1}, 0.5},
+		{InstantRequestDay{Requests: 2, Cancelled: 2}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.day.FillRate(); got != tt.want {
+			t.Errorf("FillRate() of %+v = %v, want %v", tt.day, got, tt.want)
+		}
+	}
+}
+
+func TestInstantRequestReopen(t *testing.T) {
+	dbSetup(t)
+
+	first, second := bson.NewObjectId(), bson.NewObjectId()
+	request := &InstantRequest{Student: bson.NewObjectId(), Tutors: []bson.ObjectId{first, second}, ExpiresAt: time.Now().Add(time.Minute)}
+	if err := request.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	defer GetCollection("instant_requests").RemoveId(request.ID)
+
+	if err := request.Accept(first); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first tutor's session couldn't start, the other tutor can still take the request
+	if err := request.Reopen(first); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := request.Accept(second); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, ok := GetInstantRequest(request.ID)
+	if !ok || saved.Status != InstantRequestAccepted || saved.AcceptedBy == nil || *saved.AcceptedBy != second {
+		t.Errorf("expected the request accepted by the second tutor, got %+v", saved)
+	}
+}
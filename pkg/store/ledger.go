@@ -0,0 +1,509 @@
+package store
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/logger"
+)
+
+// LedgerAccount is a kind of account money moves between. The student and tutor
+// accounts are kept per user, the platform ones are shared.
+type LedgerAccount string
+
+const (
+	// AccountStudentCash is the money the students paid with their cards
+	AccountStudentCash LedgerAccount = "student_cash"
+	// AccountStudentCredits is the credits the students have to spend on lessons
+	AccountStudentCredits LedgerAccount = "student_credits"
+	// AccountStudentPrepaid is the money paid for lesson packages that wasn't drawn yet
+	AccountStudentPrepaid LedgerAccount = "student_prepaid"
+	// AccountTutorPayable is what the tutors earned
+	AccountTutorPayable LedgerAccount = "tutor_payable"
+	// AccountPlatformRevenue is what the platform kept
+	AccountPlatformRevenue LedgerAccount = "platform_revenue"
+	// AccountPromotionalExpense is what the platform gave away as credits and referrals
+	AccountPromotionalExpense LedgerAccount = "promotional_expense"
+	// AccountTutorPayouts is what was transferred to the tutors in payout batches
+	AccountTutorPayouts LedgerAccount = "tutor_payouts"
+	// AccountGiftCards is the money paid for gift cards that wasn't redeemed yet
+	AccountGiftCards LedgerAccount = "gift_cards"
+)
+
+// DebitNormal tells if the account's balance grows with debits. The others grow with credits.
+func (a LedgerAccount) DebitNormal() bool {
+	return a == AccountStudentCash || a == AccountPromotionalExpense
+}
+
+// PerUser tells if the account is kept for each user
+func (a LedgerAccount) PerUser() bool {
+	switch a {
+	case AccountStudentCash, AccountStudentCredits, AccountStudentPrepaid, AccountTutorPayable:
+		return true
+	}
+	return false
+}
+
+// LedgerEntryKind is what moved the money of an entry
+type LedgerEntryKind string
+
+const (
+	EntryLesson   LedgerEntryKind = "lesson"
+	EntryCredit   LedgerEntryKind = "credit"
+	EntryDebit    LedgerEntryKind = "debit"
+	EntryRefund   LedgerEntryKind = "refund"
+	EntryReferral LedgerEntryKind = "referral"
+	EntryPackage  LedgerEntryKind = "package"
+	EntryDispute  LedgerEntryKind = "dispute"
+	EntryPayout   LedgerEntryKind = "payout"
+	EntryGiftCard LedgerEntryKind = "gift_card"
+)
+
+// LedgerLine moves an amount in or out of an account. Amounts are in minor units,
+// debits are positive and credits negative.
+type LedgerLine struct {
+	ID      bson.ObjectId  `json:"_id" bson:"_id"`
+	Account LedgerAccount  `json:"account" bson:"account"`
+	Owner   *bson.ObjectId `json:"owner,omitempty" bson:"owner,omitempty"`
+	Amount  int64          `json:"amount" bson:"amount"`
+
+	// Due lines of the tutors' accounts are settled by payout batches, which claim
+	// them in payout_claims. Lines posted when tutors were paid at charge time, and
+	// settled lines, aren't due.
+	Due bool `json:"due,omitempty" bson:"due,omitempty"`
+}
+
+// Debit is a line adding the amount to the account's debits. Owner is empty for
+// the platform accounts.
+func Debit(account LedgerAccount, owner bson.ObjectId, amount int64) LedgerLine {
+	line := LedgerLine{Account: account, Amount: amount, Due: account == AccountTutorPayable}
+	if owner != "" {
+		line.Owner = &owner
+	}
+	return line
+}
+
+// Credit is a line adding the amount to the account's credits
+func Credit(account LedgerAccount, owner bson.ObjectId, amount int64) LedgerLine {
+	line := Debit(account, owner, amount)
+	line.Amount = -amount
+	return line
+}
+
+// Settled marks the line as settled outside of the payouts, which leave it out
+func (l LedgerLine) Settled() LedgerLine {
+	l.Due = false
+	return l
+}
+
+// Balance is the line's amount signed to the account's normal side
+func (l LedgerLine) Balance() int64 {
+	if l.Account.DebitNormal() {
+		return l.Amount
+	}
+	return -l.Amount
+}
+
+// LedgerEntry is a journal entry of the ledger. Its lines always balance, and once
+// posted it's never changed: corrections are posted as new entries.
+type LedgerEntry struct {
+	ID          bson.ObjectId   `json:"_id" bson:"_id"`
+	Kind        LedgerEntryKind `json:"kind" bson:"kind"`
+	Description string          `json:"description" bson:"description"`
+	Currency    Currency        `json:"currency" bson:"currency"`
+	Lines       []LedgerLine    `json:"lines" bson:"lines"`
+
+	Lesson  *bson.ObjectId `json:"lesson,omitempty" bson:"lesson,omitempty"`
+	Package *bson.ObjectId `json:"package,omitempty" bson:"package,omitempty"`
+	// GiftCard is the gift card the entry bought, redeemed, expired or voided
+	GiftCard *bson.ObjectId `json:"gift_card,omitempty" bson:"gift_card,omitempty"`
+	// Stripe are the charges, transfers and refunds the money moved with
+	Stripe []string `json:"stripe,omitempty" bson:"stripe,omitempty"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// NewLedgerEntry makes an entry of the lines, leaving out the ones moving nothing
+func NewLedgerEntry(kind LedgerEntryKind, description string, lines ...LedgerLine) *LedgerEntry {
+	e := &LedgerEntry{
+		Kind:        kind,
+		Description: description,
+		Currency:    DefaultCurrency,
+		Lines:       make([]LedgerLine, 0, len(lines)),
+	}
+
+	for _, line := range lines {
+		if line.Amount != 0 {
+			e.Lines = append(e.Lines, line)
+		}
+	}
+
+	return e
+}
+
+// In sets the currency the entry's amounts are in, the default one when it isn't set
+func (e *LedgerEntry) In(currency Currency) *LedgerEntry {
+	e.Currency = currency.OrDefault()
+	return e
+}
+
+// WithStripe links the entry to the Stripe objects, leaving out the empty ids
+func (e *LedgerEntry) WithStripe(ids ...string) *LedgerEntry {
+	for _, id := range ids {
+		if id != "" {
+			e.Stripe = append(e.Stripe, id)
+		}
+	}
+	return e
+}
+
+// Validate checks the entry moves money between accounts and balances
+func (e *LedgerEntry) Validate() error {
+	if len(e.Lines) < 2 {
+		return errors.New("entry needs at least two lines")
+	}
+
+	var sum int64
+	for _, line := range e.Lines {
+		if line.Amount == 0 {
+			return errors.Errorf("line of %s moves nothing", line.Account)
+		}
+
+		if line.Account.PerUser() != (line.Owner != nil) {
+			return errors.Errorf("line of %s has the wrong owner", line.Account)
+		}
+
+		sum += line.Amount
+	}
+
+	if sum != 0 {
+		return errors.Errorf("entry is off balance by %d", sum)
+	}
+
+	return nil
+}
+
+// Post validates and saves the entry
+func (e *LedgerEntry) Post() error {
+	if err := e.Validate(); err != nil {
+		return errors.Wrapf(err, "couldn't post %s entry", e.Kind)
+	}
+
+	e.ID = bson.NewObjectId()
+	for i := range e.Lines {
+		e.Lines[i].ID = bson.NewObjectId()
+	}
+
+	if e.Currency == "" {
+		e.Currency = DefaultCurrency
+	}
+
+	e.CreatedAt = time.Now()
+
+	return errors.Wrapf(GetCollection("ledger_entries").Insert(e), "couldn't post %s entry", e.Kind)
+}
+
+// LedgerStatement is a line of a user's account with the entry it was posted in
+type LedgerStatement struct {
+	Entry       bson.ObjectId   `bson:"_id"`
+	Kind        LedgerEntryKind `bson:"kind"`
+	Description string          `bson:"description"`
+	Currency    Currency        `bson:"currency"`
+	Lesson      *bson.ObjectId  `bson:"lesson,omitempty"`
+	Stripe      []string        `bson:"stripe,omitempty"`
+	CreatedAt   time.Time       `bson:"created_at"`
+	Line        LedgerLine      `bson:"lines"`
+}
+
+// Reference is the Stripe object of the statement, or the entry when money didn't leave the platform
+func (s *LedgerStatement) Reference() string {
+	if len(s.Stripe) > 0 {
+		return s.Stripe[0]
+	}
+	return s.Entry.Hex()
+}
+
+// Dto converts the statement to a transaction, with the amount in major units
+func (s *LedgerStatement) Dto() *TransactionDto {
+	dto := &TransactionDto{
+		ID:        s.Line.ID,
+		Amount:    float64(s.Line.Balance()) / 100,
+		Currency:  s.Currency.OrDefault(),
+		Details:   s.Description,
+		Reference: s.Reference(),
+		Status:    string(s.Kind),
+		Time:      s.CreatedAt,
+	}
+
+	if s.Line.Owner != nil {
+		var err error
+		if dto.User, err = getUserDto(*s.Line.Owner); err != nil {
+			logger.Get().Errorf("couldn't get user of ledger line %s: %v", s.Line.ID.Hex(), err)
+		}
+	}
+
+	if s.Lesson != nil {
+		var exists bool
+		if dto.Lesson, exists = GetLessonsStore().Get(*s.Lesson); !exists {
+			logger.Get().Errorf("couldn't get lesson of ledger entry %s", s.Entry.Hex())
+		}
+	}
+
+	return dto
+}
+
+// statementsPipeline matches the lines of the entries. The match is on the
+// unwound lines, so line fields are under "lines".
+func statementsPipeline(match bson.M) []bson.M {
+	return []bson.M{
+		{"$unwind": "$lines"},
+		{"$match": match},
+		{"$sort": bson.M{"created_at": -1}},
+	}
+}
+
+// GetLedgerStatements gets the lines matching, newest first. Limit 0 gets them all.
+func GetLedgerStatements(match bson.M, skip, limit int) ([]LedgerStatement, error) {
+	pipeline := statementsPipeline(match)
+	if skip > 0 {
+		pipeline = append(pipeline, bson.M{"$skip": skip})
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": limit})
+	}
+
+	statements := make([]LedgerStatement, 0)
+	err := GetCollection("ledger_entries").Pipe(pipeline).All(&statements)
+	return statements, errors.Wrap(err, "couldn't get ledger statements")
+}
+
+// CountLedgerStatements counts the lines matching
+func CountLedgerStatements(match bson.M) (int, error) {
+	pipeline := append(statementsPipeline(match), bson.M{"$group": bson.M{"_id": nil, "count": bson.M{"$sum": 1}}})
+
+	var result struct {
+		Count int `bson:"count"`
+	}
+
+	if err := GetCollection("ledger_entries").Pipe(pipeline).One(&result); err != nil {
+		if err == mgo.ErrNotFound {
+			return 0, nil
+		}
+		return 0, errors.Wrap(err, "couldn't count ledger statements")
+	}
+
+	return result.Count, nil
+}
+
+// GetLedgerBalances sums the user's accounts in the currency, each signed to its normal side
+func GetLedgerBalances(owner bson.ObjectId, currency Currency) (map[LedgerAccount]int64, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"lines.owner": owner, "currency": currency.OrDefault()}},
+		{"$unwind": "$lines"},
+		{"$match": bson.M{"lines.owner": owner}},
+		{"$group": bson.M{"_id": "$lines.account", "amount": bson.M{"$sum": "$lines.amount"}}},
+	}
+
+	var sums []struct {
+		Account LedgerAccount `bson:"_id"`
+		Amount  int64         `bson:"amount"`
+	}
+
+	if err := GetCollection("ledger_entries").Pipe(pipeline).All(&sums); err != nil {
+		return nil, errors.Wrap(err, "couldn't get ledger balances")
+	}
+
+	balances := make(map[LedgerAccount]int64, len(sums))
+	for _, sum := range sums {
+		balances[sum.Account] = LedgerLine{Account: sum.Account, Amount: sum.Amount}.Balance()
+	}
+
+	return balances, nil
+}
+
+// GetLedgerEarnings sums what the tutor earned between from and to in each currency, net
+// of what was taken back by refunds. Payouts move the earnings out of the tutor's account
+// but don't change what they earned, so they're left out. Zero times don't limit the period.
+func GetLedgerEarnings(owner bson.ObjectId, from, to time.Time) ([]Money, error) {
+	match := bson.M{"lines.owner": owner, "kind": bson.M{"$ne": EntryPayout}}
+
+	period := bson.M{}
+	if !from.IsZero() {
+		period["$gte"] = from
+	}
+	if !to.IsZero() {
+		period["$lte"] = to
+	}
+	if len(period) > 0 {
+		match["created_at"] = period
+	}
+
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$unwind": "$lines"},
+		{"$match": bson.M{"lines.owner": owner, "lines.account": AccountTutorPayable}},
+		{"$group": bson.M{"_id": "$currency", "amount": bson.M{"$sum": "$lines.amount"}}},
+		{"$sort": bson.M{"_id": 1}},
+	}
+
+	var sums []struct {
+		Currency Currency `bson:"_id"`
+		Amount   int64    `bson:"amount"`
+	}
+
+	if err := GetCollection("ledger_entries").Pipe(pipeline).All(&sums); err != nil {
+		return nil, errors.Wrap(err, "couldn't get ledger earnings")
+	}
+
+	earnings := make([]Money, 0, len(sums))
+	for _, sum := range sums {
+		earnings = append(earnings, Money{
+			Amount:   LedgerLine{Account: AccountTutorPayable, Amount: sum.Amount}.Balance(),
+			Currency: sum.Currency.OrDefault(),
+		})
+	}
+
+	return earnings, nil
+}
+
+// GetLedgerEntryByStripe gets the entry linked to the Stripe object
+func GetLedgerEntryByStripe(id string) (*LedgerEntry, bool) {
+	var e LedgerEntry
+	if err := GetCollection("ledger_entries").Find(bson.M{"stripe": id}).One(&e); err != nil {
+		return nil, false
+	}
+	return &e, true
+}
+
+// GetLessonLedger gets the entries of the lesson, oldest first
+func GetLessonLedger(lesson bson.ObjectId) ([]LedgerEntry, error) {
+	entries := make([]LedgerEntry, 0)
+	err := GetCollection("ledger_entries").Find(bson.M{"lesson": lesson}).Sort("created_at").All(&entries)
+	return entries, errors.Wrap(err, "couldn't get lesson ledger")
+}
+
+// LessonPayment is what a student paid for a lesson, and what was refunded of it
+type LessonPayment struct {
+	// Credits and Cash are what the student paid with credits and card, Prepaid what the
+	// minutes drawn from their packages were worth
+	Credits int64 `json:"credits"`
+	Cash    int64 `json:"cash"`
+	Prepaid int64 `json:"prepaid"`
+	// TutorPay and Fee are what the tutor earned and the platform kept of it
+	TutorPay int64 `json:"tutor_pay"`
+	Fee      int64 `json:"fee"`
+	// Discount is what the platform paid of the lesson for a promo code
+	Discount int64 `json:"discount"`
+
+	Refunded     int64 `json:"refunded"`
+	CashRefunded int64 `json:"cash_refunded"`
+	// PrepaidRefunded is what was refunded of the packages, to the card they were bought with
+	PrepaidRefunded int64 `json:"prepaid_refunded"`
+	TutorReversed   int64 `json:"tutor_reversed"`
+	// DiscountReversed is what the refunds gave back to the platform of the discount
+	DiscountReversed int64 `json:"discount_reversed"`
+}
+
+// NewLessonPayment sums the student's lines of the lesson's entries
+func NewLessonPayment(entries []LedgerEntry, student bson.ObjectId) *LessonPayment {
+	p := &LessonPayment{}
+
+	for _, entry := range entries {
+		if entry.Kind != EntryLesson && entry.Kind != EntryPackage && entry.Kind != EntryRefund {
+			continue
+		}
+
+		paid := entry.Kind == EntryLesson || entry.Kind == EntryPackage
+
+		var own bool
+		for _, line := range entry.Lines {
+			if line.Owner != nil && *line.Owner == student {
+				own = true
+				break
+			}
+		}
+
+		if !own {
+			continue
+		}
+
+		for _, line := range entry.Lines {
+			isStudent := line.Owner != nil && *line.Owner == student
+
+			switch {
+			case paid && isStudent && line.Account == AccountStudentCredits:
+				p.Credits += line.Amount
+			case paid && isStudent && line.Account == AccountStudentCash:
+				p.Cash += line.Amount
+			case paid && isStudent && line.Account == AccountStudentPrepaid:
+				p.Prepaid += line.Amount
+			case paid && line.Account == AccountTutorPayable:
+				p.TutorPay -= line.Amount
+			case paid && line.Account == AccountPlatformRevenue:
+				p.Fee -= line.Amount
+			case paid && line.Account == AccountPromotionalExpense:
+				p.Discount += line.Amount
+			case entry.Kind == EntryRefund && isStudent && line.Amount < 0:
+				p.Refunded -= line.Amount
+				switch {
+				case entry.Package != nil:
+					p.PrepaidRefunded -= line.Amount
+				case line.Account == AccountStudentCash:
+					p.CashRefunded -= line.Amount
+				}
+			case entry.Kind == EntryRefund && line.Account == AccountTutorPayable && line.Amount > 0:
+				p.TutorReversed += line.Amount
+			case entry.Kind == EntryRefund && line.Account == AccountPromotionalExpense && line.Amount < 0:
+				p.DiscountReversed -= line.Amount
+			}
+		}
+	}
+
+	return p
+}
+
+// Refundable is what's left to refund to the student
+func (p *LessonPayment) Refundable() int64 {
+	return p.Credits + p.Cash + p.Prepaid - p.Refunded
+}
+
+// Split splits a refund of the amount between the tutor and the platform, in the
+// proportions the student paid of the lesson. The discount the platform paid isn't refunded
+// to the student, the same proportion of it goes back to the platform, so a refund of all
+// that's left takes back all of the tutor's pay.
+func (p *LessonPayment) Split(amount int64) (tutor, fee, discount int64) {
+	paid := p.Credits + p.Cash + p.Prepaid
+	if paid == 0 {
+		return 0, amount, 0
+	}
+
+	tutorLeft := p.TutorPay - p.TutorReversed
+	discountLeft := p.Discount - p.DiscountReversed
+
+	if amount >= p.Refundable() {
+		tutor, discount = tutorLeft, discountLeft
+	} else {
+		tutor = int64(math.Round(float64(amount) * float64(p.TutorPay) / float64(paid)))
+		discount = int64(math.Round(float64(amount) * float64(p.Discount) / float64(paid)))
+	}
+
+	if tutor > tutorLeft {
+		tutor = tutorLeft
+	}
+	if discount > discountLeft {
+		discount = discountLeft
+	}
+
+	if tutor < 0 {
+		tutor = 0
+	}
+	if discount < 0 {
+		discount = 0
+	}
+
+	return tutor, amount + discount - tutor, discount
+}
@@ -0,0 +1,237 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestLedgerEntryValidate(t *testing.T) {
+	student, tutor := bson.NewObjectId(), bson.NewObjectId()
+
+	lesson := NewLedgerEntry(EntryLesson, "Lesson",
+		Debit(AccountStudentCredits, student, 500),
+		Debit(AccountStudentCash, student, 865),
+		Debit(AccountStudentPrepaid, student, 0),
+		Credit(AccountTutorPayable, tutor, 1050),
+		Credit(AccountPlatformRevenue, "", 315),
+	)
+
+	if err := lesson.Validate(); err != nil {
+		t.Fatalf("expected lesson entry to balance, got %v", err)
+	}
+
+	if len(lesson.Lines) != 4 {
+		t.Errorf("expected line moving nothing to be left out, got %d lines", len(lesson.Lines))
+	}
+
+	tests := []struct {
+		name  string
+		lines []LedgerLine
+	}{
+		{"off balance", []LedgerLine{Debit(AccountStudentCash, student, 100), Credit(AccountPlatformRevenue, "", 99)}},
+		{"single line", []LedgerLine{Debit(AccountPromotionalExpense, "", 100)}},
+		{"user account without owner", []LedgerLine{Debit(AccountPromotionalExpense, "", 100), Credit(AccountStudentCredits, "", 100)}},
+		{"platform account with owner", []LedgerLine{Debit(AccountPromotionalExpense, tutor, 100), Credit(AccountTutorPayable, tutor, 100)}},
+	}
+
+	for _, tt := range tests {
+		if err := NewLedgerEntry(EntryCredit, tt.name, tt.lines...).Validate(); err == nil {
+			t.Errorf("%s: expected entry to be invalid", tt.name)
+		}
+	}
+}
+
+func TestLedgerLineBalance(t *testing.T) {
+	student := bson.NewObjectId()
+
+	tests := []struct {
+		line    LedgerLine
+		balance int64
+	}{
+		{Debit(AccountStudentCash, student, 1365), 1365},
+		{Debit(AccountStudentCredits, student, 500), -500},
+		{Credit(AccountStudentCredits, student, 500), 500},
+		{Credit(AccountPlatformRevenue, "", 315), 315},
+		{Debit(AccountPromotionalExpense, "", 1000), 1000},
+	}
+
+	for _, tt := range tests {
+		if got := tt.line.Balance(); got != tt.balance {
+			t.Errorf("%s line of %d: expected balance %d, got %d", tt.line.Account, tt.line.Amount, tt.balance, got)
+		}
+	}
+}
+
+func TestLessonPayment(t *testing.T) {
+	student, other, tutor := bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+
+	entries := []LedgerEntry{
+		*NewLedgerEntry(EntryLesson, "Lesson",
+			Debit(AccountStudentCredits, student, 365),
+			Debit(AccountStudentCash, student, 1000),
+			Credit(AccountTutorPayable, tutor, 1050),
+			Credit(AccountPlatformRevenue, "", 315),
+		),
+		*NewLedgerEntry(EntryLesson, "Lesson",
+			Debit(AccountStudentCash, other, 1365),
+			Credit(AccountTutorPayable, tutor, 1050),
+			Credit(AccountPlatformRevenue, "", 315),
+		),
+		*NewLedgerEntry(EntryRefund, "Refund",
+			Debit(AccountPlatformRevenue, "", 100),
+			Credit(AccountStudentCredits, student, 100),
+		),
+	}
+
+	p := NewLessonPayment(entries, student)
+	if p.Credits != 365 || p.Cash != 1000 || p.TutorPay != 1050 || p.Fee != 315 {
+		t.Errorf("expected only the student's lesson to be paid, got %+v", p)
+	}
+
+	if p.Refundable() != 1265 {
+		t.Errorf("expected 1265 left to refund, got %d", p.Refundable())
+	}
+
+	if tutor, fee, _ := p.Split(1365); tutor != 1050 || fee != 315 {
+		t.Errorf("expected full refund split 1050/315, got %d/%d", tutor, fee)
+	}
+
+	p.TutorReversed = 1000
+	if tutor, fee, _ := p.Split(1365); tutor != 50 || fee != 1315 {
+		t.Errorf("expected tutor share capped to what's left, got %d/%d", tutor, fee)
+	}
+}
+
+func TestLessonPaymentDiscount(t *testing.T) {
+	student, tutor := bson.NewObjectId(), bson.NewObjectId()
+
+	entries := []LedgerEntry{
+		*NewLedgerEntry(EntryLesson, "Lesson",
+			Debit(AccountStudentCash, student, 865),
+			Debit(AccountPromotionalExpense, "", 500),
+			Credit(AccountTutorPayable, tutor, 1050),
+			Credit(AccountPlatformRevenue, "", 315),
+		),
+	}
+
+	p := NewLessonPayment(entries, student)
+	if p.Cash != 865 || p.Discount != 500 || p.Refundable() != 865 {
+		t.Errorf("expected only what the student paid to be refundable, got %+v", p)
+	}
+
+	// a full refund takes back all of the tutor's pay, and gives the discount back to the platform
+	if tutor, fee, discount := p.Split(865); tutor != 1050 || fee != 315 || discount != 500 {
+		t.Errorf("expected full refund split 1050/315 with the 500 discount back, got %d/%d/%d", tutor, fee, discount)
+	}
+
+	// a part is taken back in the proportions the student paid
+	if tutor, fee, discount := p.Split(432); tutor != 524 || fee != 158 || discount != 250 {
+		t.Errorf("expected partial refund split 524/158 with 250 of the discount back, got %d/%d/%d", tutor, fee, discount)
+	}
+
+	entries = append(entries, *NewLedgerEntry(EntryRefund, "Refund",
+		Debit(AccountTutorPayable, tutor, 524),
+		Debit(AccountPlatformRevenue, "", 158),
+		Credit(AccountPromotionalExpense, "", 250),
+		Credit(AccountStudentCash, student, 432),
+	))
+
+	p = NewLessonPayment(entries, student)
+	if tutor, fee, discount := p.Split(p.Refundable()); p.DiscountReversed != 250 || tutor != 526 || fee != 157 || discount != 250 {
+		t.Errorf("expected the rest of the refund to take back the rest, got %d/%d/%d of %+v", tutor, fee, discount, p)
+	}
+}
+
+func TestLessonPaymentPrepaid(t *testing.T) {
+	student, tutor, pkg := bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+
+	drawn := NewLedgerEntry(EntryPackage, "Package",
+		Debit(AccountStudentPrepaid, student, 1200),
+		Credit(AccountTutorPayable, tutor, 900),
+		Credit(AccountPlatformRevenue, "", 300),
+	)
+	drawn.Package = &pkg
+
+	refunded := NewLedgerEntry(EntryRefund, "Refund",
+		Debit(AccountTutorPayable, tutor, 450),
+		Debit(AccountPlatformRevenue, "", 150),
+		Credit(AccountStudentCash, student, 600),
+	)
+	refunded.Package = &pkg
+
+	p := NewLessonPayment([]LedgerEntry{*drawn, *refunded}, student)
+	if p.Prepaid != 1200 || p.PrepaidRefunded != 600 || p.CashRefunded != 0 || p.Refundable() != 600 {
+		t.Errorf("expected the package's part of the lesson to be refundable, got %+v", p)
+	}
+
+	if tutor, fee, _ := p.Split(600); tutor != 450 || fee != 150 {
+		t.Errorf("expected the rest split 450/150, got %d/%d", tutor, fee)
+	}
+}
+
+func TestLedgerLineDue(t *testing.T) {
+	student, tutor := bson.NewObjectId(), bson.NewObjectId()
+
+	if !Credit(AccountTutorPayable, tutor, 1050).Due || !Debit(AccountTutorPayable, tutor, 200).Due {
+		t.Error("expected tutor lines to be due")
+	}
+
+	if Debit(AccountStudentCash, student, 1365).Due || Credit(AccountPlatformRevenue, "", 315).Due {
+		t.Error("expected student and platform lines not to be due")
+	}
+
+	if Debit(AccountTutorPayable, tutor, 1050).Settled().Due {
+		t.Error("expected settled tutor line not to be due")
+	}
+}
+
+func TestLedgerEarnings(t *testing.T) {
+	dbSetup(t)
+
+	student, tutor := bson.NewObjectId(), bson.NewObjectId()
+	defer GetCollection("ledger_entries").RemoveAll(bson.M{"lines.owner": tutor})
+
+	entries := []*LedgerEntry{
+		NewLedgerEntry(EntryLesson, "lesson",
+			Debit(AccountStudentCash, student, 1365),
+			Credit(AccountTutorPayable, tutor, 1050),
+			Credit(AccountPlatformRevenue, "", 315),
+		),
+		NewLedgerEntry(EntryLesson, "lesson",
+			Debit(AccountStudentCash, student, 2000),
+			Credit(AccountTutorPayable, tutor, 1600),
+			Credit(AccountPlatformRevenue, "", 400),
+		).In(CurrencyCAD),
+		NewLedgerEntry(EntryRefund, "refund",
+			Debit(AccountTutorPayable, tutor, 50),
+			Credit(AccountStudentCash, student, 50),
+		),
+		// the payout moves the earnings out, they were still earned
+		NewLedgerEntry(EntryPayout, "payout",
+			Debit(AccountTutorPayable, tutor, 1000).Settled(),
+			Credit(AccountTutorPayouts, "", 1000),
+		),
+	}
+
+	for _, e := range entries {
+		if err := e.Post(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	earnings, err := GetLedgerEarnings(tutor, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Money{{Amount: 1600, Currency: CurrencyCAD}, {Amount: 1000, Currency: CurrencyUSD}}
+	if len(earnings) != 2 || earnings[0] != expected[0] || earnings[1] != expected[1] {
+		t.Errorf("expected %v earned, got %v", expected, earnings)
+	}
+
+	if earnings, _ = GetLedgerEarnings(tutor, time.Now().Add(time.Hour), time.Time{}); len(earnings) != 0 {
+		t.Errorf("expected nothing earned in the period, got %v", earnings)
+	}
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// maxAgendaTopics is how many topics an agenda can have
+	maxAgendaTopics = 20
+	// maxAgendaFiles is how many library files an agenda can link to
+	maxAgendaFiles = 20
+)
+
+// AgendaTopic is something the tutor and the students want to go through in the lesson
+type AgendaTopic struct {
+	Title string `json:"title" bson:"title"`
+	Notes string `json:"notes,omitempty" bson:"notes,omitempty"`
+}
+
+// LessonAgenda is what the lesson goes through, with the library files it needs.
+// The tutor and the students can change it until the lesson starts.
+type LessonAgenda struct {
+	Topics []AgendaTopic   `json:"topics" bson:"topics"`
+	Files  []bson.ObjectId `json:"files" bson:"files"`
+
+	UpdatedBy bson.ObjectId `json:"updated_by" bson:"updated_by"`
+	UpdatedAt time.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+// LessonAgendaDto is an agenda with its files filled out
+type LessonAgendaDto struct {
+	*LessonAgenda
+	Files []*FilesDto `json:"files"`
+}
+
+// Validate checks the topics and files of the agenda, and leaves out the files linked twice
+func (a *LessonAgenda) Validate() error {
+	if len(a.Topics) > maxAgendaTopics {
+		return errors.Errorf("agenda can have up to %d topics", maxAgendaTopics)
+	}
+
+	for i := range a.Topics {
+		a.Topics[i].Title = strings.TrimSpace(a.Topics[i].Title)
+		a.Topics[i].Notes = strings.TrimSpace(a.Topics[i].Notes)
+		if a.Topics[i].Title == "" {
+			return errors.Errorf("topic %d needs a title", i+1)
+		}
+	}
+
+	files := make([]bson.ObjectId, 0, len(a.Files))
+	for _, id := range a.Files {
+		if !hasID(files, id) {
+			files = append(files, id)
+		}
+	}
+
+	if len(files) > maxAgendaFiles {
+		return errors.Errorf("agenda can link up to %d files", maxAgendaFiles)
+	}
+
+	if a.Topics == nil {
+		a.Topics = make([]AgendaTopic, 0)
+	}
+
+	a.Files = files
+	return nil
+}
+
+// Text is the agenda as a list of topics and files, as it's shown in emails
+func (a *LessonAgendaDto) Text() string {
+	if a == nil || a.LessonAgenda == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	for i, topic := range a.Topics {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, topic.Title)
+		if topic.Notes != "" {
+			fmt.Fprintf(&b, "   %s\n", topic.Notes)
+		}
+	}
+
+	if len(a.Files) > 0 {
+		b.WriteString("Files:\n")
+		for _, file := range a.Files {
+			fmt.Fprintf(&b, "- %s\n", file.Name)
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// SetAgenda saves the agenda of the lesson
+func (l *LessonMgo) SetAgenda(agenda *LessonAgenda) error {
+	if err := GetCollection("lessons").UpdateId(l.ID, bson.M{"$set": bson.M{"agenda": agenda}}); err != nil {
+		return errors.Wrap(err, "couldn't set lesson agenda")
+	}
+
+	l.Agenda = agenda
+	return nil
+}
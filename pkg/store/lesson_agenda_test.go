@@ -0,0 +1,60 @@
+package store
+
+import (
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestLessonAgendaValidate(t *testing.T) {
+	file := bson.NewObjectId()
+	agenda := LessonAgenda{
+		Topics: []AgendaTopic{{Title: " Quadratic equations ", Notes: " bring the worksheet "}},
+		Files:  []bson.ObjectId{file, file},
+	}
+
+	if err := agenda.Validate(); err != nil {
+		t.Fatalf("expected valid agenda, got %v", err)
+	}
+
+	if agenda.Topics[0].Title != "Quadratic equations" || agenda.Topics[0].Notes != "bring the worksheet" {
+		t.Errorf("expected topic trimmed, got %+v", agenda.Topics[0])
+	}
+
+	if len(agenda.Files) != 1 {
+		t.Errorf("expected file linked twice to be left out, got %v", agenda.Files)
+	}
+
+	untitled := LessonAgenda{Topics: []AgendaTopic{{Title: "Limits"}, {Title: " "}}}
+	if err := untitled.Validate(); err == nil {
+		t.Error("expected topic without title to be invalid")
+	}
+
+	long := LessonAgenda{Topics: make([]AgendaTopic, maxAgendaTopics+1)}
+	for i := range long.Topics {
+		long.Topics[i].Title = "Topic"
+	}
+	if err := long.Validate(); err == nil {
+		t.Error("expected agenda with too many topics to be invalid")
+	}
+}
+
+func TestLessonAgendaText(t *testing.T) {
+	var missing *LessonAgendaDto
+	if text := missing.Text(); text != "" {
+		t.Errorf("expected no text without an agenda, got %q", text)
+	}
+
+	agenda := &LessonAgendaDto{
+		LessonAgenda: &LessonAgenda{Topics: []AgendaTopic{
+			{Title: "Derivatives", Notes: "chain rule"},
+			{Title: "Homework review"},
+		}},
+		Files: []*FilesDto{{Name: "worksheet.pdf"}},
+	}
+
+	expected := "1. Derivatives\n   chain rule\n2. Homework review\nFiles:\n- worksheet.pdf"
+	if text := agenda.Text(); text != expected {
+		t.Errorf("expected %q, got %q", expected, text)
+	}
+}
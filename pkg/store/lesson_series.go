@@ -0,0 +1,311 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/utils/rrule"
+)
+
+// SeriesScope tells which lessons of a recurring series a change applies to.
+type SeriesScope string
+
+const (
+	// ScopeOccurrence applies the change to a single lesson of the series.
+	ScopeOccurrence SeriesScope = "this"
+	// ScopeFollowing applies the change to a lesson and the ones after it.
+	ScopeFollowing SeriesScope = "following"
+	// ScopeSeries applies the change to every lesson of the series.
+	ScopeSeries SeriesScope = "all"
+)
+
+// ParseSeriesScope parses a scope, defaulting to a single occurrence.
+func ParseSeriesScope(s string) (SeriesScope, error) {
+	switch SeriesScope(s) {
+	case "", ScopeOccurrence:
+		return ScopeOccurrence, nil
+	case ScopeFollowing, ScopeSeries:
+		return SeriesScope(s), nil
+	}
+	return ScopeOccurrence, fmt.Errorf("invalid series scope %q", s)
+}
+
+// LessonSeries is the recurrence of a group of lessons. Its ID is the
+// RecurrentID of the lessons in the series.
+type LessonSeries struct {
+	ID bson.ObjectId `json:"id" bson:"_id"`
+
+	// RRule is the RFC 5545 recurrence rule of the series.
+	RRule string `json:"rrule" bson:"rrule"`
+	// StartsAt is the start of the first lesson, the DTSTART of the rule.
+	StartsAt time.Time `json:"starts_at" bson:"starts_at"`
+	// Timezone is the location the rule is expanded in, so the lessons keep
+	// their local time when daylight saving time changes.
+	Timezone string `json:"timezone" bson:"timezone"`
+	// ExDates are the start times of the occurrences removed from the series.
+	ExDates []time.Time `json:"exdates" bson:"exdates"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// NewLessonSeries creates a series for the rule, starting at startsAt in its location.
+func NewLessonSeries(rule *rrule.Rule, startsAt time.Time) *LessonSeries {
+	return &LessonSeries{
+		ID:       bson.NewObjectId(),
+		RRule:    rule.String(),
+		StartsAt: startsAt,
+		Timezone: startsAt.Location().String(),
+		ExDates:  []time.Time{},
+	}
+}
+
+func (s *LessonSeries) Insert() error {
+	if !s.ID.Valid() {
+		s.ID = bson.NewObjectId()
+	}
+
+	s.CreatedAt = time.Now()
+
+	if err := GetCollection("lesson_series").Insert(s); err != nil {
+		return errors.Wrap(err, "couldn't insert lesson series")
+	}
+
+	return nil
+}
+
+// Location returns the location the series is expanded in
+func (s *LessonSeries) Location() *time.Location {
+	if loc, err := time.LoadLocation(s.Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// Rule parses the recurrence rule of the series
+func (s *LessonSeries) Rule() (*rrule.Rule, error) {
+	return rrule.Parse(s.RRule)
+}
+
+// Occurrences returns the start times of the series' lessons, without the excluded ones
+func (s *LessonSeries) Occurrences() ([]time.Time, error) {
+	rule, err := s.Rule()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid series rule")
+	}
+
+	return rule.All(s.StartsAt.In(s.Location()), s.ExDates...), nil
+}
+
+// Lessons returns the lessons of the series starting from the given time
+func (s *LessonSeries) Lessons(from time.Time) (lessons []LessonMgo, err error) {
+	err = GetCollection("lessons").Find(bson.M{
+		"recurrent_id": s.ID,
+		"starts_at":    bson.M{"$gte": from},
+	}).Sort("starts_at").All(&lessons)
+
+	return lessons, errors.Wrap(err, "couldn't get series lessons")
+}
+
+// Exclude removes the occurrence starting at t from the series
+func (s *LessonSeries) Exclude(t time.Time) error {
+	s.ExDates = append(s.ExDates, t)
+
+	err := GetCollection("lesson_series").UpdateId(s.ID, bson.M{
+		"$addToSet": bson.M{"exdates": t},
+	})
+
+	return errors.Wrap(err, "couldn't exclude occurrence from series")
+}
+
+// EndBefore ends the series before the occurrence starting at t
+func (s *LessonSeries) EndBefore(t time.Time) error {
+	rule, err := s.Rule()
+	if err != nil {
+		return errors.Wrap(err, "invalid series rule")
+	}
+
+	rule.Count = 0
+	rule.Until = t.Add(-time.Second)
+	s.RRule = rule.String()
+
+	err = GetCollection("lesson_series").UpdateId(s.ID, bson.M{
+		"$set": bson.M{"rrule": s.RRule},
+	})
+
+	return errors.Wrap(err, "couldn't end series")
+}
+
+// Split ends the series before the occurrence starting at t and moves the
+// following lessons to a new series, which is returned.
+func (s *LessonSeries) Split(t time.Time) (*LessonSeries, error) {
+	rule, err := s.Rule()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid series rule")
+	}
+
+	if rule.Count > 0 {
+		// COUNT includes the excluded occurrences, so they're counted in the remaining ones too
+		before := rule.Between(s.StartsAt.In(s.Location()), time.Time{}, t.Add(-time.Second))
+		rule.Count -= len(before)
+		if rule.Count < 1 {
+			return nil, errors.New("no occurrences left to split the series at")
+		}
+	}
+
+	following := NewLessonSeries(rule, t.In(s.Location()))
+	for _, ex := range s.ExDates {
+		if !ex.Before(t) {
+			following.ExDates = append(following.ExDates, ex)
+		}
+	}
+
+	if err := following.Insert(); err != nil {
+		return nil, err
+	}
+
+	if err := s.EndBefore(t); err != nil {
+		return nil, err
+	}
+
+	if _, err := GetCollection("lessons").UpdateAll(bson.M{
+		"recurrent_id": s.ID,
+		"starts_at":    bson.M{"$gte": t},
+	}, bson.M{
+		"$set": bson.M{"recurrent_id": following.ID},
+	}); err != nil {
+		return nil, errors.Wrap(err, "couldn't move lessons to the new series")
+	}
+
+	return following, nil
+}
+
+// Reschedule moves the series by a number of days, to a new time of the day.
+// Weekdays and excluded occurrences are moved with it.
+func (s *LessonSeries) Reschedule(days int, hour, min int) error {
+	rule, err := s.Rule()
+	if err != nil {
+		return errors.Wrap(err, "invalid series rule")
+	}
+
+	move := func(t time.Time) time.Time {
+		t = t.In(s.Location())
+		return time.Date(t.Year(), t.Month(), t.Day()+days, hour, min, 0, 0, t.Location())
+	}
+
+	startsAt := move(s.StartsAt)
+	if !rule.Until.IsZero() {
+		rule.Until = rule.Until.Add(startsAt.Sub(s.StartsAt))
+	}
+	rule.ShiftDays(days)
+
+	for i, ex := range s.ExDates {
+		s.ExDates[i] = move(ex)
+	}
+
+	s.StartsAt = startsAt
+	s.RRule = rule.String()
+
+	err = GetCollection("lesson_series").UpdateId(s.ID, bson.M{
+		"$set": bson.M{
+			"rrule":     s.RRule,
+			"starts_at": s.StartsAt,
+			"exdates":   s.ExDates,
+		},
+	})
+
+	return errors.Wrap(err, "couldn't reschedule series")
+}
+
+// Cancel removes the lessons of the scope, starting at t, from the series
+func (s *LessonSeries) Cancel(t time.Time, scope SeriesScope) error {
+	switch scope {
+	case ScopeFollowing, ScopeSeries:
+		return s.EndBefore(t)
+	default:
+		return s.Exclude(t)
+	}
+}
+
+// GetSeries gets the series of recurring lessons. Lessons booked before series
+// were stored only share a RecurrentID, so their weekly series is created from them.
+// It's upserted on the RecurrentID, concurrent reads all get the same series.
+func (L *LessonsStore) GetSeries(id bson.ObjectId) (*LessonSeries, bool) {
+	var series LessonSeries
+	if err := GetCollection("lesson_series").FindId(id).One(&series); err == nil {
+		return &series, true
+	}
+
+	var lessons []LessonMgo
+	if err := GetCollection("lessons").Find(bson.M{"recurrent_id": id}).Sort("starts_at").All(&lessons); err != nil || len(lessons) == 0 {
+		return nil, false
+	}
+
+	legacy := NewLessonSeries(&rrule.Rule{Freq: rrule.Weekly, Interval: 1, Count: len(lessons)}, lessons[0].StartsAt)
+
+	_, err := GetCollection("lesson_series").UpsertId(id, bson.M{
+		"$setOnInsert": bson.M{
+			"rrule":      legacy.RRule,
+			"starts_at":  legacy.StartsAt,
+			"timezone":   legacy.Timezone,
+			"exdates":    legacy.ExDates,
+			"created_at": time.Now(),
+		},
+	})
+
+	// a concurrent upsert of the same series can fail on the _id, it's there anyway
+	if err != nil && !mgo.IsDup(err) {
+		return nil, false
+	}
+
+	if err := GetCollection("lesson_series").FindId(id).One(&series); err != nil {
+		return nil, false
+	}
+
+	return &series, true
+}
+
+// Series returns the series the lesson belongs to
+func (l *LessonMgo) Series() (*LessonSeries, bool) {
+	if l.RecurrentID == nil {
+		return nil, false
+	}
+	return GetLessonsStore().GetSeries(*l.RecurrentID)
+}
+
+// ScopedLessons returns the lessons a change with the scope applies to, starting
+// with the lesson itself. Lessons that can't be modified anymore are left out.
+func (l *LessonMgo) ScopedLessons(scope SeriesScope) []LessonMgo {
+	lessons := []LessonMgo{*l}
+
+	if scope == ScopeOccurrence {
+		return lessons
+	}
+
+	series, ok := l.Series()
+	if !ok {
+		return lessons
+	}
+
+	var from time.Time
+	if scope == ScopeFollowing {
+		from = l.StartsAt
+	}
+
+	others, err := series.Lessons(from)
+	if err != nil {
+		return lessons
+	}
+
+	for _, other := range others {
+		if other.ID == l.ID || !other.CanBeModified() {
+			continue
+		}
+		lessons = append(lessons, other)
+	}
+
+	return lessons
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/logger"
+)
+
+// lessonStartTolerance is how early a lesson can be started or marked as expired,
+// to account for timers firing slightly before the lesson's start.
+const lessonStartTolerance = time.Minute
+
+var lessonStateNames = map[LessonState]string{
+	LessonBooked:    "booked",
+	LessonConfirmed: "confirmed",
+	LessonProgress:  "progress",
+	LessonCompleted: "completed",
+	LessonCancelled: "cancelled",
+	LessonExpired:   "expired",
+	LessonNoShow:    "no-show",
+}
+
+func (s LessonState) String() string {
+	if name, ok := lessonStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// IsFinal tells if no transition can leave the state
+func (s LessonState) IsFinal() bool {
+	return len(lessonTransitions[s]) == 0
+}
+
+// lessonTransitions lists the states each state can move to. Booked can move
+// to itself, when a change is proposed for a lesson that's still being booked.
+var lessonTransitions = map[LessonState][]LessonState{
+	LessonBooked:    {LessonBooked, LessonConfirmed, LessonCancelled, LessonExpired},
+	LessonConfirmed: {LessonBooked, LessonProgress, LessonCompleted, LessonCancelled, LessonNoShow},
+	LessonProgress:  {LessonCompleted, LessonNoShow},
+}
+
+// StateChange describes who changes the state of a lesson and why
+type StateChange struct {
+	// Actor is the user changing the state, nil when the system does it.
+	Actor  *UserMgo
+	Reason string
+	// Scope tells which lessons of a recurring series are changed too.
+	Scope SeriesScope
+	// Waive tells the hooks not to bill the students for the transition.
+	Waive bool
+	Data  interface{}
+}
+
+// LessonTransition is a state change of a lesson, given to guards and hooks
+type LessonTransition struct {
+	Lesson *LessonMgo
+	From   LessonState
+	To     LessonState
+	StateChange
+	// Series is true when the lesson changes because of a change on another
+	// lesson of its series.
+	Series bool
+}
+
+// LessonTransitionGuard returns an error when the transition can't happen
+type LessonTransitionGuard func(t *LessonTransition) error
+
+// LessonTransitionHook runs before or after a transition. An error from a
+// before hook stops the transition, errors from after hooks are only logged.
+type LessonTransitionHook func(t *LessonTransition) error
+
+// IllegalTransitionError is returned when a lesson can't move to a state
+type IllegalTransitionError struct {
+	From   LessonState
+	To     LessonState
+	Reason string
+}
+
+func (e *IllegalTransitionError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("lesson can't go from %s to %s", e.From, e.To)
+	}
+	return fmt.Sprintf("lesson can't go from %s to %s: %s", e.From, e.To, e.Reason)
+}
+
+var lessonGuards = map[LessonState]LessonTransitionGuard{
+	LessonConfirmed: func(t *LessonTransition) error {
+		if !t.Series && !t.Lesson.EveryoneAccepted() {
+			return fmt.Errorf("not everyone accepted the lesson")
+		}
+		return nil
+	},
+	LessonProgress: func(t *LessonTransition) error {
+		if time.Now().Add(lessonStartTolerance).Before(t.Lesson.StartsAt) {
+			return fmt.Errorf("lesson didn't start yet")
+		}
+		return nil
+	},
+	LessonCompleted: func(t *LessonTransition) error {
+		if time.Now().Add(lessonStartTolerance).Before(t.Lesson.StartsAt) {
+			return fmt.Errorf("lesson didn't start yet")
+		}
+		return nil
+	},
+	LessonExpired: func(t *LessonTransition) error {
+		if time.Now().Add(lessonStartTolerance).Before(t.Lesson.StartsAt) {
+			return fmt.Errorf("lesson didn't start yet")
+		}
+		return nil
+	},
+	LessonCancelled: func(t *LessonTransition) error {
+		if !time.Now().Before(t.Lesson.EndsAt) {
+			return fmt.Errorf("lesson already ended")
+		}
+		return nil
+	},
+}
+
+var lessonHooks = struct {
+	sync.RWMutex
+	before map[LessonState][]LessonTransitionHook
+	after  map[LessonState][]LessonTransitionHook
+}{
+	before: make(map[LessonState][]LessonTransitionHook),
+	after:  make(map[LessonState][]LessonTransitionHook),
+}
+
+// BeforeLessonState registers a hook that runs before lessons move to the state
+func BeforeLessonState(state LessonState, hook LessonTransitionHook) {
+	lessonHooks.Lock()
+	defer lessonHooks.Unlock()
+	lessonHooks.before[state] = append(lessonHooks.before[state], hook)
+}
+
+// AfterLessonState registers a hook that runs after lessons moved to the state
+func AfterLessonState(state LessonState, hook LessonTransitionHook) {
+	lessonHooks.Lock()
+	defer lessonHooks.Unlock()
+	lessonHooks.after[state] = append(lessonHooks.after[state], hook)
+}
+
+func transitionHooks(state LessonState, after bool) []LessonTransitionHook {
+	lessonHooks.RLock()
+	defer lessonHooks.RUnlock()
+	if after {
+		return lessonHooks.after[state]
+	}
+	return lessonHooks.before[state]
+}
+
+// CanTransition checks the transition table and the guard of the target state
+func (t *LessonTransition) CanTransition() error {
+	allowed := false
+	for _, to := range lessonTransitions[t.From] {
+		if to == t.To {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		return &IllegalTransitionError{From: t.From, To: t.To}
+	}
+
+	if guard, ok := lessonGuards[t.To]; ok {
+		if err := guard(t); err != nil {
+			return &IllegalTransitionError{From: t.From, To: t.To, Reason: err.Error()}
+		}
+	}
+
+	return nil
+}
+
+// timelineEntry is the record of the transition in the lesson's state timeline
+func (t *LessonTransition) timelineEntry() LessonStateData {
+	entry := LessonStateData{
+		State:  t.To,
+		From:   &t.From,
+		Time:   time.Now(),
+		Reason: t.Reason,
+		Data:   t.Data,
+	}
+
+	if t.Actor != nil {
+		entry.Actor = &t.Actor.ID
+	}
+
+	return entry
+}
+
+// apply runs the before hooks and saves the new state. The state is only saved
+// if the lesson is still in the state the transition starts from, so concurrent
+// transitions of the same lesson can't both happen and run their hooks twice.
+func (t *LessonTransition) apply() error {
+	for _, hook := range transitionHooks(t.To, false) {
+		if err := hook(t); err != nil {
+			return &IllegalTransitionError{From: t.From, To: t.To, Reason: err.Error()}
+		}
+	}
+
+	entry := t.timelineEntry()
+	err := t.Lesson.updateRevisedIf(bson.M{"state": t.From}, bson.M{
+		"$set":  bson.M{"state": t.To},
+		"$push": bson.M{"state_timeline": entry},
+	})
+	if err == mgo.ErrNotFound {
+		return &IllegalTransitionError{From: t.From, To: t.To, Reason: "lesson changed state meanwhile"}
+	}
+	if err != nil {
+		return err
+	}
+
+	t.Lesson.State = t.To
+	t.Lesson.StateTimeline = append(t.Lesson.StateTimeline, entry)
+
+	return nil
+}
+
+// after runs the after hooks of the transition
+func (t *LessonTransition) after() {
+	for _, hook := range transitionHooks(t.To, true) {
+		if err := hook(t); err != nil {
+			logger.Get().Errorf("lesson %s hook after %s failed: %v", t.Lesson.ID.Hex(), t.To, err)
+		}
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/pkg/errors"
 	"gitlab.com/learnt/api/pkg/core"
 	"gitlab.com/learnt/api/pkg/utils"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -27,6 +28,10 @@ const (
 	LessonProgress
 	LessonCompleted
 	LessonCancelled
+	// LessonExpired is a lesson that was never confirmed before it started.
+	LessonExpired
+	// LessonNoShow is a lesson a participant didn't attend.
+	LessonNoShow
 )
 
 type LessonType byte
@@ -45,9 +50,13 @@ func GetLessonsStore() *LessonsStore { return &LessonsStore{} }
 
 // LessonStateData data gathered when a state is changed
 type LessonStateData struct {
-	State LessonState `json:"state" bson:"state"`
-	Time  time.Time   `json:"time" bson:"time"`
-	Data  interface{} `json:"data" bson:"data"`
+	State LessonState  `json:"state" bson:"state"`
+	From  *LessonState `json:"from,omitempty" bson:"from,omitempty"`
+	Time  time.Time    `json:"time" bson:"time"`
+	// Actor is the user who changed the state, empty when the system did.
+	Actor  *bson.ObjectId `json:"actor,omitempty" bson:"actor,omitempty"`
+	Reason string         `json:"reason,omitempty" bson:"reason,omitempty"`
+	Data   interface{}    `json:"data" bson:"data"`
 }
 
 // LessonChangeProposal contains info about changed items in a lesson.
@@ -72,26 +81,94 @@ type LessonChangeProposal struct {
 	CreatedAt time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
 }
 
-// Apply takes a change proposal and applies it
-func (lcp *LessonChangeProposal) Apply(lessonID bson.ObjectId) (*LessonMgo, error) {
+// Apply takes a change proposal and applies it. For recurring lessons, the scope
+// tells which lessons of the series are changed too.
+func (lcp *LessonChangeProposal) Apply(lessonID bson.ObjectId, scope SeriesScope) (*LessonMgo, error) {
 	var lesson LessonMgo
 	if err := GetCollection("lessons").FindId(lessonID).One(&lesson); err != nil {
 		return nil, errors.Wrap(err, "couldn't get lesson")
 	}
 
-	if err := lesson.SetSubject(lcp.Subject); err != nil {
-		return nil, errors.Wrap(err, "couldn't set subject")
+	series, recurring := lesson.Series()
+	if !recurring {
+		scope = ScopeOccurrence
 	}
 
-	if err := lesson.SetMeetAndLocation(lcp.Meet, lcp.Location); err != nil {
-		return nil, errors.Wrap(err, "couldn't set meet & location")
+	timesChanged := !lcp.StartsAt.IsZero() && !lcp.EndsAt.IsZero() &&
+		(!lcp.StartsAt.Equal(lesson.StartsAt) || !lcp.EndsAt.Equal(lesson.EndsAt))
+
+	if recurring && timesChanged {
+		var err error
+		switch {
+		case scope == ScopeOccurrence:
+			// the moved lesson isn't an occurrence of the rule anymore
+			err = series.Exclude(lesson.StartsAt)
+		case scope == ScopeFollowing && lesson.StartsAt.After(series.StartsAt):
+			series, err = series.Split(lesson.StartsAt)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't update lesson series")
+		}
+
+		// the lesson and the following ones were moved to the new series
+		lesson.RecurrentID = &series.ID
+	}
+
+	lessons := lesson.ScopedLessons(scope)
+
+	// lessons are moved in the series' location, so they keep the same local time
+	loc := time.UTC
+	if recurring {
+		loc = series.Location()
+	}
+	from := lesson.StartsAt.In(loc)
+	to := lcp.StartsAt.In(loc)
+	days := int(dateOf(to).Sub(dateOf(from)).Hours() / 24)
+	duration := lcp.EndsAt.Sub(lcp.StartsAt)
+
+	for i := range lessons {
+		l := &lessons[i]
+
+		if err := l.SetSubject(lcp.Subject); err != nil {
+			return nil, errors.Wrap(err, "couldn't set subject")
+		}
+
+		if err := l.SetMeetAndLocation(lcp.Meet, lcp.Location); err != nil {
+			return nil, errors.Wrap(err, "couldn't set meet & location")
+		}
+
+		if lcp.StartsAt.IsZero() || lcp.EndsAt.IsZero() {
+			continue
+		}
+
+		startsAt, endsAt := lcp.StartsAt, lcp.EndsAt
+		if l.ID != lesson.ID {
+			if !timesChanged {
+				continue
+			}
+
+			d := l.StartsAt.In(loc)
+			startsAt = time.Date(d.Year(), d.Month(), d.Day()+days, to.Hour(), to.Minute(), to.Second(), 0, loc)
+			endsAt = startsAt.Add(duration)
+		}
+
+		if err := l.SetTimes(startsAt, endsAt); err != nil {
+			return nil, errors.Wrap(err, "couldn't set times")
+		}
 	}
 
-	if err := lesson.SetTimes(lcp.StartsAt, lcp.EndsAt); err != nil {
-		return nil, errors.Wrap(err, "couldn't set times")
+	if recurring && timesChanged && scope != ScopeOccurrence {
+		if err := series.Reschedule(days, to.Hour(), to.Minute()); err != nil {
+			return nil, errors.Wrap(err, "couldn't reschedule lesson series")
+		}
 	}
 
-	return &lesson, nil
+	return &lessons[0], nil
+}
+
+// dateOf returns the midnight of t's date, in UTC, to count days between dates
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
 }
 
 // DTO fills out the IDs into structs from a database type
@@ -143,7 +220,12 @@ type LessonMgo struct {
 
 	Tutor    bson.ObjectId   `json:"tutor" bson:"tutor"`
 	Students []bson.ObjectId `json:"students" bson:"students"`
-	Rate     float32         `json:"rate" bson:"rate"`
+	// Rate is the price of a single seat, per hour in minor units of Currency. Every student
+	// of the lesson is charged this rate.
+	Rate     int64    `json:"rate" bson:"rate"`
+	Currency Currency `json:"currency,omitempty" bson:"currency,omitempty"`
+	// Capacity is the maximum number of students allowed in the lesson. Anything above 1 is a group lesson.
+	Capacity int `json:"capacity,omitempty" bson:"capacity,omitempty"`
 
 	State         LessonState       `json:"state" bson:"state"`
 	StateTimeline []LessonStateData `json:"state_timeline" bson:"state_timeline"`
@@ -158,6 +240,12 @@ type LessonMgo struct {
 
 	Type LessonType `json:"type" bson:"type"`
 
+	// Course is the course the lesson teaches a session of.
+	Course *bson.ObjectId `json:"course,omitempty" bson:"course,omitempty"`
+
+	// Agenda is what the lesson goes through, set up before it starts.
+	Agenda *LessonAgenda `json:"agenda,omitempty" bson:"agenda,omitempty"`
+
 	Notifications struct {
 		OneDayBefore           bool `json:"-" bson:"one_day_before,omitempty"`
 		ThirtiethMinutesBefore bool `json:"-" bson:"thirtieth_minutes_before,omitempty"`
@@ -170,14 +258,84 @@ type LessonMgo struct {
 
 	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
 	Charge    *models.ChargeData `json:"charge,omitempty" bson:"charge,omitempty"`
+	// Charges holds the charge of every seat in a group lesson, keyed by the student's hex ID.
+	Charges map[string]*models.ChargeData `json:"charges,omitempty" bson:"charges,omitempty"`
+	// PromoCodes are the promo codes the students booked the lesson with, keyed by their hex ID.
+	// They're redeemed when the lesson is charged.
+	PromoCodes map[string]string `json:"promo_codes,omitempty" bson:"promo_codes,omitempty"`
+	// Refunding holds when a refund of a student's payment started, keyed by their hex ID,
+	// so one refund of it runs at a time.
+	Refunding map[string]time.Time `json:"-" bson:"refunding,omitempty"`
+	// RefundHeld holds when a refund that moved money couldn't be saved, keyed by the
+	// student's hex ID. The student's payment isn't refunded again until it's reconciled.
+	RefundHeld map[string]time.Time `json:"-" bson:"refund_held,omitempty"`
+
+	// NoShow records who didn't attend the lesson.
+	NoShow *NoShowReport `json:"no_show,omitempty" bson:"no_show,omitempty"`
+	// AttendanceCheckedAt is when the no-show detection checked the lesson.
+	AttendanceCheckedAt *time.Time `json:"-" bson:"attendance_checked_at,omitempty"`
+
+	// Sequence counts the changes calendars show of the lesson: its time, subject,
+	// place and state. UpdatedAt is when it last changed.
+	Sequence  int        `json:"sequence" bson:"sequence"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	// Cancellation is the policy applied when the lesson was cancelled.
+	Cancellation *LessonCancellation `json:"cancellation,omitempty" bson:"cancellation,omitempty"`
+	// SeatCancellations holds the policy applied to students that left a group
+	// lesson, keyed by the student's hex ID.
+	SeatCancellations map[string]*LessonCancellation `json:"seat_cancellations,omitempty" bson:"seat_cancellations,omitempty"`
 
 	updatemux *sync.Mutex `bson:"-"`
 }
 
+// NoShowParty is who missed a lesson
+type NoShowParty string
+
+const (
+	NoShowTutor   NoShowParty = "tutor"
+	NoShowStudent NoShowParty = "student"
+)
+
+// NoShowReport records the participants that didn't enter a lesson's classroom
+type NoShowReport struct {
+	Party      NoShowParty     `json:"party" bson:"party"`
+	Absent     []bson.ObjectId `json:"absent" bson:"absent"`
+	DetectedAt time.Time       `json:"detected_at" bson:"detected_at"`
+}
+
 func (l LessonMgo) IsInstantSession() bool {
 	return l.Type == LessonInstant
 }
 
+// IsGroup tells if the lesson accepts more than one student.
+func (l LessonMgo) IsGroup() bool {
+	return l.Capacity > 1
+}
+
+// SeatsCapacity returns the number of seats of the lesson. Lessons created before
+// group lessons existed have no capacity set, so they have as many seats as students.
+func (l LessonMgo) SeatsCapacity() int {
+	if l.Capacity < len(l.Students) {
+		return len(l.Students)
+	}
+	return l.Capacity
+}
+
+// OpenSeats returns the number of seats that students can still join.
+func (l LessonMgo) OpenSeats() int {
+	return l.SeatsCapacity() - len(l.Students)
+}
+
+// HasStudent returns whether the user is one of the lesson's students.
+func (l LessonMgo) HasStudent(userID bson.ObjectId) bool {
+	for _, s := range l.Students {
+		if s.Hex() == userID.Hex() {
+			return true
+		}
+	}
+	return false
+}
+
 func (l LessonMgo) Clone() LessonMgo {
 	out := LessonMgo{}
 	copier.Copy(&out, l)
@@ -258,7 +416,11 @@ type LessonDto struct {
 	Tutor    PublicUserDto   `json:"tutor" bson:"tutor"`
 	Students []PublicUserDto `json:"students" bson:"students"`
 	Student  *PublicUserDto  `json:"student,omitempty" bson:"student"`
-	Rate     float32         `json:"rate" bson:"rate"`
+	Rate     int64           `json:"rate" bson:"rate"`
+	Currency Currency        `json:"currency" bson:"currency"`
+
+	Capacity  int `json:"capacity,omitempty" bson:"capacity,omitempty"`
+	OpenSeats int `json:"open_seats" bson:"-"`
 
 	State         LessonState       `json:"state" bson:"state"`
 	StateTimeline []LessonStateData `json:"state_timeline" bson:"state_timeline"`
@@ -269,6 +431,8 @@ type LessonDto struct {
 
 	Recurrent   bool           `json:"recurrent" bson:"recurrent"`
 	RecurrentID *bson.ObjectId `json:"recurrent_id" bson:"recurrent_id"`
+	Course      *bson.ObjectId `json:"course,omitempty" bson:"course,omitempty"`
+	Agenda      *LessonAgenda  `json:"agenda,omitempty" bson:"agenda,omitempty"`
 
 	Notifications struct {
 		OneDayBefore           bool `json:"-" bson:"one_day_before,omitempty"`
@@ -283,6 +447,11 @@ type LessonDto struct {
 	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
 	LessonType LessonType         `json:"lesson_type" bson:"type"`
 	Charge     *models.ChargeData `json:"charge,omitempty" bson:"charge,omitempty"`
+
+	Sequence  int        `json:"sequence" bson:"sequence"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+
+	Assignments []AssignmentSummary `json:"assignments" bson:"-"`
 }
 
 // DTO fills in the IDs from the database value with stucts
@@ -321,6 +490,11 @@ func (l *LessonMgo) DTO() (*LessonDto, error) {
 		proposals[i] = *dto
 	}
 
+	assignments, err := GetAssignmentSummaries([]bson.ObjectId{l.ID})
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't get assignments")
+	}
+
 	return &LessonDto{
 		ID:              l.ID,
 		Subject:         *subject,
@@ -332,16 +506,24 @@ func (l *LessonMgo) DTO() (*LessonDto, error) {
 		Tutor:           *tutor.ToPublicDto(),
 		Students:        students,
 		Rate:            l.Rate,
+		Currency:        l.Currency.OrDefault(),
+		Capacity:        l.SeatsCapacity(),
+		OpenSeats:       l.OpenSeats(),
 		State:           l.State,
 		StateTimeline:   l.StateTimeline,
 		Accepted:        acceptedUsersDto,
 		Room:            l.Room,
 		Recurrent:       l.Recurrent,
 		RecurrentID:     l.RecurrentID,
+		Course:          l.Course,
+		Agenda:          l.Agenda,
 		Notifications:   l.Notifications,
 		ChangeProposals: proposals,
 		CreatedAt:       l.CreatedAt,
 		Charge:          l.Charge,
+		Sequence:        l.Sequence,
+		UpdatedAt:       l.UpdatedAt,
+		Assignments:     assignmentSummaries(assignments, l.ID),
 	}, nil
 }
 
@@ -350,7 +532,16 @@ func LessonsToDTO(lessons []LessonMgo) []*LessonDto {
 	subjects := map[bson.ObjectId]*Subject{}
 	users := map[bson.ObjectId]*PublicUserDto{}
 
-	var err error
+	ids := make([]bson.ObjectId, len(lessons))
+	for i := range lessons {
+		ids[i] = lessons[i].ID
+	}
+
+	assignments, err := GetAssignmentSummaries(ids)
+	if err != nil {
+		core.PrintError(err, "lessonToDTO")
+	}
+
 	lessonsDTO := make([]*LessonDto, len(lessons))
 	for i, l := range lessons {
 
@@ -409,15 +600,23 @@ func LessonsToDTO(lessons []LessonMgo) []*LessonDto {
 			Tutor:           *users[l.Tutor],
 			Students:        students,
 			Rate:            l.Rate,
+			Currency:        l.Currency.OrDefault(),
+			Capacity:        l.SeatsCapacity(),
+			OpenSeats:       l.OpenSeats(),
 			State:           l.State,
 			StateTimeline:   l.StateTimeline,
 			Accepted:        acceptedUsers,
 			Room:            l.Room,
 			Recurrent:       l.Recurrent,
+			Course:          l.Course,
+			Agenda:          l.Agenda,
 			Notifications:   l.Notifications,
 			ChangeProposals: proposals,
 			CreatedAt:       l.CreatedAt,
 			Charge:          l.Charge,
+			Sequence:        l.Sequence,
+			UpdatedAt:       l.UpdatedAt,
+			Assignments:     assignmentSummaries(assignments, l.ID),
 		}
 	}
 
@@ -471,7 +670,7 @@ func (l *LessonMgo) OtherParticipantIds(uID bson.ObjectId) (bson.ObjectId, error
 // CanBeModified tells if a lesson can be modified based on state
 func (l *LessonMgo) CanBeModified() bool {
 	switch l.State {
-	case LessonProgress, LessonCompleted, LessonCancelled:
+	case LessonProgress, LessonCompleted, LessonCancelled, LessonExpired, LessonNoShow:
 		return false
 	default:
 		return true
@@ -585,13 +784,48 @@ func (l *LessonMgo) lockMux() {
 	l.updatemux.Lock()
 }
 
+// updateRevised saves a change calendars show, bumping the lesson's sequence with it.
+// The sequence is only bumped on the lesson once the change is saved.
+func (l *LessonMgo) updateRevised(update bson.M) error {
+	return l.updateRevisedIf(nil, update)
+}
+
+// updateRevisedIf is updateRevised for a lesson still matching the query. It returns
+// mgo.ErrNotFound when the saved lesson doesn't match it anymore.
+func (l *LessonMgo) updateRevisedIf(query bson.M, update bson.M) error {
+	now := time.Now()
+
+	selector := bson.M{"_id": l.ID}
+	for k, v := range query {
+		selector[k] = v
+	}
+
+	set, _ := update["$set"].(bson.M)
+	if set == nil {
+		set = bson.M{}
+	}
+	set["updated_at"] = now
+
+	update["$set"] = set
+	update["$inc"] = bson.M{"sequence": 1}
+
+	if err := GetCollection("lessons").Update(selector, update); err != nil {
+		return err
+	}
+
+	l.Sequence++
+	l.UpdatedAt = &now
+
+	return nil
+}
+
 // SetSubject updates the lesson's subject.
 func (l *LessonMgo) SetSubject(s bson.ObjectId) error {
 	l.lockMux()
 	defer l.updatemux.Unlock()
 
 	l.Subject = s
-	err := GetCollection("lessons").UpdateId(l.ID, bson.M{"$set": bson.M{"subject": s}})
+	err := l.updateRevised(bson.M{"$set": bson.M{"subject": s}})
 
 	return errors.Wrap(err, "couldn't update lesson subject")
 }
@@ -604,7 +838,7 @@ func (l *LessonMgo) SetMeetAndLocation(m Meet, loc string) error {
 	l.Meet = m
 	l.Location = loc
 
-	err := GetCollection("lessons").UpdateId(l.ID, bson.M{"$set": bson.M{
+	err := l.updateRevised(bson.M{"$set": bson.M{
 		"meet":     m,
 		"location": loc,
 	}})
@@ -620,7 +854,7 @@ func (l *LessonMgo) SetTimes(startsAt, endsAt time.Time) error {
 	l.StartsAt = startsAt
 	l.EndsAt = endsAt
 
-	err := GetCollection("lessons").UpdateId(l.ID, bson.M{"$set": bson.M{
+	err := l.updateRevised(bson.M{"$set": bson.M{
 		"starts_at": l.StartsAt,
 		"ends_at":   l.EndsAt,
 	}})
@@ -661,40 +895,72 @@ func (l *LessonMgo) ProposeChange(change LessonChangeProposal) error {
 	return nil
 }
 
-// SetState sets the lesson's state, and updates its data, if provided.
-func (l *LessonMgo) SetState(state LessonState, all *bool, dataOpts ...interface{}) (err error) {
-	var data interface{}
-	if len(dataOpts) > 0 {
-		data = dataOpts[0]
-	}
+// SetState moves the lesson to a new state, if the transition is allowed from its
+// current state. For recurring lessons, the change's scope tells which lessons of
+// the series move too; the ones that can't are left as they are.
+func (l *LessonMgo) SetState(state LessonState, change StateChange) error {
+	_, err := l.SetScopedState(state, change)
+	return err
+}
+
+// SetScopedState is SetState returning the lessons it moved, the lesson first. Lessons of
+// the series another change moved meanwhile aren't among them.
+func (l *LessonMgo) SetScopedState(state LessonState, change StateChange) (moved []*LessonMgo, err error) {
+	transition := &LessonTransition{Lesson: l, From: l.State, To: state, StateChange: change}
 
 	l.lockMux()
-	defer l.updatemux.Unlock()
+	err = transition.CanTransition()
+	if err == nil {
+		err = transition.apply()
+	}
+	l.updatemux.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	moved = append(moved, l)
+
+	// hooks run unlocked, so they can update the lesson
+	transition.after()
 
-	idsToUpdate := []bson.ObjectId{l.ID}
-	if all != nil && *all && l.RecurrentID != nil {
-		futureLessons := GetLessonsStore().GetFutureRecurringLessons(*l.RecurrentID, l.StartsAt)
-		for _, lesson := range futureLessons {
-			idsToUpdate = append(idsToUpdate, lesson.ID)
+	// the earliest cancelled lesson is where the series stops
+	from := l.StartsAt
+
+	lessons := l.ScopedLessons(change.Scope)
+	for i := 1; i < len(lessons); i++ {
+		other := &LessonTransition{Lesson: &lessons[i], From: lessons[i].State, To: state, StateChange: change, Series: true}
+		if err := other.CanTransition(); err != nil {
+			logger.Get().Infof("skipping lesson %s of the series: %v", lessons[i].ID.Hex(), err)
+			continue
+		}
+
+		if err := other.apply(); err != nil {
+			logger.Get().Errorf("couldn't set state of lesson %s of the series: %v", lessons[i].ID.Hex(), err)
+			continue
+		}
+		other.after()
+		moved = append(moved, &lessons[i])
+
+		if lessons[i].StartsAt.Before(from) {
+			from = lessons[i].StartsAt
 		}
 	}
 
-	logger.Get().Infof("IDs to Update: %#v", idsToUpdate)
-	for _, id := range idsToUpdate {
-		err = GetCollection("lessons").UpdateId(id, bson.M{
-			"$set": bson.M{"state": state},
-			"$push": bson.M{
-				"state_timeline": LessonStateData{State: state, Time: time.Now(), Data: data},
-			},
-		})
+	if state != LessonCancelled {
+		return moved, nil
 	}
 
-	return
+	if series, ok := l.Series(); ok {
+		return moved, series.Cancel(from, change.Scope)
+	}
+
+	return moved, nil
 }
 
 // SetState sets the lesson's state, and updates its data, if provided.
 func (l *LessonMgo) SetEndsAt(endsAt time.Time) (err error) {
-	err = GetCollection("lessons").UpdateId(l.ID, bson.M{
+	err = l.updateRevised(bson.M{
 		"$set": bson.M{"ends_at": endsAt},
 	})
 
@@ -721,7 +987,22 @@ func (l *LessonMgo) HasUserID(userID bson.ObjectId) bool {
 
 // EveryoneAccepted returns whether everyone in the lesson has accepted the lesson, or not.
 func (l *LessonMgo) EveryoneAccepted() bool {
-	return len(l.Students)+1 /*tutor*/ == len(l.Accepted)
+	accepted := make(map[string]bool, len(l.Accepted))
+	for _, id := range l.Accepted {
+		accepted[id.Hex()] = true
+	}
+
+	if !accepted[l.Tutor.Hex()] {
+		return false
+	}
+
+	for _, id := range l.Students {
+		if !accepted[id.Hex()] {
+			return false
+		}
+	}
+
+	return true
 }
 
 // AcceptBy sets the accepted state by the specified user. Returns whether everyone accepted the lesson,
@@ -751,28 +1032,216 @@ func (l *LessonMgo) AcceptBy(user *UserMgo) (everyone bool, err error) {
 	return l.EveryoneAccepted(), err
 }
 
+// Join takes an open seat of a group lesson for the student. Joining a lesson
+// counts as accepting it.
+func (l *LessonMgo) Join(student *UserMgo) error {
+	l.lockMux()
+	defer l.updatemux.Unlock()
+
+	if !l.IsGroup() {
+		return errors.New("only group lessons can be joined")
+	}
+
+	if !l.CanBeModified() {
+		return errors.New("lesson can't be joined anymore")
+	}
+
+	if l.Tutor.Hex() == student.ID.Hex() || l.HasStudent(student.ID) {
+		return errors.New("already a participant of the lesson")
+	}
+
+	// the seat check is done in the query too, so two students can't take the last seat at the same time
+	err := GetCollection("lessons").Update(bson.M{
+		"_id": l.ID,
+		"students": bson.M{
+			"$ne":  student.ID,
+			"$not": bson.M{"$size": l.SeatsCapacity()},
+		},
+	}, bson.M{
+		"$push": bson.M{
+			"students": student.ID,
+			"accepted": student.ID,
+		},
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "no open seats left in the lesson")
+	}
+
+	l.Students = append(l.Students, student.ID)
+	l.Accepted = append(l.Accepted, student.ID)
+
+	return nil
+}
+
+// Leave frees the student's seat in a group lesson.
+func (l *LessonMgo) Leave(student *UserMgo) error {
+	l.lockMux()
+	defer l.updatemux.Unlock()
+
+	if !l.IsGroup() {
+		return errors.New("only group lessons can be left")
+	}
+
+	if !l.CanBeModified() {
+		return errors.New("lesson can't be left anymore")
+	}
+
+	if !l.HasStudent(student.ID) {
+		return errors.New("not a student of the lesson")
+	}
+
+	// the seat is only freed once, leaving twice at the same time doesn't bill the student twice
+	err := GetCollection("lessons").Update(bson.M{
+		"_id":      l.ID,
+		"students": student.ID,
+	}, bson.M{
+		"$pull": bson.M{
+			"students": student.ID,
+			"accepted": student.ID,
+		},
+	})
+
+	if err == mgo.ErrNotFound {
+		return errors.New("not a student of the lesson")
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't remove the student from the lesson")
+	}
+
+	l.Students = removeObjectID(l.Students, student.ID)
+	l.Accepted = removeObjectID(l.Accepted, student.ID)
+
+	return nil
+}
+
+// SetPromoCode sets the promo code the student's seat is charged with, an empty code removes it
+func (l *LessonMgo) SetPromoCode(student bson.ObjectId, code string) error {
+	l.lockMux()
+	defer l.updatemux.Unlock()
+
+	field := "promo_codes." + student.Hex()
+	update := bson.M{"$set": bson.M{field: code}}
+	if code == "" {
+		update = bson.M{"$unset": bson.M{field: ""}}
+	}
+
+	if err := GetCollection("lessons").UpdateId(l.ID, update); err != nil {
+		return errors.Wrap(err, "couldn't set the promo code of the lesson")
+	}
+
+	if code == "" {
+		delete(l.PromoCodes, student.Hex())
+		return nil
+	}
+
+	if l.PromoCodes == nil {
+		l.PromoCodes = make(map[string]string)
+	}
+	l.PromoCodes[student.Hex()] = code
+
+	return nil
+}
+
+// ClaimRefund claims the refund of what the student paid for the lesson. It fails while
+// another refund of it runs, refunds claimed before staleBefore are taken as stopped.
+// It also fails while a refund that couldn't be saved is held.
+func (l *LessonMgo) ClaimRefund(student bson.ObjectId, staleBefore time.Time) error {
+	field := "refunding." + student.Hex()
+
+	err := GetCollection("lessons").Update(bson.M{
+		"_id":                          l.ID,
+		"refund_held." + student.Hex(): bson.M{"$exists": false},
+		"$or": []bson.M{
+			{field: bson.M{"$exists": false}},
+			{field: bson.M{"$lt": staleBefore}},
+		},
+	}, bson.M{"$set": bson.M{field: time.Now()}})
+
+	if err == mgo.ErrNotFound {
+		return errors.New("the lesson is already being refunded, or its last refund wasn't saved")
+	}
+
+	return errors.Wrap(err, "couldn't claim the refund of the lesson")
+}
+
+// ReleaseRefund lets the student's payment of the lesson be refunded again
+func (l *LessonMgo) ReleaseRefund(student bson.ObjectId) error {
+	return errors.Wrap(GetCollection("lessons").UpdateId(l.ID, bson.M{"$unset": bson.M{"refunding." + student.Hex(): ""}}), "couldn't release the refund of the lesson")
+}
+
+// HoldRefund turns the claim of a refund that moved money but couldn't be saved into a
+// hold that doesn't go stale, so the student's payment isn't refunded twice.
+func (l *LessonMgo) HoldRefund(student bson.ObjectId) error {
+	err := GetCollection("lessons").UpdateId(l.ID, bson.M{
+		"$set":   bson.M{"refund_held." + student.Hex(): time.Now()},
+		"$unset": bson.M{"refunding." + student.Hex(): ""},
+	})
+	return errors.Wrap(err, "couldn't hold the refund of the lesson")
+}
+
+// SetCapacity updates the number of seats of the lesson. The capacity can't be lower
+// than the number of students already in the lesson.
+func (l *LessonMgo) SetCapacity(capacity int) error {
+	l.lockMux()
+	defer l.updatemux.Unlock()
+
+	if capacity < 1 {
+		return errors.New("capacity must be at least 1")
+	}
+
+	if capacity < len(l.Students) {
+		return fmt.Errorf("lesson already has %d students", len(l.Students))
+	}
+
+	l.Capacity = capacity
+
+	err := GetCollection("lessons").UpdateId(l.ID, bson.M{"$set": bson.M{"capacity": capacity}})
+	return errors.Wrap(err, "couldn't update lesson capacity")
+}
+
+func removeObjectID(ids []bson.ObjectId, id bson.ObjectId) []bson.ObjectId {
+	out := make([]bson.ObjectId, 0, len(ids))
+	for _, v := range ids {
+		if v.Hex() != id.Hex() {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 // Reject cancels a lesson with a rejection
 func (l *LessonMgo) Reject(user *UserMgo, reason string) (err error) {
-	// TODO: Case when one student from many reject the lesson
-	// Remove from students
-	// If lesson has fewer than X students reject the lesson
-
 	if !l.HasUser(user) {
 		return errors.New("only lesson participants can reject the lesson")
 	}
 
-	if l.State == LessonCancelled {
-		return errors.New("lesson already cancelled")
-	}
-
-	err = l.SetState(LessonCancelled, nil, map[string]interface{}{
-		"user":   user.ID,
-		"reason": reason,
+	err = l.SetState(LessonCancelled, StateChange{
+		Actor:  user,
+		Reason: reason,
+		Scope:  ScopeOccurrence,
 	})
 
 	return errors.Wrap(err, "Fail to set cancelled state")
 }
 
+// SetAttendance saves the result of the lesson's no-show check. A nil noShow
+// means everyone attended.
+func (l *LessonMgo) SetAttendance(noShow *NoShowReport) error {
+	now := time.Now()
+	l.NoShow = noShow
+	l.AttendanceCheckedAt = &now
+
+	set := bson.M{"attendance_checked_at": now}
+	if noShow != nil {
+		set["no_show"] = noShow
+	}
+
+	err := GetCollection("lessons").UpdateId(l.ID, bson.M{"$set": set})
+	return errors.Wrap(err, "couldn't save lesson attendance")
+}
+
 // SetRecurrent updates a lesson to be recurrent
 func (l *LessonMgo) SetRecurrent(r bool, count int) error {
 	if l.Recurrent == r {
@@ -887,14 +1356,61 @@ func (L *LessonsStore) GetLessonsWithin24Hours(start time.Time, end time.Time) (
 	return
 }
 
+// GetFutureRecurringLessons gets the lessons of the series starting from startDate
 func (L *LessonsStore) GetFutureRecurringLessons(recurrentID bson.ObjectId, startDate time.Time) (lessons []LessonMgo) {
-	GetCollection("lessons").Find(bson.M{"$and": []bson.M{
-		{"recurrent_id": recurrentID},
-		{"starts_at": bson.M{"$gte": startDate}},
-	}}).Sort("-created_at").All(&lessons)
+	series, ok := L.GetSeries(recurrentID)
+	if !ok {
+		return
+	}
+
+	lessons, err := series.Lessons(startDate)
+	if err != nil {
+		logger.Get().Errorf("couldn't get future recurring lessons: %v", err)
+	}
 	return
 }
 
+// GetLessonsToCheckAttendance gets the confirmed or running lessons that started
+// between since and until, and weren't checked for no-shows yet.
+func (L *LessonsStore) GetLessonsToCheckAttendance(since, until time.Time) (lessons []LessonMgo, err error) {
+	err = GetCollection("lessons").Find(bson.M{
+		"state":                 bson.M{"$in": []LessonState{LessonConfirmed, LessonProgress}},
+		"type":                  bson.M{"$ne": LessonInstant},
+		"starts_at":             bson.M{"$gte": since, "$lte": until},
+		"attendance_checked_at": bson.M{"$exists": false},
+	}).All(&lessons)
+
+	return lessons, errors.Wrap(err, "couldn't get lessons to check attendance")
+}
+
+// GetOpenGroupLessons gets the upcoming group lessons that still have open seats.
+// An invalid subject returns open lessons of all subjects.
+func (L *LessonsStore) GetOpenGroupLessons(subject bson.ObjectId, offset, limit int) (*PaginatedLessons, error) {
+	q := bson.M{
+		"capacity":  bson.M{"$gt": 1},
+		"starts_at": bson.M{"$gt": time.Now()},
+		"state":     bson.M{"$in": []LessonState{LessonBooked, LessonConfirmed}},
+		"$expr":     bson.M{"$lt": []interface{}{bson.M{"$size": "$students"}, "$capacity"}},
+	}
+
+	if subject.Valid() {
+		q["subject"] = subject
+	}
+
+	query := GetCollection("lessons").Find(q).Sort("starts_at")
+	length, err := query.Count()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't get lessons from database")
+	}
+
+	var l []LessonMgo
+	if err := query.Skip(offset).Limit(limit).All(&l); err != nil {
+		return nil, errors.Wrap(err, "couldn't get lessons from database")
+	}
+
+	return &PaginatedLessons{Lessons: l, Length: length}, nil
+}
+
 func (L *LessonsStore) GetAllUserLessons(user *UserMgo) ([]LessonMgo, error) {
 	var lessons []LessonMgo
 	if err := GetCollection("lessons").Find(bson.M{"$or": []bson.M{
@@ -974,6 +1490,7 @@ func (L *LessonsStore) GetUserLessons(user *UserMgo, lessThanEqual bool, state L
 				"tutor":            1,
 				"students":         1,
 				"rate":             1,
+				"capacity":         1,
 				"state":            1,
 				"state_timeline":   1,
 				"accepted":         1,
@@ -982,6 +1499,10 @@ func (L *LessonsStore) GetUserLessons(user *UserMgo, lessThanEqual bool, state L
 				"auto_billable":    1,
 				"created_at":       1,
 				"recurrent":        1,
+				"sequence":         1,
+				"course":           1,
+				"agenda":           1,
+				"updated_at":       1,
 			},
 		},
 	}
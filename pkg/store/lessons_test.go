@@ -0,0 +1,446 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/utils/rrule"
+)
+
+func TestLessonSeats(t *testing.T) {
+	s1, s2 := bson.NewObjectId(), bson.NewObjectId()
+
+	tests := []struct {
+		name     string
+		lesson   LessonMgo
+		group    bool
+		capacity int
+		open     int
+	}{
+		{
+			name:     "one to one lesson",
+			lesson:   LessonMgo{Students: []bson.ObjectId{s1}},
+			group:    false,
+			capacity: 1,
+			open:     0,
+		},
+		{
+			name:     "empty group lesson",
+			lesson:   LessonMgo{Capacity: 4},
+			group:    true,
+			capacity: 4,
+			open:     4,
+		},
+		{
+			name:     "group lesson with students",
+			lesson:   LessonMgo{Capacity: 4, Students: []bson.ObjectId{s1, s2}},
+			group:    true,
+			capacity: 4,
+			open:     2,
+		},
+		{
+			name:     "lesson without capacity",
+			lesson:   LessonMgo{Students: []bson.ObjectId{s1, s2}},
+			group:    false,
+			capacity: 2,
+			open:     0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.lesson.IsGroup(); got != tt.group {
+				t.Errorf("IsGroup() = %v, want %v", got, tt.group)
+			}
+			if got := tt.lesson.SeatsCapacity(); got != tt.capacity {
+				t.Errorf("SeatsCapacity() = %v, want %v", got, tt.capacity)
+			}
+			if got := tt.lesson.OpenSeats(); got != tt.open {
+				t.Errorf("OpenSeats() = %v, want %v", got, tt.open)
+			}
+		})
+	}
+}
+
+func TestLessonEveryoneAccepted(t *testing.T) {
+	tutor, s1, s2 := bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+
+	lesson := LessonMgo{
+		Tutor:    tutor,
+		Students: []bson.ObjectId{s1, s2},
+		Accepted: []bson.ObjectId{tutor, s1, s1},
+	}
+
+	if lesson.EveryoneAccepted() {
+		t.Error("lesson shouldn't be accepted while a student didn't accept it")
+	}
+
+	lesson.Accepted = append(lesson.Accepted, s2)
+	if !lesson.EveryoneAccepted() {
+		t.Error("lesson should be accepted by everyone")
+	}
+}
+
+func TestTutoringSeatRate(t *testing.T) {
+	tutoring := Tutoring{Rate: 4000}
+
+	if got := tutoring.SeatRate(3); got != 4000 {
+		t.Errorf("SeatRate() without group rate = %v, want 4000", got)
+	}
+
+	tutoring.GroupRate = 2500
+	if got := tutoring.SeatRate(1); got != 4000 {
+		t.Errorf("SeatRate() for one seat = %v, want 4000", got)
+	}
+	if got := tutoring.SeatRate(3); got != 2500 {
+		t.Errorf("SeatRate() for a group = %v, want 2500", got)
+	}
+}
+
+func TestParseSeriesScope(t *testing.T) {
+	tests := map[string]SeriesScope{
+		"":          ScopeOccurrence,
+		"this":      ScopeOccurrence,
+		"following": ScopeFollowing,
+		"all":       ScopeSeries,
+	}
+
+	for in, want := range tests {
+		got, err := ParseSeriesScope(in)
+		if err != nil || got != want {
+			t.Errorf("ParseSeriesScope(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+
+	if _, err := ParseSeriesScope("future"); err == nil {
+		t.Error("ParseSeriesScope() should fail on unknown scopes")
+	}
+}
+
+func TestGetSeriesLegacy(t *testing.T) {
+	dbSetup(t)
+
+	id := bson.NewObjectId()
+	defer GetCollection("lessons").RemoveAll(bson.M{"recurrent_id": id})
+	defer GetCollection("lesson_series").RemoveId(id)
+
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+	for i := 0; i < 3; i++ {
+		lesson := LessonMgo{ID: bson.NewObjectId(), RecurrentID: &id, StartsAt: start.AddDate(0, 0, 7*i)}
+		if err := GetCollection("lessons").Insert(lesson); err != nil {
+			t.Fatalf("couldn't insert lesson: %v", err)
+		}
+	}
+
+	found := make(chan bool, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, ok := GetLessonsStore().GetSeries(id)
+			found <- ok
+		}()
+	}
+
+	for i := 0; i < 2; i++ {
+		if !<-found {
+			t.Error("couldn't get the legacy series")
+		}
+	}
+
+	if n, _ := GetCollection("lesson_series").FindId(id).Count(); n != 1 {
+		t.Errorf("%d legacy series stored, want 1", n)
+	}
+}
+
+func TestLessonCanTransition(t *testing.T) {
+	tutor, student := bson.NewObjectId(), bson.NewObjectId()
+
+	future := LessonMgo{
+		Tutor:    tutor,
+		Students: []bson.ObjectId{student},
+		Accepted: []bson.ObjectId{tutor, student},
+		StartsAt: time.Now().Add(time.Hour),
+		EndsAt:   time.Now().Add(2 * time.Hour),
+	}
+
+	started := future
+	started.StartsAt = time.Now().Add(-time.Hour)
+	started.EndsAt = time.Now().Add(time.Hour)
+
+	ended := future
+	ended.StartsAt = time.Now().Add(-2 * time.Hour)
+	ended.EndsAt = time.Now().Add(-time.Hour)
+
+	pending := future
+	pending.Accepted = []bson.ObjectId{student}
+
+	tests := []struct {
+		name   string
+		lesson LessonMgo
+		from   LessonState
+		to     LessonState
+		series bool
+		legal  bool
+	}{
+		{"confirm accepted lesson", future, LessonBooked, LessonConfirmed, false, true},
+		{"confirm pending lesson", pending, LessonBooked, LessonConfirmed, false, false},
+		{"confirm pending lesson of a series", pending, LessonBooked, LessonConfirmed, true, true},
+		{"start future lesson", future, LessonConfirmed, LessonProgress, false, false},
+		{"start started lesson", started, LessonConfirmed, LessonProgress, false, true},
+		{"start booked lesson", started, LessonBooked, LessonProgress, false, false},
+		{"expire booked lesson", started, LessonBooked, LessonExpired, false, true},
+		{"complete lesson in progress", started, LessonProgress, LessonCompleted, false, true},
+		{"cancel in progress lesson", started, LessonProgress, LessonCancelled, false, false},
+		{"cancel ended lesson", ended, LessonConfirmed, LessonCancelled, false, false},
+		{"cancel future lesson", future, LessonConfirmed, LessonCancelled, false, true},
+		{"cancel cancelled lesson", future, LessonCancelled, LessonCancelled, false, false},
+		{"reopen completed lesson", ended, LessonCompleted, LessonBooked, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lesson := tt.lesson
+			transition := &LessonTransition{Lesson: &lesson, From: tt.from, To: tt.to, Series: tt.series}
+
+			err := transition.CanTransition()
+			if tt.legal && err != nil {
+				t.Errorf("expected legal transition, got %v", err)
+			}
+
+			if !tt.legal {
+				if _, ok := err.(*IllegalTransitionError); !ok {
+					t.Errorf("expected an IllegalTransitionError, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRoomEnteredBy(t *testing.T) {
+	tutor, student := bson.NewObjectId(), bson.NewObjectId()
+
+	room := RoomEntity{Activity: []RoomActivity{
+		{User: &student, Action: ROOM_ACTIVITY_EDIT},
+		{User: &tutor, Action: ROOM_ACTIVITY_ENTER},
+		{User: nil, Action: ROOM_ACTIVITY_ENTER},
+	}}
+
+	if !room.EnteredBy(tutor) {
+		t.Error("tutor entered the room")
+	}
+	if room.EnteredBy(student) {
+		t.Error("student only edited the room, without entering it")
+	}
+}
+
+func TestChangeProposalFollowing(t *testing.T) {
+	dbSetup(t)
+
+	rule, err := rrule.Parse("FREQ=WEEKLY;COUNT=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 7).Add(10 * time.Hour)
+	series := NewLessonSeries(rule, first)
+	if err := series.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	defer GetCollection("lesson_series").RemoveAll(bson.M{"created_at": bson.M{"$gte": series.CreatedAt}})
+
+	lessons := make([]LessonMgo, 3)
+	for i := range lessons {
+		starts := first.AddDate(0, 0, 7*i)
+		lessons[i] = LessonMgo{ID: bson.NewObjectId(), RecurrentID: &series.ID, StartsAt: starts, EndsAt: starts.Add(time.Hour)}
+		if err := GetCollection("lessons").Insert(&lessons[i]); err != nil {
+			t.Fatal(err)
+		}
+		defer GetCollection("lessons").RemoveId(lessons[i].ID)
+	}
+
+	// the second lesson and the one after it move two hours later
+	second := lessons[1].StartsAt.Add(2 * time.Hour)
+	change := &LessonChangeProposal{Subject: bson.NewObjectId(), StartsAt: second, EndsAt: second.Add(time.Hour)}
+	if _, err := change.Apply(lessons[1].ID, ScopeFollowing); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, l := range lessons {
+		var saved LessonMgo
+		if err := GetCollection("lessons").FindId(l.ID).One(&saved); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := l.StartsAt
+		if i > 0 {
+			expected = l.StartsAt.Add(2 * time.Hour)
+		}
+
+		if !saved.StartsAt.Equal(expected) {
+			t.Errorf("lesson %d: expected to start at %v, got %v", i, expected, saved.StartsAt)
+		}
+
+		if i > 0 && (saved.RecurrentID == nil || *saved.RecurrentID == series.ID) {
+			t.Errorf("lesson %d: expected to be moved to the new series, got %v", i, saved.RecurrentID)
+		}
+	}
+}
+
+func TestLessonLeftOnce(t *testing.T) {
+	dbSetup(t)
+
+	student := &UserMgo{ID: bson.NewObjectId()}
+	lesson := LessonMgo{ID: bson.NewObjectId(), Capacity: 3, Students: []bson.ObjectId{student.ID, bson.NewObjectId()}}
+	if err := GetCollection("lessons").Insert(&lesson); err != nil {
+		t.Fatal(err)
+	}
+	defer GetCollection("lessons").RemoveId(lesson.ID)
+
+	// two requests read the lesson before either freed the seat
+	first, second := lesson, lesson
+	if err := first.Leave(student); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.Leave(student); err == nil {
+		t.Error("expected the seat freed only once")
+	}
+
+	var saved LessonMgo
+	if err := GetCollection("lessons").FindId(lesson.ID).One(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(saved.Students) != 1 || saved.HasStudent(student.ID) {
+		t.Errorf("expected only the other student left, got %v", saved.Students)
+	}
+}
+
+func TestLessonSequenceBumpedWhenSaved(t *testing.T) {
+	dbSetup(t)
+
+	// the lesson isn't saved, the change can't be
+	lesson := &LessonMgo{ID: bson.NewObjectId(), Sequence: 3}
+	if err := lesson.SetTimes(time.Now(), time.Now().Add(time.Hour)); err == nil {
+		t.Fatal("expected the change of a lesson that doesn't exist to fail")
+	}
+
+	if lesson.Sequence != 3 {
+		t.Errorf("expected the sequence kept at 3, got %d", lesson.Sequence)
+	}
+
+	if err := GetCollection("lessons").Insert(lesson); err != nil {
+		t.Fatal(err)
+	}
+	defer GetCollection("lessons").RemoveId(lesson.ID)
+
+	if err := lesson.SetTimes(time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	var saved LessonMgo
+	if err := GetCollection("lessons").FindId(lesson.ID).One(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	if lesson.Sequence != 4 || saved.Sequence != 4 {
+		t.Errorf("expected the sequence bumped to 4, got %d and %d saved", lesson.Sequence, saved.Sequence)
+	}
+}
+
+func TestLessonStateSetOnce(t *testing.T) {
+	dbSetup(t)
+
+	starts := time.Now().Add(-10 * time.Minute)
+	lesson := LessonMgo{ID: bson.NewObjectId(), State: LessonConfirmed, StartsAt: starts, EndsAt: starts.Add(time.Hour)}
+	if err := GetCollection("lessons").Insert(&lesson); err != nil {
+		t.Fatal(err)
+	}
+	defer GetCollection("lessons").RemoveId(lesson.ID)
+
+	// two requests read the confirmed lesson before either completed it
+	first, second := lesson, lesson
+	if err := first.SetState(LessonCompleted, StateChange{}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := second.SetState(LessonNoShow, StateChange{})
+	if _, ok := err.(*IllegalTransitionError); !ok {
+		t.Fatalf("expected the second transition refused, got %v", err)
+	}
+
+	var saved LessonMgo
+	if err := GetCollection("lessons").FindId(lesson.ID).One(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	if saved.State != LessonCompleted || len(saved.StateTimeline) != 1 {
+		t.Errorf("expected the lesson completed once, got %s with %d transitions", saved.State, len(saved.StateTimeline))
+	}
+}
+
+func TestLessonRefundHeld(t *testing.T) {
+	dbSetup(t)
+
+	student := bson.NewObjectId()
+	lesson := LessonMgo{ID: bson.NewObjectId(), Students: []bson.ObjectId{student}}
+	if err := GetCollection("lessons").Insert(&lesson); err != nil {
+		t.Fatal(err)
+	}
+	defer GetCollection("lessons").RemoveId(lesson.ID)
+
+	if err := lesson.ClaimRefund(student, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the refund moved money and couldn't be saved
+	if err := lesson.HoldRefund(student); err != nil {
+		t.Fatal(err)
+	}
+
+	// unlike a claim, the hold doesn't go stale
+	if err := lesson.ClaimRefund(student, time.Now().Add(time.Hour)); err == nil {
+		t.Error("expected the held refund to keep the lesson from being refunded again")
+	}
+}
+
+func TestLessonScopedStateMoved(t *testing.T) {
+	dbSetup(t)
+
+	rule, err := rrule.Parse("FREQ=WEEKLY;COUNT=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 7).Add(10 * time.Hour)
+	series := NewLessonSeries(rule, first)
+	if err := series.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	defer GetCollection("lesson_series").RemoveAll(bson.M{"created_at": bson.M{"$gte": series.CreatedAt}})
+
+	lessons := make([]LessonMgo, 3)
+	for i := range lessons {
+		starts := first.AddDate(0, 0, 7*i)
+		lessons[i] = LessonMgo{ID: bson.NewObjectId(), RecurrentID: &series.ID, State: LessonConfirmed, StartsAt: starts, EndsAt: starts.Add(time.Hour)}
+		if err := GetCollection("lessons").Insert(&lessons[i]); err != nil {
+			t.Fatal(err)
+		}
+		defer GetCollection("lessons").RemoveId(lessons[i].ID)
+	}
+
+	// the last lesson was cancelled by someone else, it isn't moved again
+	if err := lessons[2].SetState(LessonCancelled, StateChange{}); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := lessons[0].SetScopedState(LessonCancelled, StateChange{Scope: ScopeSeries})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(moved) != 2 || moved[0] != &lessons[0] || moved[1].ID != lessons[1].ID {
+		t.Errorf("expected the first two lessons moved, got %d", len(moved))
+	}
+}
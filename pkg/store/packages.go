@@ -0,0 +1,364 @@
+package store
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// defaultPackageValidDays is how long a package can be used when the offer doesn't say
+const defaultPackageValidDays = 180
+
+// PackageOffer is a bundle of lesson hours a tutor sells up front, at a discount
+type PackageOffer struct {
+	ID    bson.ObjectId `json:"_id" bson:"_id"`
+	Name  string        `json:"name" bson:"name"`
+	Hours int           `json:"hours" bson:"hours"`
+	// Discount is the percent off the tutor's rate.
+	Discount  int `json:"discount" bson:"discount"`
+	ValidDays int `json:"valid_days" bson:"valid_days"`
+}
+
+// Validate checks the offer and sets the defaults of the missing fields
+func (o *PackageOffer) Validate() error {
+	if !o.ID.Valid() {
+		o.ID = bson.NewObjectId()
+	}
+
+	if o.Hours < 1 || o.Hours > 100 {
+		return errors.New("package hours must be between 1 and 100")
+	}
+
+	if o.Discount < 0 || o.Discount > 50 {
+		return errors.New("package discount must be between 0% and 50%")
+	}
+
+	if o.ValidDays == 0 {
+		o.ValidDays = defaultPackageValidDays
+	}
+
+	if o.ValidDays < 7 || o.ValidDays > 730 {
+		return errors.New("package must be valid between 7 and 730 days")
+	}
+
+	return nil
+}
+
+// PackageStatus is the state of a bought package
+type PackageStatus string
+
+const (
+	// PackagePending is a package waiting for the student's card to be charged for it
+	PackagePending  PackageStatus = "pending"
+	PackageActive   PackageStatus = "active"
+	PackageUsed     PackageStatus = "used"
+	PackageExpired  PackageStatus = "expired"
+	PackageRefunded PackageStatus = "refunded"
+	// PackageRefunding is a package whose refund was started and not saved yet
+	PackageRefunding PackageStatus = "refunding"
+)
+
+// LessonPackage is a package a student bought from a tutor. Its minutes are drawn
+// as the lessons between them complete, until it's used, expires or is refunded.
+type LessonPackage struct {
+	ID      bson.ObjectId `json:"_id" bson:"_id"`
+	Tutor   bson.ObjectId `json:"tutor" bson:"tutor"`
+	Student bson.ObjectId `json:"student" bson:"student"`
+	Offer   bson.ObjectId `json:"offer" bson:"offer"`
+	Name    string        `json:"name" bson:"name"`
+
+	Rate     int64    `json:"rate" bson:"rate"`
+	Currency Currency `json:"currency,omitempty" bson:"currency,omitempty"`
+	Discount int      `json:"discount" bson:"discount"`
+
+	Minutes     int `json:"minutes" bson:"minutes"`
+	MinutesLeft int `json:"minutes_left" bson:"minutes_left"`
+
+	// Price is what the student paid and TutorPay what the tutor earns for all
+	// the minutes, in minor units of Currency.
+	Price    int64  `json:"price" bson:"price"`
+	TutorPay int64  `json:"tutor_pay" bson:"tutor_pay"`
+	ChargeID string `json:"charge_id" bson:"charge_id"`
+
+	Status     PackageStatus `json:"status" bson:"status"`
+	ExpiresAt  time.Time     `json:"expires_at" bson:"expires_at"`
+	CreatedAt  time.Time     `json:"created_at" bson:"created_at"`
+	RefundID   string        `json:"refund_id,omitempty" bson:"refund_id,omitempty"`
+	RefundedAt *time.Time    `json:"refunded_at,omitempty" bson:"refunded_at,omitempty"`
+	// RefundingFrom is the status the package had before its refund started
+	RefundingFrom PackageStatus `json:"-" bson:"refunding_from,omitempty"`
+}
+
+// NewLessonPackage prices the offer with the tutor's rate. studentCost and
+// tutorPay are what all the hours cost without the discount, in minor units.
+func NewLessonPackage(offer *PackageOffer, tutor, student *UserMgo, studentCost, tutorPay int64) *LessonPackage {
+	off := float64(100-offer.Discount) / 100
+
+	return &LessonPackage{
+		ID:          bson.NewObjectId(),
+		Tutor:       tutor.ID,
+		Student:     student.ID,
+		Offer:       offer.ID,
+		Name:        offer.Name,
+		Rate:        tutor.Tutoring.Rate,
+		Currency:    tutor.Tutoring.Currency.OrDefault(),
+		Discount:    offer.Discount,
+		Minutes:     offer.Hours * 60,
+		MinutesLeft: offer.Hours * 60,
+		Price:       int64(math.Round(float64(studentCost) * off)),
+		TutorPay:    int64(math.Round(float64(tutorPay) * off)),
+		Status:      PackageActive,
+		ExpiresAt:   time.Now().AddDate(0, 0, offer.ValidDays),
+	}
+}
+
+// Value returns what the minutes of the package cost the student and pay the tutor, in minor units
+func (p *LessonPackage) Value(minutes int) (studentCost, tutorPay int64) {
+	if p.Minutes == 0 {
+		return 0, 0
+	}
+
+	share := float64(minutes) / float64(p.Minutes)
+	return int64(math.Round(float64(p.Price) * share)), int64(math.Round(float64(p.TutorPay) * share))
+}
+
+// IsUsable tells if minutes can still be drawn from the package
+func (p *LessonPackage) IsUsable() bool {
+	return p.Status == PackageActive && p.MinutesLeft > 0 && time.Now().Before(p.ExpiresAt)
+}
+
+func (p *LessonPackage) Insert() error {
+	if !p.ID.Valid() {
+		p.ID = bson.NewObjectId()
+	}
+
+	p.CreatedAt = time.Now()
+
+	return errors.Wrap(GetCollection("lesson_packages").Insert(p), "couldn't insert lesson package")
+}
+
+// Activate makes the pending package usable once the student was charged for it
+func (p *LessonPackage) Activate(chargeID string) error {
+	err := GetCollection("lesson_packages").Update(bson.M{
+		"_id":    p.ID,
+		"status": PackagePending,
+	}, bson.M{"$set": bson.M{"status": PackageActive, "charge_id": chargeID}})
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't activate lesson package")
+	}
+
+	p.Status = PackageActive
+	p.ChargeID = chargeID
+	return nil
+}
+
+// Remove removes the pending package the student couldn't be charged for
+func (p *LessonPackage) Remove() error {
+	return errors.Wrap(GetCollection("lesson_packages").Remove(bson.M{"_id": p.ID, "status": PackagePending}), "couldn't remove lesson package")
+}
+
+// Draw takes up to minutes from the package and returns how many it took. It fails
+// when the balance changed since the package was read, so a minute can't be drawn twice.
+func (p *LessonPackage) Draw(minutes int) (int, error) {
+	if !p.IsUsable() || minutes <= 0 {
+		return 0, nil
+	}
+
+	drawn := minutes
+	if drawn > p.MinutesLeft {
+		drawn = p.MinutesLeft
+	}
+
+	set := bson.M{"minutes_left": p.MinutesLeft - drawn}
+	if drawn == p.MinutesLeft {
+		set["status"] = PackageUsed
+	}
+
+	err := GetCollection("lesson_packages").Update(bson.M{
+		"_id":          p.ID,
+		"status":       PackageActive,
+		"minutes_left": p.MinutesLeft,
+	}, bson.M{"$set": set})
+
+	if err == mgo.ErrNotFound {
+		return 0, errors.New("package balance changed, try again")
+	}
+
+	if err != nil {
+		return 0, errors.Wrap(err, "couldn't draw from lesson package")
+	}
+
+	p.MinutesLeft -= drawn
+	if p.MinutesLeft == 0 {
+		p.Status = PackageUsed
+	}
+
+	return drawn, nil
+}
+
+// Expire ends a package that wasn't used before it expired
+func (p *LessonPackage) Expire() error {
+	err := GetCollection("lesson_packages").Update(bson.M{
+		"_id":    p.ID,
+		"status": PackageActive,
+	}, bson.M{"$set": bson.M{"status": PackageExpired}})
+
+	if err == mgo.ErrNotFound {
+		return errors.New("package isn't active")
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't expire lesson package")
+	}
+
+	p.Status = PackageExpired
+	return nil
+}
+
+// ClaimRefund marks an active or expired package as being refunded, so its minutes can't
+// be drawn or refunded again meanwhile. It fails when the package was refunded already
+// or its balance changed.
+func (p *LessonPackage) ClaimRefund() error {
+	if p.Status != PackageActive && p.Status != PackageExpired {
+		return errors.New("package can't be refunded")
+	}
+
+	err := GetCollection("lesson_packages").Update(bson.M{
+		"_id":          p.ID,
+		"status":       p.Status,
+		"minutes_left": p.MinutesLeft,
+	}, bson.M{"$set": bson.M{
+		"status":         PackageRefunding,
+		"refunding_from": p.Status,
+	}})
+
+	if err == mgo.ErrNotFound {
+		return errors.New("package was already refunded or its balance changed")
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't claim lesson package refund")
+	}
+
+	p.RefundingFrom = p.Status
+	p.Status = PackageRefunding
+
+	return nil
+}
+
+// ReleaseRefund gives the package back the status it had before a refund that didn't go through
+func (p *LessonPackage) ReleaseRefund() error {
+	err := GetCollection("lesson_packages").Update(bson.M{
+		"_id":    p.ID,
+		"status": PackageRefunding,
+	}, bson.M{
+		"$set":   bson.M{"status": p.RefundingFrom},
+		"$unset": bson.M{"refunding_from": 1},
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't release lesson package refund")
+	}
+
+	p.Status = p.RefundingFrom
+	p.RefundingFrom = ""
+
+	return nil
+}
+
+// SetRefunded empties the package being refunded once its unused minutes were refunded
+func (p *LessonPackage) SetRefunded(refundID string) error {
+	now := time.Now()
+
+	err := GetCollection("lesson_packages").Update(bson.M{
+		"_id":    p.ID,
+		"status": PackageRefunding,
+	}, bson.M{
+		"$set": bson.M{
+			"status":       PackageRefunded,
+			"minutes_left": 0,
+			"refund_id":    refundID,
+			"refunded_at":  now,
+		},
+		"$unset": bson.M{"refunding_from": 1},
+	})
+
+	if err == mgo.ErrNotFound {
+		return errors.New("package isn't being refunded")
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't refund lesson package")
+	}
+
+	p.Status = PackageRefunded
+	p.RefundingFrom = ""
+	p.MinutesLeft = 0
+	p.RefundID = refundID
+	p.RefundedAt = &now
+
+	return nil
+}
+
+// GetLessonPackage gets a package by ID
+func GetLessonPackage(id bson.ObjectId) (*LessonPackage, bool) {
+	var p LessonPackage
+	if err := GetCollection("lesson_packages").FindId(id).One(&p); err != nil {
+		return nil, false
+	}
+	return &p, true
+}
+
+// GetUsablePackages gets the packages the student can draw lessons with the tutor
+// from, the one expiring first is drawn first.
+func GetUsablePackages(tutor, student bson.ObjectId) (packages []LessonPackage, err error) {
+	err = GetCollection("lesson_packages").Find(bson.M{
+		"tutor":        tutor,
+		"student":      student,
+		"status":       PackageActive,
+		"minutes_left": bson.M{"$gt": 0},
+		"expires_at":   bson.M{"$gt": time.Now()},
+	}).Sort("expires_at").All(&packages)
+
+	return packages, errors.Wrap(err, "couldn't get usable lesson packages")
+}
+
+// GetUserPackages gets the packages the user bought or sold, newest first
+func GetUserPackages(user bson.ObjectId) (packages []LessonPackage, err error) {
+	err = GetCollection("lesson_packages").Find(bson.M{
+		"$or": []bson.M{
+			{"tutor": user},
+			{"student": user},
+		},
+	}).Sort("-created_at").All(&packages)
+
+	return packages, errors.Wrap(err, "couldn't get lesson packages")
+}
+
+// GetPrepaidPackages gets the active packages of the user, with minutes left
+func GetPrepaidPackages(user bson.ObjectId) (packages []LessonPackage, err error) {
+	err = GetCollection("lesson_packages").Find(bson.M{
+		"$or": []bson.M{
+			{"tutor": user},
+			{"student": user},
+		},
+		"status":       PackageActive,
+		"minutes_left": bson.M{"$gt": 0},
+	}).Sort("expires_at").All(&packages)
+
+	return packages, errors.Wrap(err, "couldn't get prepaid lesson packages")
+}
+
+// GetExpiredPackages gets the active packages past their expiry
+func GetExpiredPackages() (packages []LessonPackage, err error) {
+	err = GetCollection("lesson_packages").Find(bson.M{
+		"status":     PackageActive,
+		"expires_at": bson.M{"$lte": time.Now()},
+	}).All(&packages)
+
+	return packages, errors.Wrap(err, "couldn't get expired lesson packages")
+}
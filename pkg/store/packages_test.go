@@ -0,0 +1,107 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestPackageOfferValidate(t *testing.T) {
+	offer := PackageOffer{Hours: 10, Discount: 10}
+	if err := offer.Validate(); err != nil {
+		t.Fatalf("expected valid offer, got %v", err)
+	}
+
+	if !offer.ID.Valid() || offer.ValidDays != defaultPackageValidDays {
+		t.Errorf("expected id and default validity to be set, got %+v", offer)
+	}
+
+	invalid := []PackageOffer{
+		{Hours: 0, Discount: 10},
+		{Hours: 10, Discount: 60},
+		{Hours: 10, Discount: -1},
+		{Hours: 10, ValidDays: 1000},
+	}
+
+	for _, o := range invalid {
+		if err := o.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", o)
+		}
+	}
+}
+
+func TestLessonPackageValue(t *testing.T) {
+	tutor := &UserMgo{ID: bson.NewObjectId(), Tutoring: &Tutoring{Rate: 4000, Currency: CurrencyCAD}}
+	student := &UserMgo{ID: bson.NewObjectId()}
+	offer := &PackageOffer{ID: bson.NewObjectId(), Hours: 10, Discount: 10, ValidDays: 30}
+
+	pkg := NewLessonPackage(offer, tutor, student, 52000, 40000)
+
+	if pkg.Price != 46800 || pkg.TutorPay != 36000 {
+		t.Fatalf("expected discounted price 46800 and pay 36000, got %d and %d", pkg.Price, pkg.TutorPay)
+	}
+
+	if pkg.Currency != CurrencyCAD {
+		t.Errorf("expected package priced in the tutor's currency, got %s", pkg.Currency)
+	}
+
+	if pkg.Minutes != 600 || pkg.MinutesLeft != 600 {
+		t.Fatalf("expected 600 minutes, got %d", pkg.Minutes)
+	}
+
+	if cost, pay := pkg.Value(60); cost != 4680 || pay != 3600 {
+		t.Errorf("expected an hour to cost 4680 and pay 3600, got %d and %d", cost, pay)
+	}
+
+	if !pkg.IsUsable() {
+		t.Error("expected new package to be usable")
+	}
+
+	pkg.ExpiresAt = time.Now().Add(-time.Minute)
+	if pkg.IsUsable() {
+		t.Error("expected expired package not to be usable")
+	}
+}
+
+func TestLessonPackageRefundClaimedOnce(t *testing.T) {
+	dbSetup(t)
+
+	pkg := &LessonPackage{ID: bson.NewObjectId(), Minutes: 600, MinutesLeft: 300, Status: PackageActive}
+	if err := GetCollection("lesson_packages").Insert(pkg); err != nil {
+		t.Fatal(err)
+	}
+	defer GetCollection("lesson_packages").RemoveId(pkg.ID)
+
+	// two requests read the package before either refunded it
+	first, second := *pkg, *pkg
+	if err := first.ClaimRefund(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.ClaimRefund(); err == nil {
+		t.Error("expected the package claimed only once")
+	}
+
+	// a refund that didn't go through leaves the package as it was
+	if err := first.ReleaseRefund(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, ok := GetLessonPackage(pkg.ID)
+	if !ok || saved.Status != PackageActive || saved.MinutesLeft != 300 {
+		t.Fatalf("expected the package active again, got %+v", saved)
+	}
+
+	if err := saved.ClaimRefund(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := saved.SetRefunded("re_test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if saved, _ = GetLessonPackage(pkg.ID); saved.Status != PackageRefunded || saved.MinutesLeft != 0 {
+		t.Errorf("expected the package refunded, got %+v", saved)
+	}
+}
@@ -0,0 +1,299 @@
+package store
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// PayoutBatchStatus is how far the payouts of a batch got
+type PayoutBatchStatus string
+
+const (
+	// PayoutBatchPending is a batch waiting for an admin to approve it
+	PayoutBatchPending PayoutBatchStatus = "pending"
+	// PayoutBatchPaying is a batch whose transfers are being sent, no one else pays it meanwhile
+	PayoutBatchPaying PayoutBatchStatus = "paying"
+	PayoutBatchPaid   PayoutBatchStatus = "paid"
+	// PayoutBatchFailed is a batch with payouts that failed, to be retried
+	PayoutBatchFailed PayoutBatchStatus = "failed"
+)
+
+// PayoutStatus is how a tutor's payout of a batch went
+type PayoutStatus string
+
+const (
+	PayoutPending PayoutStatus = "pending"
+	PayoutPaid    PayoutStatus = "paid"
+	PayoutFailed  PayoutStatus = "failed"
+	// PayoutSkipped is a tutor left out of the batch, their earnings wait for the next one
+	PayoutSkipped PayoutStatus = "skipped"
+)
+
+// TutorPayout is what a batch pays a tutor in a currency, in its minor units. Tutors earning
+// in more than one currency get a payout for each.
+type TutorPayout struct {
+	Tutor    bson.ObjectId `json:"tutor" bson:"tutor"`
+	Amount   int64         `json:"amount" bson:"amount"`
+	Currency Currency      `json:"currency" bson:"currency"`
+	Status   PayoutStatus  `json:"status" bson:"status"`
+	// Error is why the payout failed or was skipped
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+
+	TransferID string         `json:"transfer_id,omitempty" bson:"transfer_id,omitempty"`
+	Entry      *bson.ObjectId `json:"entry,omitempty" bson:"entry,omitempty"`
+	Attempts   int            `json:"attempts" bson:"attempts"`
+	// Failures is how many transfers definitely failed. The transfer is retried with a new
+	// key after each, the key of one that may have gone through is kept.
+	Failures int        `json:"failures,omitempty" bson:"failures,omitempty"`
+	PaidAt   *time.Time `json:"paid_at,omitempty" bson:"paid_at,omitempty"`
+}
+
+// PayoutBatch pays the tutors what they earned by its cutoff, one transfer each
+type PayoutBatch struct {
+	ID     bson.ObjectId     `json:"_id" bson:"_id"`
+	Status PayoutBatchStatus `json:"status" bson:"status"`
+	// Cutoff is when the earnings of the batch were made by, later ones are held for the next batch
+	Cutoff  time.Time     `json:"cutoff" bson:"cutoff"`
+	Payouts []TutorPayout `json:"payouts" bson:"payouts"`
+
+	CreatedAt  time.Time      `json:"created_at" bson:"created_at"`
+	ApprovedBy *bson.ObjectId `json:"approved_by,omitempty" bson:"approved_by,omitempty"`
+	ApprovedAt *time.Time     `json:"approved_at,omitempty" bson:"approved_at,omitempty"`
+	// PayingAt is when the batch was last claimed to be paid
+	PayingAt *time.Time `json:"paying_at,omitempty" bson:"paying_at,omitempty"`
+}
+
+// Totals is what the batch pays in each currency, leaving out the tutors it skipped
+func (b *PayoutBatch) Totals() map[Currency]int64 {
+	totals := make(map[Currency]int64)
+	for _, payout := range b.Payouts {
+		if payout.Status != PayoutSkipped {
+			totals[payout.Currency.OrDefault()] += payout.Amount
+		}
+	}
+	return totals
+}
+
+// SetStatus sets the status of the approved batch from its payouts
+func (b *PayoutBatch) SetStatus() {
+	b.Status = PayoutBatchPaid
+	for _, payout := range b.Payouts {
+		if payout.Status == PayoutFailed || payout.Status == PayoutPending {
+			b.Status = PayoutBatchFailed
+			return
+		}
+	}
+}
+
+// Insert stores the new batch
+func (b *PayoutBatch) Insert() error {
+	b.ID = bson.NewObjectId()
+	b.CreatedAt = time.Now()
+	if b.Status == "" {
+		b.Status = PayoutBatchPending
+	}
+
+	return errors.Wrap(GetCollection("payout_batches").Insert(b), "couldn't insert payout batch")
+}
+
+// Save saves the status and payouts of the batch
+func (b *PayoutBatch) Save() error {
+	return errors.Wrap(GetCollection("payout_batches").UpdateId(b.ID, bson.M{
+		"$set": bson.M{
+			"status":      b.Status,
+			"payouts":     b.Payouts,
+			"approved_by": b.ApprovedBy,
+			"approved_at": b.ApprovedAt,
+		},
+	}), "couldn't save payout batch")
+}
+
+// Claim moves the batch from the status to paying, for one approval or retry to pay it. It fails
+// when the batch isn't in the status anymore, another one is paying it. Paying batches can be
+// claimed again once they were claimed before staleBefore, the ones left when paying them stopped.
+func (b *PayoutBatch) Claim(from PayoutBatchStatus, staleBefore time.Time) error {
+	query := bson.M{"_id": b.ID, "status": from}
+	if from == PayoutBatchPaying {
+		query["paying_at"] = bson.M{"$lt": staleBefore}
+	}
+
+	now := time.Now()
+	err := GetCollection("payout_batches").Update(query, bson.M{
+		"$set": bson.M{
+			"status":      PayoutBatchPaying,
+			"paying_at":   now,
+			"approved_by": b.ApprovedBy,
+			"approved_at": b.ApprovedAt,
+		},
+	})
+
+	if err == mgo.ErrNotFound {
+		return errors.New("payout batch is already being paid")
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't claim payout batch")
+	}
+
+	b.Status = PayoutBatchPaying
+	b.PayingAt = &now
+	return nil
+}
+
+// GetPayoutBatch gets the batch by id
+func GetPayoutBatch(id bson.ObjectId) (*PayoutBatch, bool) {
+	var b PayoutBatch
+	if err := GetCollection("payout_batches").FindId(id).One(&b); err != nil {
+		return nil, false
+	}
+	return &b, true
+}
+
+// GetPayoutBatches gets the batches in the status, all of them when it's empty, newest first
+func GetPayoutBatches(status PayoutBatchStatus, limit int) ([]PayoutBatch, error) {
+	query := bson.M{}
+	if status != "" {
+		query["status"] = status
+	}
+
+	batches := make([]PayoutBatch, 0)
+	err := GetCollection("payout_batches").Find(query).Sort("-created_at").Limit(limit).All(&batches)
+	return batches, errors.Wrap(err, "couldn't get payout batches")
+}
+
+// GetLastPayoutBatch gets the newest batch
+func GetLastPayoutBatch() (*PayoutBatch, bool) {
+	batches, err := GetPayoutBatches("", 1)
+	if err != nil || len(batches) == 0 {
+		return nil, false
+	}
+	return &batches[0], true
+}
+
+// PayoutClaim is a due line of a tutor's account claimed by a batch. Posted entries are never
+// changed, the claims are kept apart from them, one per line.
+type PayoutClaim struct {
+	ID       bson.ObjectId `json:"_id" bson:"_id"`
+	Line     bson.ObjectId `json:"line" bson:"line"`
+	Entry    bson.ObjectId `json:"entry" bson:"entry"`
+	Batch    bson.ObjectId `json:"batch" bson:"batch"`
+	Tutor    bson.ObjectId `json:"tutor" bson:"tutor"`
+	Currency Currency      `json:"currency" bson:"currency"`
+	Amount   int64         `json:"amount" bson:"amount"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// payableLines is the pipeline of the due lines of the tutors no batch claimed, matching the
+// entry. What they earned waits for the cutoff, what they owe is taken from their next payout.
+func payableLines(cutoff time.Time, entry bson.M) []bson.M {
+	match := bson.M{"lines": bson.M{"$elemMatch": bson.M{"account": AccountTutorPayable, "due": true}}}
+	for k, v := range entry {
+		match[k] = v
+	}
+
+	line := bson.M{
+		"lines.account": AccountTutorPayable,
+		"lines.due":     true,
+		"$or": []bson.M{
+			{"lines.amount": bson.M{"$gt": 0}},
+			{"created_at": bson.M{"$lte": cutoff}},
+		},
+	}
+	if owner, ok := entry["lines.owner"]; ok {
+		line["lines.owner"] = owner
+	}
+
+	return []bson.M{
+		{"$match": match},
+		{"$unwind": "$lines"},
+		{"$match": line},
+		{"$lookup": bson.M{"from": "payout_claims", "localField": "lines._id", "foreignField": "line", "as": "claims"}},
+		{"$match": bson.M{"claims": bson.M{"$size": 0}}},
+	}
+}
+
+// TutorEarnings is what a tutor is owed in a currency, negative when they owe the platform
+type TutorEarnings struct {
+	Tutor    bson.ObjectId `bson:"tutor"`
+	Currency Currency      `bson:"currency"`
+	Amount   int64         `bson:"amount"`
+}
+
+// GetPayableEarnings sums the due lines of each tutor by the cutoff, in each currency
+func GetPayableEarnings(cutoff time.Time) ([]TutorEarnings, error) {
+	pipeline := append(payableLines(cutoff, nil),
+		bson.M{"$group": bson.M{
+			"_id":    bson.M{"tutor": "$lines.owner", "currency": bson.M{"$ifNull": []interface{}{"$currency", DefaultCurrency}}},
+			"amount": bson.M{"$sum": "$lines.amount"},
+		}},
+		bson.M{"$project": bson.M{"_id": 0, "tutor": "$_id.tutor", "currency": "$_id.currency", "amount": 1}},
+	)
+
+	earnings := make([]TutorEarnings, 0)
+	if err := GetCollection("ledger_entries").Pipe(pipeline).All(&earnings); err != nil {
+		return nil, errors.Wrap(err, "couldn't get payable earnings")
+	}
+
+	for i := range earnings {
+		earnings[i].Amount = LedgerLine{Account: AccountTutorPayable, Amount: earnings[i].Amount}.Balance()
+	}
+
+	sort.Slice(earnings, func(i, j int) bool {
+		if earnings[i].Tutor != earnings[j].Tutor {
+			return earnings[i].Tutor < earnings[j].Tutor
+		}
+		return earnings[i].Currency < earnings[j].Currency
+	})
+
+	return earnings, nil
+}
+
+// ClaimEarnings claims the tutor's due lines in the currency by the cutoff for the batch, so no
+// other batch pays them, and returns what they come to
+func ClaimEarnings(tutor bson.ObjectId, currency Currency, cutoff time.Time, batch bson.ObjectId) (int64, error) {
+	pipeline := payableLines(cutoff, bson.M{"lines.owner": tutor, "currency": currency.Query()})
+
+	statements := make([]LedgerStatement, 0)
+	if err := GetCollection("ledger_entries").Pipe(pipeline).All(&statements); err != nil {
+		return 0, errors.Wrap(err, "couldn't get payable earnings")
+	}
+
+	var amount int64
+	for _, s := range statements {
+		err := GetCollection("payout_claims").Insert(&PayoutClaim{
+			ID:        bson.NewObjectId(),
+			Line:      s.Line.ID,
+			Entry:     s.Entry,
+			Batch:     batch,
+			Tutor:     tutor,
+			Currency:  currency.OrDefault(),
+			Amount:    s.Line.Balance(),
+			CreatedAt: time.Now(),
+		})
+
+		// a line claimed meanwhile isn't the batch's
+		if mgo.IsDup(err) {
+			continue
+		}
+
+		if err != nil {
+			return amount, errors.Wrap(err, "couldn't claim earnings")
+		}
+
+		amount += s.Line.Balance()
+	}
+
+	return amount, nil
+}
+
+// ReleaseEarnings gives back the tutor's lines in the currency the batch claimed, for the next
+// batch to claim
+func ReleaseEarnings(tutor bson.ObjectId, currency Currency, batch bson.ObjectId) error {
+	_, err := GetCollection("payout_claims").RemoveAll(bson.M{"batch": batch, "tutor": tutor, "currency": currency.OrDefault()})
+	return errors.Wrap(err, "couldn't release earnings")
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestPayoutBatchSetStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []PayoutStatus
+		expected PayoutBatchStatus
+	}{
+		{"all paid", []PayoutStatus{PayoutPaid, PayoutPaid}, PayoutBatchPaid},
+		{"skipped left out", []PayoutStatus{PayoutPaid, PayoutSkipped}, PayoutBatchPaid},
+		{"one failed", []PayoutStatus{PayoutPaid, PayoutFailed, PayoutSkipped}, PayoutBatchFailed},
+		{"one not paid yet", []PayoutStatus{PayoutPending, PayoutPaid}, PayoutBatchFailed},
+	}
+
+	for _, tt := range tests {
+		batch := &PayoutBatch{Status: PayoutBatchPending}
+		for _, status := range tt.statuses {
+			batch.Payouts = append(batch.Payouts, TutorPayout{Tutor: bson.NewObjectId(), Status: status})
+		}
+
+		if batch.SetStatus(); batch.Status != tt.expected {
+			t.Errorf("%s: expected batch %s, got %s", tt.name, tt.expected, batch.Status)
+		}
+	}
+}
+
+func TestPayoutBatchTotals(t *testing.T) {
+	batch := &PayoutBatch{Payouts: []TutorPayout{
+		{Amount: 10500, Currency: CurrencyUSD, Status: PayoutPaid},
+		{Amount: 4000, Status: PayoutFailed},
+		{Amount: 900, Currency: CurrencyUSD, Status: PayoutSkipped},
+		{Amount: 6000, Currency: CurrencyGBP, Status: PayoutPaid},
+	}}
+
+	totals := batch.Totals()
+	if totals[CurrencyUSD] != 14500 || totals[CurrencyGBP] != 6000 || len(totals) != 2 {
+		t.Errorf("expected skipped payouts left out of 14500 usd and 6000 gbp, got %v", totals)
+	}
+}
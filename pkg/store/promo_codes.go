@@ -0,0 +1,360 @@
+package store
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gitlab.com/learnt/api/pkg/logger"
+)
+
+// PromoKind is how a promo code takes its discount off a lesson
+type PromoKind string
+
+const (
+	// PromoPercent takes a percentage off the lesson
+	PromoPercent PromoKind = "percent"
+	// PromoFixed takes a fixed amount off the lesson, in the code's currency
+	PromoFixed PromoKind = "fixed"
+)
+
+// PromoCode is a code students book lessons with for a discount. The platform pays the
+// discount, tutors are paid the same.
+type PromoCode struct {
+	ID          bson.ObjectId `json:"_id" bson:"_id"`
+	Code        string        `json:"code" bson:"code"`
+	Description string        `json:"description,omitempty" bson:"description,omitempty"`
+	Kind        PromoKind     `json:"kind" bson:"kind"`
+	// Percent is what percent codes take off, from 1 to 100
+	Percent int `json:"percent,omitempty" bson:"percent,omitempty"`
+	// Amount is what fixed codes take off, in minor units of Currency. They only
+	// apply to lessons in that currency.
+	Amount   int64    `json:"amount,omitempty" bson:"amount,omitempty"`
+	Currency Currency `json:"currency,omitempty" bson:"currency,omitempty"`
+
+	// FirstLessonOnly codes apply to the student's first paid lesson only
+	FirstLessonOnly bool `json:"first_lesson_only" bson:"first_lesson_only"`
+	// Subjects and Tutors limit the lessons the code applies to, any when empty
+	Subjects []bson.ObjectId `json:"subjects,omitempty" bson:"subjects,omitempty"`
+	Tutors   []bson.ObjectId `json:"tutors,omitempty" bson:"tutors,omitempty"`
+
+	// MaxRedemptions caps the redemptions of the code, MaxPerUser those of each student.
+	// Zero doesn't cap them.
+	MaxRedemptions int `json:"max_redemptions,omitempty" bson:"max_redemptions,omitempty"`
+	MaxPerUser     int `json:"max_per_user,omitempty" bson:"max_per_user,omitempty"`
+	// Redemptions counts the times the code was redeemed
+	Redemptions int `json:"redemptions" bson:"redemptions"`
+
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	// Disabled codes can't be redeemed anymore, they're kept for their redemptions
+	Disabled bool `json:"disabled" bson:"disabled"`
+
+	CreatedBy bson.ObjectId `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+}
+
+// PromoUse is a lesson a student wants a promo code's discount on
+type PromoUse struct {
+	Student  bson.ObjectId
+	Tutor    bson.ObjectId
+	Subject  bson.ObjectId
+	Currency Currency
+	// FirstLesson tells the student has no paid lessons yet
+	FirstLesson bool
+}
+
+// NormalizePromoCode is the code the way it's kept, codes aren't case sensitive
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate checks the code can be created
+func (p *PromoCode) Validate() error {
+	p.Code = NormalizePromoCode(p.Code)
+	if p.Code == "" || strings.ContainsAny(p.Code, " \t\n") {
+		return errors.New("code can't be empty or have spaces")
+	}
+
+	switch p.Kind {
+	case PromoPercent:
+		if p.Percent < 1 || p.Percent > 100 {
+			return errors.New("percent must be from 1 to 100")
+		}
+		p.Amount, p.Currency = 0, ""
+	case PromoFixed:
+		if p.Amount <= 0 {
+			return errors.New("amount must be positive")
+		}
+		p.Currency = p.Currency.OrDefault()
+		if !p.Currency.Valid() {
+			return errors.Errorf("invalid currency %s", p.Currency)
+		}
+		p.Percent = 0
+	default:
+		return errors.Errorf("invalid kind %s", p.Kind)
+	}
+
+	if p.MaxRedemptions < 0 || p.MaxPerUser < 0 {
+		return errors.New("usage caps can't be negative")
+	}
+
+	return nil
+}
+
+// Check tells why the code can't be redeemed for the lesson, nil when it can. The
+// student's own cap is checked when it's redeemed.
+func (p *PromoCode) Check(use PromoUse, now time.Time) error {
+	switch {
+	case p.Disabled:
+		return errors.New("promo code is no longer valid")
+	case p.ExpiresAt != nil && now.After(*p.ExpiresAt):
+		return errors.New("promo code has expired")
+	case p.MaxRedemptions > 0 && p.Redemptions >= p.MaxRedemptions:
+		return errors.New("promo code was used up")
+	case p.FirstLessonOnly && !use.FirstLesson:
+		return errors.New("promo code is only for a first lesson")
+	case p.Kind == PromoFixed && p.Currency.OrDefault() != use.Currency.OrDefault():
+		return errors.Errorf("promo code is only for lessons in %s", p.Currency)
+	case len(p.Tutors) > 0 && !containsID(p.Tutors, use.Tutor):
+		return errors.New("promo code isn't for lessons with this tutor")
+	case len(p.Subjects) > 0 && !containsID(p.Subjects, use.Subject):
+		return errors.New("promo code isn't for lessons of this subject")
+	}
+
+	return nil
+}
+
+// Discount is what the code takes off the cost, never more than it
+func (p *PromoCode) Discount(cost int64) int64 {
+	discount := p.Amount
+	if p.Kind == PromoPercent {
+		discount = int64(math.Round(float64(cost) * float64(p.Percent) / 100))
+	}
+
+	if discount > cost {
+		return cost
+	}
+	return discount
+}
+
+func containsID(ids []bson.ObjectId, id bson.ObjectId) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Insert stores the new code. Codes are unique.
+func (p *PromoCode) Insert() error {
+	if _, exists := GetPromoCode(p.Code); exists {
+		return errors.Errorf("promo code %s already exists", p.Code)
+	}
+
+	p.ID = bson.NewObjectId()
+	p.Redemptions = 0
+	p.CreatedAt = time.Now()
+
+	return errors.Wrap(GetCollection("promo_codes").Insert(p), "couldn't insert promo code")
+}
+
+// Disable stops the code from being redeemed
+func (p *PromoCode) Disable() error {
+	p.Disabled = true
+	return errors.Wrap(GetCollection("promo_codes").UpdateId(p.ID, bson.M{"$set": bson.M{"disabled": true}}), "couldn't disable promo code")
+}
+
+// GetPromoCode gets the code, in any case
+func GetPromoCode(code string) (*PromoCode, bool) {
+	var p PromoCode
+	if err := GetCollection("promo_codes").Find(bson.M{"code": NormalizePromoCode(code)}).One(&p); err != nil {
+		return nil, false
+	}
+	return &p, true
+}
+
+// GetPromoCodeByID gets the code by id
+func GetPromoCodeByID(id bson.ObjectId) (*PromoCode, bool) {
+	var p PromoCode
+	if err := GetCollection("promo_codes").FindId(id).One(&p); err != nil {
+		return nil, false
+	}
+	return &p, true
+}
+
+// GetPromoCodes gets the codes, newest first
+func GetPromoCodes() ([]PromoCode, error) {
+	codes := make([]PromoCode, 0)
+	err := GetCollection("promo_codes").Find(nil).Sort("-created_at").All(&codes)
+	return codes, errors.Wrap(err, "couldn't get promo codes")
+}
+
+// PromoRedemption is a promo code's discount taken off a student's lesson
+type PromoRedemption struct {
+	ID     bson.ObjectId  `json:"_id" bson:"_id"`
+	Promo  bson.ObjectId  `json:"promo" bson:"promo"`
+	Code   string         `json:"code" bson:"code"`
+	User   bson.ObjectId  `json:"user" bson:"user"`
+	Lesson *bson.ObjectId `json:"lesson,omitempty" bson:"lesson,omitempty"`
+	// PerLesson is "promo:lesson:user" for redemptions of a lesson. Its index is unique,
+	// a student redeems a code once for a lesson.
+	PerLesson string `json:"-" bson:"per_lesson,omitempty"`
+	// Seq numbers the student's redemptions of the code, they're unique
+	Seq    int   `json:"seq" bson:"seq"`
+	Amount int64 `json:"amount" bson:"amount"`
+	// Currency is the lesson's, the discount is in it
+	Currency  Currency  `json:"currency" bson:"currency"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// perUserCap is how many times a student can redeem the code, zero when it isn't capped.
+// First lesson codes are redeemed once.
+func (p *PromoCode) perUserCap() int {
+	if p.FirstLessonOnly {
+		return 1
+	}
+	return p.MaxPerUser
+}
+
+// Redeem takes the discount off the student's lesson, within the code's caps. The student's
+// redemptions are numbered up to their cap and the numbers are unique, so two redeemed at
+// once can't both take the last one. The count of redemptions is raised only while it's
+// under the code's cap, so the code isn't redeemed past it.
+func (p *PromoCode) Redeem(user bson.ObjectId, lesson *bson.ObjectId, amount int64, currency Currency) (*PromoRedemption, error) {
+	r := &PromoRedemption{
+		Promo:    p.ID,
+		Code:     p.Code,
+		User:     user,
+		Lesson:   lesson,
+		Amount:   amount,
+		Currency: currency.OrDefault(),
+	}
+
+	if lesson != nil {
+		r.PerLesson = p.ID.Hex() + ":" + lesson.Hex() + ":" + user.Hex()
+	}
+
+	if err := r.insert(p.perUserCap()); err != nil {
+		return nil, err
+	}
+
+	query := bson.M{"_id": p.ID, "disabled": false}
+	if p.MaxRedemptions > 0 {
+		query["redemptions"] = bson.M{"$lt": p.MaxRedemptions}
+	}
+
+	if err := GetCollection("promo_codes").Update(query, bson.M{"$inc": bson.M{"redemptions": 1}}); err != nil {
+		if errRemove := GetCollection("promo_redemptions").RemoveId(r.ID); errRemove != nil {
+			logger.Get().Errorf("couldn't remove redemption of promo code %s: %v", p.Code, errRemove)
+		}
+
+		if err == mgo.ErrNotFound {
+			return nil, errors.New("promo code was used up")
+		}
+		return nil, errors.Wrap(err, "couldn't redeem promo code")
+	}
+	p.Redemptions++
+
+	return r, nil
+}
+
+// insert stores the redemption with the first number of the student's that's free, up to
+// the cap. Numbers of released redemptions are taken again.
+func (r *PromoRedemption) insert(perUser int) error {
+	first, last := 1, perUser
+	if perUser == 0 {
+		used, err := GetCollection("promo_redemptions").Find(bson.M{"promo": r.Promo, "user": r.User}).Count()
+		if err != nil {
+			return errors.Wrap(err, "couldn't count promo code redemptions")
+		}
+
+		// uncapped codes only need a number no one else took meanwhile
+		first, last = used+1, used+3
+	}
+
+	for seq := first; seq <= last; seq++ {
+		r.ID = bson.NewObjectId()
+		r.Seq = seq
+		r.CreatedAt = time.Now()
+
+		err := GetCollection("promo_redemptions").Insert(r)
+		if err == nil {
+			return nil
+		}
+
+		if !mgo.IsDup(err) {
+			return errors.Wrap(err, "couldn't insert promo code redemption")
+		}
+
+		if r.PerLesson != "" {
+			if n, _ := GetCollection("promo_redemptions").Find(bson.M{"per_lesson": r.PerLesson}).Count(); n > 0 {
+				return errors.New("promo code was already redeemed for the lesson")
+			}
+		}
+	}
+
+	if perUser == 0 {
+		return errors.New("promo code is being redeemed, try again")
+	}
+	return errors.New("promo code was already used")
+}
+
+// Release gives the redemption back to the code, for lessons that couldn't be charged
+func (r *PromoRedemption) Release() error {
+	if err := GetCollection("promo_redemptions").RemoveId(r.ID); err != nil {
+		return errors.Wrap(err, "couldn't remove promo code redemption")
+	}
+
+	return errors.Wrap(GetCollection("promo_codes").UpdateId(r.Promo, bson.M{"$inc": bson.M{"redemptions": -1}}), "couldn't release promo code redemption")
+}
+
+// GetPromoRedemptions gets the redemptions matching, newest first
+func GetPromoRedemptions(match bson.M) ([]PromoRedemption, error) {
+	redemptions := make([]PromoRedemption, 0)
+	err := GetCollection("promo_redemptions").Find(match).Sort("-created_at").All(&redemptions)
+	return redemptions, errors.Wrap(err, "couldn't get promo code redemptions")
+}
+
+// Dto converts the redemption to a transaction of the credits summary, with the amount in major units
+func (r *PromoRedemption) Dto() *TransactionDto {
+	dto := &TransactionDto{
+		ID:        r.ID,
+		Amount:    float64(r.Amount) / 100,
+		Currency:  r.Currency.OrDefault(),
+		Details:   "Promo code " + r.Code,
+		Reference: r.Code,
+		Status:    "promo",
+		Time:      r.CreatedAt,
+	}
+
+	var err error
+	if dto.User, err = getUserDto(r.User); err != nil {
+		logger.Get().Errorf("couldn't get user of promo code redemption %s: %v", r.ID.Hex(), err)
+	}
+
+	if r.Lesson != nil {
+		var exists bool
+		if dto.Lesson, exists = GetLessonsStore().Get(*r.Lesson); !exists {
+			logger.Get().Errorf("couldn't get lesson of promo code redemption %s", r.ID.Hex())
+		}
+	}
+
+	return dto
+}
+
+// HasPaidLessons tells if the student was charged for a lesson before. Lessons a promo
+// code paid all of have no lines of the student, their redemptions count.
+func HasPaidLessons(student bson.ObjectId) (bool, error) {
+	n, err := GetCollection("ledger_entries").Find(bson.M{"kind": EntryLesson, "lines.owner": student}).Count()
+	if err != nil || n > 0 {
+		return n > 0, errors.Wrap(err, "couldn't count paid lessons")
+	}
+
+	n, err = GetCollection("promo_redemptions").Find(bson.M{"user": student, "lesson": bson.M{"$exists": true}}).Count()
+	return n > 0, errors.Wrap(err, "couldn't count paid lessons")
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestPromoCodeValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		promo PromoCode
+		ok    bool
+	}{
+		{"percent", PromoCode{Code: " welcome10 ", Kind: PromoPercent, Percent: 10}, true},
+		{"fixed", PromoCode{Code: "FIVE", Kind: PromoFixed, Amount: 500}, true},
+		{"percent over 100", PromoCode{Code: "ALL", Kind: PromoPercent, Percent: 120}, false},
+		{"fixed without amount", PromoCode{Code: "NONE", Kind: PromoFixed}, false},
+		{"fixed in unknown currency", PromoCode{Code: "EUR", Kind: PromoFixed, Amount: 500, Currency: "eur"}, false},
+		{"code with spaces", PromoCode{Code: "TWO WORDS", Kind: PromoPercent, Percent: 10}, false},
+		{"unknown kind", PromoCode{Code: "FREE", Kind: "free"}, false},
+		{"negative cap", PromoCode{Code: "CAP", Kind: PromoPercent, Percent: 10, MaxPerUser: -1}, false},
+	}
+
+	for _, tt := range tests {
+		promo := tt.promo
+		if err := promo.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: expected valid %v, got %v", tt.name, tt.ok, err)
+		}
+	}
+
+	promo := PromoCode{Code: " welcome10 ", Kind: PromoPercent, Percent: 10}
+	if promo.Validate(); promo.Code != "WELCOME10" {
+		t.Errorf("expected code normalized, got %q", promo.Code)
+	}
+
+	promo = PromoCode{Code: "FIVE", Kind: PromoFixed, Amount: 500}
+	if promo.Validate(); promo.Currency != DefaultCurrency {
+		t.Errorf("expected fixed code in the default currency, got %q", promo.Currency)
+	}
+}
+
+func TestPromoCodeCheck(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	tutor, subject := bson.NewObjectId(), bson.NewObjectId()
+	use := PromoUse{Student: bson.NewObjectId(), Tutor: tutor, Subject: subject, Currency: CurrencyUSD}
+
+	tests := []struct {
+		name  string
+		promo PromoCode
+		use   PromoUse
+		ok    bool
+	}{
+		{"any lesson", PromoCode{Kind: PromoPercent, Percent: 10}, use, true},
+		{"disabled", PromoCode{Kind: PromoPercent, Percent: 10, Disabled: true}, use, false},
+		{"expired", PromoCode{Kind: PromoPercent, Percent: 10, ExpiresAt: &past}, use, false},
+		{"used up", PromoCode{Kind: PromoPercent, Percent: 10, MaxRedemptions: 5, Redemptions: 5}, use, false},
+		{"not a first lesson", PromoCode{Kind: PromoPercent, Percent: 10, FirstLessonOnly: true}, use, false},
+		{"first lesson", PromoCode{Kind: PromoPercent, Percent: 10, FirstLessonOnly: true}, PromoUse{Tutor: tutor, FirstLesson: true}, true},
+		{"other currency", PromoCode{Kind: PromoFixed, Amount: 500, Currency: CurrencyGBP}, use, false},
+		{"other tutor", PromoCode{Kind: PromoPercent, Percent: 10, Tutors: []bson.ObjectId{bson.NewObjectId()}}, use, false},
+		{"tutor and subject", PromoCode{Kind: PromoPercent, Percent: 10, Tutors: []bson.ObjectId{tutor}, Subjects: []bson.ObjectId{subject}}, use, true},
+		{"other subject", PromoCode{Kind: PromoPercent, Percent: 10, Subjects: []bson.ObjectId{bson.NewObjectId()}}, use, false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.promo.Check(tt.use, now); (err == nil) != tt.ok {
+			t.Errorf("%s: expected redeemable %v, got %v", tt.name, tt.ok, err)
+		}
+	}
+}
+
+func TestPromoCodeDiscount(t *testing.T) {
+	tests := []struct {
+		promo    PromoCode
+		cost     int64
+		expected int64
+	}{
+		{PromoCode{Kind: PromoPercent, Percent: 15}, 1365, 205},
+		{PromoCode{Kind: PromoPercent, Percent: 100}, 1365, 1365},
+		{PromoCode{Kind: PromoFixed, Amount: 500}, 1365, 500},
+		{PromoCode{Kind: PromoFixed, Amount: 2000}, 1365, 1365},
+	}
+
+	for _, tt := range tests {
+		if discount := tt.promo.Discount(tt.cost); discount != tt.expected {
+			t.Errorf("expected %d off %d, got %d", tt.expected, tt.cost, discount)
+		}
+	}
+}
+
+func TestPromoCodeRedeemOncePerLesson(t *testing.T) {
+	dbSetup(t)
+
+	promo := &PromoCode{Code: "LESSON" + bson.NewObjectId().Hex(), Kind: PromoFixed, Amount: 500, MaxPerUser: 5}
+	if err := promo.Insert(); err != nil {
+		t.Fatalf("couldn't insert promo code: %v", err)
+	}
+	defer GetCollection("promo_codes").RemoveId(promo.ID)
+	defer GetCollection("promo_redemptions").RemoveAll(bson.M{"promo": promo.ID})
+
+	student, lesson := bson.NewObjectId(), bson.NewObjectId()
+	if _, err := promo.Redeem(student, &lesson, 500, ""); err != nil {
+		t.Fatalf("couldn't redeem promo code: %v", err)
+	}
+
+	if _, err := promo.Redeem(student, &lesson, 500, ""); err == nil {
+		t.Error("redeemed the promo code twice for the lesson")
+	}
+
+	other := bson.NewObjectId()
+	if _, err := promo.Redeem(student, &other, 500, ""); err != nil {
+		t.Errorf("couldn't redeem promo code for another lesson: %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package store
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// RescheduleStatus is the state of a reschedule proposal
+type RescheduleStatus string
+
+const (
+	RescheduleProposed  RescheduleStatus = "proposed"
+	RescheduleAccepted  RescheduleStatus = "accepted"
+	RescheduleCountered RescheduleStatus = "countered"
+	RescheduleDeclined  RescheduleStatus = "declined"
+	RescheduleExpired   RescheduleStatus = "expired"
+)
+
+// RescheduleSlot is a time a lesson can be moved to
+type RescheduleSlot struct {
+	StartsAt time.Time `json:"starts_at" bson:"starts_at"`
+	EndsAt   time.Time `json:"ends_at" bson:"ends_at"`
+}
+
+// Duration of the slot
+func (s RescheduleSlot) Duration() time.Duration {
+	return s.EndsAt.Sub(s.StartsAt)
+}
+
+// RescheduleProposal offers alternative times for a lesson. The other side of the
+// lesson picks one of the slots, counters with slots of their own, or declines.
+type RescheduleProposal struct {
+	ID     bson.ObjectId `json:"_id" bson:"_id"`
+	Lesson bson.ObjectId `json:"lesson" bson:"lesson"`
+	// Parent is the proposal this one counters.
+	Parent *bson.ObjectId `json:"parent,omitempty" bson:"parent,omitempty"`
+	// Round starts at 1 and grows with every counter-proposal.
+	Round int `json:"round" bson:"round"`
+
+	ProposedBy bson.ObjectId    `json:"proposed_by" bson:"proposed_by"`
+	Slots      []RescheduleSlot `json:"slots" bson:"slots"`
+	Status     RescheduleStatus `json:"status" bson:"status"`
+	// Picked is the index of the accepted slot. In group lessons it's picked by the first
+	// student accepting, the others accept the same slot.
+	Picked *int `json:"picked,omitempty" bson:"picked,omitempty"`
+	// AcceptedBy are the users who accepted the picked slot so far
+	AcceptedBy []bson.ObjectId `json:"accepted_by,omitempty" bson:"accepted_by,omitempty"`
+	// Open is the lesson while the proposal waits for an answer. Its index is unique,
+	// a lesson has one open proposal.
+	Open *bson.ObjectId `json:"-" bson:"open,omitempty"`
+
+	// Message is the message of the proposal in the lesson's thread.
+	Message *bson.ObjectId `json:"message,omitempty" bson:"message,omitempty"`
+
+	ExpiresAt   time.Time  `json:"expires_at" bson:"expires_at"`
+	CreatedAt   time.Time  `json:"created_at" bson:"created_at"`
+	RespondedAt *time.Time `json:"responded_at,omitempty" bson:"responded_at,omitempty"`
+}
+
+// IsPending tells if the proposal still waits for an answer
+func (p *RescheduleProposal) IsPending() bool {
+	return p.Status == RescheduleProposed && time.Now().Before(p.ExpiresAt)
+}
+
+// Insert opens the proposal. It fails when another proposal of the lesson waits for an
+// answer, one that expired without being marked so is expired first.
+func (p *RescheduleProposal) Insert() error {
+	if !p.ID.Valid() {
+		p.ID = bson.NewObjectId()
+	}
+
+	p.Status = RescheduleProposed
+	p.Open = &p.Lesson
+	p.CreatedAt = time.Now()
+
+	err := GetCollection("reschedule_proposals").Insert(p)
+	if mgo.IsDup(err) {
+		var open RescheduleProposal
+		if errOpen := GetCollection("reschedule_proposals").Find(bson.M{"open": p.Lesson}).One(&open); errOpen != nil || open.IsPending() {
+			return errors.New("a reschedule is already proposed, answer or counter it")
+		}
+
+		if errExpire := open.Respond(RescheduleExpired, nil); errExpire != nil {
+			return errExpire
+		}
+
+		err = GetCollection("reschedule_proposals").Insert(p)
+		if mgo.IsDup(err) {
+			return errors.New("a reschedule is already proposed, answer or counter it")
+		}
+	}
+
+	return errors.Wrap(err, "couldn't insert reschedule proposal")
+}
+
+// Accept records the user accepting the slot. Everyone accepting a proposal accepts the
+// same slot, it fails when another one was accepted or the proposal isn't pending anymore.
+func (p *RescheduleProposal) Accept(user bson.ObjectId, slot int) error {
+	var updated RescheduleProposal
+	_, err := GetCollection("reschedule_proposals").Find(bson.M{
+		"_id":        p.ID,
+		"status":     RescheduleProposed,
+		"expires_at": bson.M{"$gt": time.Now()},
+		"$or": []bson.M{
+			{"picked": bson.M{"$exists": false}},
+			{"picked": slot},
+		},
+	}).Apply(mgo.Change{
+		Update:    bson.M{"$set": bson.M{"picked": slot}, "$addToSet": bson.M{"accepted_by": user}},
+		ReturnNew: true,
+	}, &updated)
+
+	if err == mgo.ErrNotFound {
+		return errors.New("reschedule proposal was already answered, expired or another time was accepted")
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't accept reschedule proposal")
+	}
+
+	p.Picked, p.AcceptedBy = updated.Picked, updated.AcceptedBy
+	return nil
+}
+
+// AcceptedByUser tells if the user accepted the proposal
+func (p *RescheduleProposal) AcceptedByUser(user bson.ObjectId) bool {
+	for _, id := range p.AcceptedBy {
+		if id == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Respond moves a pending proposal to the status. It fails when the proposal was
+// already answered or expired, so two answers can't both win.
+func (p *RescheduleProposal) Respond(status RescheduleStatus, picked *int) error {
+	now := time.Now()
+
+	set := bson.M{"status": status, "responded_at": now}
+	if picked != nil {
+		set["picked"] = *picked
+	}
+
+	query := bson.M{"_id": p.ID, "status": RescheduleProposed}
+	if status != RescheduleExpired {
+		query["expires_at"] = bson.M{"$gt": now}
+	}
+
+	err := GetCollection("reschedule_proposals").Update(query, bson.M{"$set": set, "$unset": bson.M{"open": ""}})
+	if err == mgo.ErrNotFound {
+		return errors.New("reschedule proposal was already answered or expired")
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't update reschedule proposal")
+	}
+
+	p.Status = status
+	p.Picked = picked
+	p.RespondedAt = &now
+	p.Open = nil
+
+	if p.Message != nil {
+		// the message in the thread isn't actionable anymore
+		_ = GetMessengerCollection("messages").UpdateId(*p.Message, bson.M{
+			"$set": bson.M{"data.data.status": status},
+		})
+	}
+
+	return nil
+}
+
+// SetMessage links the proposal to its message in the lesson's thread
+func (p *RescheduleProposal) SetMessage(id bson.ObjectId) error {
+	p.Message = &id
+	err := GetCollection("reschedule_proposals").UpdateId(p.ID, bson.M{"$set": bson.M{"message": id}})
+	return errors.Wrap(err, "couldn't link reschedule proposal to message")
+}
+
+// GetRescheduleProposal gets a proposal by ID
+func GetRescheduleProposal(id bson.ObjectId) (*RescheduleProposal, bool) {
+	var p RescheduleProposal
+	if err := GetCollection("reschedule_proposals").FindId(id).One(&p); err != nil {
+		return nil, false
+	}
+	return &p, true
+}
+
+// GetPendingReschedule gets the proposal of the lesson waiting for an answer
+func GetPendingReschedule(lesson bson.ObjectId) (*RescheduleProposal, bool) {
+	var p RescheduleProposal
+	err := GetCollection("reschedule_proposals").Find(bson.M{
+		"lesson":     lesson,
+		"status":     RescheduleProposed,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).One(&p)
+
+	if err != nil {
+		return nil, false
+	}
+
+	return &p, true
+}
+
+// GetRescheduleProposals gets the negotiation of a lesson, oldest proposal first
+func GetRescheduleProposals(lesson bson.ObjectId) (proposals []RescheduleProposal, err error) {
+	err = GetCollection("reschedule_proposals").Find(bson.M{"lesson": lesson}).Sort("created_at").All(&proposals)
+	return proposals, errors.Wrap(err, "couldn't get reschedule proposals")
+}
+
+// GetExpiredReschedules gets the proposals nobody answered in time
+func GetExpiredReschedules() (proposals []RescheduleProposal, err error) {
+	err = GetCollection("reschedule_proposals").Find(bson.M{
+		"status":     RescheduleProposed,
+		"expires_at": bson.M{"$lte": time.Now()},
+	}).All(&proposals)
+
+	return proposals, errors.Wrap(err, "couldn't get expired reschedule proposals")
+}
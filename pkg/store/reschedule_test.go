@@ -0,0 +1,83 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestRescheduleProposalIsPending(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  RescheduleStatus
+		expires time.Duration
+		pending bool
+	}{
+		{"proposed", RescheduleProposed, time.Hour, true},
+		{"expired by time", RescheduleProposed, -time.Minute, false},
+		{"countered", RescheduleCountered, time.Hour, false},
+		{"accepted", RescheduleAccepted, time.Hour, false},
+	}
+
+	for _, tt := range tests {
+		p := RescheduleProposal{Status: tt.status, ExpiresAt: time.Now().Add(tt.expires)}
+		if got := p.IsPending(); got != tt.pending {
+			t.Errorf("%s: IsPending() = %v, want %v", tt.name, got, tt.pending)
+		}
+	}
+}
+
+func TestRescheduleProposalOpenOnce(t *testing.T) {
+	dbSetup(t)
+
+	lesson := bson.NewObjectId()
+	defer GetCollection("reschedule_proposals").RemoveAll(bson.M{"lesson": lesson})
+
+	first := &RescheduleProposal{Lesson: lesson, Round: 1, ProposedBy: bson.NewObjectId(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := first.Insert(); err != nil {
+		t.Fatalf("couldn't insert proposal: %v", err)
+	}
+
+	second := &RescheduleProposal{Lesson: lesson, Round: 1, ProposedBy: bson.NewObjectId(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := second.Insert(); err == nil {
+		t.Fatal("inserted a second open proposal for the lesson")
+	}
+
+	if err := first.Respond(RescheduleCountered, nil); err != nil {
+		t.Fatalf("couldn't counter proposal: %v", err)
+	}
+
+	if err := second.Insert(); err != nil {
+		t.Errorf("couldn't insert proposal after the open one was answered: %v", err)
+	}
+}
+
+func TestRescheduleProposalAcceptSameSlot(t *testing.T) {
+	dbSetup(t)
+
+	lesson := bson.NewObjectId()
+	defer GetCollection("reschedule_proposals").RemoveAll(bson.M{"lesson": lesson})
+
+	p := &RescheduleProposal{Lesson: lesson, Round: 1, ProposedBy: bson.NewObjectId(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := p.Insert(); err != nil {
+		t.Fatalf("couldn't insert proposal: %v", err)
+	}
+
+	first, second := bson.NewObjectId(), bson.NewObjectId()
+	if err := p.Accept(first, 1); err != nil {
+		t.Fatalf("couldn't accept proposal: %v", err)
+	}
+
+	if err := p.Accept(second, 0); err == nil {
+		t.Error("accepted another slot than the one already accepted")
+	}
+
+	if err := p.Accept(second, 1); err != nil {
+		t.Fatalf("couldn't accept the same slot: %v", err)
+	}
+
+	if !p.AcceptedByUser(first) || !p.AcceptedByUser(second) {
+		t.Errorf("accepted by %v, want both students", p.AcceptedBy)
+	}
+}
@@ -55,6 +55,24 @@ func (r *RoomEntity) GetLesson() *LessonMgo {
 	return r.Lesson
 }
 
+// EnteredBy tells if the user entered the room at least once
+func (r *RoomEntity) EnteredBy(userID bson.ObjectId) bool {
+	for _, activity := range r.Activity {
+		if activity.Action == ROOM_ACTIVITY_ENTER && activity.User != nil && activity.User.Hex() == userID.Hex() {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRoomForLesson gets the room opened for the lesson, if any
+func GetRoomForLesson(lessonID bson.ObjectId) (room *RoomEntity, exist bool) {
+	if err := GetCollection("rooms").Find(bson.M{"lesson": lessonID}).One(&room); err != nil {
+		return nil, false
+	}
+	return room, true
+}
+
 func (r *RoomEntity) String() string {
 	return fmt.Sprintf("[Room %s]", r.ID.Hex())
 }
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// StripeEventState is how far the processing of a Stripe event got
+type StripeEventState string
+
+const (
+	StripeEventReceived  StripeEventState = "received"
+	StripeEventProcessed StripeEventState = "processed"
+	// StripeEventIgnored is an event of a type nothing handles
+	StripeEventIgnored StripeEventState = "ignored"
+	StripeEventFailed  StripeEventState = "failed"
+)
+
+// StripeEvent is a webhook event received from Stripe, keyed by the event's id
+type StripeEvent struct {
+	ID   string `json:"_id" bson:"_id"`
+	Type string `json:"type" bson:"type"`
+	// Payload is the event as it was received
+	Payload string           `json:"-" bson:"payload"`
+	State   StripeEventState `json:"state" bson:"state"`
+	Error   string           `json:"error,omitempty" bson:"error,omitempty"`
+
+	Attempts   int       `json:"attempts" bson:"attempts"`
+	ReceivedAt time.Time `json:"received_at" bson:"received_at"`
+	// ClaimedAt is when the processing of the event last started
+	ClaimedAt   time.Time  `json:"claimed_at" bson:"claimed_at"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty" bson:"processed_at,omitempty"`
+}
+
+// Insert stores the event, claimed to be processed. It tells false when the event was received before.
+func (e *StripeEvent) Insert() (bool, error) {
+	e.State = StripeEventReceived
+	e.ReceivedAt = time.Now()
+	e.ClaimedAt = e.ReceivedAt
+
+	err := GetCollection("stripe_events").Insert(e)
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+
+	return err == nil, errors.Wrap(err, "couldn't insert stripe event")
+}
+
+// Claim takes the event to process it again: a failed event, or one that's still received
+// since staleBefore, when processing it stopped midway. Only one claim of the event succeeds.
+func (e *StripeEvent) Claim(staleBefore time.Time) error {
+	now := time.Now()
+
+	err := GetCollection("stripe_events").Update(bson.M{
+		"_id": e.ID,
+		"$or": []bson.M{
+			{"state": StripeEventFailed},
+			{"state": StripeEventReceived, "claimed_at": bson.M{"$lt": staleBefore}},
+			{"state": StripeEventReceived, "claimed_at": bson.M{"$exists": false}, "received_at": bson.M{"$lt": staleBefore}},
+		},
+	}, bson.M{
+		"$set": bson.M{"state": StripeEventReceived, "claimed_at": now},
+	})
+
+	if err == mgo.ErrNotFound {
+		return errors.New("event is being processed or was processed already")
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "couldn't claim stripe event")
+	}
+
+	e.State = StripeEventReceived
+	e.ClaimedAt = now
+
+	return nil
+}
+
+// SetProcessed saves how processing the event went
+func (e *StripeEvent) SetProcessed(state StripeEventState, processErr error) error {
+	now := time.Now()
+
+	e.State = state
+	e.Error = ""
+	if processErr != nil {
+		e.Error = processErr.Error()
+	}
+	e.Attempts++
+	e.ProcessedAt = &now
+
+	return errors.Wrap(GetCollection("stripe_events").UpdateId(e.ID, bson.M{
+		"$set": bson.M{"state": e.State, "error": e.Error, "processed_at": now},
+		"$inc": bson.M{"attempts": 1},
+	}), "couldn't update stripe event")
+}
+
+// GetStripeEvent gets the event by its Stripe id
+func GetStripeEvent(id string) (*StripeEvent, bool) {
+	var e StripeEvent
+	if err := GetCollection("stripe_events").FindId(id).One(&e); err != nil {
+		return nil, false
+	}
+	return &e, true
+}
+
+// GetStripeEvents gets the events in the state, newest first
+func GetStripeEvents(state StripeEventState, limit int) ([]StripeEvent, error) {
+	events := make([]StripeEvent, 0)
+	err := GetCollection("stripe_events").Find(bson.M{"state": state}).Sort("-received_at").Limit(limit).All(&events)
+	return events, errors.Wrap(err, "couldn't get stripe events")
+}
@@ -34,6 +34,7 @@ type TransactionDto struct {
 	ID        bson.ObjectId    `json:"_id" bson:"_id"`
 	User      *PublicUserDto   `json:"user" bson:"user"`
 	Amount    float64          `json:"amount" bson:"amount"`
+	Currency  Currency         `json:"currency" bson:"currency"`
 	Lesson    *LessonMgo       `json:"lesson" bson:"lesson"`
 	Details   string           `json:"details" bson:"details"`
 	Reference string           `json:"reference" bson:"reference"`
@@ -48,6 +49,7 @@ func (t *TransactionMgo) Dto() *TransactionDto {
 		ID:        t.ID,
 		User:      &PublicUserDto{ID: t.User},
 		Amount:    t.Amount,
+		Currency:  DefaultCurrency,
 		Details:   t.Details,
 		Reference: t.Reference,
 		Status:    t.Status,
@@ -57,6 +57,12 @@ const (
 	MeetBoth = MeetOnline | MeetInPerson
 )
 
+// defaultTravelBuffer is the minutes a tutor needs around in-person lessons when they haven't set it
+const defaultTravelBuffer = 45
+
+// minimumRate is the least a tutor can charge per hour, in minor units of their currency
+const minimumRate = 3000
+
 func (m Meet) String() string {
 	switch m {
 	case MeetOnline:
@@ -364,28 +370,41 @@ type TutoringSubjectDto struct {
 }
 
 type Tutoring struct {
-	Rate                float32           `json:"rate" bson:"rate,omitempty"`
-	LessonBuffer        int               `json:"lesson_buffer" bson:"lesson_buffer"`
-	Rating              float32           `json:"rating" bson:"rating"`
-	Reviewers           float32           `json:"reviewers" bson:"reviewers"`
-	InstantSession      bool              `json:"instant_session" bson:"instant_session"`
-	InstantBooking      bool              `json:"instant_booking" bson:"instant_booking"`
-	Meet                Meet              `json:"meet" bson:"meet,omitempty"`
-	Availability        *Availability     `json:"availability" bson:"availability,omitempty"`
-	Blackout            *Availability     `json:"blackout" bson:"blackout,omitempty"`
-	Degrees             []TutoringDegree  `json:"degrees" bson:"degrees"`
-	Subjects            []TutoringSubject `json:"subjects" bson:"subjects"`
-	Title               string            `json:"title,omitempty" bson:"title,omitempty"`
-	Video               *Upload           `json:"video,omitempty" bson:"video"`
-	Resume              *Upload           `json:"resume" bson:"resume"`
-	YouTubeVideo        string            `json:"youtube_video,omitempty" bson:"youtube_video,omitempty"`
-	PromoteVideoAllowed bool              `json:"promote_video_allowed,omitempty" bson:"promote_video_allowed,omitempty"`
-	ProfileChecked      *time.Time        `json:"profile_checked,omitempty" bson:"profile_checked,omitempty"`
+	// Rate and GroupRate are per hour, in minor units of the tutor's currency
+	Rate                int64               `json:"rate" bson:"rate,omitempty"`
+	GroupRate           int64               `json:"group_rate,omitempty" bson:"group_rate,omitempty"`
+	Currency            Currency            `json:"currency,omitempty" bson:"currency,omitempty"`
+	LessonBuffer        int                 `json:"lesson_buffer" bson:"lesson_buffer"`
+	TravelBuffer        int                 `json:"travel_buffer" bson:"travel_buffer"`
+	Rating              float32             `json:"rating" bson:"rating"`
+	Reviewers           float32             `json:"reviewers" bson:"reviewers"`
+	InstantSession      bool                `json:"instant_session" bson:"instant_session"`
+	InstantBooking      bool                `json:"instant_booking" bson:"instant_booking"`
+	Meet                Meet                `json:"meet" bson:"meet,omitempty"`
+	Availability        *Availability       `json:"availability" bson:"availability,omitempty"`
+	Blackout            *Availability       `json:"blackout" bson:"blackout,omitempty"`
+	Degrees             []TutoringDegree    `json:"degrees" bson:"degrees"`
+	Subjects            []TutoringSubject   `json:"subjects" bson:"subjects"`
+	Title               string              `json:"title,omitempty" bson:"title,omitempty"`
+	Video               *Upload             `json:"video,omitempty" bson:"video"`
+	Resume              *Upload             `json:"resume" bson:"resume"`
+	YouTubeVideo        string              `json:"youtube_video,omitempty" bson:"youtube_video,omitempty"`
+	PromoteVideoAllowed bool                `json:"promote_video_allowed,omitempty" bson:"promote_video_allowed,omitempty"`
+	ProfileChecked      *time.Time          `json:"profile_checked,omitempty" bson:"profile_checked,omitempty"`
+	CancellationPolicy  *CancellationPolicy `json:"cancellation_policy,omitempty" bson:"cancellation_policy,omitempty"`
+	Packages            []*PackageOffer     `json:"packages,omitempty" bson:"packages,omitempty"`
+	// ExternalBusy is the busy time imported from the tutor's external calendars,
+	// it blocks bookings like the blackout but can't be edited.
+	ExternalBusy []*AvailabilitySlot `json:"external_busy,omitempty" bson:"external_busy,omitempty"`
 }
 
 type TutoringDto struct {
-	Rate                float32              `json:"rate" bson:"rate,omitempty"`
+	Rate                int64                `json:"rate" bson:"rate,omitempty"`
+	GroupRate           int64                `json:"group_rate,omitempty" bson:"group_rate,omitempty"`
+	Currency            Currency             `json:"currency" bson:"currency"`
+	DisplayRate         *Money               `json:"display_rate,omitempty" bson:"-"`
 	Buffer              int                  `json:"lesson_buffer" bson:"lesson_buffer"`
+	TravelBuffer        int                  `json:"travel_buffer" bson:"travel_buffer"`
 	Rating              float32              `json:"rating" bson:"rating"`
 	Reviewers           float32              `json:"reviewers" bson:"reviewers"`
 	InstantSession      bool                 `json:"instant_session" bson:"instant_session"`
@@ -401,6 +420,8 @@ type TutoringDto struct {
 	HoursTaught         float64              `json:"hours_taught"`
 	PromoteVideoAllowed bool                 `json:"promote_video_allowed,omitempty" bson:"promote_video_allowed,omitempty"`
 	ProfileChecked      *time.Time           `json:"profile_checked,omitempty" bson:"profile_checked,omitempty"`
+	CancellationPolicy  *CancellationPolicy  `json:"cancellation_policy,omitempty" bson:"cancellation_policy,omitempty"`
+	Packages            []*PackageOffer      `json:"packages,omitempty" bson:"packages,omitempty"`
 }
 
 // UserCard represents the structure of a credit card that gets inserted in the database.
@@ -427,6 +448,9 @@ type Payments struct {
 	ConnectID  string      `json:"connect" bson:"connect"`
 	Cards      []*UserCard `json:"cards" bson:"cards"`
 	Credits    int64       `json:"credits,omitempty" bson:"credits,omitempty"`
+	// ChargesEnabled and PayoutsEnabled are what Stripe last said the connect account can do
+	ChargesEnabled bool `json:"charges_enabled" bson:"charges_enabled,omitempty"`
+	PayoutsEnabled bool `json:"payouts_enabled" bson:"payouts_enabled,omitempty"`
 	BankAccount
 }
 
@@ -583,7 +607,10 @@ func (u *UserMgo) TutoringDto() (tutoring *TutoringDto) {
 	return &TutoringDto{
 		Availability:        u.Tutoring.Availability,
 		Rate:                u.Tutoring.Rate,
+		GroupRate:           u.Tutoring.GroupRate,
+		Currency:            u.Tutoring.Currency.OrDefault(),
 		Buffer:              u.Tutoring.LessonBuffer,
+		TravelBuffer:        u.Tutoring.TravelBuffer,
 		Meet:                u.Tutoring.Meet,
 		Rating:              u.Tutoring.Rating,
 		Reviewers:           u.Tutoring.Reviewers,
@@ -597,6 +624,8 @@ func (u *UserMgo) TutoringDto() (tutoring *TutoringDto) {
 		HoursTaught:         hoursTaught,
 		Title:               u.Tutoring.Title,
 		PromoteVideoAllowed: u.Tutoring.PromoteVideoAllowed,
+		CancellationPolicy:  u.Tutoring.CancellationPolicy,
+		Packages:            u.Tutoring.Packages,
 	}
 }
 
@@ -611,6 +640,9 @@ type UserPreferences struct {
 
 	/* Is Publicly Searchable? */
 	IsPrivate bool `json:"is_private" bson:"is_private"`
+
+	/* Currency prices are shown in */
+	Currency Currency `json:"currency,omitempty" bson:"currency,omitempty"`
 }
 
 func (u *UserMgo) UpdatePreferences(p *UserPreferences) (err error) {
@@ -618,6 +650,10 @@ func (u *UserMgo) UpdatePreferences(p *UserPreferences) (err error) {
 		return fmt.Errorf("user preferences can't be nil")
 	}
 
+	if p.Currency != "" && !p.Currency.Valid() {
+		return fmt.Errorf("prices can't be shown in %s", p.Currency)
+	}
+
 	u.Preferences = p
 
 	err = GetCollection("users").UpdateId(u.ID, bson.M{"$set": bson.M{"preferences": u.Preferences}})
@@ -628,6 +664,19 @@ func (u *UserMgo) UpdatePreferences(p *UserPreferences) (err error) {
 	return nil
 }
 
+// DisplayCurrency is the currency the user wants prices shown in. Tutors see them in their own by default.
+func (u *UserMgo) DisplayCurrency() Currency {
+	if u.Preferences != nil && u.Preferences.Currency != "" {
+		return u.Preferences.Currency
+	}
+
+	if u.Tutoring != nil {
+		return u.Tutoring.Currency.OrDefault()
+	}
+
+	return DefaultCurrency
+}
+
 func (u *UserMgo) IsReceiveUpdates() bool {
 	return u.Preferences.ReceiveUpdates
 }
@@ -723,6 +772,8 @@ type UserMgo struct {
 	Favorite          Favorite                 `json:"favorite,omitempty" bson:"favorite,omitempty"`
 	SocialNetworks    []SocialNetwork          `json:"social_networks,omitempty" bson:"social_networks,omitempty"`
 	Files             []bson.ObjectId          `json:"files,omitempty" bson:"files,omitempty"`
+	ExternalCalendars []*ExternalCalendar      `json:"-" bson:"external_calendars,omitempty"`
+	CalendarFeed      *CalendarFeed            `json:"-" bson:"calendar_feed,omitempty"`
 }
 
 type UserDto struct {
@@ -756,8 +807,12 @@ type UserDto struct {
 }
 
 type PublicTutoringDto struct {
-	Rate           float32              `json:"rate" bson:"rate,omitempty"`
+	Rate           int64                `json:"rate" bson:"rate,omitempty"`
+	GroupRate      int64                `json:"group_rate,omitempty" bson:"group_rate,omitempty"`
+	Currency       Currency             `json:"currency" bson:"currency"`
+	DisplayRate    *Money               `json:"display_rate,omitempty" bson:"-"`
 	Buffer         int                  `json:"lesson_buffer" bson:"lesson_buffer"`
+	TravelBuffer   int                  `json:"travel_buffer" bson:"travel_buffer"`
 	Rating         float32              `json:"rating" bson:"rating"`
 	Reviewers      float32              `json:"reviewers" bson:"reviewers"`
 	InstantSession bool                 `json:"instant_session" bson:"instant_session"`
@@ -767,6 +822,7 @@ type PublicTutoringDto struct {
 	Subjects       []TutoringSubjectDto `json:"subjects" bson:"subjects"`
 	Title          string               `json:"title,omitempty" bson:"title,omitempty"`
 	HoursTaught    float64              `json:"hours_taught"`
+	Packages       []*PackageOffer      `json:"packages,omitempty" bson:"packages,omitempty"`
 }
 
 type PublicUserLocation struct {
@@ -871,7 +927,10 @@ func (u *UserMgo) ToPublicDto() *PublicUserDto {
 
 		dto.Tutoring = &PublicTutoringDto{
 			Rate:           tutoring.Rate,
+			GroupRate:      tutoring.GroupRate,
+			Currency:       tutoring.Currency.OrDefault(),
 			Buffer:         tutoring.LessonBuffer,
+			TravelBuffer:   tutoring.TravelBuffer,
 			Rating:         tutoring.Rating,
 			Reviewers:      tutoring.Reviewers,
 			InstantSession: tutoring.InstantSession,
@@ -881,6 +940,7 @@ func (u *UserMgo) ToPublicDto() *PublicUserDto {
 			Subjects:       subjects,
 			Title:          tutoring.Title,
 			HoursTaught:    hoursTaught,
+			Packages:       tutoring.Packages,
 		}
 	}
 	return dto
@@ -973,7 +1033,7 @@ func (u *UserMgo) Dto(sensitive ...bool) *UserDto {
 	return dto
 }
 
-// SaveNew sets reasonable defaults ID, LessonBuffer, and SSN, standardizes the name capitalization, and inserts the user
+// SaveNew sets reasonable defaults ID, LessonBuffer, TravelBuffer, and SSN, standardizes the name capitalization, and inserts the user
 func (u *UserMgo) SaveNew() (err error) {
 	if !u.ID.Valid() {
 		u.ID = bson.NewObjectId()
@@ -983,6 +1043,10 @@ func (u *UserMgo) SaveNew() (err error) {
 		u.Tutoring.LessonBuffer = 15
 	}
 
+	if u.Tutoring != nil && u.Tutoring.TravelBuffer == 0 {
+		u.Tutoring.TravelBuffer = defaultTravelBuffer
+	}
+
 	if u.Profile.SocialSecurityNumber != "" {
 		// Mask out the SSN before saving
 		ssn := u.Profile.SocialSecurityNumber
@@ -1104,6 +1168,23 @@ func (u *UserMgo) SetPaymentsConnect(connectID string) (err error) {
 	})
 }
 
+// SetConnectStatus saves what the user's connect account can do
+func (u *UserMgo) SetConnectStatus(chargesEnabled, payoutsEnabled bool) error {
+	if u.Payments == nil {
+		return errors.New("user has no payment account")
+	}
+
+	u.Payments.ChargesEnabled = chargesEnabled
+	u.Payments.PayoutsEnabled = payoutsEnabled
+
+	return GetCollection("users").UpdateId(u.ID, bson.M{
+		"$set": bson.M{
+			"payments.charges_enabled": chargesEnabled,
+			"payments.payouts_enabled": payoutsEnabled,
+		},
+	})
+}
+
 // SetCards will update the database with card info
 func (u *UserMgo) SetCards(cards []*UserCard) error {
 	if u.Payments == nil {
@@ -1726,14 +1807,43 @@ func (u *UserMgo) UpdateTimezone(tz string) (err error) {
 }
 
 func (u *UserMgo) UpdateTutoring(t *Tutoring) error {
-	if t.Rate < 30 {
-		return fmt.Errorf("rate can't be lower than $30")
+	t.Currency = t.Currency.OrDefault()
+	if !t.Currency.Valid() {
+		return fmt.Errorf("lessons can't be priced in %s", t.Currency)
+	}
+
+	if t.Rate < minimumRate {
+		return fmt.Errorf("rate can't be lower than %s", t.Currency.Format(minimumRate))
 	}
 
 	if t.LessonBuffer < 15 {
 		return fmt.Errorf("lesson buffer time can't be lower than 15 minutes")
 	}
 
+	if t.TravelBuffer == 0 {
+		t.TravelBuffer = defaultTravelBuffer
+	}
+
+	if t.TravelBuffer < t.LessonBuffer {
+		return fmt.Errorf("travel buffer time can't be lower than the lesson buffer time")
+	}
+
+	if t.GroupRate < 0 || t.GroupRate > t.Rate {
+		return fmt.Errorf("group rate must be between 0 and the hourly rate")
+	}
+
+	if t.CancellationPolicy != nil {
+		if err := t.CancellationPolicy.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, offer := range t.Packages {
+		if err := offer.Validate(); err != nil {
+			return err
+		}
+	}
+
 	if t.Title == "" {
 		return fmt.Errorf("tutoring title is required")
 	}
@@ -1743,9 +1853,14 @@ func (u *UserMgo) UpdateTutoring(t *Tutoring) error {
 	}
 
 	u.Tutoring.Rate = t.Rate
+	u.Tutoring.GroupRate = t.GroupRate
+	u.Tutoring.Currency = t.Currency
 	u.Tutoring.LessonBuffer = t.LessonBuffer
+	u.Tutoring.TravelBuffer = t.TravelBuffer
 	u.Tutoring.Title = t.Title
 	u.Tutoring.Meet = t.Meet
+	u.Tutoring.CancellationPolicy = t.CancellationPolicy
+	u.Tutoring.Packages = t.Packages
 
 	err := GetCollection("users").UpdateId(u.ID, bson.M{"$set": bson.M{"tutoring": u.Tutoring}})
 	if err != nil {
@@ -2093,7 +2208,20 @@ func (u *UserMgo) GetLessonsTimelineSlots() (slots []timeline.SlotProvider) {
 	GetCollection("lessons").Find(query).All(&queried)
 
 	for _, lesson := range queried {
-		slots = append(slots, lesson.GetTimelineSlot())
+		slot := lesson.GetTimelineSlot()
+
+		// a tutor can't be booked in the buffer around their lessons
+		if lesson.Tutor == u.ID {
+			buffer := u.lessonBuffer(lesson.Meet, lesson.Meet)
+			slot = &timeline.Slot{
+				ID:        slot.GetID(),
+				From:      slot.GetFrom().Add(-buffer),
+				To:        slot.GetTo().Add(buffer),
+				Occurence: slot.GetOccurence(),
+			}
+		}
+
+		slots = append(slots, slot)
 	}
 
 	return slots
@@ -2242,64 +2370,72 @@ func (u *UserMgo) RoleForLesson(lesson *LessonMgo) (role Role, found bool) {
 }
 
 func (u *UserMgo) IsFree(when time.Time, duration time.Duration) bool {
+	return u.IsFreeFor(when, duration, MeetOnline, nil)
+}
+
+func (u *UserMgo) IsFreeIgnoreIDs(when time.Time, duration time.Duration, IDs []bson.ObjectId) bool {
+	return u.IsFreeFor(when, duration, MeetOnline, IDs)
+}
+
+// IsFreeFor tells if the user has no lesson in the interval, other than the ignored
+// ones. Tutors also need their buffer between the interval and their lessons, the
+// travel buffer when either lesson is in person.
+func (u *UserMgo) IsFreeFor(when time.Time, duration time.Duration, meet Meet, IDs []bson.ObjectId) bool {
 	ends := when.Add(duration)
+	widest := u.lessonBuffer(MeetInPerson, MeetInPerson)
 
-	query := bson.M{"$and": []bson.M{
+	if IDs == nil {
+		IDs = []bson.ObjectId{}
+	}
+
+	var lessons []LessonMgo
+	err := GetCollection("lessons").Find(bson.M{"$and": []bson.M{
 		{"$or": []bson.M{
 			{"tutor": u.ID},
-			{"student": u.ID},
-		}},
-		{"$or": []bson.M{
-			{"starts_at": bson.M{"$lt": when}, "ends_at": bson.M{"$gt": ends}},
-			{"starts_at": bson.M{"$gt": when, "$lt": ends}},
-			{"ends_at": bson.M{"$gt": when, "$lt": ends}},
-		}},
-		{"$and": []bson.M{
-			{"state": bson.M{"$ne": LessonCancelled}},
+			{"students": u.ID},
 		}},
-	}}
+		{"starts_at": bson.M{"$lt": ends.Add(widest)}},
+		{"ends_at": bson.M{"$gt": when.Add(-widest)}},
+		{"state": bson.M{"$ne": LessonCancelled}},
+		{"_id": bson.M{"$nin": IDs}},
+	}}).All(&lessons)
 
-	n, err := GetCollection("lessons").Find(query).Count()
 	if err != nil {
 		return false
 	}
 
-	if n == 0 {
-		return true
+	for _, lesson := range lessons {
+		var buffer time.Duration
+		if lesson.Tutor == u.ID {
+			buffer = u.lessonBuffer(meet, lesson.Meet)
+		}
+
+		if lesson.StartsAt.Before(ends.Add(buffer)) && lesson.EndsAt.After(when.Add(-buffer)) {
+			return false
+		}
 	}
 
-	return false
+	return true
 }
 
-func (u *UserMgo) IsFreeIgnoreIDs(when time.Time, duration time.Duration, IDs []bson.ObjectId) bool {
-	ends := when.Add(duration)
-
-	query := GetCollection("lessons").Find(bson.M{"$and": []bson.M{
-		{"$or": []bson.M{
-			{"tutor": u.ID},
-			{"student": u.ID},
-		}},
-		{"$or": []bson.M{
-			{"starts_at": bson.M{"$gte": when, "$lte": ends}},
-			{"ends_at": bson.M{"$gte": when, "$lte": ends}},
-			{"starts_at": bson.M{"$lte": when}, "ends_at": bson.M{"$gte": ends}},
-		}},
-		{"$and": []bson.M{
-			{"state": bson.M{"$ne": LessonCancelled}},
-			{"_id": bson.M{"$nin": IDs}},
-		}},
-	}})
-
-	n, err := query.Count()
-	if err != nil {
-		return false
+// lessonBuffer is the time a tutor needs between two lessons, none for users that don't tutor
+func (u *UserMgo) lessonBuffer(a, b Meet) time.Duration {
+	if u.Tutoring == nil {
+		return 0
 	}
 
-	if n == 0 {
-		return true
+	if a == MeetInPerson || b == MeetInPerson {
+		travel := u.Tutoring.TravelBuffer
+		if travel == 0 {
+			travel = defaultTravelBuffer
+		}
+
+		if travel > u.Tutoring.LessonBuffer {
+			return time.Duration(travel) * time.Minute
+		}
 	}
 
-	return false
+	return time.Duration(u.Tutoring.LessonBuffer) * time.Minute
 }
 
 // SetReferralCode generates a pseudo-random string of specified length and assigns it to the user.
@@ -2396,6 +2532,25 @@ func (u *UserMgo) SetReferrer(s string) (*ReferLink, error) {
 	return referLink, nil
 }
 
+// SeatRate returns the rate a student pays for a seat in a lesson of the given capacity.
+// Group lessons use the tutor's group rate when one is set.
+func (t *Tutoring) SeatRate(capacity int) int64 {
+	if capacity > 1 && t.GroupRate > 0 {
+		return t.GroupRate
+	}
+	return t.Rate
+}
+
+// ShowIn adds the rate converted to the viewer's currency. They're still charged in the tutor's.
+func (t *TutoringDto) ShowIn(currency Currency, fx FXTable) {
+	t.DisplayRate = fx.Display(t.Rate, t.Currency, currency)
+}
+
+// ShowIn adds the rate converted to the viewer's currency. They're still charged in the tutor's.
+func (t *PublicTutoringDto) ShowIn(currency Currency, fx FXTable) {
+	t.DisplayRate = fx.Display(t.Rate, t.Currency, currency)
+}
+
 func (u *UserMgo) TimezoneLocation() *time.Location {
 	if l, err := time.LoadLocation(u.Timezone); err == nil {
 		return l
@@ -2403,6 +2558,21 @@ func (u *UserMgo) TimezoneLocation() *time.Location {
 	return time.UTC
 }
 
+// GetPackageOffer gets an offer of the tutor's packages
+func (u *UserMgo) GetPackageOffer(id bson.ObjectId) (*PackageOffer, bool) {
+	if u.Tutoring == nil {
+		return nil, false
+	}
+
+	for _, offer := range u.Tutoring.Packages {
+		if offer.ID == id {
+			return offer, true
+		}
+	}
+
+	return nil, false
+}
+
 func (u *UserMgo) GetFiles() ([]*FilesMgo, error) {
 	files := make([]*FilesMgo, len(u.Files))
 	if err := GetCollection("files").FindId(bson.M{"$in": u.Files}).All(&files); err != nil {
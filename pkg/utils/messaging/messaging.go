@@ -46,6 +46,9 @@ const (
 	TPL_LESSON_REMINDER_15_MINS_PRIOR      Tpl = "lesson-noti-15-mins-prior"
 	TPL_MESSAGE_NOTIFICATION               Tpl = "message-notification"
 	TPL_INSTANT_LESSON_REQUEST      	   Tpl = "instant-session-requested"
+	TPL_INSTANT_LESSON_UNFILLED            Tpl = "instant-session-unfilled-admin"
+	TPL_ASSIGNMENT_DUE                     Tpl = "assignment-due-reminder"
+	TPL_GIFT_CARD                          Tpl = "gift-card-received" // no sms
 
 	HIRING_EMAIL = "hello@learnt.io"
 )
@@ -0,0 +1,292 @@
+// Package rrule implements the subset of RFC 5545 recurrence rules used to
+// schedule lesson series: DAILY, WEEKLY and MONTHLY frequencies with INTERVAL,
+// BYDAY (weekly only), COUNT and UNTIL.
+package rrule
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+// MaxOccurrences caps the expansion of a rule, so rules without COUNT or UNTIL
+// can't expand forever.
+const MaxOccurrences = 520
+
+const (
+	untilLayout     = "20060102T150405Z"
+	untilDateLayout = "20060102"
+)
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+var weekdayNames = map[time.Weekday]string{
+	time.Monday:    "MO",
+	time.Tuesday:   "TU",
+	time.Wednesday: "WE",
+	time.Thursday:  "TH",
+	time.Friday:    "FR",
+	time.Saturday:  "SA",
+	time.Sunday:    "SU",
+}
+
+// Rule is a parsed RRULE
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	ByDay    []time.Weekday
+	Count    int
+	Until    time.Time
+}
+
+// Parse parses an RRULE value like "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=10".
+// The "RRULE:" prefix is optional.
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, errors.New("empty rule")
+	}
+
+	r := &Rule{Interval: 1}
+
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+
+		key, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+
+		switch key {
+		case "FREQ":
+			r.Freq = Frequency(value)
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid INTERVAL")
+			}
+			r.Interval = interval
+		case "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid COUNT")
+			}
+			r.Count = count
+		case "UNTIL":
+			until, err := time.Parse(untilLayout, value)
+			if err != nil {
+				if until, err = time.Parse(untilDateLayout, value); err != nil {
+					return nil, errors.Wrap(err, "invalid UNTIL")
+				}
+				// a date UNTIL includes the whole day
+				until = until.Add(24*time.Hour - time.Second)
+			}
+			r.Until = until
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, ok := weekdays[day]
+				if !ok {
+					return nil, fmt.Errorf("unsupported BYDAY value %q", day)
+				}
+				r.ByDay = append(r.ByDay, weekday)
+			}
+		case "WKST":
+			if value != "MO" {
+				return nil, fmt.Errorf("unsupported WKST %q", value)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Validate checks that the rule can be expanded
+func (r *Rule) Validate() error {
+	switch r.Freq {
+	case Daily, Weekly, Monthly:
+	case "":
+		return errors.New("FREQ is required")
+	default:
+		return fmt.Errorf("unsupported FREQ %q", r.Freq)
+	}
+
+	if r.Interval < 1 {
+		return errors.New("INTERVAL must be at least 1")
+	}
+
+	if r.Count < 0 {
+		return errors.New("COUNT can't be negative")
+	}
+
+	if r.Count > 0 && !r.Until.IsZero() {
+		return errors.New("COUNT and UNTIL can't be used together")
+	}
+
+	if len(r.ByDay) > 0 && r.Freq != Weekly {
+		return errors.New("BYDAY is only supported for WEEKLY rules")
+	}
+
+	return nil
+}
+
+// IsBounded tells if the rule ends, with either COUNT or UNTIL
+func (r *Rule) IsBounded() bool {
+	return r.Count > 0 || !r.Until.IsZero()
+}
+
+// String formats the rule back to its RRULE value
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			days[i] = weekdayNames[day]
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(untilLayout))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// ShiftDays moves the BYDAY weekdays by the number of days, used when a whole
+// series is moved to other days.
+func (r *Rule) ShiftDays(days int) {
+	for i, day := range r.ByDay {
+		r.ByDay[i] = time.Weekday(((int(day)+days)%7 + 7) % 7)
+	}
+}
+
+// All expands the rule starting at dtstart, skipping the excluded dates.
+// Occurrences keep dtstart's wall clock time in dtstart's location, so they
+// don't drift when daylight saving time changes.
+func (r *Rule) All(dtstart time.Time, exdates ...time.Time) []time.Time {
+	return r.Between(dtstart, time.Time{}, time.Time{}, exdates...)
+}
+
+// Between expands the rule starting at dtstart and returns the occurrences
+// starting in [from, to]. Zero from or to leave that side open.
+func (r *Rule) Between(dtstart, from, to time.Time, exdates ...time.Time) []time.Time {
+	out := make([]time.Time, 0)
+
+	excluded := func(t time.Time) bool {
+		for _, ex := range exdates {
+			if ex.Equal(t) {
+				return true
+			}
+		}
+		return false
+	}
+
+	count := 0
+	for period := 0; count < MaxOccurrences; period++ {
+		candidates := r.period(dtstart, period)
+		if candidates == nil {
+			break
+		}
+
+		for _, t := range candidates {
+			if t.Before(dtstart) {
+				continue
+			}
+
+			if !r.Until.IsZero() && t.After(r.Until) {
+				return out
+			}
+
+			if !to.IsZero() && t.After(to) {
+				return out
+			}
+
+			// excluded occurrences still count for COUNT, as in RFC 5545
+			count++
+			if r.Count > 0 && count > r.Count {
+				return out
+			}
+
+			if (from.IsZero() || !t.Before(from)) && !excluded(t) {
+				out = append(out, t)
+			}
+		}
+	}
+
+	return out
+}
+
+// period returns the candidate occurrences of the n-th period of the rule.
+// An empty slice is a period without occurrences, nil means the rule can't go on.
+func (r *Rule) period(dtstart time.Time, n int) []time.Time {
+	y, m, d := dtstart.Date()
+	h, min, sec := dtstart.Clock()
+	loc := dtstart.Location()
+	step := n * r.Interval
+
+	switch r.Freq {
+	case Daily:
+		return []time.Time{time.Date(y, m, d+step, h, min, sec, 0, loc)}
+	case Monthly:
+		first := time.Date(y, m+time.Month(step), 1, h, min, sec, 0, loc)
+		// months without the day of dtstart are skipped
+		if d > daysIn(first.Month(), first.Year()) {
+			return []time.Time{}
+		}
+		return []time.Time{time.Date(first.Year(), first.Month(), d, h, min, sec, 0, loc)}
+	case Weekly:
+		if len(r.ByDay) == 0 {
+			return []time.Time{time.Date(y, m, d+7*step, h, min, sec, 0, loc)}
+		}
+
+		// weeks start on monday
+		monday := d - (int(dtstart.Weekday())+6)%7 + 7*step
+		days := make([]time.Time, 0, len(r.ByDay))
+		for _, day := range r.ByDay {
+			days = append(days, time.Date(y, m, monday+(int(day)+6)%7, h, min, sec, 0, loc))
+		}
+		sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+		return days
+	}
+
+	return nil
+}
+
+func daysIn(m time.Month, year int) int {
+	return time.Date(year, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
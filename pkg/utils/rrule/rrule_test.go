@@ -0,0 +1,164 @@
+package rrule
+
+import (
+	"testing"
+	"time"
+)
+
+func dt(d, h int) time.Time {
+	return time.Date(2021, time.March, d, h, 0, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+		err  bool
+	}{
+		{rule: "FREQ=WEEKLY;COUNT=4", want: "FREQ=WEEKLY;COUNT=4"},
+		{rule: "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=6", want: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=6"},
+		{rule: "FREQ=DAILY;UNTIL=20210310T000000Z", want: "FREQ=DAILY;UNTIL=20210310T000000Z"},
+		{rule: "FREQ=DAILY;UNTIL=20210310", want: "FREQ=DAILY;UNTIL=20210310T235959Z"},
+		{rule: "FREQ=YEARLY", err: true},
+		{rule: "COUNT=3", err: true},
+		{rule: "FREQ=WEEKLY;BYDAY=1MO", err: true},
+		{rule: "FREQ=DAILY;BYDAY=MO", err: true},
+		{rule: "FREQ=DAILY;COUNT=2;UNTIL=20210310", err: true},
+		{rule: "FREQ=DAILY;INTERVAL=0", err: true},
+	}
+
+	for _, tt := range tests {
+		r, err := Parse(tt.rule)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Parse(%q) expected an error", tt.rule)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Parse(%q) unexpected error: %v", tt.rule, err)
+			continue
+		}
+
+		if r.String() != tt.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.rule, r.String(), tt.want)
+		}
+	}
+}
+
+func TestAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		start   time.Time
+		exdates []time.Time
+		want    []time.Time
+	}{
+		{
+			name:  "weekly count",
+			rule:  "FREQ=WEEKLY;COUNT=3",
+			start: dt(1, 10),
+			want:  []time.Time{dt(1, 10), dt(8, 10), dt(15, 10)},
+		},
+		{
+			name:  "biweekly",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;COUNT=3",
+			start: dt(1, 10),
+			want:  []time.Time{dt(1, 10), dt(15, 10), dt(29, 10)},
+		},
+		{
+			// March 3rd 2021 is a wednesday
+			name:  "weekdays skip days before start",
+			rule:  "FREQ=WEEKLY;BYDAY=MO,WE,FR;COUNT=4",
+			start: dt(3, 10),
+			want:  []time.Time{dt(3, 10), dt(5, 10), dt(8, 10), dt(10, 10)},
+		},
+		{
+			name:  "until is inclusive",
+			rule:  "FREQ=DAILY;INTERVAL=3;UNTIL=20210307T100000Z",
+			start: dt(1, 10),
+			want:  []time.Time{dt(1, 10), dt(4, 10), dt(7, 10)},
+		},
+		{
+			name:    "exdates count against COUNT",
+			rule:    "FREQ=WEEKLY;COUNT=3",
+			start:   dt(1, 10),
+			exdates: []time.Time{dt(8, 10)},
+			want:    []time.Time{dt(1, 10), dt(15, 10)},
+		},
+		{
+			name:  "monthly skips short months",
+			rule:  "FREQ=MONTHLY;COUNT=3",
+			start: time.Date(2021, time.January, 31, 9, 0, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2021, time.January, 31, 9, 0, 0, 0, time.UTC),
+				time.Date(2021, time.March, 31, 9, 0, 0, 0, time.UTC),
+				time.Date(2021, time.May, 31, 9, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := r.All(tt.start, tt.exdates...)
+			if len(got) != len(tt.want) {
+				t.Fatalf("All() = %v, want %v", got, tt.want)
+			}
+
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("All()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAllKeepsWallClockAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("timezone data not available")
+	}
+
+	// DST starts on March 14th 2021 in New York
+	r, _ := Parse("FREQ=WEEKLY;COUNT=2")
+	got := r.All(time.Date(2021, time.March, 10, 17, 0, 0, 0, loc))
+
+	for _, o := range got {
+		if o.Hour() != 17 {
+			t.Errorf("occurrence %v doesn't start at 17:00", o)
+		}
+	}
+
+	if d := got[1].Sub(got[0]); d != 7*24*time.Hour-time.Hour {
+		t.Errorf("expected the DST week to be an hour shorter, got %v", d)
+	}
+}
+
+func TestBetween(t *testing.T) {
+	r, _ := Parse("FREQ=DAILY")
+
+	got := r.Between(dt(1, 10), dt(5, 0), dt(7, 23))
+	if len(got) != 3 || !got[0].Equal(dt(5, 10)) || !got[2].Equal(dt(7, 10)) {
+		t.Errorf("Between() = %v", got)
+	}
+
+	if all := r.All(dt(1, 10)); len(all) != MaxOccurrences {
+		t.Errorf("unbounded rule expanded to %d occurrences, want %d", len(all), MaxOccurrences)
+	}
+}
+
+func TestShiftDays(t *testing.T) {
+	r, _ := Parse("FREQ=WEEKLY;BYDAY=MO,SU")
+	r.ShiftDays(-1)
+
+	if r.String() != "FREQ=WEEKLY;BYDAY=SU,SA" {
+		t.Errorf("ShiftDays(-1) = %q", r.String())
+	}
+}
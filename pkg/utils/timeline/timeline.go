@@ -163,16 +163,19 @@ func Shift(slot SlotProvider, from, to time.Time) SlotProvider {
 		return nil
 	}
 
+	// AddDate keeps the wall clock time in the slot's location, so weekly slots
+	// set in a tutor's location don't move when daylight saving time changes
 	slotFrom := slot.GetFrom()
-	duration := slot.GetTo().Sub(slotFrom)
+	slotTo := slot.GetTo()
 
 	for slotFrom.Before(to) {
 		slotFrom = slotFrom.AddDate(0, 0, 7)
+		slotTo = slotTo.AddDate(0, 0, 7)
 
 		slot = &Slot{
 			ID:        slot.GetID(),
 			From:      slotFrom,
-			To:        slotFrom.Add(duration),
+			To:        slotTo,
 			Occurence: slot.GetOccurence(),
 		}
 
@@ -216,6 +219,62 @@ func split(slot SlotProvider, sub SlotProvider) (out []SlotProvider) {
 	return
 }
 
+// Expand repeats the weekly slots between from and to, so the slots can be
+// combined with the set operations. Slots that don't repeat are kept as they are.
+func Expand(slots []SlotProvider, from, to time.Time) (out []SlotProvider) {
+	out = make([]SlotProvider, 0, len(slots))
+
+	for _, slot := range slots {
+		if slot.GetOccurence() != Weekly {
+			out = append(out, slot)
+			continue
+		}
+
+		if SlotEnters(slot, from, to) {
+			out = append(out, slot)
+		}
+
+		for next := Shift(slot, from, to); next != nil; next = Shift(next, from, to) {
+			out = append(out, next)
+		}
+	}
+
+	return
+}
+
+// merge sorts the slots and merges the ones that overlap or touch into slots
+// that don't repeat
+func merge(slots []SlotProvider) []SlotProvider {
+	srt := &timelineSort{append([]SlotProvider(nil), slots...)}
+	sort.Sort(srt)
+
+	out := make([]SlotProvider, 0, len(slots))
+	var last *Slot
+
+	for _, slot := range srt.Slots {
+		if !slot.GetTo().After(slot.GetFrom()) {
+			continue
+		}
+
+		if last != nil && !slot.GetFrom().After(last.To) {
+			if slot.GetTo().After(last.To) {
+				last.To = slot.GetTo()
+			}
+			continue
+		}
+
+		last = &Slot{
+			ID:        slot.GetID(),
+			From:      slot.GetFrom(),
+			To:        slot.GetTo(),
+			Occurence: None,
+		}
+		out = append(out, last)
+	}
+
+	return out
+}
+
 type Timeline struct {
 	slots []SlotProvider
 	mux   sync.Mutex
@@ -393,6 +452,85 @@ func (t *Timeline) Get(from, to time.Time, diff ...SlotProvider) (out []SlotProv
 	return
 }
 
+// NewTimeline builds a timeline of the slots, merging the ones that overlap. Weekly
+// slots have to be expanded first, the timeline's slots don't repeat.
+func NewTimeline(slots ...SlotProvider) *Timeline {
+	return &Timeline{slots: merge(slots)}
+}
+
+// Slots returns the slots of the timeline, sorted
+func (t *Timeline) Slots() []SlotProvider {
+	return append([]SlotProvider(nil), t.slots...)
+}
+
+// Union returns the time covered by either timeline
+func (t *Timeline) Union(other *Timeline) *Timeline {
+	return NewTimeline(append(t.Slots(), other.slots...)...)
+}
+
+// Intersect returns the time covered by both timelines
+func (t *Timeline) Intersect(other *Timeline) *Timeline {
+	a, b := merge(t.slots), merge(other.slots)
+	out := make([]SlotProvider, 0)
+
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		from, to := a[i].GetFrom(), a[i].GetTo()
+		if b[j].GetFrom().After(from) {
+			from = b[j].GetFrom()
+		}
+		if b[j].GetTo().Before(to) {
+			to = b[j].GetTo()
+		}
+
+		if to.After(from) {
+			out = append(out, &Slot{ID: a[i].GetID(), From: from, To: to, Occurence: None})
+		}
+
+		// move past the slot ending first
+		if a[i].GetTo().Before(b[j].GetTo()) {
+			i++
+		} else {
+			j++
+		}
+	}
+
+	return &Timeline{slots: out}
+}
+
+// Difference returns the time covered by the timeline and not by the other one
+func (t *Timeline) Difference(other *Timeline) *Timeline {
+	sub := merge(other.slots)
+	out := make([]SlotProvider, 0)
+
+	for _, slot := range merge(t.slots) {
+		from, to := slot.GetFrom(), slot.GetTo()
+
+		for _, s := range sub {
+			if !s.GetTo().After(from) {
+				continue
+			}
+			if !s.GetFrom().Before(to) {
+				break
+			}
+
+			if s.GetFrom().After(from) {
+				out = append(out, &Slot{ID: slot.GetID(), From: from, To: s.GetFrom(), Occurence: None})
+			}
+			from = s.GetTo()
+
+			if !to.After(from) {
+				break
+			}
+		}
+
+		if to.After(from) {
+			out = append(out, &Slot{ID: slot.GetID(), From: from, To: to, Occurence: None})
+		}
+	}
+
+	return &Timeline{slots: out}
+}
+
 func (t *Timeline) Sort() {
 	srt := &timelineSort{t.slots}
 	sort.Sort(srt)
@@ -411,8 +549,25 @@ type Availability struct {
 	booked   []SlotProvider
 }
 
+// Get expands the availability between from and to into concrete slots in UTC
 func (a *Availability) Get(from, to time.Time) (out []SlotProvider, err error) {
-	return a.timeline.Get(from, to, a.booked...)
+	slots, err := a.timeline.Get(from, to, a.booked...)
+	if err != nil {
+		return slots, err
+	}
+
+	// copies, so the timeline's slots keep their location
+	out = make([]SlotProvider, len(slots))
+	for i, slot := range slots {
+		out[i] = &Slot{
+			ID:        slot.GetID(),
+			From:      slot.GetFrom().UTC(),
+			To:        slot.GetTo().UTC(),
+			Occurence: slot.GetOccurence(),
+		}
+	}
+
+	return out, nil
 }
 
 // verify if recurrent available
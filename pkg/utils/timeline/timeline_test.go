@@ -854,6 +854,47 @@ func TestSlotShift(t *testing.T) {
 	}
 }
 
+func TestSlotShiftAcrossDST(t *testing.T) {
+
+	zone, er := time.LoadLocation("America/New_York")
+	if er != nil {
+		t.Error("Invalid timezone")
+		return
+	}
+
+	// Mondays 9-11am, daylight saving time starts on March 14 2021
+	slot := &Slot{
+		From:      time.Date(2021, time.March, 8, 9, 0, 0, 0, zone),
+		To:        time.Date(2021, time.March, 8, 11, 0, 0, 0, zone),
+		Occurence: Weekly,
+	}
+
+	line := &Timeline{}
+	line.Add(slot)
+
+	av := line.GetAvailability()
+
+	slots, _ := av.Get(
+		time.Date(2021, time.March, 15, 0, 0, 0, 0, time.UTC),
+		time.Date(2021, time.March, 16, 0, 0, 0, 0, time.UTC),
+	)
+
+	if len(slots) != 1 {
+		t.Fatalf("Expected one slot, got %d", len(slots))
+	}
+
+	if !slots[0].GetFrom().Equal(time.Date(2021, time.March, 15, 13, 0, 0, 0, time.UTC)) {
+		t.Error("Expected slot to start at 9am local time", printSlots(slots))
+	}
+
+	if !av.IsAvailableRecurrent(
+		time.Date(2021, time.March, 8, 10, 0, 0, 0, zone),
+		time.Date(2021, time.March, 8, 11, 0, 0, 0, zone),
+	) {
+		t.Error("Expecting available")
+	}
+}
+
 func TestSlotEnters(t *testing.T) {
 
 	a := &Slot{
@@ -900,3 +941,66 @@ func TestSlotEnters(t *testing.T) {
 	}
 
 }
+
+func TestTimelineSetOperations(t *testing.T) {
+	a := NewTimeline(
+		&Slot{From: nt(1, 9, 0), To: nt(1, 12, 0)},
+		&Slot{From: nt(1, 11, 0), To: nt(1, 13, 0)},
+		&Slot{From: nt(1, 15, 0), To: nt(1, 18, 0)},
+	)
+	b := NewTimeline(
+		&Slot{From: nt(1, 10, 0), To: nt(1, 16, 0)},
+		&Slot{From: nt(1, 17, 0), To: nt(1, 17, 30)},
+	)
+
+	if a.Len() != 2 {
+		t.Fatalf("Expected overlapping slots to merge into 2, found:\n%s", printSlots(a.Slots()))
+	}
+
+	tests := []struct {
+		name string
+		got  *Timeline
+		want []Slot
+	}{
+		{"union", a.Union(b), []Slot{
+			{From: nt(1, 9, 0), To: nt(1, 18, 0)},
+		}},
+		{"intersect", a.Intersect(b), []Slot{
+			{From: nt(1, 10, 0), To: nt(1, 13, 0)},
+			{From: nt(1, 15, 0), To: nt(1, 16, 0)},
+			{From: nt(1, 17, 0), To: nt(1, 17, 30)},
+		}},
+		{"difference", a.Difference(b), []Slot{
+			{From: nt(1, 9, 0), To: nt(1, 10, 0)},
+			{From: nt(1, 16, 0), To: nt(1, 17, 0)},
+			{From: nt(1, 17, 30), To: nt(1, 18, 0)},
+		}},
+	}
+
+	for _, tt := range tests {
+		slots := tt.got.Slots()
+		if len(slots) != len(tt.want) {
+			t.Errorf("%s: expected %d slots, found:\n%s", tt.name, len(tt.want), printSlots(slots))
+			continue
+		}
+
+		for i, want := range tt.want {
+			if !slots[i].GetFrom().Equal(want.From) || !slots[i].GetTo().Equal(want.To) {
+				t.Errorf("%s: unexpected slots:\n%s", tt.name, printSlots(slots))
+				break
+			}
+		}
+	}
+}
+
+func TestExpandWeekly(t *testing.T) {
+	slots := Expand([]SlotProvider{
+		&Slot{From: nt(1, 10, 0), To: nt(1, 11, 0), Occurence: Weekly},
+		&Slot{From: nt(2, 10, 0), To: nt(2, 11, 0), Occurence: None},
+	}, nt(1, 0, 0), nt(22, 0, 0))
+
+	// Jan 1, 8, 15 and the one-off slot of Jan 2
+	if len(slots) != 4 {
+		t.Errorf("Expected 4 slots, found:\n%s", printSlots(slots))
+	}
+}
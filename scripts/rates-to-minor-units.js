@@ -0,0 +1,71 @@
+/* convert the rates and prices kept in dollars to cents, and set them in usd
+Rates of tutoring, lessons, lesson charges and packages, and prices of courses
+Documents with a currency are already converted, so it's safe to run again
+In terminal execute `mongo mongodb://localhost:port/db_name rates-to-minor-units.js`
+ */
+
+function cents(dollars) {
+    return NumberLong(Math.round(dollars * 100));
+}
+
+function convertCharge(charge) {
+    if (charge && typeof charge.tutor_rate == "number") {
+        charge.tutor_rate = cents(charge.tutor_rate);
+        charge.currency = "usd";
+    }
+}
+
+var count = 0;
+db.getCollection("users").find({
+    "tutoring": { "$exists": true },
+    "tutoring.currency": { "$exists": false },
+}).forEach(function(user) {
+    var set = { "tutoring.currency": "usd" };
+    if (user.tutoring.rate) {
+        set["tutoring.rate"] = cents(user.tutoring.rate);
+    }
+    if (user.tutoring.group_rate) {
+        set["tutoring.group_rate"] = cents(user.tutoring.group_rate);
+    }
+    db.getCollection("users").updateOne({ _id: user._id }, { "$set": set });
+    count++;
+});
+print("Converted", count, "tutors");
+
+count = 0;
+db.getCollection("lessons").find({
+    "currency": { "$exists": false },
+}).forEach(function(lesson) {
+    var set = { "currency": "usd", "rate": cents(lesson.rate || 0) };
+    if (lesson.charge) {
+        convertCharge(lesson.charge);
+        set["charge"] = lesson.charge;
+    }
+    if (lesson.charges) {
+        for (var student in lesson.charges) {
+            convertCharge(lesson.charges[student]);
+        }
+        set["charges"] = lesson.charges;
+    }
+    db.getCollection("lessons").updateOne({ _id: lesson._id }, { "$set": set });
+    count++;
+});
+print("Converted", count, "lessons");
+
+count = 0;
+db.getCollection("lesson_packages").find({
+    "currency": { "$exists": false },
+}).forEach(function(pkg) {
+    db.getCollection("lesson_packages").updateOne({ _id: pkg._id }, { "$set": { "currency": "usd", "rate": cents(pkg.rate || 0) } });
+    count++;
+});
+print("Converted", count, "packages");
+
+count = 0;
+db.getCollection("courses").find({
+    "currency": { "$exists": false },
+}).forEach(function(course) {
+    db.getCollection("courses").updateOne({ _id: course._id }, { "$set": { "currency": "usd", "price": cents(course.price || 0) } });
+    count++;
+});
+print("Converted", count, "courses");